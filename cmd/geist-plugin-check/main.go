@@ -0,0 +1,58 @@
+// Command geist-plugin-check runs the plugin conformance suite against a
+// backend plugin executable and reports the result of every check.
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/plugin/conformance"
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkListen  string
+	checkTarget  string
+	checkTimeout time.Duration
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "geist-plugin-check <plugin>",
+	Short: "Check a backend plugin against the geistd plugin protocol",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		results := conformance.Check(conformance.Options{
+			Path:    args[0],
+			Listen:  checkListen,
+			Target:  checkTarget,
+			Timeout: checkTimeout,
+		})
+
+		failed := 0
+		for _, r := range results {
+			if r.Err != nil {
+				failed++
+				logging.Log.Errorf("FAIL %s: %v", r.Name, r.Err)
+				continue
+			}
+			logging.Log.Infof("ok   %s", r.Name)
+		}
+		if failed > 0 {
+			logging.Log.Errorf("%d of %d checks failed", failed, len(results))
+			os.Exit(1)
+		}
+	},
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	rootCmd.Flags().StringVar(&checkListen, "listen", "", "SOCKS5 listen address for the test proxy (default: free local port)")
+	rootCmd.Flags().StringVar(&checkTarget, "target", "", "host:port to CONNECT to through the started proxy")
+	rootCmd.Flags().DurationVar(&checkTimeout, "timeout", 10*time.Second, "how long state changes may take")
+}
@@ -0,0 +1,118 @@
+// Command geist-plugin-direct is the reference backend plugin for geistd.
+// It serves the proxy's SOCKS5 listener itself and connects to targets
+// directly from the daemon host, without any tunnel. Besides being useful
+// for local testing it shows the minimal shape of a plugin built on
+// package plugin.
+//
+// Install it by copying the binary into the daemon's plugin_dir and use it
+// with "backend: direct" on a host.
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/socks5"
+	"github.com/mfulz/portgeist/plugin"
+)
+
+// directBackend implements plugin.ExitAwareBackend.
+type directBackend struct {
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+	settings  map[string]map[string]any
+	exited    func(name string)
+}
+
+func main() {
+	b := &directBackend{
+		listeners: make(map[string]net.Listener),
+		settings:  make(map[string]map[string]any),
+	}
+	if err := plugin.Serve("direct", b); err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// SetExitHandler registers the callback reporting unexpected exits.
+func (b *directBackend) SetExitHandler(cb func(name string)) {
+	b.exited = cb
+}
+
+// Configure stores the backend settings of a proxy.
+func (b *directBackend) Configure(name string, cfg map[string]any) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.settings[name] = cfg
+	return nil
+}
+
+// Start opens the SOCKS5 listener of a proxy.
+func (b *directBackend) Start(params plugin.StartParams) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.listeners[params.Name]; ok {
+		return fmt.Errorf("proxy '%s' is already running", params.Name)
+	}
+
+	timeout := 10 * time.Second
+	if v, ok := b.settings[params.Name]["dial_timeout"].(float64); ok && v > 0 {
+		timeout = time.Duration(v) * time.Second
+	}
+
+	ln, err := net.Listen("tcp", params.Listen)
+	if err != nil {
+		return fmt.Errorf("listen on %s failed: %w", params.Listen, err)
+	}
+	b.listeners[params.Name] = ln
+
+	dial := func(network, addr string) (net.Conn, error) {
+		return net.DialTimeout(network, addr, timeout)
+	}
+	go func() {
+		err := socks5.Serve(ln, dial)
+
+		b.mu.Lock()
+		current := b.listeners[params.Name] == ln
+		if current {
+			delete(b.listeners, params.Name)
+		}
+		b.mu.Unlock()
+
+		// a listener removed by Stop is an intentional exit
+		if current && b.exited != nil {
+			fmt.Fprintf(os.Stderr, "proxy '%s' exited: %v\n", params.Name, err)
+			b.exited(params.Name)
+		}
+	}()
+	return nil
+}
+
+// Stop closes the listener of a proxy.
+func (b *directBackend) Stop(name string) error {
+	b.mu.Lock()
+	ln, ok := b.listeners[name]
+	delete(b.listeners, name)
+	b.mu.Unlock()
+
+	if ok {
+		return ln.Close()
+	}
+	return nil
+}
+
+// Status reports whether the proxy's listener is open. The plugin serves
+// all proxies itself, so its own PID is reported.
+func (b *directBackend) Status(name string) (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.listeners[name]; !ok {
+		return 0, false
+	}
+	return os.Getpid(), true
+}
@@ -0,0 +1,152 @@
+// Package cmd provides CLI commands for the geistctl binary.
+// This file defines the "acl" command group for inspecting ACL decisions.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/controlcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"github.com/spf13/cobra"
+)
+
+var explainQuery protocol.ACLExplainRequest
+
+// ACLCmd is the root command for ACL inspection.
+var ACLCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "Inspect access control decisions",
+}
+
+// aclExplainCmd prints how the daemon decides a permission for a user.
+var aclExplainCmd = &cobra.Command{
+	Use:   "explain",
+	Short: "Show why a user is allowed or denied a permission",
+	Long: `Show why a user is allowed or denied a permission, listing the group
+memberships, the roles considered and every rule evaluated. Without --proxy
+the global rules apply; --host adds the rules of the host and its backend. The request is authenticated as --control-user.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		ex, err := controlcli.ACLExplain(explainQuery, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		for _, line := range formatExplanation(ex) {
+			logging.Log.Infoln(line)
+		}
+	},
+}
+
+// formatExplanation renders an ACL evaluation trace line by line.
+func formatExplanation(ex *protocol.ACLExplanation) []string {
+	decision := "DENY"
+	if ex.Allowed {
+		decision = "ALLOW"
+	}
+	target := "global"
+	if ex.Proxy != "" {
+		target = "proxy " + ex.Proxy
+	}
+	if ex.Host != "" {
+		target += " via host " + ex.Host
+	}
+
+	lines := []string{fmt.Sprintf("%s: %s %s on %s — %s", decision, ex.User, ex.Permission, target, ex.Reason)}
+	if !ex.KnownUser {
+		return lines
+	}
+
+	groups := "-"
+	if len(ex.Groups) > 0 {
+		groups = strings.Join(ex.Groups, ", ")
+	}
+	lines = append(lines, fmt.Sprintf("Groups: %s", groups))
+	lines = append(lines, fmt.Sprintf("Context: instance=%s source=%s time=%s", orDash(ex.Instance), orDash(ex.Source), ex.Time))
+
+	lines = append(lines, "Roles:")
+	if len(ex.Roles) == 0 {
+		lines = append(lines, "  -")
+	}
+	for _, r := range ex.Roles {
+		state := "does not grant"
+		switch {
+		case !r.Exists:
+			state = "undefined"
+		case r.Condition != "":
+			state = "inactive (" + r.Condition + ")"
+		case r.Grants && r.GrantedBy != r.Name:
+			state = "grants (inherited from " + r.GrantedBy + ")"
+		case r.Grants:
+			state = "grants"
+		}
+		lines = append(lines, fmt.Sprintf("  %-16s via %-16s %s", r.Name, r.Via, state))
+	}
+
+	for _, st := range ex.RuleSets {
+		verdict := "deny"
+		if st.Allowed {
+			verdict = "allow"
+		}
+		lines = append(lines, fmt.Sprintf("Rules of %s '%s': %s — %s", st.Source, st.Object, verdict, st.Reason))
+		lines = append(lines, formatRuleTraces(st.Rules)...)
+	}
+	return lines
+}
+
+// formatRuleTraces renders one line per evaluated rule.
+func formatRuleTraces(rules []protocol.ACLRuleTrace) []string {
+	var lines []string
+	for _, r := range rules {
+		kind := "allow"
+		if r.Deny {
+			kind = "deny"
+		}
+		perms := "*"
+		if len(r.Permissions) > 0 {
+			perms = strings.Join(r.Permissions, ",")
+		}
+		line := fmt.Sprintf("  #%d %-5s subjects=%s permissions=%s", r.Index, kind, strings.Join(r.Subjects, ","), perms)
+		if len(r.Proxies) > 0 {
+			line += " proxies=" + strings.Join(r.Proxies, ",")
+		}
+		switch {
+		case !r.ProxyMatch:
+			line += " -> skip (proxy)"
+		case !r.PermissionMatch:
+			line += " -> skip (permission)"
+		case r.MatchedSubject == "":
+			line += " -> skip (subject)"
+		case !r.ConditionMatch:
+			line += fmt.Sprintf(" -> skip (%s)", r.Condition)
+		default:
+			line += fmt.Sprintf(" -> %s (matched %s)", r.Effect, r.MatchedSubject)
+		}
+		if r.Description != "" {
+			line += fmt.Sprintf(" %q", r.Description)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func init() {
+	aclExplainCmd.Flags().StringVarP(&explainQuery.User, "user", "u", "", "User to explain (defaults to the control user)")
+	aclExplainCmd.Flags().StringVarP(&explainQuery.Proxy, "proxy", "p", "", "Evaluate the rules of this proxy")
+	aclExplainCmd.Flags().StringVar(&explainQuery.Host, "host", "", "Also evaluate the rules of this host and its backend")
+	aclExplainCmd.Flags().StringVar(&explainQuery.Instance, "instance", "", "Control instance for rule conditions (defaults to the one used)")
+	aclExplainCmd.Flags().StringVar(&explainQuery.Source, "source", "", "Client address for rule conditions (defaults to this client)")
+	aclExplainCmd.Flags().StringVar(&explainQuery.Time, "at", "", "Evaluation time in RFC 3339 (defaults to now)")
+	aclExplainCmd.Flags().StringVar(&explainQuery.Permission, "perm", "", "Permission to check, e.g. proxy_start")
+	aclExplainCmd.Flags().StringVarP(&daemonName, "daemon", "d", "", "Daemon name from ctl_config")
+	aclExplainCmd.Flags().StringVar(&controlUser, "control-user", "admin", "Control user to authenticate as")
+	aclExplainCmd.Flags().StringVar(&overrideAddr, "addr", "", "Direct override address for daemon (unix socket or host:port)")
+	aclExplainCmd.Flags().StringVar(&overrideToken, "token", "", "Auth token for manually specified daemon")
+	_ = aclExplainCmd.MarkFlagRequired("perm")
+
+	ACLCmd.AddCommand(aclExplainCmd)
+}
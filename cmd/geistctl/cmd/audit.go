@@ -0,0 +1,68 @@
+// Package cmd provides CLI commands for the geistctl binary.
+// This file defines the "audit" command reading back the daemon's audit log.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/controlcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"github.com/spf13/cobra"
+)
+
+var auditQuery protocol.AuditRequest
+
+// AuditCmd prints audit log entries of the daemon.
+var AuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show who did what on the daemon",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		resp, err := controlcli.Audit(auditQuery, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		for _, e := range resp.Entries {
+			logging.Log.Infoln(formatAuditEntry(e))
+		}
+	},
+}
+
+// formatAuditEntry renders an audit entry as a single line.
+func formatAuditEntry(e protocol.AuditEntry) string {
+	parts := []string{e.Time, e.Instance, e.User, e.Command}
+	if e.Proxy != "" {
+		parts = append(parts, fmt.Sprintf("proxy=%s", e.Proxy))
+	}
+	if e.Host != "" {
+		parts = append(parts, fmt.Sprintf("host=%s", e.Host))
+	}
+	parts = append(parts, e.Decision, e.Outcome)
+	if e.Error != "" {
+		parts = append(parts, fmt.Sprintf("(%s)", e.Error))
+	}
+	if e.Peer != "" {
+		parts = append(parts, fmt.Sprintf("peer=%s", e.Peer))
+	}
+	if e.Session != "" {
+		parts = append(parts, fmt.Sprintf("session=%s", e.Session))
+	}
+	return strings.Join(parts, " ")
+}
+
+func init() {
+	AuditCmd.Flags().StringVar(&auditQuery.Since, "since", "", "Only entries after this time (RFC 3339 or duration like 24h)")
+	AuditCmd.Flags().StringVar(&auditQuery.Until, "until", "", "Only entries before this time (RFC 3339 or duration)")
+	AuditCmd.Flags().StringVar(&auditQuery.User, "actor", "", "Only entries of this ACL user")
+	AuditCmd.Flags().StringVarP(&auditQuery.Command, "command", "c", "", "Only entries of matching commands, e.g. proxy.*")
+	AuditCmd.Flags().IntVarP(&auditQuery.Limit, "limit", "n", 100, "Maximum number of entries, keeping the most recent")
+	AuditCmd.Flags().StringVarP(&daemonName, "daemon", "d", "", "Daemon name from ctl_config")
+	AuditCmd.Flags().StringVarP(&controlUser, "user", "u", "admin", "Control user to authenticate as")
+	AuditCmd.Flags().StringVar(&overrideAddr, "addr", "", "Direct override address for daemon (unix socket or host:port)")
+	AuditCmd.Flags().StringVar(&overrideToken, "token", "", "Auth token for manually specified daemon")
+}
@@ -0,0 +1,88 @@
+// Package cmd provides CLI commands for the geistctl binary.
+// This file defines the "config" command group for changing the
+// configuration of a running daemon. Changes are applied immediately and
+// written back to the daemon's config file.
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	configName  string
+	configForce bool
+)
+
+// ConfigCmd is the root command for runtime configuration subcommands.
+var ConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Change the configuration of a running daemon",
+}
+
+// printChanges logs what the daemon changed while applying a config edit.
+func printChanges(r *protocol.ReloadResponse) {
+	for _, line := range formatChanges(r) {
+		logging.Log.Infoln(line)
+	}
+}
+
+// parseOptions turns key=value pairs into a map. Values are parsed as
+// YAML, so numbers, booleans and lists like [a, b] keep their type.
+func parseOptions(pairs []string) (map[string]any, error) {
+	out := make(map[string]any)
+	for _, pair := range pairs {
+		key, raw, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid option %q, expected key=value", pair)
+		}
+		var value any
+		if err := yaml.Unmarshal([]byte(raw), &value); err != nil || value == nil {
+			value = raw
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+// readACLFile reads access rules in the config file format from path.
+func readACLFile(path string) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules any
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// readSecret reads a secret from the first line of standard input, which
+// keeps it out of the shell history.
+func readSecret() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("read secret: %w", err)
+	}
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("empty secret")
+	}
+	return secret, nil
+}
+
+func init() {
+	// persistent options
+	ConfigCmd.PersistentFlags().StringVarP(&daemonName, "daemon", "d", "", "Daemon name from ctl_config")
+	ConfigCmd.PersistentFlags().StringVarP(&controlUser, "user", "u", "admin", "Control user to authenticate as")
+	ConfigCmd.PersistentFlags().StringVar(&overrideAddr, "addr", "", "Direct override address for daemon (unix socket or host:port)")
+	ConfigCmd.PersistentFlags().StringVar(&overrideToken, "token", "", "Auth token for manually specified daemon")
+}
@@ -0,0 +1,135 @@
+// Package cmd provides CLI commands for the geistctl binary.
+// This file defines the "config control" subcommands for opening and
+// closing control listeners of a running daemon.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/controlcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cfgControl         protocol.ControlConfig
+	cfgControlDisabled bool
+)
+
+// configControlCmd is the root command for control instance subcommands.
+var configControlCmd = &cobra.Command{
+	Use:   "control",
+	Short: "Add, remove, enable and disable control listeners",
+}
+
+// configControlListCmd lists the configured control instances.
+var configControlListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List control instances",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		list, err := controlcli.ConfigControlList(cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		for _, line := range formatControlTable(list.Instances) {
+			logging.Log.Infoln(line)
+		}
+	},
+}
+
+// formatControlTable renders control instances as aligned table rows.
+// The instance the command was sent to is marked with "*".
+func formatControlTable(entries []protocol.ControlEntry) []string {
+	var buf strings.Builder
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMODE\tLISTEN\tENABLED")
+	for _, e := range entries {
+		name := e.Name
+		if e.Current {
+			name += " *"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\n", name, e.Mode, e.Listen, e.Enabled)
+	}
+	_ = w.Flush()
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// configControlAddCmd adds a control instance.
+var configControlAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a control instance, started right away unless --disabled",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" || cfgControl.Mode == "" || cfgControl.Listen == "" {
+			logging.Log.Infoln("Please provide -n <name>, --mode <unix|tcp|tls> and --listen <address>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		req := protocol.ControlAddRequest{Name: configName, Control: cfgControl}
+		req.Control.Enabled = !cfgControlDisabled
+		result, err := controlcli.ConfigControlAdd(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// controlNameCmd returns a command sending the name of a control instance
+// with send.
+func controlNameCmd(use, short string, send func(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Run: func(cmd *cobra.Command, args []string) {
+			if configName == "" {
+				logging.Log.Infoln("Please provide -n <name>")
+				return
+			}
+
+			cfg := configloader.MustGetConfig[*configcli.Config]()
+			result, err := send(configName, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+			if err != nil {
+				logging.Log.Errorf("[geistctl] error: %v", err)
+				return
+			}
+			printChanges(result)
+		},
+	}
+}
+
+func init() {
+	configControlCmd.PersistentFlags().StringVarP(&configName, "name", "n", "", "Control instance name")
+
+	f := configControlAddCmd.Flags()
+	f.StringVar(&cfgControl.Mode, "mode", "", "Listener mode: unix, tcp or tls")
+	f.StringVar(&cfgControl.Listen, "listen", "", "Socket path or host:port")
+	f.BoolVar(&cfgControlDisabled, "disabled", false, "Add the instance without starting it")
+	f.StringVar(&cfgControl.TLSCert, "tls-cert", "", "Server certificate (mode tls)")
+	f.StringVar(&cfgControl.TLSKey, "tls-key", "", "Server private key (mode tls)")
+	f.StringVar(&cfgControl.TLSClientCA, "tls-client-ca", "", "CA bundle verifying client certificates (mode tls)")
+	f.StringVar(&cfgControl.TLSClientAuth, "tls-client-auth", "", "Client certificate auth: none, optional or require (mode tls)")
+	f.StringToStringVar(&cfgControl.TLSUsers, "tls-user", nil, "Certificate common name to ACL user, as cn=user (mode tls)")
+	f.StringVar(&cfgControl.SocketOwner, "socket-owner", "", "Owner of the socket file (mode unix)")
+	f.StringVar(&cfgControl.SocketGroup, "socket-group", "", "Group of the socket file (mode unix)")
+	f.StringVar(&cfgControl.SocketMode, "socket-mode", "", "Octal permissions of the socket file, e.g. 0660 (mode unix)")
+	f.BoolVar(&cfgControl.PeerCred, "peercred", false, "Authenticate callers by their uid (mode unix)")
+	f.StringToStringVar(&cfgControl.PeerCredUIDs, "peercred-uid", nil, "Local user to ACL user, as uid=user (mode unix)")
+	f.StringToStringVar(&cfgControl.PeerCredGIDs, "peercred-gid", nil, "Local group to ACL user, as gid=user (mode unix)")
+
+	configControlCmd.AddCommand(configControlListCmd)
+	configControlCmd.AddCommand(configControlAddCmd)
+	configControlCmd.AddCommand(controlNameCmd("remove", "Remove a control instance, draining its connections", controlcli.ConfigControlRemove))
+	configControlCmd.AddCommand(controlNameCmd("enable", "Enable and start a control instance", controlcli.ConfigControlEnable))
+	configControlCmd.AddCommand(controlNameCmd("disable", "Disable a control instance, draining its connections", controlcli.ConfigControlDisable))
+	ConfigCmd.AddCommand(configControlCmd)
+}
@@ -0,0 +1,249 @@
+// Package cmd provides CLI commands for the geistctl binary.
+// This file defines the "config host" subcommands for adding, changing
+// and removing hosts of a running daemon.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/controlcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cfgHost        protocol.HostConfig
+	cfgHostOptions []string
+	cfgHostACLs    string
+)
+
+// hostFlagFields maps the host flags to the fields they set.
+var hostFlagFields = map[string]string{
+	"address":     "address",
+	"port":        "port",
+	"login":       "login",
+	"backend":     "backend",
+	"option":      "config",
+	"allow":       "allowed_proxies",
+	"host-key":    "host_key",
+	"known-hosts": "known_hosts",
+	"policy":      "host_key_policy",
+	"acls":        "acls",
+}
+
+// configHostCmd is the root command for host configuration subcommands.
+var configHostCmd = &cobra.Command{
+	Use:   "host",
+	Short: "Add, change and remove hosts",
+}
+
+// configHostListCmd lists the configured hosts.
+var configHostListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List configured hosts",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		list, err := controlcli.ConfigHostList(cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		if len(list.Hosts) == 0 {
+			logging.Log.Warnln("No hosts available.")
+			return
+		}
+
+		for _, line := range formatHostTable(list.Hosts) {
+			logging.Log.Infoln(line)
+		}
+	},
+}
+
+// formatHostTable renders host entries as aligned table rows.
+func formatHostTable(entries []protocol.HostEntry) []string {
+	var buf strings.Builder
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tADDRESS\tLOGIN\tBACKEND\tALLOWED PROXIES\tACTIVE FOR")
+	for _, e := range entries {
+		address := e.Address
+		if e.Port != 0 {
+			address = fmt.Sprintf("%s:%d", e.Address, e.Port)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Name, address, orDash(e.Login), orDash(e.Backend),
+			orDash(strings.Join(e.AllowedProxies, ",")), orDash(strings.Join(e.ActiveFor, ",")))
+	}
+	_ = w.Flush()
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// configHostGetCmd shows the definition of a host.
+var configHostGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Show the definition of a host",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <host>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		h, err := controlcli.ConfigHostGet(configName, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		logging.Log.Infof("Name:            %s\nAddress:         %s\nPort:            %d\nLogin:           %s\nBackend:         %s\nAllowed Proxies: %s\nActive For:      %s\n",
+			h.Name, h.Address, h.Port, orDash(h.Login), orDash(h.Backend),
+			orDash(strings.Join(h.AllowedProxies, ", ")), orDash(strings.Join(h.ActiveFor, ", ")))
+		if h.HostKeyPolicy != "" {
+			logging.Log.Infof("Host Key Policy: %s\n", h.HostKeyPolicy)
+		}
+		if h.HostKey != "" {
+			logging.Log.Infof("Host Key:        %s\n", h.HostKey)
+		}
+		if h.KnownHosts != "" {
+			logging.Log.Infof("Known Hosts:     %s\n", h.KnownHosts)
+		}
+		for k, v := range h.Config {
+			logging.Log.Infof("Option:          %s=%v\n", k, v)
+		}
+		if h.ACLs != nil {
+			logging.Log.Infof("ACLs:            %v\n", h.ACLs)
+		}
+	},
+}
+
+// hostEditRequest builds the request for the host flags given on cmd.
+// Unless all is set, only the fields of the changed flags are sent.
+func hostEditRequest(cmd *cobra.Command, all bool) (protocol.HostEditRequest, error) {
+	req := protocol.HostEditRequest{Name: configName, Host: cfgHost}
+	if cmd.Flags().Changed("option") {
+		options, err := parseOptions(cfgHostOptions)
+		if err != nil {
+			return req, err
+		}
+		req.Host.Config = options
+	}
+	if cfgHostACLs != "" {
+		rules, err := readACLFile(cfgHostACLs)
+		if err != nil {
+			return req, err
+		}
+		req.Host.ACLs = rules
+	}
+	if all {
+		return req, nil
+	}
+	for flag, field := range hostFlagFields {
+		if cmd.Flags().Changed(flag) {
+			req.Fields = append(req.Fields, field)
+		}
+	}
+	if len(req.Fields) == 0 {
+		return req, fmt.Errorf("nothing to change")
+	}
+	return req, nil
+}
+
+// configHostAddCmd adds a host.
+var configHostAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a host",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" || cfgHost.Address == "" {
+			logging.Log.Infoln("Please provide -n <host> and --address <address>")
+			return
+		}
+		req, err := hostEditRequest(cmd, true)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigHostAdd(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configHostUpdateCmd changes the given fields of a host.
+var configHostUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Change fields of a host, affected proxies are restarted",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <host>")
+			return
+		}
+		req, err := hostEditRequest(cmd, false)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigHostUpdate(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configHostRemoveCmd removes a host.
+var configHostRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove a host",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <host>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigHostRemove(configName, configForce, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+func init() {
+	configHostCmd.PersistentFlags().StringVarP(&configName, "name", "n", "", "Host name")
+
+	for _, c := range []*cobra.Command{configHostAddCmd, configHostUpdateCmd} {
+		c.Flags().StringVar(&cfgHost.Address, "address", "", "Address of the host")
+		c.Flags().IntVar(&cfgHost.Port, "port", 0, "SSH port of the host")
+		c.Flags().StringVar(&cfgHost.Login, "login", "", "Login used to connect")
+		c.Flags().StringVar(&cfgHost.Backend, "backend", "", "Backend running the proxies")
+		c.Flags().StringArrayVar(&cfgHostOptions, "option", nil, "Backend option as key=value (repeatable)")
+		c.Flags().StringSliceVar(&cfgHost.AllowedProxies, "allow", nil, "Proxies allowed to use the host")
+		c.Flags().StringVar(&cfgHost.HostKey, "host-key", "", "Pinned host key")
+		c.Flags().StringVar(&cfgHost.KnownHosts, "known-hosts", "", "known_hosts file of the host")
+		c.Flags().StringVar(&cfgHost.HostKeyPolicy, "policy", "", "Host key policy")
+		c.Flags().StringVar(&cfgHostACLs, "acls", "", "YAML file with the access rules of the host")
+	}
+	configHostRemoveCmd.Flags().BoolVar(&configForce, "force", false, "Remove the host even if proxies run on it or use it as default or fallback")
+
+	configHostCmd.AddCommand(configHostListCmd)
+	configHostCmd.AddCommand(configHostGetCmd)
+	configHostCmd.AddCommand(configHostAddCmd)
+	configHostCmd.AddCommand(configHostUpdateCmd)
+	configHostCmd.AddCommand(configHostRemoveCmd)
+	ConfigCmd.AddCommand(configHostCmd)
+}
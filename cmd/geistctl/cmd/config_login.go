@@ -0,0 +1,204 @@
+// Package cmd provides CLI commands for the geistctl binary.
+// This file defines the "config login" subcommands for adding, rotating
+// and removing SSH logins of a running daemon.
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/controlcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cfgLogin         protocol.LoginConfig
+	cfgPasswordStdin bool
+)
+
+// loginFlagFields maps the login flags to the fields they set.
+var loginFlagFields = map[string]string{
+	"ssh-user":       "user",
+	"password":       "password",
+	"password-stdin": "password",
+	"key-file":       "key_file",
+	"passphrase":     "passphrase",
+	"certificate":    "certificate",
+	"agent":          "agent",
+}
+
+// configLoginCmd is the root command for login configuration subcommands.
+var configLoginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Add, rotate and remove SSH logins",
+}
+
+// configLoginListCmd lists the configured logins without their secrets.
+var configLoginListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List configured logins",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		list, err := controlcli.ConfigLoginList(cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		if len(list.Logins) == 0 {
+			logging.Log.Warnln("No logins available.")
+			return
+		}
+
+		for _, line := range formatLoginTable(list.Logins) {
+			logging.Log.Infoln(line)
+		}
+	},
+}
+
+// formatLoginTable renders login entries as aligned table rows.
+func formatLoginTable(entries []protocol.LoginEntry) []string {
+	var buf strings.Builder
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tUSER\tAUTH\tHOSTS")
+	for _, e := range entries {
+		var auth []string
+		if e.HasPassword {
+			auth = append(auth, "password")
+		}
+		if e.KeyFile != "" {
+			auth = append(auth, "key "+e.KeyFile)
+		}
+		if e.Certificate != "" {
+			auth = append(auth, "certificate")
+		}
+		if e.Agent {
+			auth = append(auth, "agent")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			e.Name, e.User, orDash(strings.Join(auth, ",")), orDash(strings.Join(e.Hosts, ",")))
+	}
+	_ = w.Flush()
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// loginEditRequest builds the request for the login flags given on cmd.
+// Unless all is set, only the fields of the changed flags are sent.
+func loginEditRequest(cmd *cobra.Command, all bool) (protocol.LoginEditRequest, error) {
+	req := protocol.LoginEditRequest{Name: configName, Login: cfgLogin}
+	if cfgPasswordStdin {
+		password, err := readSecret()
+		if err != nil {
+			return req, err
+		}
+		req.Login.Password = password
+	}
+	if all {
+		return req, nil
+	}
+	for flag, field := range loginFlagFields {
+		if cmd.Flags().Changed(flag) && !slices.Contains(req.Fields, field) {
+			req.Fields = append(req.Fields, field)
+		}
+	}
+	if len(req.Fields) == 0 {
+		return req, fmt.Errorf("nothing to change")
+	}
+	return req, nil
+}
+
+// configLoginAddCmd adds a login.
+var configLoginAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add an SSH login",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" || cfgLogin.User == "" {
+			logging.Log.Infoln("Please provide -n <login> and --ssh-user <user>")
+			return
+		}
+		req, err := loginEditRequest(cmd, true)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigLoginAdd(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configLoginUpdateCmd changes or rotates the credentials of a login.
+var configLoginUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Change or rotate credentials of a login, affected proxies are restarted",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <login>")
+			return
+		}
+		req, err := loginEditRequest(cmd, false)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigLoginUpdate(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configLoginRemoveCmd removes a login.
+var configLoginRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove a login no host uses",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <login>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigLoginRemove(configName, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+func init() {
+	configLoginCmd.PersistentFlags().StringVarP(&configName, "name", "n", "", "Login name")
+
+	for _, c := range []*cobra.Command{configLoginAddCmd, configLoginUpdateCmd} {
+		c.Flags().StringVar(&cfgLogin.User, "ssh-user", "", "SSH user name")
+		c.Flags().StringVar(&cfgLogin.Password, "password", "", "SSH password (prefer --password-stdin)")
+		c.Flags().BoolVar(&cfgPasswordStdin, "password-stdin", false, "Read the SSH password from standard input")
+		c.Flags().StringVar(&cfgLogin.KeyFile, "key-file", "", "Private key file on the daemon host")
+		c.Flags().StringVar(&cfgLogin.Passphrase, "passphrase", "", "Passphrase of the key file")
+		c.Flags().StringVar(&cfgLogin.Certificate, "certificate", "", "OpenSSH user certificate for the key file")
+		c.Flags().BoolVar(&cfgLogin.Agent, "agent", false, "Use the ssh-agent of the daemon")
+	}
+
+	configLoginCmd.AddCommand(configLoginListCmd)
+	configLoginCmd.AddCommand(configLoginAddCmd)
+	configLoginCmd.AddCommand(configLoginUpdateCmd)
+	configLoginCmd.AddCommand(configLoginRemoveCmd)
+	ConfigCmd.AddCommand(configLoginCmd)
+}
@@ -0,0 +1,151 @@
+// Package cmd provides CLI commands for the geistctl binary.
+// This file defines the "config proxy" subcommands for adding, changing
+// and removing proxies of a running daemon.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/controlcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cfgProxy     protocol.ProxyConfig
+	cfgProxyACLs string
+)
+
+// proxyFlagFields maps the proxy flags to the fields they set.
+var proxyFlagFields = map[string]string{
+	"port":           "port",
+	"default":        "default",
+	"fallback":       "fallback",
+	"failover-after": "failover_after",
+	"health-target":  "health_target",
+	"autostart":      "autostart",
+	"acls":           "acls",
+}
+
+// configProxyCmd is the root command for proxy configuration subcommands.
+var configProxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Add, change and remove proxies",
+}
+
+// proxyEditRequest builds the request for the proxy flags given on cmd.
+// Unless all is set, only the fields of the changed flags are sent.
+func proxyEditRequest(cmd *cobra.Command, all bool) (protocol.ProxyEditRequest, error) {
+	req := protocol.ProxyEditRequest{Name: configName, Proxy: cfgProxy}
+	if cfgProxyACLs != "" {
+		rules, err := readACLFile(cfgProxyACLs)
+		if err != nil {
+			return req, err
+		}
+		req.Proxy.ACLs = rules
+	}
+	if all {
+		return req, nil
+	}
+	for flag, field := range proxyFlagFields {
+		if cmd.Flags().Changed(flag) {
+			req.Fields = append(req.Fields, field)
+		}
+	}
+	if len(req.Fields) == 0 {
+		return req, fmt.Errorf("nothing to change")
+	}
+	return req, nil
+}
+
+// configProxyAddCmd adds a proxy.
+var configProxyAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a proxy, started right away with --autostart",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" || cfgProxy.Port == 0 || cfgProxy.Default == "" {
+			logging.Log.Infoln("Please provide -n <proxy>, --port <port> and --default <host>")
+			return
+		}
+		req, err := proxyEditRequest(cmd, true)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigProxyAdd(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configProxyUpdateCmd changes the given fields of a proxy.
+var configProxyUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Change fields of a proxy, a running proxy is restarted if needed",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <proxy>")
+			return
+		}
+		req, err := proxyEditRequest(cmd, false)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigProxyUpdate(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configProxyRemoveCmd removes a proxy.
+var configProxyRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove a proxy, stopping it if it is running",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <proxy>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigProxyRemove(configName, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+func init() {
+	configProxyCmd.PersistentFlags().StringVarP(&configName, "name", "n", "", "Proxy name")
+
+	for _, c := range []*cobra.Command{configProxyAddCmd, configProxyUpdateCmd} {
+		c.Flags().IntVar(&cfgProxy.Port, "port", 0, "Local port of the proxy")
+		c.Flags().StringVar(&cfgProxy.Default, "default", "", "Default host")
+		c.Flags().StringSliceVar(&cfgProxy.Fallback, "fallback", nil, "Ordered fallback hosts")
+		c.Flags().IntVar(&cfgProxy.FailoverAfter, "failover-after", 0, "Tunnel exits on one host before failing over")
+		c.Flags().StringVar(&cfgProxy.HealthTarget, "health-target", "", "Health probe target (host:port)")
+		c.Flags().BoolVar(&cfgProxy.Autostart, "autostart", false, "Start the proxy with the daemon")
+		c.Flags().StringVar(&cfgProxyACLs, "acls", "", "YAML file with the access rules of the proxy")
+	}
+
+	configProxyCmd.AddCommand(configProxyAddCmd)
+	configProxyCmd.AddCommand(configProxyUpdateCmd)
+	configProxyCmd.AddCommand(configProxyRemoveCmd)
+	ConfigCmd.AddCommand(configProxyCmd)
+}
@@ -0,0 +1,382 @@
+// Package cmd provides CLI commands for the geistctl binary.
+// This file defines the "config user" subcommands for managing control
+// users, their tokens, groups and roles on a running daemon.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/controlcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cfgUserRoles  []string
+	cfgUserGroups []string
+	cfgGroup      protocol.GroupConfig
+	cfgRole       protocol.RoleConfig
+)
+
+// changedFields returns the fields of the flags given on cmd.
+func changedFields(cmd *cobra.Command, flagFields map[string]string) ([]string, error) {
+	var fields []string
+	for flag, field := range flagFields {
+		if cmd.Flags().Changed(flag) {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("nothing to change")
+	}
+	return fields, nil
+}
+
+// printToken shows a newly issued token. It is not stored anywhere else.
+func printToken(r *protocol.UserTokenResponse) {
+	logging.Log.Infof("Token for '%s' (shown only once, store it now): %s\n", r.Name, r.Token)
+	if r.Changes != nil {
+		printChanges(r.Changes)
+	}
+}
+
+// configUserCmd is the root command for control user subcommands.
+var configUserCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage control users, tokens, groups and roles",
+}
+
+// configUserListCmd lists the control users, groups and roles.
+var configUserListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List control users, groups and roles",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		list, err := controlcli.ConfigUserList(cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		for _, line := range formatUserTable(list) {
+			logging.Log.Infoln(line)
+		}
+	},
+}
+
+// formatUserTable renders users, groups and roles as aligned tables.
+func formatUserTable(list *protocol.UserListResponse) []string {
+	var buf strings.Builder
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tTOKEN\tROLES\tGROUPS")
+	for _, u := range list.Users {
+		token := "set"
+		if !u.HasToken {
+			token = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", u.Name, token, orDash(strings.Join(u.Roles, ",")), orDash(strings.Join(u.Groups, ",")))
+	}
+	_ = w.Flush()
+	if len(list.Groups) > 0 {
+		fmt.Fprintln(&buf)
+		fmt.Fprintln(w, "GROUP\tROLES\tGROUPS\tMEMBERS")
+		for _, g := range list.Groups {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", g.Name, orDash(strings.Join(g.Roles, ",")), orDash(strings.Join(g.Groups, ",")), orDash(strings.Join(g.Members, ",")))
+		}
+		_ = w.Flush()
+	}
+	if len(list.Roles) > 0 {
+		fmt.Fprintln(&buf)
+		fmt.Fprintln(w, "ROLE\tEXTENDS\tPERMISSIONS")
+		for _, r := range list.Roles {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, orDash(strings.Join(r.Extends, ",")), orDash(strings.Join(r.Permissions, ",")))
+		}
+		_ = w.Flush()
+	}
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// configUserAddCmd adds a control user and shows its token.
+var configUserAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a control user with a new token",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <user>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		req := protocol.UserEditRequest{Name: configName, Roles: cfgUserRoles, Groups: cfgUserGroups}
+		result, err := controlcli.ConfigUserAdd(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printToken(result)
+	},
+}
+
+// configUserUpdateCmd changes the roles or groups of a control user.
+var configUserUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Change roles or group memberships of a control user",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <user>")
+			return
+		}
+		fields, err := changedFields(cmd, map[string]string{"roles": "roles", "groups": "groups"})
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		req := protocol.UserEditRequest{Name: configName, Roles: cfgUserRoles, Groups: cfgUserGroups, Fields: fields}
+		result, err := controlcli.ConfigUserUpdate(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configUserRotateCmd replaces the token of a control user.
+var configUserRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Issue a new token for a control user and revoke its sessions",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <user>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigUserRotate(configName, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printToken(result)
+	},
+}
+
+// configUserRemoveCmd removes a control user.
+var configUserRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove a control user",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <user>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigUserRemove(configName, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configGroupCmd is the root command for control group subcommands.
+var configGroupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Add, change and remove control groups",
+}
+
+// groupFlagFields maps the group flags to the fields they set.
+var groupFlagFields = map[string]string{
+	"members": "members",
+	"groups":  "groups",
+	"roles":   "roles",
+}
+
+// configGroupAddCmd adds a control group.
+var configGroupAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a control group",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <group>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		req := protocol.GroupEditRequest{Name: configName, Group: cfgGroup}
+		result, err := controlcli.ConfigGroupAdd(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configGroupUpdateCmd changes the given fields of a control group.
+var configGroupUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Change members, nested groups or roles of a control group",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <group>")
+			return
+		}
+		fields, err := changedFields(cmd, groupFlagFields)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		req := protocol.GroupEditRequest{Name: configName, Group: cfgGroup, Fields: fields}
+		result, err := controlcli.ConfigGroupUpdate(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configGroupRemoveCmd removes a control group.
+var configGroupRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove a control group",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <group>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigGroupRemove(configName, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configRoleCmd is the root command for control role subcommands.
+var configRoleCmd = &cobra.Command{
+	Use:   "role",
+	Short: "Add, change and remove control roles",
+}
+
+// roleFlagFields maps the role flags to the fields they set.
+var roleFlagFields = map[string]string{
+	"permissions": "permissions",
+	"extends":     "extends",
+}
+
+// configRoleAddCmd adds a control role.
+var configRoleAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a control role",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <role>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		req := protocol.RoleEditRequest{Name: configName, Role: cfgRole}
+		result, err := controlcli.ConfigRoleAdd(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configRoleUpdateCmd changes the given fields of a control role.
+var configRoleUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Change permissions or parents of a control role",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <role>")
+			return
+		}
+		fields, err := changedFields(cmd, roleFlagFields)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		req := protocol.RoleEditRequest{Name: configName, Role: cfgRole, Fields: fields}
+		result, err := controlcli.ConfigRoleUpdate(req, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+// configRoleRemoveCmd removes a control role.
+var configRoleRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove a control role no user, group or role references",
+	Run: func(cmd *cobra.Command, args []string) {
+		if configName == "" {
+			logging.Log.Infoln("Please provide -n <role>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.ConfigRoleRemove(configName, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		printChanges(result)
+	},
+}
+
+func init() {
+	configUserCmd.PersistentFlags().StringVarP(&configName, "name", "n", "", "User, group or role name")
+
+	for _, c := range []*cobra.Command{configUserAddCmd, configUserUpdateCmd} {
+		c.Flags().StringSliceVar(&cfgUserRoles, "roles", nil, "Roles of the user")
+		c.Flags().StringSliceVar(&cfgUserGroups, "groups", nil, "Groups the user is a member of")
+	}
+	for _, c := range []*cobra.Command{configGroupAddCmd, configGroupUpdateCmd} {
+		c.Flags().StringSliceVar(&cfgGroup.Members, "members", nil, "Member users")
+		c.Flags().StringSliceVar(&cfgGroup.Groups, "groups", nil, "Nested groups whose members belong to this group")
+		c.Flags().StringSliceVar(&cfgGroup.Roles, "roles", nil, "Roles of the group")
+	}
+	for _, c := range []*cobra.Command{configRoleAddCmd, configRoleUpdateCmd} {
+		c.Flags().StringSliceVar(&cfgRole.Permissions, "permissions", nil, "Granted permissions")
+		c.Flags().StringSliceVar(&cfgRole.Extends, "extends", nil, "Roles whose permissions are inherited")
+	}
+
+	configGroupCmd.AddCommand(configGroupAddCmd)
+	configGroupCmd.AddCommand(configGroupUpdateCmd)
+	configGroupCmd.AddCommand(configGroupRemoveCmd)
+	configRoleCmd.AddCommand(configRoleAddCmd)
+	configRoleCmd.AddCommand(configRoleUpdateCmd)
+	configRoleCmd.AddCommand(configRoleRemoveCmd)
+
+	configUserCmd.AddCommand(configUserListCmd)
+	configUserCmd.AddCommand(configUserAddCmd)
+	configUserCmd.AddCommand(configUserUpdateCmd)
+	configUserCmd.AddCommand(configUserRotateCmd)
+	configUserCmd.AddCommand(configUserRemoveCmd)
+	configUserCmd.AddCommand(configGroupCmd)
+	configUserCmd.AddCommand(configRoleCmd)
+	ConfigCmd.AddCommand(configUserCmd)
+}
@@ -0,0 +1,72 @@
+// Package cmd provides CLI commands for the geistctl binary.
+// This file defines the "events" command that follows the daemon's
+// event stream.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/controlcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"github.com/spf13/cobra"
+)
+
+var (
+	eventTypes   []string
+	eventProxies []string
+)
+
+// EventsCmd prints daemon events as they happen.
+var EventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Follow proxy state changes and other daemon events",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		filter := protocol.SubscribeRequest{Types: eventTypes, Proxies: eventProxies}
+		err := controlcli.Subscribe(filter, cfg, daemonName, overrideAddr, overrideToken, controlUser, func(ev protocol.Event) {
+			logging.Log.Infoln(formatEvent(ev))
+		})
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+		}
+	},
+}
+
+// formatEvent renders an event as a single line.
+func formatEvent(ev protocol.Event) string {
+	parts := []string{ev.Time, ev.Type}
+	if ev.Proxy != "" {
+		parts = append(parts, fmt.Sprintf("proxy=%s", ev.Proxy))
+	}
+	if ev.PrevHost != "" {
+		parts = append(parts, fmt.Sprintf("host=%s->%s", ev.PrevHost, ev.Host))
+	} else if ev.Host != "" {
+		parts = append(parts, fmt.Sprintf("host=%s", ev.Host))
+	}
+	if ev.Health != "" {
+		parts = append(parts, fmt.Sprintf("health=%s", ev.Health))
+	}
+	if ev.User != "" {
+		parts = append(parts, fmt.Sprintf("user=%s", ev.User))
+	}
+	if ev.Instance != "" {
+		parts = append(parts, fmt.Sprintf("instance=%s", ev.Instance))
+	}
+	if ev.Message != "" {
+		parts = append(parts, fmt.Sprintf("(%s)", ev.Message))
+	}
+	return strings.Join(parts, " ")
+}
+
+func init() {
+	EventsCmd.Flags().StringSliceVarP(&eventTypes, "type", "t", nil, "Only show events of these types (e.g. proxy.failed)")
+	EventsCmd.Flags().StringSliceVarP(&eventProxies, "proxy", "p", nil, "Only show events of these proxies")
+	EventsCmd.Flags().StringVarP(&daemonName, "daemon", "d", "", "Daemon name from ctl_config")
+	EventsCmd.Flags().StringVarP(&controlUser, "user", "u", "admin", "Control user to authenticate as")
+	EventsCmd.Flags().StringVar(&overrideAddr, "addr", "", "Direct override address for daemon (unix socket or host:port)")
+	EventsCmd.Flags().StringVar(&overrideToken, "token", "", "Auth token for manually specified daemon")
+}
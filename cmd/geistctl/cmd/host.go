@@ -0,0 +1,84 @@
+// Package cmd provides CLI commands for the geistctl binary.
+// This file defines the "host" subcommands for inspecting and approving
+// SSH host keys recorded by the daemon.
+package cmd
+
+import (
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/controlcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+var (
+	hostName        string
+	hostFingerprint string
+	hostScan        bool
+)
+
+// HostCmd is the root command for host-related subcommands.
+var HostCmd = &cobra.Command{
+	Use:   "host",
+	Short: "Inspect and trust SSH host keys",
+}
+
+// hostFingerprintsCmd lists trusted and pending host keys.
+var hostFingerprintsCmd = &cobra.Command{
+	Use:   "fingerprints",
+	Short: "Show trusted and pending host key fingerprints",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		keys, err := controlcli.HostKeys(hostName, hostScan, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+
+		for _, h := range keys.Hosts {
+			logging.Log.Infof("Host: %s (%s)\nPolicy: %s\n", h.Host, h.Address, h.Policy)
+			if h.Pinned != "" {
+				logging.Log.Infof("  pinned:  %s\n", h.Pinned)
+			}
+			for _, k := range h.Trusted {
+				logging.Log.Infof("  trusted: %s %s\n", k.Type, k.Fingerprint)
+			}
+			for _, k := range h.Pending {
+				logging.Log.Infof("  pending: %s %s (%s, seen %s)\n", k.Type, k.Fingerprint, k.Reason, k.SeenAt)
+			}
+		}
+	},
+}
+
+// hostTrustCmd approves a pending host key.
+var hostTrustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "Trust a pending host key by fingerprint",
+	Run: func(cmd *cobra.Command, args []string) {
+		if hostName == "" || hostFingerprint == "" {
+			logging.Log.Infoln("Please provide -o <host> and -f <fingerprint>")
+			return
+		}
+
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		if err := controlcli.TrustHostKey(hostName, hostFingerprint, cfg, daemonName, overrideAddr, overrideToken, controlUser); err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+		}
+	},
+}
+
+func init() {
+	// persistent options
+	HostCmd.PersistentFlags().StringVarP(&hostName, "host", "o", "", "Host name")
+	HostCmd.PersistentFlags().StringVarP(&daemonName, "daemon", "d", "", "Daemon name from ctl_config")
+	HostCmd.PersistentFlags().StringVarP(&controlUser, "user", "u", "admin", "Control user to authenticate as")
+	HostCmd.PersistentFlags().StringVar(&overrideAddr, "addr", "", "Direct override address for daemon (unix socket or host:port)")
+	HostCmd.PersistentFlags().StringVar(&overrideToken, "token", "", "Auth token for manually specified daemon")
+
+	hostFingerprintsCmd.Flags().BoolVar(&hostScan, "scan", false, "Connect to the host and record its current key as pending")
+	hostTrustCmd.Flags().StringVarP(&hostFingerprint, "fingerprint", "f", "", "SHA256 fingerprint of the key to trust")
+
+	// attach commands
+	HostCmd.AddCommand(hostFingerprintsCmd)
+	HostCmd.AddCommand(hostTrustCmd)
+}
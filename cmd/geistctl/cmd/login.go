@@ -0,0 +1,63 @@
+// Package cmd provides CLI commands for the geistctl binary.
+// This file defines the "login" and "logout" commands managing session
+// tokens cached per daemon.
+package cmd
+
+import (
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/controlcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+var (
+	loginTTL   string
+	loginScope []string
+)
+
+// LoginCmd exchanges the configured token for a cached session token.
+var LoginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Obtain a short-lived session token for a daemon",
+	Long: `Obtain a short-lived session token for a daemon. Until it expires or
+"geistctl logout" is run, commands against that daemon authenticate with
+the session instead of the long-lived token.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		login, err := controlcli.Login(loginTTL, loginScope, cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		logging.Log.Infof("Logged in as '%s' (session %s), expires %s", login.User, login.Session, login.ExpiresAt)
+		if len(login.Scope) > 0 {
+			logging.Log.Infof("Scope: %v", login.Scope)
+		}
+	},
+}
+
+// LogoutCmd revokes the cached session token.
+var LogoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Revoke the cached session token for a daemon",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		if err := controlcli.Logout(cfg, daemonName, overrideAddr, controlUser); err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		logging.Log.Infoln("Logged out")
+	},
+}
+
+func init() {
+	for _, c := range []*cobra.Command{LoginCmd, LogoutCmd} {
+		c.Flags().StringVarP(&daemonName, "daemon", "d", "", "Daemon name from ctl_config")
+		c.Flags().StringVarP(&controlUser, "user", "u", "admin", "Control user to authenticate as")
+		c.Flags().StringVar(&overrideAddr, "addr", "", "Direct override address for daemon (unix socket or host:port)")
+		c.Flags().StringVar(&overrideToken, "token", "", "Auth token for manually specified daemon")
+	}
+	LoginCmd.Flags().StringVar(&loginTTL, "ttl", "", "Session lifetime, e.g. 30m (daemon default if empty)")
+	LoginCmd.Flags().StringSliceVar(&loginScope, "scope", nil, "Limit the session to commands matching these patterns, e.g. proxy.status,proxy.list")
+}
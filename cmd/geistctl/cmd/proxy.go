@@ -4,6 +4,10 @@
 package cmd
 
 import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
 	"github.com/mfulz/portgeist/internal/configcli"
 	"github.com/mfulz/portgeist/internal/configloader"
 	"github.com/mfulz/portgeist/internal/controlcli"
@@ -51,6 +55,18 @@ var proxyStopCmd = &cobra.Command{
 	},
 }
 
+// proxyResetCmd clears the failed state of a proxy.
+var proxyResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Clear the failed state and restart history of a proxy",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		if err := controlcli.ResetProxy(proxyName, cfg, daemonName, overrideAddr, overrideToken, controlUser); err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+		}
+	},
+}
+
 // proxyStatusCmd shows status info about a proxy.
 var proxyStatusCmd = &cobra.Command{
 	Use:   "status",
@@ -63,8 +79,25 @@ var proxyStatusCmd = &cobra.Command{
 			return
 		}
 
-		logging.Log.Infof("Proxy: %s\nBackend: %s\nRunning: %v\nPID: %d\nActive Host: %s\n",
-			status.Name, status.Backend, status.Running, status.PID, status.ActiveHost)
+		logging.Log.Infof("Proxy: %s\nBackend: %s\nState: %s\nRunning: %v\nPID: %d\nActive Host: %s\nCandidates: %v (index %d)\nRestarts: %d\n",
+			status.Name, status.Backend, status.State, status.Running, status.PID, status.ActiveHost,
+			status.Candidates, status.CandidateIndex, status.Restarts)
+		if status.NextRestart != "" {
+			logging.Log.Infof("Next Restart: %s\n", status.NextRestart)
+		}
+		if status.LastError != "" {
+			logging.Log.Infof("Last Error: %s\n", status.LastError)
+		}
+		if status.Health != "" {
+			logging.Log.Infof("Health: %s (latency %dms, last probe %s, failures %d)\n",
+				status.Health, status.LatencyMs, status.LastProbe, status.HealthFailures)
+			if status.HealthError != "" {
+				logging.Log.Infof("Health Error: %s\n", status.HealthError)
+			}
+		}
+		for _, s := range status.Skipped {
+			logging.Log.Infof("Skipped: %s at %s: %s\n", s.Host, s.At, s.Reason)
+		}
 	},
 }
 
@@ -103,13 +136,33 @@ var proxyListCmd = &cobra.Command{
 			return
 		}
 
-		logging.Log.Infoln("Available proxies:")
-		for _, name := range list.Proxies {
-			logging.Log.Infof(" - %s\n", name)
+		for _, line := range formatProxyTable(list.Proxies) {
+			logging.Log.Infoln(line)
 		}
 	},
 }
 
+// formatProxyTable renders proxy list entries as aligned table rows.
+func formatProxyTable(entries []protocol.ProxyListEntry) []string {
+	var buf strings.Builder
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPORT\tSTATE\tACTIVE HOST\tHEALTH\tALLOWED HOSTS")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n",
+			e.Name, e.Port, e.State, orDash(e.ActiveHost), orDash(e.Health), orDash(strings.Join(e.AllowedHosts, ",")))
+	}
+	_ = w.Flush()
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// orDash returns s or "-" if s is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
 // proxySetActiveCmd sets the active host for a given proxy.
 var proxySetActiveCmd = &cobra.Command{
 	Use:   "setactive",
@@ -177,4 +230,5 @@ func init() {
 	ProxyCmd.AddCommand(proxyInfoCmd)
 	ProxyCmd.AddCommand(proxyListCmd)
 	ProxyCmd.AddCommand(proxySetActiveCmd)
+	ProxyCmd.AddCommand(proxyResetCmd)
 }
@@ -0,0 +1,76 @@
+// Package cmd provides CLI commands for the geistctl binary.
+// This file defines the "reload" command applying a changed daemon config.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/controlcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"github.com/spf13/cobra"
+)
+
+// ReloadCmd makes the daemon re-read its configuration file.
+var ReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Reload the daemon configuration",
+	Long: `Reload the daemon configuration file. Only proxies whose effective
+configuration changed are restarted; an invalid file is rejected and the
+running configuration is kept.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := configloader.MustGetConfig[*configcli.Config]()
+		result, err := controlcli.Reload(cfg, daemonName, overrideAddr, overrideToken, controlUser)
+		if err != nil {
+			logging.Log.Errorf("[geistctl] error: %v", err)
+			return
+		}
+		for _, line := range formatReload(result) {
+			logging.Log.Infoln(line)
+		}
+	},
+}
+
+// formatReload renders the changes applied by a reload.
+func formatReload(r *protocol.ReloadResponse) []string {
+	changes := formatChanges(r)
+	if len(changes) == 0 {
+		return []string{"Configuration reloaded, nothing changed"}
+	}
+	return append([]string{"Configuration reloaded"}, changes...)
+}
+
+// formatChanges renders one line per kind of change applied by a reload
+// or config edit.
+func formatChanges(r *protocol.ReloadResponse) []string {
+	var lines []string
+	add := func(label string, names []string) {
+		if len(names) > 0 {
+			lines = append(lines, fmt.Sprintf("  %-20s %s", label+":", strings.Join(names, ", ")))
+		}
+	}
+	add("proxies restarted", r.ProxiesRestarted)
+	add("proxies started", r.ProxiesStarted)
+	add("proxies stopped", r.ProxiesStopped)
+	add("controls restarted", r.ControlsRestarted)
+	add("controls started", r.ControlsStarted)
+	add("controls stopped", r.ControlsStopped)
+	if r.ACLChanged {
+		lines = append(lines, "  acl rules updated")
+	}
+	add("restart required", r.RestartRequired)
+	for _, e := range r.Errors {
+		lines = append(lines, "  error: "+e)
+	}
+	return lines
+}
+
+func init() {
+	ReloadCmd.Flags().StringVarP(&daemonName, "daemon", "d", "", "Daemon name from ctl_config")
+	ReloadCmd.Flags().StringVarP(&controlUser, "user", "u", "admin", "Control user to authenticate as")
+	ReloadCmd.Flags().StringVar(&overrideAddr, "addr", "", "Direct override address for daemon (unix socket or host:port)")
+	ReloadCmd.Flags().StringVar(&overrideToken, "token", "", "Auth token for manually specified daemon")
+}
@@ -35,4 +35,12 @@ func main() {
 func init() {
 	rootCmd.AddCommand(cmd.ProxyCmd)
 	rootCmd.AddCommand(cmd.LaunchCmd)
+	rootCmd.AddCommand(cmd.HostCmd)
+	rootCmd.AddCommand(cmd.EventsCmd)
+	rootCmd.AddCommand(cmd.LoginCmd)
+	rootCmd.AddCommand(cmd.LogoutCmd)
+	rootCmd.AddCommand(cmd.AuditCmd)
+	rootCmd.AddCommand(cmd.ACLCmd)
+	rootCmd.AddCommand(cmd.ReloadCmd)
+	rootCmd.AddCommand(cmd.ConfigCmd)
 }
@@ -0,0 +1,62 @@
+// Package cmd provides CLI commands for the geistd binary besides running
+// the daemon itself. This file defines the "acl" helper subcommands.
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+var hashAlgorithm string
+
+// ACLCmd is the root command for ACL helpers.
+var ACLCmd = &cobra.Command{
+	Use:   "acl",
+	Short: "Access control helpers",
+}
+
+// aclHashTokenCmd prints the hash of a token for use in the config.
+var aclHashTokenCmd = &cobra.Command{
+	Use:   "hash-token [token]",
+	Short: "Hash a control token for the acl.users section or a credentials file",
+	Long: `Hash a control token for the acl.users section or a credentials file.
+The token is read from standard input if not given as argument, which keeps
+it out of the shell history.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var token string
+		if len(args) == 1 {
+			token = args[0]
+		} else {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				logging.Log.Errorf("[geistd] Failed to read token: %v", err)
+				return
+			}
+			token = strings.TrimRight(line, "\r\n")
+		}
+		if token == "" {
+			logging.Log.Errorln("[geistd] Empty token")
+			return
+		}
+
+		hash, err := acl.HashToken(token, hashAlgorithm)
+		if err != nil {
+			logging.Log.Errorf("[geistd] Failed to hash token: %v", err)
+			return
+		}
+		fmt.Println(hash)
+	},
+}
+
+func init() {
+	aclHashTokenCmd.Flags().StringVar(&hashAlgorithm, "algorithm", acl.HashArgon2id, "Hash algorithm (argon2id or bcrypt)")
+
+	ACLCmd.AddCommand(aclHashTokenCmd)
+}
@@ -0,0 +1,64 @@
+// Package cmd provides CLI commands for the geistd binary besides running
+// the daemon itself. This file defines the "certs" subcommands that set up
+// certificates for TLS control instances.
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/tlsutil"
+	"github.com/spf13/cobra"
+)
+
+var (
+	certsDir     string
+	certsHosts   []string
+	certsClients []string
+)
+
+// CertsCmd is the root command for certificate helpers.
+var CertsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "Manage certificates for TLS control instances",
+}
+
+// certsInitCmd creates a local CA and issues server and client certificates.
+var certsInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a local CA with a server certificate and client certificates",
+	Run: func(cmd *cobra.Command, args []string) {
+		ca, created, err := tlsutil.InitCA(certsDir)
+		if err != nil {
+			logging.Log.Errorf("[geistd] Failed to set up CA: %v", err)
+			return
+		}
+		if created {
+			logging.Log.Infof("Created CA %s", filepath.Join(certsDir, tlsutil.CAFile))
+		} else {
+			logging.Log.Infof("Using existing CA %s", filepath.Join(certsDir, tlsutil.CAFile))
+		}
+
+		if err := ca.IssueServer(certsDir, "server", certsHosts); err != nil {
+			logging.Log.Errorf("[geistd] Failed to issue server certificate: %v", err)
+			return
+		}
+		logging.Log.Infof("Issued server certificate %s for %v", filepath.Join(certsDir, "server.pem"), certsHosts)
+
+		for _, user := range certsClients {
+			if err := ca.IssueClient(certsDir, user); err != nil {
+				logging.Log.Errorf("[geistd] Failed to issue client certificate for '%s': %v", user, err)
+				return
+			}
+			logging.Log.Infof("Issued client certificate %s for user '%s'", filepath.Join(certsDir, "client-"+user+".pem"), user)
+		}
+	},
+}
+
+func init() {
+	certsInitCmd.Flags().StringVar(&certsDir, "dir", "tls", "Directory to store the CA and certificates in")
+	certsInitCmd.Flags().StringSliceVar(&certsHosts, "host", []string{"localhost", "127.0.0.1"}, "DNS names and IP addresses of the daemon")
+	certsInitCmd.Flags().StringSliceVar(&certsClients, "client", nil, "ACL users to issue client certificates for")
+
+	CertsCmd.AddCommand(certsInitCmd)
+}
@@ -0,0 +1,236 @@
+// Package cmd provides CLI commands for the geistd binary besides running
+// the daemon itself. This file defines "acl simulate", which evaluates a
+// policy offline against a table of test cases.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/control"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	simulatePolicy  string
+	simulateCases   string
+	simulateVerbose bool
+)
+
+// simulationCase is a single expected ACL decision. Without a proxy the
+// global rules apply, a host adds the rules of the host and its backend.
+type simulationCase struct {
+	Name   string `mapstructure:"name"`
+	User   string `mapstructure:"user"`
+	Proxy  string `mapstructure:"proxy"`
+	Host   string `mapstructure:"host"`
+	Perm   string `mapstructure:"perm"`
+	Expect string `mapstructure:"expect"` // "allow" or "deny"
+
+	// request context for rule conditions
+	Instance string `mapstructure:"instance"`
+	Source   string `mapstructure:"source"`
+	Time     string `mapstructure:"time"` // RFC 3339, default now
+}
+
+// aclSimulateCmd evaluates test cases against a policy without a daemon.
+var aclSimulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Evaluate a policy file against a table of test cases",
+	Long: `Evaluate the acl, proxies, hosts and backends sections of a policy file against a table
+of test cases without running the daemon. The cases file holds a list:
+
+  cases:
+    - user: alice
+      proxy: work
+      perm: proxy_start
+      host: exit-1    # optional, adds host and backend rules
+      instance: local # optional request context for rule conditions
+      source: 10.0.0.5
+      time: 2025-01-06T10:00:00+01:00
+      expect: allow
+
+Exits non-zero if any case fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if simulatePolicy == "" {
+			path, err := configloader.ResolveConfigPath("geistd", "geistd.yaml")
+			if err != nil {
+				logging.Log.Errorf("[geistd] %v", err)
+				os.Exit(1)
+			}
+			simulatePolicy = path
+		}
+
+		// only the acl, proxies, hosts and backends sections are used
+		var policy configd.Config
+		if err := readYAML(simulatePolicy, &policy); err != nil {
+			logging.Log.Errorf("[geistd] Failed to load policy: %v", err)
+			os.Exit(1)
+		}
+		var table struct {
+			Cases []simulationCase `mapstructure:"cases"`
+		}
+		if err := readYAML(simulateCases, &table); err != nil {
+			logging.Log.Errorf("[geistd] Failed to load cases: %v", err)
+			os.Exit(1)
+		}
+
+		// credentials do not influence decisions and may live elsewhere
+		policy.ACL.Credentials = nil
+		if err := acl.Init(policy.ACL, control.Permissions); err != nil {
+			logging.Log.Errorf("[geistd] Invalid policy: %v", err)
+			os.Exit(1)
+		}
+		if err := validatePolicy(&policy); err != nil {
+			logging.Log.Errorf("[geistd] Invalid policy: %v", err)
+			os.Exit(1)
+		}
+		if !policy.ACL.Enabled {
+			fmt.Println("note: acl.enabled is false, every request is allowed")
+		}
+
+		failed := 0
+		for i, c := range table.Cases {
+			ex, err := simulate(c, &policy)
+			name := c.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d %s %s %s %s", i+1, c.User, c.Perm, c.Proxy, c.Host)
+			}
+			if err != nil {
+				failed++
+				fmt.Printf("FAIL %s: %v\n", strings.TrimSpace(name), err)
+				continue
+			}
+
+			got := "deny"
+			if ex.Allowed {
+				got = "allow"
+			}
+			result := "PASS"
+			if got != c.Expect {
+				result = "FAIL"
+				failed++
+			}
+			fmt.Printf("%s %s: %s (expected %s) — %s\n", result, strings.TrimSpace(name), got, c.Expect, ex.Reason)
+			if simulateVerbose || result == "FAIL" {
+				printTrace(ex)
+			}
+		}
+
+		fmt.Printf("%d cases, %d passed, %d failed\n", len(table.Cases), len(table.Cases)-failed, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// simulate evaluates a single case against the initialized policy.
+func simulate(c simulationCase, policy *configd.Config) (*protocol.ACLExplanation, error) {
+	if c.Expect != "allow" && c.Expect != "deny" {
+		return nil, fmt.Errorf("expect must be allow or deny, got '%s'", c.Expect)
+	}
+	perm := acl.Permission(c.Perm)
+	if !acl.IsPermission(perm) {
+		return nil, fmt.Errorf("unknown permission '%s'", c.Perm)
+	}
+	var sets []acl.ACLRuleSet
+	if c.Proxy != "" {
+		proxyCfg, ok := policy.Proxies.Proxies[c.Proxy]
+		if !ok {
+			return nil, fmt.Errorf("unknown proxy '%s'", c.Proxy)
+		}
+		if _, ok := policy.Hosts[c.Host]; c.Host != "" && !ok {
+			return nil, fmt.Errorf("unknown host '%s'", c.Host)
+		}
+		sets = control.TargetRules(policy, c.Proxy, proxyCfg, c.Host)
+	} else if c.Host != "" {
+		return nil, fmt.Errorf("host '%s' requires a proxy", c.Host)
+	}
+	actx := acl.Context{User: c.User, Instance: c.Instance, Source: c.Source}
+	if c.Time != "" {
+		t, err := time.Parse(time.RFC3339, c.Time)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time: %w", err)
+		}
+		actx.Time = t
+	}
+	return acl.Explain(actx, perm, sets...), nil
+}
+
+// validatePolicy checks the rule patterns of proxies, hosts and backends.
+func validatePolicy(policy *configd.Config) error {
+	for name, proxyCfg := range policy.Proxies.Proxies {
+		if err := proxyCfg.ACLs.Validate(); err != nil {
+			return fmt.Errorf("proxy '%s': %w", name, err)
+		}
+	}
+	for name, host := range policy.Hosts {
+		if err := host.ACLs.Validate(); err != nil {
+			return fmt.Errorf("host '%s': %w", name, err)
+		}
+	}
+	for name := range policy.Backends {
+		rules, err := policy.BackendACLs(name)
+		if err != nil {
+			return err
+		}
+		if err := rules.Validate(); err != nil {
+			return fmt.Errorf("backend '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// printTrace prints the roles and rules of an evaluation indented below
+// the result line.
+func printTrace(ex *protocol.ACLExplanation) {
+	if len(ex.Groups) > 0 {
+		fmt.Printf("    groups: %s\n", strings.Join(ex.Groups, ", "))
+	}
+	for _, r := range ex.Roles {
+		fmt.Printf("    role %s via %s: exists=%t grants=%t granted_by=%q", r.Name, r.Via, r.Exists, r.Grants, r.GrantedBy)
+		if r.Condition != "" {
+			fmt.Printf(" (%s)", r.Condition)
+		}
+		fmt.Println()
+	}
+	for _, st := range ex.RuleSets {
+		fmt.Printf("    %s '%s': allowed=%t (%s)\n", st.Source, st.Object, st.Allowed, st.Reason)
+		for _, r := range st.Rules {
+			fmt.Printf("      rule #%d deny=%t subjects=%s proxy_match=%t permission_match=%t matched=%q condition_match=%t -> %s",
+				r.Index, r.Deny, strings.Join(r.Subjects, ","), r.ProxyMatch, r.PermissionMatch, r.MatchedSubject, r.ConditionMatch, r.Effect)
+			if r.Condition != "" {
+				fmt.Printf(" (%s)", r.Condition)
+			}
+			fmt.Println()
+		}
+	}
+}
+
+// readYAML decodes the YAML file at path into out using the config tags.
+func readYAML(path string, out any) error {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	return v.Unmarshal(out)
+}
+
+func init() {
+	aclSimulateCmd.Flags().StringVarP(&simulatePolicy, "config", "c", "", "Policy file (defaults to the daemon config)")
+	aclSimulateCmd.Flags().StringVar(&simulateCases, "cases", "", "YAML file with the test cases")
+	aclSimulateCmd.Flags().BoolVarP(&simulateVerbose, "verbose", "v", false, "Print the evaluation trace of every case")
+	_ = aclSimulateCmd.MarkFlagRequired("cases")
+
+	ACLCmd.AddCommand(aclSimulateCmd)
+}
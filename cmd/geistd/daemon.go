@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/mfulz/portgeist/dispatch"
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/audit"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/control"
+	"github.com/mfulz/portgeist/internal/events"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/proxy"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// daemon holds the running configuration and the control instances served
+// from it. Reloads and config edits are serialized by mu.
+type daemon struct {
+	mu      sync.Mutex
+	cfg     *configd.Config
+	mgr     *proxy.Manager
+	servers map[string]*control.Server // running control instances by name
+	sum     [sha256.Size]byte          // content of the config file last applied
+}
+
+// newDaemon returns a daemon running cfg with the proxies of mgr.
+func newDaemon(cfg *configd.Config, mgr *proxy.Manager) *daemon {
+	return &daemon{
+		cfg:     cfg,
+		mgr:     mgr,
+		servers: make(map[string]*control.Server),
+	}
+}
+
+// dispatcher builds the handlers of a control instance for cfg.
+func (d *daemon) dispatcher(cfg *configd.Config, inst configd.ControlInstance) *dispatch.Dispatcher {
+	mgr := d.mgr
+	dispatcher := dispatch.New()
+	dispatcher.Register(protocol.CmdProxyStart, control.StartProxyHandler(cfg, inst, mgr))
+	dispatcher.Register(protocol.CmdProxyStop, control.StopProxyHandler(cfg, inst, mgr))
+	dispatcher.Register(protocol.CmdProxyStatus, control.ProxyStatusHandler(cfg, inst, mgr))
+	dispatcher.Register(protocol.CmdProxyList, control.ProxyListHandler(cfg, inst, mgr))
+	dispatcher.Register(protocol.CmdProxyInfo, control.ProxyInfoHandler(cfg, inst, mgr))
+	dispatcher.Register(protocol.CmdProxySetActive, control.ProxySetActiveHandler(cfg, inst, mgr))
+	dispatcher.Register(protocol.CmdProxyResolv, control.ResolveProxyHandler(cfg, inst))
+	dispatcher.Register(protocol.CmdProxyReset, control.ResetProxyHandler(cfg, inst, mgr))
+	dispatcher.Register(protocol.CmdHostKeys, control.HostKeysHandler(cfg, inst))
+	dispatcher.Register(protocol.CmdHostTrust, control.HostTrustHandler(cfg, inst))
+	dispatcher.Register(protocol.CmdLogin, control.LoginHandler(cfg, inst))
+	dispatcher.Register(protocol.CmdLogout, control.LogoutHandler(cfg, inst))
+	dispatcher.Register(protocol.CmdAudit, control.AuditHandler(cfg, inst))
+	dispatcher.Register(protocol.CmdACLExplain, control.ExplainHandler(cfg, inst))
+	dispatcher.Register(protocol.CmdReload, control.ReloadHandler(inst, d.reload))
+	dispatcher.Register(protocol.CmdConfigHostList, control.HostListHandler(cfg, inst, mgr))
+	dispatcher.Register(protocol.CmdConfigHostGet, control.HostGetHandler(cfg, inst, mgr))
+	dispatcher.Register(protocol.CmdConfigHostAdd, control.HostAddHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigHostUpdate, control.HostUpdateHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigHostRemove, control.HostRemoveHandler(cfg, inst, mgr, d.edit))
+	dispatcher.Register(protocol.CmdConfigProxyAdd, control.ProxyAddHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigProxyUpdate, control.ProxyUpdateHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigProxyRemove, control.ProxyRemoveHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigLoginList, control.LoginListHandler(cfg, inst))
+	dispatcher.Register(protocol.CmdConfigLoginAdd, control.LoginAddHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigLoginUpdate, control.LoginUpdateHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigLoginRemove, control.LoginRemoveHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigUserList, control.UserListHandler(cfg, inst))
+	dispatcher.Register(protocol.CmdConfigUserAdd, control.UserAddHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigUserUpdate, control.UserUpdateHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigUserRemove, control.UserRemoveHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigUserRotate, control.UserRotateHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigGroupAdd, control.GroupAddHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigGroupUpdate, control.GroupUpdateHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigGroupRemove, control.GroupRemoveHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigRoleAdd, control.RoleAddHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigRoleUpdate, control.RoleUpdateHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigRoleRemove, control.RoleRemoveHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigControlList, control.ControlListHandler(cfg, inst))
+	dispatcher.Register(protocol.CmdConfigControlAdd, control.ControlAddHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigControlRemove, control.ControlRemoveHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigControlEnable, control.ControlEnableHandler(cfg, inst, d.edit))
+	dispatcher.Register(protocol.CmdConfigControlDisable, control.ControlDisableHandler(cfg, inst, d.edit))
+	dispatcher.Observe(audit.Observe)
+	return dispatcher
+}
+
+// startControls starts all enabled control instances of the running config.
+func (d *daemon) startControls() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, inst := range d.cfg.Control.Instances {
+		if !inst.Enabled {
+			continue
+		}
+		if err := d.startControl(d.cfg, inst); err != nil {
+			logging.Log.Errorf("[control:%s] Error: %v", inst.Name, err)
+		}
+	}
+}
+
+// startControl starts a single control instance serving cfg.
+// The caller must hold d.mu.
+func (d *daemon) startControl(cfg *configd.Config, inst configd.ControlInstance) error {
+	logging.Log.Infof("[control:%s] Starting (%s): %s", inst.Name, inst.Mode, inst.Listen)
+	srv, err := control.StartServerInstance(inst, cfg, d.dispatcher(cfg, inst))
+	if err != nil {
+		return err
+	}
+	d.servers[inst.Name] = srv
+	return nil
+}
+
+// reload re-reads the config file and applies it. A file that fails
+// validation is rejected and the running configuration stays in place.
+func (d *daemon) reload() (*protocol.ReloadResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.reloadFile(false)
+}
+
+// reloadChanged is reload for the config watcher. A file whose content was
+// applied last, e.g. because edit wrote it, is not applied again and nil
+// is returned.
+func (d *daemon) reloadChanged() (*protocol.ReloadResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.reloadFile(true)
+}
+
+// reloadFile implements reload and reloadChanged.
+// The caller must hold d.mu.
+func (d *daemon) reloadFile(onlyChanged bool) (*protocol.ReloadResponse, error) {
+	path := d.cfg.Path()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("error loading config: %w", err)
+		logging.Log.Errorf("[geistd] Reload rejected, keeping the running config: %v", err)
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if onlyChanged {
+		if sum == d.sum {
+			logging.Log.Debugf("[geistd] Config file unchanged since it was last applied")
+			return nil, nil
+		}
+		logging.Log.Infof("[geistd] Config file changed, reloading...")
+	}
+
+	next, err := configd.Parse(path, data)
+	if err == nil {
+		err = validateACL(next)
+	}
+	if err != nil {
+		logging.Log.Errorf("[geistd] Reload rejected, keeping the running config: %v", err)
+		return nil, err
+	}
+	d.sum = sum
+	return d.switchTo(next), nil
+}
+
+// edit changes entries of the config file and applies the result like a
+// reload. prepare is called with the running config and returns the
+// changes; the file is only written if the changed config is valid.
+func (d *daemon) edit(prepare func(cfg *configd.Config) ([]configd.Change, error)) (*protocol.ReloadResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	changes, err := prepare(d.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	path := d.cfg.Path()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	data, err = configd.Edit(data, changes...)
+	if err != nil {
+		return nil, err
+	}
+	next, err := configd.Parse(path, data)
+	if err == nil {
+		err = validateACL(next)
+	}
+	if err != nil {
+		logging.Log.Warnf("[geistd] Config change rejected: %v", err)
+		return nil, err
+	}
+	if err := configd.WriteFile(path, data); err != nil {
+		return nil, fmt.Errorf("write config: %w", err)
+	}
+	// The watcher sees the write, it must not apply the change again.
+	d.sum = sha256.Sum256(data)
+	return d.switchTo(next), nil
+}
+
+// validateACL checks the access control settings of a loaded config.
+func validateACL(cfg *configd.Config) error {
+	if err := acl.Validate(cfg.ACL, control.Permissions); err != nil {
+		return fmt.Errorf("invalid config: acl: %w", err)
+	}
+	return nil
+}
+
+// switchTo applies next, logs the outcome and publishes it as event.
+// The caller must hold d.mu.
+func (d *daemon) switchTo(next *configd.Config) *protocol.ReloadResponse {
+	result := d.apply(next)
+	summary := reloadSummary(result)
+	if len(result.Errors) > 0 {
+		logging.Log.Warnf("[geistd] Config reloaded with errors: %s", summary)
+	} else {
+		logging.Log.Infof("[geistd] Config reloaded: %s", summary)
+	}
+	events.Publish(protocol.Event{Type: protocol.EventConfigReloaded, Message: summary})
+	return result
+}
+
+// apply switches the daemon from the running config to next, which must
+// be valid. The ACL engine is swapped first so restarted proxies and
+// control instances are already checked against the new rules.
+// The caller must hold d.mu.
+func (d *daemon) apply(next *configd.Config) *protocol.ReloadResponse {
+	old := d.cfg
+	result := &protocol.ReloadResponse{
+		ACLChanged:      !reflect.DeepEqual(old.ACL, next.ACL),
+		RestartRequired: restartRequired(old, next),
+	}
+
+	if err := acl.Init(next.ACL, control.Permissions); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("acl: %v", err))
+	}
+
+	changes := d.mgr.Apply(old, next)
+	result.ProxiesStarted = changes.Started
+	result.ProxiesStopped = changes.Stopped
+	result.ProxiesRestarted = changes.Restarted
+	result.Errors = append(result.Errors, changes.Errors...)
+
+	d.applyControls(next, result)
+
+	d.cfg = next
+	configloader.ReplaceConfig(next)
+	return result
+}
+
+// applyControls stops control instances that were removed or disabled,
+// restarts those whose settings changed and starts new ones. Unchanged
+// instances keep their connections and serve the next request with next.
+// The caller must hold d.mu.
+func (d *daemon) applyControls(next *configd.Config, result *protocol.ReloadResponse) {
+	wanted := make(map[string]configd.ControlInstance)
+	var names []string
+	for _, inst := range next.Control.Instances {
+		if inst.Enabled {
+			wanted[inst.Name] = inst
+		}
+	}
+	for name := range d.servers {
+		names = append(names, name)
+	}
+	for name := range wanted {
+		if _, ok := d.servers[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		srv, running := d.servers[name]
+		inst, ok := wanted[name]
+		switch {
+		case running && !ok:
+			logging.Log.Infof("[control:%s] Removed from config, stopping", name)
+			srv.Close()
+			delete(d.servers, name)
+			result.ControlsStopped = append(result.ControlsStopped, name)
+		case running && reflect.DeepEqual(srv.Instance(), inst):
+			srv.Update(next, d.dispatcher(next, inst))
+		case running:
+			logging.Log.Infof("[control:%s] Settings changed, restarting", name)
+			srv.Close()
+			delete(d.servers, name)
+			if err := d.startControl(next, inst); err != nil {
+				logging.Log.Errorf("[control:%s] Error: %v", name, err)
+				result.Errors = append(result.Errors, fmt.Sprintf("control '%s': %v", name, err))
+				result.ControlsStopped = append(result.ControlsStopped, name)
+				continue
+			}
+			result.ControlsRestarted = append(result.ControlsRestarted, name)
+		default:
+			if err := d.startControl(next, inst); err != nil {
+				logging.Log.Errorf("[control:%s] Error: %v", name, err)
+				result.Errors = append(result.Errors, fmt.Sprintf("control '%s': %v", name, err))
+				continue
+			}
+			result.ControlsStarted = append(result.ControlsStarted, name)
+		}
+	}
+}
+
+// restartRequired lists the changed settings that are only read when the
+// daemon starts.
+func restartRequired(old, next *configd.Config) []string {
+	var out []string
+	if !reflect.DeepEqual(old.Logger, next.Logger) {
+		out = append(out, "log")
+	}
+	if old.StateDir != next.StateDir {
+		out = append(out, "state_dir")
+	}
+	if old.PluginDir != next.PluginDir {
+		out = append(out, "plugin_dir")
+	}
+	if old.HostKeys.File != next.HostKeys.File {
+		out = append(out, "host_keys.file")
+	}
+	if old.Audit != next.Audit {
+		out = append(out, "audit")
+	}
+	if old.Reload != next.Reload {
+		out = append(out, "reload")
+	}
+	return out
+}
+
+// reloadSummary renders the outcome of a reload as a single line.
+func reloadSummary(r *protocol.ReloadResponse) string {
+	s := fmt.Sprintf("proxies restarted=%v started=%v stopped=%v, controls restarted=%v started=%v stopped=%v, acl changed=%t",
+		r.ProxiesRestarted, r.ProxiesStarted, r.ProxiesStopped,
+		r.ControlsRestarted, r.ControlsStarted, r.ControlsStopped, r.ACLChanged)
+	if len(r.RestartRequired) > 0 {
+		s += fmt.Sprintf(", restart required for %v", r.RestartRequired)
+	}
+	if len(r.Errors) > 0 {
+		s += fmt.Sprintf(", errors: %v", r.Errors)
+	}
+	return s
+}
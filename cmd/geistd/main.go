@@ -3,22 +3,50 @@
 package main
 
 import (
+	"context"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
-	"github.com/mfulz/portgeist/dispatch"
+	"github.com/mfulz/portgeist/cmd/geistd/cmd"
 	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/audit"
 	_ "github.com/mfulz/portgeist/internal/backend"
 	"github.com/mfulz/portgeist/internal/configd"
 	"github.com/mfulz/portgeist/internal/configloader"
 	"github.com/mfulz/portgeist/internal/control"
+	"github.com/mfulz/portgeist/internal/hostkeys"
 	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/pluginhost"
 	"github.com/mfulz/portgeist/internal/proxy"
-	"github.com/mfulz/portgeist/protocol"
+	"github.com/mfulz/portgeist/internal/state"
+	"github.com/spf13/cobra"
 )
 
+var rootCmd = &cobra.Command{
+	Use:   "geistd",
+	Short: "Portgeist proxy daemon",
+	Long:  `geistd runs the configured proxies and serves the control interfaces used by geistctl.`,
+	Run: func(c *cobra.Command, args []string) {
+		runDaemon()
+	},
+}
+
 func main() {
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(cmd.CertsCmd)
+	rootCmd.AddCommand(cmd.ACLCmd)
+}
+
+// runDaemon loads the configuration, starts proxies and control instances
+// and blocks until the daemon is shut down.
+func runDaemon() {
 	err := configd.LoadConfig()
 	if err != nil {
 		logging.Log.Fatalf("[geistd] Failed to load config: %v", err)
@@ -26,68 +54,67 @@ func main() {
 	cfg := configloader.MustGetConfig[*configd.Config]()
 	logging.Log.Debugln("[geistd] Configuration loaded successfully:\n%v", cfg)
 
-	if err := acl.Init(cfg.ACL, []acl.Permission{
-		"proxy_start",
-		"proxy_stop",
-		"proxy_status",
-		"proxy_list",
-		"proxy_info",
-		"proxy_setactive",
-		"proxy_resolve",
-	}); err != nil {
+	if err := acl.Init(cfg.ACL, control.Permissions); err != nil {
 		logging.Log.Fatalf("[geistd] Failed to init acls: %v", err)
 	}
 
-	// Start autostart proxies
-	for name, p := range cfg.Proxies.Proxies {
-		if p.Autostart {
-			logging.Log.Infof("[proxy] Autostart enabled for '%s'", name)
-			if err := proxy.StartProxy(name, p, cfg); err != nil {
-				logging.Log.Warnf("[proxy] Failed to start '%s': %v", name, err)
-			} else {
-				logging.Log.Infof("[proxy] Proxy '%s' started", name)
-			}
-		}
+	if err := audit.Init(cfg.Audit); err != nil {
+		logging.Log.Fatalf("[geistd] Failed to init audit log: %v", err)
 	}
 
+	if err := hostkeys.Init(cfg.HostKeys.File); err != nil {
+		logging.Log.Fatalf("[geistd] Failed to init host key store: %v", err)
+	}
+
+	store, err := state.Open(filepath.Join(cfg.StateDir, state.FileName))
+	if err != nil {
+		logging.Log.Fatalf("[geistd] Failed to open state store: %v", err)
+	}
+
+	plugins, err := pluginhost.LoadDir(cfg.PluginDir)
+	if err != nil {
+		logging.Log.Warnf("[geistd] Failed to load plugins: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mgr := proxy.NewManager(ctx, store)
+
+	// Restore saved proxy states and start autostart proxies
+	mgr.Reconcile(cfg)
+
 	// Start all enabled control instances
-	for _, inst := range cfg.Control.Instances {
-		if !inst.Enabled {
-			continue
-		}
+	d := newDaemon(cfg, mgr)
+	d.startControls()
 
-		go func(inst configd.ControlInstance) {
-			logging.Log.Infof("[control:%s] Starting (%s): %s", inst.Name, inst.Mode, inst.Listen)
-
-			dispatcher := dispatch.New()
-			dispatcher.Register(protocol.CmdProxyStart, control.StartProxyHandler(cfg, inst))
-			dispatcher.Register(protocol.CmdProxyStop, control.StopProxyHandler(cfg, inst))
-			dispatcher.Register(protocol.CmdProxyStatus, control.ProxyStatusHandler(cfg, inst))
-			dispatcher.Register(protocol.CmdProxyList, control.ProxyListHandler(cfg, inst))
-			dispatcher.Register(protocol.CmdProxyInfo, control.ProxyInfoHandler(cfg, inst))
-			dispatcher.Register(protocol.CmdProxySetActive, control.ProxySetActiveHandler(cfg, inst))
-			dispatcher.Register(protocol.CmdProxyResolv, control.ResolveProxyHandler(cfg, inst))
-			control.SetDispatcher(dispatcher)
-
-			if err := control.StartServerInstance(inst, cfg); err != nil {
-				logging.Log.Errorf("[control:%s] Error: %v", inst.Name, err)
-			}
-		}(inst)
+	if cfg.Reload.Watch {
+		if err := watchConfig(ctx, d, cfg.Path()); err != nil {
+			logging.Log.Warnf("[geistd] Failed to watch config: %v", err)
+		}
 	}
 
 	logging.Log.Infoln("[geistd] Daemon is running. Waiting for control events...")
-	waitForShutdown()
+	waitForShutdown(cancel, d, mgr, plugins)
 	// select {}
 }
 
-func waitForShutdown() {
+// waitForShutdown reloads the config on SIGHUP and blocks until SIGINT or
+// SIGTERM, then stops all proxies, waits for their backends to exit and
+// terminates the plugin processes.
+func waitForShutdown(cancel context.CancelFunc, d *daemon, mgr *proxy.Manager, plugins []*pluginhost.Plugin) {
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	sig := <-sigChan
+	for sig == syscall.SIGHUP {
+		logging.Log.Infof("[geistd] Caught signal: %s. Reloading config...", sig)
+		_, _ = d.reload()
+		sig = <-sigChan
+	}
 	logging.Log.Infof("[geistd] Caught signal: %s. Shutting down...", sig)
 
-	proxy.StopAll()
+	cancel()
+	<-mgr.Done()
+	pluginhost.CloseAll(plugins)
 
 	os.Exit(0)
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mfulz/portgeist/internal/logging"
+)
+
+// watchDelay coalesces the bursts of events editors cause when saving.
+var watchDelay = 500 * time.Millisecond
+
+// watchConfig reloads the daemon whenever the file at path changes until
+// ctx is cancelled. The directory is watched since editors often replace
+// the file instead of writing to it. Writes of the daemon itself are
+// skipped by reloadChanged.
+func watchConfig(ctx context.Context, d *daemon, path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(filepath.Dir(abs)); err != nil {
+		w.Close()
+		return fmt.Errorf("watch %s: %w", filepath.Dir(abs), err)
+	}
+	logging.Log.Infof("[geistd] Watching %s for changes", abs)
+
+	go func() {
+		defer w.Close()
+		timer := time.NewTimer(watchDelay)
+		timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != abs || ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				timer.Reset(watchDelay)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logging.Log.Warnf("[geistd] Config watch error: %v", err)
+			case <-timer.C:
+				_, _ = d.reloadChanged()
+			}
+		}
+	}()
+	return nil
+}
@@ -4,6 +4,7 @@ package dispatch
 
 import (
 	"sync"
+	"time"
 
 	"github.com/mfulz/portgeist/protocol"
 )
@@ -11,10 +12,24 @@ import (
 // HandlerFunc defines the signature of a command handler.
 type HandlerFunc func(req *protocol.Request) *protocol.Response
 
+// Call describes where a request came from and how its caller was
+// authenticated. It is passed to observers along with the request.
+type Call struct {
+	Instance   string    // control instance the request arrived on
+	Peer       string    // remote address of the client
+	AuthSource string    // token, session, tls or peercred; empty if unauthenticated
+	Session    string    // session id for session tokens
+	Started    time.Time // when the request was received
+}
+
+// ObserverFunc is notified about every answered request.
+type ObserverFunc func(call Call, req *protocol.Request, resp *protocol.Response)
+
 // Dispatcher maps command strings to their handlers.
 type Dispatcher struct {
-	mu       sync.RWMutex
-	handlers map[string]HandlerFunc
+	mu        sync.RWMutex
+	handlers  map[string]HandlerFunc
+	observers []ObserverFunc
 }
 
 // New creates a new Dispatcher.
@@ -31,18 +46,47 @@ func (d *Dispatcher) Register(command string, handler HandlerFunc) {
 	d.handlers[command] = handler
 }
 
+// Observe registers an observer notified after each dispatched request.
+func (d *Dispatcher) Observe(observer ObserverFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.observers = append(d.observers, observer)
+}
+
 // Dispatch executes the handler for a given request.
 func (d *Dispatcher) Dispatch(req *protocol.Request) *protocol.Response {
+	return d.DispatchCall(Call{Started: time.Now()}, req)
+}
+
+// DispatchCall executes the handler for a request received as described
+// by call and notifies the observers.
+func (d *Dispatcher) DispatchCall(call Call, req *protocol.Request) *protocol.Response {
 	d.mu.RLock()
 	handler, ok := d.handlers[req.Type]
 	d.mu.RUnlock()
 
+	var resp *protocol.Response
 	if !ok {
-		return &protocol.Response{
+		resp = &protocol.Response{
 			Status: "error",
 			Error:  "unknown command",
 		}
+	} else {
+		resp = handler(req)
 	}
 
-	return handler(req)
+	d.Notify(call, req, resp)
+	return resp
+}
+
+// Notify passes a request answered without a handler, e.g. rejected
+// before dispatching, to the observers.
+func (d *Dispatcher) Notify(call Call, req *protocol.Request, resp *protocol.Response) {
+	d.mu.RLock()
+	observers := d.observers
+	d.mu.RUnlock()
+
+	for _, o := range observers {
+		o(call, req, resp)
+	}
 }
@@ -0,0 +1,17 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/logging"
+	"go.uber.org/zap"
+)
+
+// testPerms are the permissions the test configurations may use.
+var testPerms = []Permission{"proxy_start", "proxy_stop", "proxy_list", "proxy_setactive", "config_host_add"}
+
+// initLogging silences the engine's debug output.
+func initLogging(t *testing.T) {
+	t.Helper()
+	logging.Log = zap.NewNop().Sugar()
+}
@@ -0,0 +1,275 @@
+package acl
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Context describes who asks for a permission and from where. Rule
+// conditions are evaluated against it.
+type Context struct {
+	User     string
+	Instance string    // control instance the request arrived on
+	Source   string    // client address, "ip" or "ip:port" for network clients
+	Time     time.Time // evaluation time, now if zero
+}
+
+// Conditions restrict when a rule applies. All given conditions must hold;
+// within a list any entry may match.
+type Conditions struct {
+	Instances []string     `mapstructure:"instances"` // control instance name patterns
+	Sources   []string     `mapstructure:"sources"`   // client CIDRs or addresses
+	Time      []TimeWindow `mapstructure:"time"`      // time windows
+
+	compiled *compiledConditions // set by Compile
+}
+
+// compiledConditions holds the parsed sources and windows of Conditions.
+type compiledConditions struct {
+	sources []*net.IPNet
+	windows []*compiledWindow
+}
+
+// TimeWindow is a daily time range on the given days. To may be before From
+// for windows spanning midnight; the day refers to the start of the window.
+type TimeWindow struct {
+	Days     []string `mapstructure:"days"`     // e.g. mon, tue or ranges like mon-fri; empty means every day
+	From     string   `mapstructure:"from"`     // HH:MM, default 00:00
+	To       string   `mapstructure:"to"`       // HH:MM (exclusive), default 24:00
+	TimeZone string   `mapstructure:"timezone"` // IANA zone like Europe/Berlin, default local time
+}
+
+// weekdays maps day names to time.Weekday.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// empty reports whether no condition is set.
+func (c Conditions) empty() bool {
+	return len(c.Instances) == 0 && len(c.Sources) == 0 && len(c.Time) == 0
+}
+
+// Validate checks patterns, networks and time windows.
+func (c Conditions) Validate() error {
+	_, err := c.compile()
+	return err
+}
+
+// Compile validates the conditions and keeps their parsed form, so
+// evaluating them does not parse networks or load time zones again.
+func (c *Conditions) Compile() error {
+	cc, err := c.compile()
+	if err != nil {
+		return err
+	}
+	c.compiled = cc
+	return nil
+}
+
+// compile parses the sources and time windows.
+func (c Conditions) compile() (*compiledConditions, error) {
+	for _, pattern := range c.Instances {
+		if !validPattern(pattern) {
+			return nil, fmt.Errorf("invalid instance pattern %q", pattern)
+		}
+	}
+	cc := &compiledConditions{}
+	for _, src := range c.Sources {
+		n, err := parseSource(src)
+		if err != nil {
+			return nil, err
+		}
+		cc.sources = append(cc.sources, n)
+	}
+	for i, w := range c.Time {
+		cw, err := w.compile()
+		if err != nil {
+			return nil, fmt.Errorf("time[%d]: %w", i, err)
+		}
+		cc.windows = append(cc.windows, cw)
+	}
+	return cc, nil
+}
+
+// match reports whether ctx satisfies the conditions. The returned text
+// names the first condition that failed. Conditions that were not
+// compiled, e.g. built in code, are parsed on the fly.
+func (c Conditions) match(ctx Context) (bool, string) {
+	if c.empty() {
+		return true, ""
+	}
+	cc := c.compiled
+	if cc == nil {
+		var err error
+		if cc, err = c.compile(); err != nil {
+			return false, err.Error()
+		}
+	}
+
+	if len(c.Instances) > 0 && !slices.ContainsFunc(c.Instances, func(p string) bool { return globMatch(p, ctx.Instance) }) {
+		return false, fmt.Sprintf("instance '%s' not in %v", ctx.Instance, c.Instances)
+	}
+
+	if len(cc.sources) > 0 {
+		ip := sourceIP(ctx.Source)
+		matched := ip != nil && slices.ContainsFunc(cc.sources, func(n *net.IPNet) bool {
+			return n.Contains(ip)
+		})
+		if !matched {
+			return false, fmt.Sprintf("source '%s' not in %v", ctx.Source, c.Sources)
+		}
+	}
+
+	if len(cc.windows) > 0 {
+		now := ctx.Time
+		if now.IsZero() {
+			now = time.Now()
+		}
+		matched := slices.ContainsFunc(cc.windows, func(cw *compiledWindow) bool {
+			return cw.contains(now)
+		})
+		if !matched {
+			return false, fmt.Sprintf("time %s outside the allowed windows", now.Format(time.RFC3339))
+		}
+	}
+	return true, ""
+}
+
+// parseSource parses a CIDR or a single address.
+func parseSource(src string) (*net.IPNet, error) {
+	if _, n, err := net.ParseCIDR(src); err == nil {
+		return n, nil
+	}
+	ip := net.ParseIP(src)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid source %q", src)
+	}
+	bits := 32
+	if ip.To4() == nil {
+		bits = 128
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// sourceIP extracts the IP of a client address, nil for non-IP clients
+// such as unix sockets.
+func sourceIP(source string) net.IP {
+	if host, _, err := net.SplitHostPort(source); err == nil {
+		source = host
+	}
+	return net.ParseIP(source)
+}
+
+// compiledWindow is a validated TimeWindow.
+type compiledWindow struct {
+	days     map[time.Weekday]bool // nil means every day
+	from, to int                   // minutes since midnight
+	loc      *time.Location
+}
+
+// compile validates w and resolves its time zone.
+func (w TimeWindow) compile() (*compiledWindow, error) {
+	cw := &compiledWindow{from: 0, to: 24 * 60, loc: time.Local}
+
+	var err error
+	if w.From != "" {
+		if cw.from, err = parseClock(w.From); err != nil {
+			return nil, err
+		}
+	}
+	if w.To != "" {
+		if cw.to, err = parseClock(w.To); err != nil {
+			return nil, err
+		}
+	}
+	if cw.from == cw.to {
+		return nil, fmt.Errorf("empty time window %s-%s", w.From, w.To)
+	}
+	if w.TimeZone != "" {
+		if cw.loc, err = loadLocation(w.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", w.TimeZone, err)
+		}
+	}
+
+	for _, d := range w.Days {
+		first, last, isRange := strings.Cut(strings.ToLower(d), "-")
+		if !isRange {
+			last = first
+		}
+		start, ok1 := weekdays[first]
+		end, ok2 := weekdays[last]
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("invalid day %q", d)
+		}
+		if cw.days == nil {
+			cw.days = make(map[time.Weekday]bool)
+		}
+		for day := start; ; day = (day + 1) % 7 {
+			cw.days[day] = true
+			if day == end {
+				break
+			}
+		}
+	}
+	return cw, nil
+}
+
+// zones caches loaded time zones by name, so reloads reuse them.
+var zones sync.Map
+
+// loadLocation returns the named time zone, loading it only once.
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := zones.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := zones.LoadOrStore(name, loc)
+	return actual.(*time.Location), nil
+}
+
+// contains reports whether t lies within the window.
+func (cw *compiledWindow) contains(t time.Time) bool {
+	t = t.In(cw.loc)
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	if cw.from < cw.to {
+		return cw.onDay(day) && minute >= cw.from && minute < cw.to
+	}
+	// window spans midnight: the late part belongs to the previous day
+	if minute >= cw.from {
+		return cw.onDay(day)
+	}
+	return minute < cw.to && cw.onDay((day+6)%7)
+}
+
+// onDay reports whether the window is active on day.
+func (cw *compiledWindow) onDay(day time.Weekday) bool {
+	return cw.days == nil || cw.days[day]
+}
+
+// parseClock parses HH:MM into minutes since midnight; 24:00 is allowed
+// as end of day.
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || len(s) != 5 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
@@ -0,0 +1,144 @@
+package acl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeWindowContains(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	// 2026-10-12 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, 12+day, hour, minute, 0, 0, zurich)
+	}
+
+	tests := []struct {
+		name   string
+		window TimeWindow
+		t      time.Time
+		want   bool
+	}{
+		{"inside business hours", TimeWindow{Days: []string{"mon-fri"}, From: "08:00", To: "18:00", TimeZone: "Europe/Zurich"}, at(0, 9, 30), true},
+		{"end is exclusive", TimeWindow{Days: []string{"mon-fri"}, From: "08:00", To: "18:00", TimeZone: "Europe/Zurich"}, at(0, 18, 0), false},
+		{"weekend", TimeWindow{Days: []string{"mon-fri"}, From: "08:00", To: "18:00", TimeZone: "Europe/Zurich"}, at(5, 9, 30), false},
+		{"other zone", TimeWindow{From: "08:00", To: "18:00", TimeZone: "America/New_York"}, at(0, 9, 30), false},
+		{"every day", TimeWindow{From: "08:00", TimeZone: "Europe/Zurich"}, at(6, 23, 59), true},
+		{"wrapping day range", TimeWindow{Days: []string{"sat-mon"}, TimeZone: "Europe/Zurich"}, at(6, 12, 0), true},
+		{"midnight late part", TimeWindow{Days: []string{"fri"}, From: "22:00", To: "02:00", TimeZone: "Europe/Zurich"}, at(4, 23, 0), true},
+		{"midnight early part belongs to start day", TimeWindow{Days: []string{"fri"}, From: "22:00", To: "02:00", TimeZone: "Europe/Zurich"}, at(5, 1, 0), true},
+		{"midnight early part of other day", TimeWindow{Days: []string{"fri"}, From: "22:00", To: "02:00", TimeZone: "Europe/Zurich"}, at(4, 1, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cw, err := tt.window.compile()
+			if err != nil {
+				t.Fatalf("compile() = %v", err)
+			}
+			if got := cw.contains(tt.t); got != tt.want {
+				t.Fatalf("contains(%s) = %t, want %t", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionsCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		when    Conditions
+		wantErr bool
+	}{
+		{"empty", Conditions{}, false},
+		{"valid", Conditions{Instances: []string{"local*"}, Sources: []string{"10.0.0.0/8", "::1"}, Time: []TimeWindow{{Days: []string{"mon-fri"}, From: "08:00", To: "18:00"}}}, false},
+		{"bad pattern", Conditions{Instances: []string{"["}}, true},
+		{"bad source", Conditions{Sources: []string{"10.0.0.300"}}, true},
+		{"bad day", Conditions{Time: []TimeWindow{{Days: []string{"someday"}}}}, true},
+		{"bad clock", Conditions{Time: []TimeWindow{{From: "8:00"}}}, true},
+		{"empty window", Conditions{Time: []TimeWindow{{From: "08:00", To: "08:00"}}}, true},
+		{"bad zone", Conditions{Time: []TimeWindow{{TimeZone: "Mars/Olympus"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.when
+			err := c.Compile()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Compile() = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && c.compiled == nil {
+				t.Fatal("Compile() did not keep the parsed conditions")
+			}
+		})
+	}
+}
+
+func TestConditions(t *testing.T) {
+	initLogging(t)
+	// 2026-10-12 is a Monday
+	monday := time.Date(2026, 10, 12, 10, 0, 0, 0, time.Local)
+	sunday := time.Date(2026, 10, 18, 10, 0, 0, 0, time.Local)
+	office := Conditions{Time: []TimeWindow{{Days: []string{"mon-fri"}, From: "08:00", To: "18:00"}}}
+
+	cfg := ACLConfig{
+		Enabled: true,
+		Users: map[string]User{
+			"alice":      {Roles: []string{"admin"}},
+			"contractor": {Roles: []string{"operator"}, When: office},
+			"bob":        {Roles: []string{"local-admin"}},
+		},
+		Roles: map[string]Role{
+			"operator":    {Permissions: []Permission{"proxy_start", "proxy_list"}},
+			"admin":       {Permissions: []Permission{"config_host_add"}, Extends: []string{"operator"}},
+			"local-admin": {Extends: []string{"admin"}, When: Conditions{Instances: []string{"local"}}},
+		},
+	}
+	if err := Init(cfg, testPerms); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := ProxyRules("pp", ACLRuleSet{Rules: []ACLRule{
+		{Subjects: []string{"*"}},
+		{
+			Subjects:    []string{"*"},
+			Permissions: []Permission{"proxy_start"},
+			Deny:        true,
+			When:        Conditions{Sources: []string{"192.0.2.0/24"}},
+		},
+	}})
+	if err := rules.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		ctx   Context
+		perm  Permission
+		rules ACLRuleSet
+		want  bool
+	}{
+		{"global without conditions", Context{User: "alice", Instance: "remote"}, "config_host_add", ACLRuleSet{}, true},
+		{"user condition holds", Context{User: "contractor", Time: monday}, "proxy_list", ACLRuleSet{}, true},
+		{"user condition fails on global permission", Context{User: "contractor", Time: sunday}, "proxy_list", ACLRuleSet{}, false},
+		{"user condition fails on proxy permission", Context{User: "contractor", Time: sunday}, "proxy_start", rules, false},
+		{"role condition holds", Context{User: "bob", Instance: "local"}, "config_host_add", ACLRuleSet{}, true},
+		{"role condition holds for extended role", Context{User: "bob", Instance: "local"}, "proxy_list", ACLRuleSet{}, true},
+		{"role condition fails", Context{User: "bob", Instance: "remote"}, "config_host_add", ACLRuleSet{}, false},
+		{"role condition fails for extended role", Context{User: "bob", Instance: "remote"}, "proxy_list", ACLRuleSet{}, false},
+		{"rule condition skips deny", Context{User: "alice", Source: "10.0.0.1:4711"}, "proxy_start", rules, true},
+		{"rule condition applies deny", Context{User: "alice", Source: "192.0.2.7:4711"}, "proxy_start", rules, false},
+		{"source rule never matches unix clients", Context{User: "alice", Source: "@"}, "proxy_start", rules, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Can(tt.ctx, tt.perm, tt.rules); got != tt.want {
+				t.Fatalf("Can() = %t, want %t: %s", got, tt.want, Explain(tt.ctx, tt.perm, tt.rules).Reason)
+			}
+		})
+	}
+
+	ex := Explain(Context{User: "bob", Instance: "remote"}, "config_host_add")
+	if len(ex.Roles) != 1 || ex.Roles[0].Condition == "" {
+		t.Fatalf("Explain() roles = %+v, want the failed role condition", ex.Roles)
+	}
+}
@@ -6,16 +6,18 @@
 // Example usage:
 //
 //	acl.Init(cfg)
-//	if !acl.Can("userx", "proxy_start") {
+//	if !acl.Can(acl.Context{User: "userx"}, "proxy_start", acl.ACLRuleSet{}) {
 //		return errors.New("permission denied")
 //	}
 package acl
 
 import (
 	"fmt"
+	"maps"
 	"slices"
+	"strings"
+	"sync/atomic"
 
-	"github.com/mfulz/portgeist/internal/logging"
 	"github.com/mfulz/portgeist/protocol"
 )
 
@@ -23,38 +25,55 @@ import (
 type Permission string
 
 // ACLRuleSet defines a set of permissions for a proxy or other object.
+// Proxies without rules use the default rule set, see ProxyRules.
 type ACLRuleSet struct {
-	// TODO defaults?
 	Rules []ACLRule `mapstructure:"rules"`
+
+	proxy  string // proxy matched against ACLRule.Proxies
+	source string // "proxy", "defaults", "host" or "backend"
+	object string // name of the proxy, host or backend owning the rules
 }
 
-// ACLRule defines permissions for a proxy or other object.
+// ACLRule defines permissions for a proxy or other object. Subjects and
+// Proxies are glob patterns (path.Match syntax); a subject matches the user
+// name or any group the user belongs to, including nested groups. Rules
+// whose conditions do not hold are skipped.
 type ACLRule struct {
 	Description string       `mapstructure:"description"`
 	Subjects    []string     `mapstructure:"subjects"`
 	Permissions []Permission `mapstructure:"permissions,omitempty"`
 	Deny        bool         `mapstructure:"deny"`
+	Proxies     []string     `mapstructure:"proxies,omitempty"` // proxies the rule applies to, all if empty
+	When        Conditions   `mapstructure:"when"`
 }
 
-// User defines a named user (e.g. login name).
+// User defines a named user (e.g. login name). A user whose conditions do
+// not hold is granted nothing.
 type User struct {
-	Name   string   `mapstructure:"name"`
-	Roles  []string `mapstructure:"roles"`
-	Token  string   `mapstructure:"token"`
+	Name   string     `mapstructure:"name"`
+	Roles  []string   `mapstructure:"roles"`
+	Token  string     `mapstructure:"token"`
+	When   Conditions `mapstructure:"when"`
 	groups []string
 }
 
-// Group defines a named group of users.
+// Group defines a named group of users. Members of the nested Groups
+// belong to this group as well.
 type Group struct {
 	Name    string   `mapstructure:"name"`
 	Members []string `mapstructure:"members"`
+	Groups  []string `mapstructure:"groups"`
 	Roles   []string `mapstructure:"roles"`
 }
 
-// Role defines a named role, grouping one or more permissions.
+// Role defines a named role, grouping one or more permissions. A role
+// grants the permissions of the roles it extends as well. A role whose
+// conditions do not hold grants nothing, neither do the roles it extends.
 type Role struct {
 	Name        string       `mapstructure:"name"`
 	Permissions []Permission `mapstructure:"permissions"`
+	Extends     []string     `mapstructure:"extends"`
+	When        Conditions   `mapstructure:"when"`
 }
 
 // ACLConfig defines the global ACL structure loaded from config.
@@ -63,6 +82,16 @@ type ACLConfig struct {
 	Users   map[string]User  `mapstructure:"users"`
 	Groups  map[string]Group `mapstructure:"groups"`
 	Roles   map[string]Role  `mapstructure:"roles"`
+
+	// Defaults apply to proxies without rules of their own.
+	Defaults ACLRuleSet `mapstructure:"defaults"`
+
+	// Credentials lists additional token sources consulted in order
+	// after the tokens configured on the users.
+	Credentials []CredentialSource `mapstructure:"credentials"`
+
+	// Sessions sets the lifetime of tokens issued by system.login.
+	Sessions SessionConfig `mapstructure:"sessions"`
 }
 
 // aclChecker represents the internal ACL state and evaluation logic.
@@ -71,215 +100,371 @@ type aclChecker struct {
 	users   map[string]User
 	groups  map[string]Group
 	roles   map[string]Role
+	perms   map[Permission]struct{} // permission names accepted at Init time
+
+	defaults  ACLRuleSet
+	verifiers []Verifier
+	sessions  SessionConfig
 }
 
 // aclhandle is the globally accessible instance used for all ACL checks.
-var aclhandle *aclChecker
-
-// validPerms holds the accepted set of permission names passed at Init time.
-var validPerms map[Permission]struct{}
+// It is replaced as a whole so checks never see a partially applied config.
+var aclhandle atomic.Pointer[aclChecker]
 
-// Init initializes the global ACL engine from config.
+// Init initializes the global ACL engine from config. Calling it again
+// swaps the engine atomically; checks in flight finish with the previous
+// one. On error the running engine is left untouched.
 func Init(cfg ACLConfig, perms []Permission) error {
+	a, err := newChecker(cfg, perms)
+	if err != nil {
+		return err
+	}
+	aclhandle.Store(a)
+	return nil
+}
+
+// Validate checks cfg like Init without installing it.
+func Validate(cfg ACLConfig, perms []Permission) error {
+	_, err := newChecker(cfg, perms)
+	return err
+}
+
+// newChecker validates cfg and builds the engine evaluating it.
+func newChecker(cfg ACLConfig, perms []Permission) (*aclChecker, error) {
+	// Names, group memberships and conditions are resolved on copies, so
+	// the caller's config is left as it was passed in.
+	users := maps.Clone(cfg.Users)
+	groups := maps.Clone(cfg.Groups)
+	roles := maps.Clone(cfg.Roles)
+	defaults := cfg.Defaults
+	defaults.Rules = slices.Clone(defaults.Rules)
+
 	pmap := make(map[Permission]struct{}, len(perms))
 	for _, p := range perms {
 		pmap[p] = struct{}{}
 	}
 
 	// Validate roles
-	for roleName, role := range cfg.Roles {
+	for roleName, role := range roles {
 		for _, perm := range role.Permissions {
 			if _, ok := pmap[perm]; !ok {
-				return fmt.Errorf("invalid permission '%s' in role '%s'", perm, roleName)
+				return nil, fmt.Errorf("invalid permission '%s' in role '%s'", perm, roleName)
+			}
+		}
+		for _, parent := range role.Extends {
+			if _, ok := roles[parent]; !ok {
+				return nil, fmt.Errorf("unknown role '%s' extended by role '%s'", parent, roleName)
 			}
 		}
+		if err := role.When.Compile(); err != nil {
+			return nil, fmt.Errorf("role '%s': when: %w", roleName, err)
+		}
 		if role.Name == "" {
 			role.Name = roleName
-			cfg.Roles[roleName] = role
 		}
+		roles[roleName] = role
+	}
+
+	if cycle := findCycle(roles, func(r Role) []string { return r.Extends }); cycle != nil {
+		return nil, fmt.Errorf("role inheritance cycle: %s", strings.Join(cycle, " -> "))
 	}
 
 	// Validate users
-	for name, user := range cfg.Users {
+	for name, user := range users {
 		if user.Name == "" {
 			user.Name = name
-			cfg.Users[name] = user
 		}
+		user.groups = nil
+		if err := user.When.Compile(); err != nil {
+			return nil, fmt.Errorf("user '%s': when: %w", name, err)
+		}
+		users[name] = user
+		if err := ValidateHash(user.Token); err != nil {
+			return nil, fmt.Errorf("invalid token of user '%s': %w", name, err)
+		}
+	}
+
+	verifiers := []Verifier{configVerifier(users)}
+	for i, src := range cfg.Credentials {
+		v, err := newVerifier(src)
+		if err != nil {
+			return nil, fmt.Errorf("credentials[%d]: %w", i, err)
+		}
+		verifiers = append(verifiers, v)
 	}
 
 	// Validate groups
-	for name, group := range cfg.Groups {
+	for name, group := range groups {
 		if group.Name == "" {
 			group.Name = name
-			cfg.Groups[name] = group
+			groups[name] = group
+		}
+		for _, nested := range group.Groups {
+			if _, ok := groups[nested]; !ok {
+				return nil, fmt.Errorf("unknown group '%s' nested in group '%s'", nested, name)
+			}
 		}
 
 		for _, member := range group.Members {
-			if u, ok := cfg.Users[member]; ok {
+			if u, ok := users[member]; ok {
 				u.groups = append(u.groups, group.Name)
-				cfg.Users[member] = u
+				users[member] = u
 				continue
 			}
 			// if user not existing error out
-			return fmt.Errorf("invalid user '%s' in group '%s'", member, group.Name)
+			return nil, fmt.Errorf("invalid user '%s' in group '%s'", member, group.Name)
 		}
 	}
 
-	aclhandle = &aclChecker{
-		enabled: cfg.Enabled,
-		users:   cfg.Users,
-		groups:  cfg.Groups,
-		roles:   cfg.Roles,
+	if cycle := findCycle(groups, func(g Group) []string { return g.Groups }); cycle != nil {
+		return nil, fmt.Errorf("group nesting cycle: %s", strings.Join(cycle, " -> "))
+	}
+	for name, user := range users {
+		user.groups = expandGroups(user.groups, groups)
+		users[name] = user
 	}
 
-	validPerms = pmap
-	return nil
+	if err := defaults.Compile(); err != nil {
+		return nil, fmt.Errorf("defaults: %w", err)
+	}
+
+	return &aclChecker{
+		enabled: cfg.Enabled,
+		users:   users,
+		groups:  groups,
+		roles:   roles,
+		perms:   pmap,
+
+		defaults:  defaults,
+		verifiers: verifiers,
+		sessions:  cfg.Sessions,
+	}, nil
 }
 
 // aclValid checks whether ACLs are ready and enabled.
 // Returns (true, false) → reject: uninitialized
 // Returns (true, true)  → allow: disabled in config
 // Returns (false, _)    → continue with normal check
-func aclValid() (bool, bool) {
-	if aclhandle == nil {
+func aclValid(a *aclChecker) (bool, bool) {
+	if a == nil {
 		return true, false
 	}
-	if !aclhandle.enabled {
+	if !a.enabled {
 		return true, true
 	}
 	return false, false
 }
 
-// Can checks whether the given user has the specified global permission.
-func Can(user string, perm Permission, rules ACLRuleSet) bool {
-	if handled, result := aclValid(); handled {
+// Can checks whether the user of ctx has the permission and the rules
+// allow it in ctx.
+func Can(ctx Context, perm Permission, rules ACLRuleSet) bool {
+	a := aclhandle.Load()
+	if handled, result := aclValid(a); handled {
 		return result
 	}
-	return aclhandle.can(user, perm, rules)
+	return a.can(ctx, perm, rules)
 }
 
-// hasPerm checks if the ACLRule has the permission. If perms are empty it matches all
-func (r *ACLRule) hasPerm(perm Permission) bool {
-	if len(r.Permissions) == 0 {
-		return true
+// CanAll checks whether the user of ctx has the permission and every rule
+// set, e.g. those of a proxy and the host it runs on, allows it.
+func CanAll(ctx Context, perm Permission, sets ...ACLRuleSet) bool {
+	a := aclhandle.Load()
+	if handled, result := aclValid(a); handled {
+		return result
 	}
-
-	return slices.Contains(r.Permissions, perm)
+	return a.can(ctx, perm, sets...)
 }
 
-// hasSubject checks if the ACLRule has the subject.
-func (r *ACLRule) hasSubject(user string) bool {
-	if len(r.Subjects) == 0 {
-		return false
+// ProxyRules returns the rules evaluated for the proxy name: its own rules,
+// or the default rule set if it has none. Rules limited to other proxies
+// are skipped during evaluation.
+func ProxyRules(name string, rules ACLRuleSet) ACLRuleSet {
+	rules.proxy = name
+	rules.object = name
+	rules.source = "proxy"
+	if a := aclhandle.Load(); len(rules.Rules) == 0 && a != nil {
+		rules.Rules = a.defaults.Rules
+		rules.source = "defaults"
 	}
+	return rules
+}
 
-	for _, s := range r.Subjects {
-		if aclhandle.userMatches(user, s) {
-			return true
-		}
-	}
+// HostRules returns the rules of the named host evaluated for proxy.
+func HostRules(proxy, host string, rules ACLRuleSet) ACLRuleSet {
+	rules.proxy = proxy
+	rules.object = host
+	rules.source = "host"
+	return rules
+}
 
-	return false
+// BackendRules returns the rules of the named backend evaluated for proxy.
+func BackendRules(proxy, backend string, rules ACLRuleSet) ACLRuleSet {
+	rules.proxy = proxy
+	rules.object = backend
+	rules.source = "backend"
+	return rules
 }
 
-// Authenticate checks if user uses correct token
-func Authenticate(authReq *protocol.Auth) bool {
-	if handled, result := aclValid(); handled {
-		return result
-	}
+// Validate checks the glob patterns and conditions of all rules.
+func (s ACLRuleSet) Validate() error {
+	s.Rules = slices.Clone(s.Rules)
+	return s.Compile()
+}
 
-	if authReq == nil {
-		return false
+// Compile validates the rules like Validate and compiles their conditions
+// in place. Rule sets loaded with the config are compiled once, so checks
+// do not parse them again.
+func (s *ACLRuleSet) Compile() error {
+	for i := range s.Rules {
+		rule := &s.Rules[i]
+		for _, pattern := range append(slices.Clone(rule.Subjects), rule.Proxies...) {
+			if !validPattern(pattern) {
+				return fmt.Errorf("rule %d: invalid pattern %q", i, pattern)
+			}
+		}
+		if err := rule.When.Compile(); err != nil {
+			return fmt.Errorf("rule %d: when: %w", i, err)
+		}
 	}
-
-	return aclhandle.userCredsValid(authReq.User, authReq.Token)
+	return nil
 }
 
-// authenticate authenticate the user by token verification
-func (a *aclChecker) userCredsValid(user, token string) bool {
-	u, ok := a.users[user]
-	if !ok {
-		return false
+// appliesTo checks if the rule applies to the object. Rules without
+// proxy patterns apply to all objects.
+func (r *ACLRule) appliesTo(object string) bool {
+	if len(r.Proxies) == 0 {
+		return true
 	}
-
-	return u.Token == token
+	return slices.ContainsFunc(r.Proxies, func(pattern string) bool {
+		return globMatch(pattern, object)
+	})
 }
 
-// matchRules checks if the actual user is matching the acl rules
-func (a *aclChecker) can(user string, perm Permission, rules ACLRuleSet) bool {
-	matches := false
+// hasPerm checks if the ACLRule has the permission. If perms are empty it matches all
+func (r *ACLRule) hasPerm(perm Permission) bool {
+	if len(r.Permissions) == 0 {
+		return true
+	}
 
-	logging.Log.Debugf("Ruleset: %v", rules)
+	return slices.Contains(r.Permissions, perm)
+}
 
-	if !a.userHasPermission(user, perm) {
-		return false
-	}
+// Identity describes who a request acts as and how that was established.
+// Transports pass the identity they verified themselves, e.g. a TLS client
+// certificate or unix peer credentials, to Authenticate as peer.
+type Identity struct {
+	User    string   // ACL user the caller acts as
+	Source  string   // "token", "session", "tls" or "peercred"
+	Session *Session // set for Source "session"
+}
 
-	if len(rules.Rules) == 0 {
-		// all roles, groups are allowed just permission needs to be checked
-		return true
+// Authenticate checks the credentials of a request and returns the identity
+// it acts as. Session tokens issued by Login and long-lived tokens are
+// verified against the named user. Without a token the caller is
+// identified by peer, if given; the request may then only name peer's user.
+func Authenticate(authReq *protocol.Auth, peer *Identity) (Identity, error) {
+	var user, token string
+	if authReq != nil {
+		user, token = authReq.User, authReq.Token
 	}
 
-	for _, rule := range rules.Rules {
-		if !rule.hasPerm(perm) {
-			continue
+	a := aclhandle.Load()
+	if handled, result := aclValid(a); handled {
+		if !result {
+			return Identity{User: user}, ErrInvalidCredentials
 		}
-
-		if !rule.hasSubject(user) {
-			continue
+		if user == "" && peer != nil {
+			return *peer, nil
 		}
+		return Identity{User: user, Source: "token"}, nil
+	}
 
-		if rule.Deny {
-			return false
+	if IsSessionToken(token) {
+		s, err := verifySession(user, token)
+		if err != nil {
+			return Identity{User: user}, err
 		}
-		matches = true
+		if _, ok := a.users[s.User]; !ok {
+			return Identity{User: s.User}, ErrInvalidCredentials
+		}
+		return Identity{User: s.User, Source: "session", Session: s}, nil
 	}
 
-	return matches
-}
-
-// userHasPermission checks if user belongs to group that owns a role.
-func (a *aclChecker) userRoles(user User) []string {
-	var ret []string
-
-	ret = append(ret, user.Roles...)
-
-	for _, groupName := range user.groups {
-		if group, ok := a.groups[groupName]; ok {
-			ret = append(ret, group.Roles...)
+	if token != "" {
+		if !a.userCredsValid(user, token) {
+			return Identity{User: user}, ErrInvalidCredentials
 		}
+		return Identity{User: user, Source: "token"}, nil
 	}
 
-	return ret
+	if peer == nil || peer.User == "" || (user != "" && user != peer.User) {
+		return Identity{User: user}, ErrInvalidCredentials
+	}
+	if _, ok := a.users[peer.User]; !ok {
+		return Identity{User: peer.User}, ErrInvalidCredentials
+	}
+	return *peer, nil
 }
 
-// userHasPermission checks if user belongs to group that owns a role.
-func (a *aclChecker) userHasPermission(user string, perm Permission) bool {
-	u, ok := a.users[user]
-	if !ok {
+// userCredsValid authenticates the user by asking the verifiers in order;
+// the first one holding credentials for the user decides. Users without
+// any credentials can only authenticate by transport identity.
+func (a *aclChecker) userCredsValid(user, token string) bool {
+	if _, ok := a.users[user]; !ok {
 		return false
 	}
 
-	uroles := a.userRoles(u)
-	for _, roleName := range uroles {
-		if role, ok := a.roles[roleName]; ok {
-			if slices.Contains(role.Permissions, perm) {
-				return true
-			}
+	for _, v := range a.verifiers {
+		if found, valid := v.Verify(user, token); found {
+			return valid
 		}
 	}
-
 	return false
 }
 
-// userMatches returns true if the subject matches the user or one of their groups.
+// can checks if the actual user is matching the acl rules
+func (a *aclChecker) can(ctx Context, perm Permission, sets ...ACLRuleSet) bool {
+	return a.explain(ctx, perm, sets...).Allowed
+}
+
+// userMatches returns true if the subject pattern matches the user or one
+// of their groups.
 func (a *aclChecker) userMatches(user string, subject string) bool {
-	if subject == user {
+	if globMatch(subject, user) {
 		return true
 	}
 	u, ok := a.users[user]
 	if !ok {
 		return false
 	}
-	return slices.Contains(u.groups, subject)
+	return slices.ContainsFunc(u.groups, func(group string) bool {
+		return globMatch(subject, group)
+	})
+}
+
+// roleGrant returns the role granting perm to roleName in ctx, which is
+// roleName itself or one of the roles it extends. If none does, the first
+// role condition that failed on the way is returned.
+func (a *aclChecker) roleGrant(ctx Context, roleName string, perm Permission) (string, bool, string) {
+	role, ok := a.roles[roleName]
+	if !ok {
+		return "", false, ""
+	}
+	if ok, cond := role.When.match(ctx); !ok {
+		return "", false, fmt.Sprintf("role '%s': %s", roleName, cond)
+	}
+	if slices.Contains(role.Permissions, perm) {
+		return roleName, true, ""
+	}
+	var failed string
+	for _, parent := range role.Extends {
+		by, ok, cond := a.roleGrant(ctx, parent, perm)
+		if ok {
+			return by, true, ""
+		}
+		if failed == "" {
+			failed = cond
+		}
+	}
+	return "", false, failed
 }
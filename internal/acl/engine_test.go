@@ -0,0 +1,175 @@
+package acl
+
+import (
+	"strings"
+	"testing"
+)
+
+// testConfig is the policy evaluated by TestCan.
+func testConfig() ACLConfig {
+	return ACLConfig{
+		Enabled: true,
+		Users: map[string]User{
+			"alice":   {Roles: []string{"admin"}},
+			"bob":     {},
+			"carol":   {},
+			"ops-dan": {Roles: []string{"viewer"}},
+			"eve":     {Roles: []string{"viewer"}},
+		},
+		Groups: map[string]Group{
+			"staff":  {Groups: []string{"oncall"}, Roles: []string{"viewer"}},
+			"oncall": {Members: []string{"bob"}, Groups: []string{"pager"}, Roles: []string{"operator"}},
+			"pager":  {Members: []string{"carol"}},
+		},
+		Roles: map[string]Role{
+			"viewer":   {Permissions: []Permission{"proxy_list"}},
+			"operator": {Permissions: []Permission{"proxy_start", "proxy_stop"}, Extends: []string{"viewer"}},
+			"admin":    {Permissions: []Permission{"config_host_add"}, Extends: []string{"operator"}},
+		},
+		Defaults: ACLRuleSet{Rules: []ACLRule{
+			{Subjects: []string{"staff", "admin*", "alice"}},
+		}},
+	}
+}
+
+func TestCan(t *testing.T) {
+	initLogging(t)
+	if err := Init(testConfig(), testPerms); err != nil {
+		t.Fatal(err)
+	}
+
+	prod := []ACLRule{
+		{Subjects: []string{"ops-*"}, Proxies: []string{"prod-*"}},
+		{Subjects: []string{"oncall"}},
+		{Subjects: []string{"pager"}, Permissions: []Permission{"proxy_stop"}, Deny: true},
+	}
+
+	tests := []struct {
+		name  string
+		user  string
+		perm  Permission
+		rules ACLRuleSet
+		want  bool
+	}{
+		{"unknown user", "mallory", "proxy_list", ACLRuleSet{}, false},
+		{"no role grants", "ops-dan", "proxy_start", ACLRuleSet{}, false},
+		{"direct role", "alice", "config_host_add", ACLRuleSet{}, true},
+		{"inherited role", "alice", "proxy_list", ACLRuleSet{}, true},
+		{"role of group", "bob", "proxy_start", ACLRuleSet{}, true},
+		{"role of nested group", "carol", "proxy_start", ACLRuleSet{}, true},
+		{"role of outer group", "carol", "proxy_list", ACLRuleSet{}, true},
+		{"role inherited via group", "bob", "proxy_list", ACLRuleSet{}, true},
+		{"group role does not leak", "bob", "config_host_add", ACLRuleSet{}, false},
+
+		{"defaults by user", "alice", "proxy_start", ProxyRules("web", ACLRuleSet{}), true},
+		{"defaults by outer group of nested member", "carol", "proxy_start", ProxyRules("web", ACLRuleSet{}), true},
+		{"defaults do not match", "eve", "proxy_list", ProxyRules("web", ACLRuleSet{}), false},
+		{"own rules replace defaults", "alice", "proxy_start", ProxyRules("web", ACLRuleSet{Rules: prod}), false},
+
+		{"glob subject and proxy", "ops-dan", "proxy_list", ProxyRules("prod-eu", ACLRuleSet{Rules: prod}), true},
+		{"glob proxy does not match", "ops-dan", "proxy_list", ProxyRules("dev-eu", ACLRuleSet{Rules: prod}), false},
+		{"group subject", "bob", "proxy_stop", ProxyRules("dev-eu", ACLRuleSet{Rules: prod}), true},
+		{"nested group subject", "carol", "proxy_start", ProxyRules("dev-eu", ACLRuleSet{Rules: prod}), true},
+		{"deny wins over allow", "carol", "proxy_stop", ProxyRules("dev-eu", ACLRuleSet{Rules: prod}), false},
+		{"deny limited to its permission", "carol", "proxy_list", ProxyRules("dev-eu", ACLRuleSet{Rules: prod}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := Context{User: tt.user}
+			if got := Can(ctx, tt.perm, tt.rules); got != tt.want {
+				t.Fatalf("Can() = %t, want %t: %s", got, tt.want, Explain(ctx, tt.perm, tt.rules).Reason)
+			}
+		})
+	}
+}
+
+func TestCanDisabledAndUninitialized(t *testing.T) {
+	initLogging(t)
+	aclhandle.Store(nil)
+	if Can(Context{User: "alice"}, "proxy_list", ACLRuleSet{}) {
+		t.Fatal("Can() allowed before Init")
+	}
+
+	cfg := testConfig()
+	cfg.Enabled = false
+	if err := Init(cfg, testPerms); err != nil {
+		t.Fatal(err)
+	}
+	if !Can(Context{User: "mallory"}, "config_host_add", ACLRuleSet{}) {
+		t.Fatal("Can() denied with ACL disabled")
+	}
+}
+
+func TestInitValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *ACLConfig)
+		wantErr string
+	}{
+		{"valid", func(cfg *ACLConfig) {}, ""},
+		{"unknown permission", func(cfg *ACLConfig) {
+			cfg.Roles["viewer"] = Role{Permissions: []Permission{"proxy_fly"}}
+		}, "invalid permission 'proxy_fly'"},
+		{"unknown extended role", func(cfg *ACLConfig) {
+			cfg.Roles["viewer"] = Role{Extends: []string{"root"}}
+		}, "unknown role 'root'"},
+		{"role cycle", func(cfg *ACLConfig) {
+			cfg.Roles["viewer"] = Role{Extends: []string{"admin"}}
+		}, "role inheritance cycle"},
+		{"unknown nested group", func(cfg *ACLConfig) {
+			cfg.Groups["pager"] = Group{Groups: []string{"nobody"}}
+		}, "unknown group 'nobody'"},
+		{"group cycle", func(cfg *ACLConfig) {
+			cfg.Groups["pager"] = Group{Groups: []string{"staff"}}
+		}, "group nesting cycle"},
+		{"unknown member", func(cfg *ACLConfig) {
+			cfg.Groups["pager"] = Group{Members: []string{"mallory"}}
+		}, "invalid user 'mallory'"},
+		{"invalid default pattern", func(cfg *ACLConfig) {
+			cfg.Defaults.Rules[0].Subjects = []string{"[staff"}
+		}, "invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			tt.modify(&cfg)
+			err := Validate(cfg, testPerms)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInitKeepsConfig(t *testing.T) {
+	initLogging(t)
+	cfg := testConfig()
+	cfg.Defaults.Rules[0].When = Conditions{Sources: []string{"127.0.0.1"}}
+	if err := Init(cfg, testPerms); err != nil {
+		t.Fatalf("Init() = %v", err)
+	}
+
+	if u := cfg.Users["bob"]; u.Name != "" || u.groups != nil {
+		t.Errorf("user bob = %+v, want it unresolved", u)
+	}
+	if g := cfg.Groups["pager"]; g.Name != "" {
+		t.Errorf("group pager = %+v, want it unresolved", g)
+	}
+	if r := cfg.Roles["viewer"]; r.Name != "" {
+		t.Errorf("role viewer = %+v, want it unresolved", r)
+	}
+	if cfg.Defaults.Rules[0].When.compiled != nil {
+		t.Error("default rules compiled in place")
+	}
+
+	// the engine still resolved them
+	if !Can(Context{User: "carol"}, "proxy_list", ACLRuleSet{}) {
+		t.Error("carol lost the roles granted through nested groups")
+	}
+}
@@ -0,0 +1,198 @@
+package acl
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// Explain evaluates perm for ctx against the rule sets exactly like CanAll
+// and returns the trace of every role and rule considered.
+func Explain(ctx Context, perm Permission, sets ...ACLRuleSet) *protocol.ACLExplanation {
+	a := aclhandle.Load()
+	if a == nil {
+		return &protocol.ACLExplanation{User: ctx.User, Permission: string(perm), Reason: "ACL engine not initialized"}
+	}
+	if !a.enabled {
+		return &protocol.ACLExplanation{User: ctx.User, Permission: string(perm), Allowed: true, Reason: "ACL disabled"}
+	}
+	return a.explain(ctx, perm, sets...)
+}
+
+// IsPermission reports whether perm was registered with Init.
+func IsPermission(perm Permission) bool {
+	a := aclhandle.Load()
+	if a == nil {
+		return false
+	}
+	_, ok := a.perms[perm]
+	return ok
+}
+
+// explain implements the permission check. A user whose conditions hold
+// needs a role granting perm whose conditions hold as well; in every rule
+// set with rules, at least one rule must allow the user and none may deny
+// it. Without rule sets, e.g. for global permissions, only the user and
+// role conditions restrict the context.
+func (a *aclChecker) explain(ctx Context, perm Permission, sets ...ACLRuleSet) *protocol.ACLExplanation {
+	if ctx.Time.IsZero() {
+		ctx.Time = time.Now()
+	}
+	user := ctx.User
+	ex := &protocol.ACLExplanation{
+		User:       user,
+		Permission: string(perm),
+		Instance:   ctx.Instance,
+		Source:     ctx.Source,
+		Time:       ctx.Time.Format(time.RFC3339),
+	}
+
+	u, ok := a.users[user]
+	if !ok {
+		ex.Reason = fmt.Sprintf("unknown user '%s'", user)
+		return ex
+	}
+	ex.KnownUser = true
+	ex.Groups = slices.Clone(u.groups)
+
+	if ok, cond := u.When.match(ctx); !ok {
+		ex.Reason = fmt.Sprintf("conditions of user '%s' do not hold: %s", user, cond)
+		return ex
+	}
+
+	granted := false
+	failed := ""
+	for _, rt := range a.roleTraces(u) {
+		if rt.Exists {
+			rt.GrantedBy, rt.Grants, rt.Condition = a.roleGrant(ctx, rt.Name, perm)
+			granted = granted || rt.Grants
+			if failed == "" {
+				failed = rt.Condition
+			}
+		}
+		ex.Roles = append(ex.Roles, rt)
+	}
+	if !granted {
+		ex.Reason = fmt.Sprintf("no role of '%s' grants '%s'", user, perm)
+		if failed != "" {
+			ex.Reason += fmt.Sprintf(" (%s)", failed)
+		}
+		return ex
+	}
+
+	ex.Allowed = true
+	ex.Reason = "granted by role, no rules apply"
+	for _, rules := range sets {
+		if len(rules.Rules) == 0 {
+			// all roles, groups are allowed just permission needs to be checked
+			continue
+		}
+		st := a.explainSet(ctx, perm, rules)
+		ex.RuleSets = append(ex.RuleSets, st)
+
+		// the first denying set decides, later ones are still traced
+		if !ex.Allowed {
+			continue
+		}
+		ex.Allowed = st.Allowed
+		ex.Reason = fmt.Sprintf("%s '%s': %s", st.Source, st.Object, st.Reason)
+	}
+	return ex
+}
+
+// explainSet evaluates a single rule set.
+func (a *aclChecker) explainSet(ctx Context, perm Permission, rules ACLRuleSet) protocol.ACLRuleSetTrace {
+	st := protocol.ACLRuleSetTrace{Source: rules.source, Object: rules.object}
+
+	var allowedBy, deniedBy *protocol.ACLRuleTrace
+	for i, rule := range rules.Rules {
+		rt := protocol.ACLRuleTrace{
+			Index:       i,
+			Description: rule.Description,
+			Subjects:    rule.Subjects,
+			Deny:        rule.Deny,
+			Proxies:     rule.Proxies,
+			Effect:      "skip",
+		}
+		for _, p := range rule.Permissions {
+			rt.Permissions = append(rt.Permissions, string(p))
+		}
+
+		rt.ProxyMatch = rule.appliesTo(rules.proxy)
+		rt.PermissionMatch = rule.hasPerm(perm)
+		if rt.ProxyMatch && rt.PermissionMatch {
+			rt.MatchedSubject = a.matchingSubject(ctx.User, rule.Subjects)
+		}
+		rt.ConditionMatch = true
+		if rt.MatchedSubject != "" && !rule.When.empty() {
+			rt.ConditionMatch, rt.Condition = rule.When.match(ctx)
+		}
+		if rt.ProxyMatch && rt.PermissionMatch && rt.MatchedSubject != "" && rt.ConditionMatch {
+			if rule.Deny {
+				rt.Effect = "deny"
+			} else {
+				rt.Effect = "allow"
+			}
+		}
+		st.Rules = append(st.Rules, rt)
+
+		last := &st.Rules[len(st.Rules)-1]
+		switch {
+		case last.Effect == "deny" && deniedBy == nil:
+			deniedBy = last
+		case last.Effect == "allow" && allowedBy == nil:
+			allowedBy = last
+		}
+	}
+
+	switch {
+	case deniedBy != nil:
+		st.Reason = fmt.Sprintf("denied by rule %d%s (subject '%s')", deniedBy.Index, describe(deniedBy), deniedBy.MatchedSubject)
+	case allowedBy != nil:
+		st.Allowed = true
+		st.Reason = fmt.Sprintf("allowed by rule %d%s (subject '%s')", allowedBy.Index, describe(allowedBy), allowedBy.MatchedSubject)
+	default:
+		st.Reason = "no rule matches the user and permission"
+	}
+	return st
+}
+
+// roleTraces lists the roles of u with where they come from.
+func (a *aclChecker) roleTraces(u User) []protocol.ACLRoleTrace {
+	var out []protocol.ACLRoleTrace
+	for _, r := range u.Roles {
+		_, exists := a.roles[r]
+		out = append(out, protocol.ACLRoleTrace{Name: r, Via: "user", Exists: exists})
+	}
+	for _, groupName := range u.groups {
+		group, ok := a.groups[groupName]
+		if !ok {
+			continue
+		}
+		for _, r := range group.Roles {
+			_, exists := a.roles[r]
+			out = append(out, protocol.ACLRoleTrace{Name: r, Via: "group:" + groupName, Exists: exists})
+		}
+	}
+	return out
+}
+
+// matchingSubject returns the first subject matching user or "".
+func (a *aclChecker) matchingSubject(user string, subjects []string) string {
+	for _, s := range subjects {
+		if a.userMatches(user, s) {
+			return s
+		}
+	}
+	return ""
+}
+
+// describe formats a rule description for reasons.
+func describe(rt *protocol.ACLRuleTrace) string {
+	if rt.Description == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", rt.Description)
+}
@@ -0,0 +1,137 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// explainConfig has alice granted proxy_start directly, bob through the ops
+// group and carol with a role that does not exist.
+func explainConfig() ACLConfig {
+	return ACLConfig{
+		Enabled: true,
+		Users: map[string]User{
+			"alice": {Roles: []string{"starter"}, Token: "a"},
+			"bob":   {Token: "b"},
+			"carol": {Roles: []string{"ghost"}, Token: "c"},
+		},
+		Groups: map[string]Group{
+			"ops": {Members: []string{"bob"}, Roles: []string{"starter", "lister"}},
+		},
+		Roles: map[string]Role{
+			"starter": {Permissions: []Permission{"proxy_start"}},
+			"lister":  {Permissions: []Permission{"proxy_list"}},
+		},
+	}
+}
+
+func TestExplain(t *testing.T) {
+	initLogging(t)
+	if err := Init(explainConfig(), testPerms); err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+
+	rules := ProxyRules("pp", ACLRuleSet{Rules: []ACLRule{
+		{Description: "ops may stop", Subjects: []string{"ops"}, Permissions: []Permission{"proxy_stop"}},
+		{Description: "no bob", Subjects: []string{"bob"}, Permissions: []Permission{"proxy_start"}, Deny: true},
+		{Subjects: []string{"alice", "ops"}, Permissions: []Permission{"proxy_start"}},
+	}})
+	host := HostRules("pp", "zurich", ACLRuleSet{Rules: []ACLRule{
+		{Subjects: []string{"ops"}},
+	}})
+
+	tests := []struct {
+		name    string
+		user    string
+		perm    Permission
+		sets    []ACLRuleSet
+		allowed bool
+		reason  string
+		effects [][]string
+	}{
+		{"unknown user", "mallory", "proxy_start", nil, false, "unknown user 'mallory'", nil},
+		{"missing role", "carol", "proxy_start", nil, false, "no role of 'carol' grants 'proxy_start'", nil},
+		{"role without rules", "alice", "proxy_start", nil, true, "granted by role, no rules apply", nil},
+		{"allowed by rule", "alice", "proxy_start", []ACLRuleSet{rules}, true, "proxy 'pp': allowed by rule 2 (subject 'alice')",
+			[][]string{{"skip", "skip", "allow"}}},
+		{"denied by rule", "bob", "proxy_start", []ACLRuleSet{rules}, false, "proxy 'pp': denied by rule 1 (no bob) (subject 'bob')",
+			[][]string{{"skip", "deny", "allow"}}},
+		{"no rule matches", "bob", "proxy_list", []ACLRuleSet{rules}, false, "proxy 'pp': no rule matches the user and permission",
+			[][]string{{"skip", "skip", "skip"}}},
+		{"denied by host", "alice", "proxy_start", []ACLRuleSet{rules, host}, false, "host 'zurich': no rule matches the user and permission",
+			[][]string{{"skip", "skip", "allow"}, {"skip"}}},
+		{"denying set decides", "bob", "proxy_start", []ACLRuleSet{rules, host}, false, "proxy 'pp': denied by rule 1 (no bob) (subject 'bob')",
+			[][]string{{"skip", "deny", "allow"}, {"allow"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := Context{User: tt.user}
+			ex := Explain(ctx, tt.perm, tt.sets...)
+			if ex.Allowed != tt.allowed {
+				t.Errorf("Allowed = %t, want %t", ex.Allowed, tt.allowed)
+			}
+			if ex.Reason != tt.reason {
+				t.Errorf("Reason = %q, want %q", ex.Reason, tt.reason)
+			}
+			if got := CanAll(ctx, tt.perm, tt.sets...); got != ex.Allowed {
+				t.Errorf("CanAll() = %t disagrees with Explain()", got)
+			}
+			if len(ex.RuleSets) != len(tt.effects) {
+				t.Fatalf("got %d rule set traces, want %d", len(ex.RuleSets), len(tt.effects))
+			}
+			for i, effects := range tt.effects {
+				rules := ex.RuleSets[i].Rules
+				if len(rules) != len(effects) {
+					t.Fatalf("set %d: got %d rule traces, want %d", i, len(rules), len(effects))
+				}
+				for j, effect := range effects {
+					if rules[j].Effect != effect {
+						t.Errorf("set %d rule %d effect = %q, want %q", i, j, rules[j].Effect, effect)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExplainRoles(t *testing.T) {
+	initLogging(t)
+	if err := Init(explainConfig(), testPerms); err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+
+	ex := Explain(Context{User: "bob"}, "proxy_list", ACLRuleSet{})
+	if !ex.KnownUser || len(ex.Groups) != 1 || ex.Groups[0] != "ops" {
+		t.Fatalf("KnownUser = %t, Groups = %v", ex.KnownUser, ex.Groups)
+	}
+	want := []protocol.ACLRoleTrace{
+		{Name: "starter", Via: "group:ops", Exists: true, Grants: false},
+		{Name: "lister", Via: "group:ops", Exists: true, Grants: true, GrantedBy: "lister"},
+	}
+	if len(ex.Roles) != len(want) {
+		t.Fatalf("Roles = %+v, want %+v", ex.Roles, want)
+	}
+	for i := range want {
+		if ex.Roles[i] != want[i] {
+			t.Errorf("role %d = %+v, want %+v", i, ex.Roles[i], want[i])
+		}
+	}
+
+	ex = Explain(Context{User: "carol"}, "proxy_start", ACLRuleSet{})
+	if len(ex.Roles) != 1 || ex.Roles[0].Exists || ex.Roles[0].Via != "user" {
+		t.Errorf("Roles = %+v, want the missing user role 'ghost'", ex.Roles)
+	}
+}
+
+func TestExplainDisabled(t *testing.T) {
+	initLogging(t)
+	if err := Init(ACLConfig{}, testPerms); err != nil {
+		t.Fatalf("Init() failed: %v", err)
+	}
+	ex := Explain(Context{User: "anyone"}, "proxy_start", ACLRuleSet{})
+	if !ex.Allowed || ex.Reason != "ACL disabled" {
+		t.Errorf("Explain() = %t %q, want allowed by disabled ACL", ex.Allowed, ex.Reason)
+	}
+}
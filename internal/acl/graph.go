@@ -0,0 +1,95 @@
+package acl
+
+import (
+	"path"
+	"slices"
+	"sort"
+)
+
+// globMatch reports whether name matches pattern. Invalid patterns, which
+// Validate rejects, never match.
+func globMatch(pattern, name string) bool {
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
+
+// validPattern reports whether pattern is a valid glob pattern.
+func validPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// findCycle returns a cycle in the graph formed by the nodes and their
+// edges, starting and ending with the same node, or nil if there is none.
+// Edges to unknown nodes are ignored.
+func findCycle[T any](nodes map[string]T, edges func(T) []string) []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(nodes))
+
+	var stack []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			start := slices.Index(stack, name)
+			return append(slices.Clone(stack[start:]), name)
+		case done:
+			return nil
+		}
+		node, ok := nodes[name]
+		if !ok {
+			return nil
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, next := range edges(node) {
+			if cycle := visit(next); cycle != nil {
+				return cycle
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+		return nil
+	}
+
+	// sorted for a stable error message
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// expandGroups adds every group that transitively nests one of the direct
+// groups. Direct groups come first.
+func expandGroups(direct []string, groups map[string]Group) []string {
+	parents := make(map[string][]string)
+	for name, g := range groups {
+		for _, nested := range g.Groups {
+			parents[nested] = append(parents[nested], name)
+		}
+	}
+	for _, p := range parents {
+		sort.Strings(p)
+	}
+
+	out := slices.Clone(direct)
+	for i := 0; i < len(out); i++ {
+		for _, parent := range parents[out[i]] {
+			if !slices.Contains(out, parent) {
+				out = append(out, parent)
+			}
+		}
+	}
+	return out
+}
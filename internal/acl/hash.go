@@ -0,0 +1,163 @@
+package acl
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Token hash algorithms accepted by HashToken.
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+)
+
+// argon2id parameters for new hashes (OWASP minimum recommendation).
+// Verification uses the parameters stored in the hash.
+const (
+	argonMemory  = 19 * 1024 // KiB
+	argonTime    = 2
+	argonThreads = 1
+	argonKeyLen  = 32
+	argonSaltLen = 16
+)
+
+// Upper bounds for the parameters of stored argon2id hashes. Every
+// authentication recomputes the hash, a configured hash must not be able
+// to exhaust the daemon's memory or CPU.
+const (
+	maxArgonMemory  = 1024 * 1024 // KiB
+	maxArgonTime    = 16
+	maxArgonThreads = 16
+	maxArgonKeyLen  = 128
+)
+
+// HashToken returns a salted hash of token in PHC string format
+// ($argon2id$...) or as bcrypt hash ($2a$...).
+func HashToken(token, algorithm string) (string, error) {
+	switch algorithm {
+	case "", HashArgon2id:
+		salt := make([]byte, argonSaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(token), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, argonMemory, argonTime, argonThreads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)), nil
+	case HashBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.DefaultCost)
+		return string(hash), err
+	}
+	return "", fmt.Errorf("unsupported hash algorithm %q", algorithm)
+}
+
+// NewToken returns a random control token.
+func NewToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// IsHashed reports whether stored is a token hash rather than a plaintext token.
+func IsHashed(stored string) bool {
+	return strings.HasPrefix(stored, "$argon2id$") || isBcrypt(stored)
+}
+
+// VerifyToken compares token against stored, which is either a hash
+// produced by HashToken or a plaintext token. Plaintext tokens are
+// compared in constant time.
+func VerifyToken(stored, token string) bool {
+	switch {
+	case stored == "":
+		return false
+	case strings.HasPrefix(stored, "$argon2id$"):
+		return verifyArgon2id(stored, token)
+	case isBcrypt(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(token)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(token)) == 1
+}
+
+// ValidateHash checks that stored is either plaintext or a well-formed hash.
+func ValidateHash(stored string) error {
+	switch {
+	case strings.HasPrefix(stored, "$argon2id$"):
+		_, _, _, err := parseArgon2id(stored)
+		return err
+	case isBcrypt(stored):
+		_, err := bcrypt.Cost([]byte(stored))
+		return err
+	}
+	return nil
+}
+
+// isBcrypt reports whether stored looks like a bcrypt hash.
+func isBcrypt(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$")
+}
+
+// argonParams are the cost parameters stored in an argon2id hash.
+type argonParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// parseArgon2id splits an argon2id PHC string.
+func parseArgon2id(stored string) (argonParams, []byte, []byte, error) {
+	var p argonParams
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, fmt.Errorf("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2id parameters %q", parts[3])
+	}
+	switch {
+	case p.memory < 1 || p.memory > maxArgonMemory:
+		return p, nil, nil, fmt.Errorf("argon2id memory must be between 1 and %d KiB", maxArgonMemory)
+	case p.time < 1 || p.time > maxArgonTime:
+		return p, nil, nil, fmt.Errorf("argon2id time must be between 1 and %d", maxArgonTime)
+	case p.threads < 1 || p.threads > maxArgonThreads:
+		return p, nil, nil, fmt.Errorf("argon2id parallelism must be between 1 and %d", maxArgonThreads)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	if len(salt) == 0 {
+		return p, nil, nil, fmt.Errorf("argon2id salt is empty")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("malformed argon2id key: %w", err)
+	}
+	if len(key) == 0 || len(key) > maxArgonKeyLen {
+		return p, nil, nil, fmt.Errorf("argon2id key must be between 1 and %d bytes", maxArgonKeyLen)
+	}
+	return p, salt, key, nil
+}
+
+// verifyArgon2id recomputes the key for token and compares in constant time.
+func verifyArgon2id(stored, token string) bool {
+	p, salt, key, err := parseArgon2id(stored)
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(token), salt, p.time, p.memory, p.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
@@ -0,0 +1,191 @@
+package acl
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mfulz/portgeist/protocol"
+	"golang.org/x/crypto/argon2"
+)
+
+func TestVerifyToken(t *testing.T) {
+	argonHash, err := HashToken("s3cret", HashArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := HashToken("s3cret", HashBcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hash with parameters other than the defaults of HashToken
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte("s3cret"), salt, 1, 8*1024, 2, 16)
+	customHash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, 8*1024, 1, 2,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	tests := []struct {
+		name   string
+		stored string
+		token  string
+		want   bool
+	}{
+		{"argon2id match", argonHash, "s3cret", true},
+		{"argon2id mismatch", argonHash, "s3cre", false},
+		{"argon2id stored parameters", customHash, "s3cret", true},
+		{"argon2id stored parameters mismatch", customHash, "other", false},
+		{"argon2id malformed", "$argon2id$v=19$m=1$abc", "s3cret", false},
+		// would make argon2.IDKey panic
+		{"argon2id zero parallelism", "$argon2id$v=19$m=19456,t=2,p=0$c2FsdA$a2V5", "s3cret", false},
+		{"argon2id zero time", "$argon2id$v=19$m=19456,t=0,p=1$c2FsdA$a2V5", "s3cret", false},
+		{"bcrypt match", bcryptHash, "s3cret", true},
+		{"bcrypt mismatch", bcryptHash, "S3cret", false},
+		{"plaintext match", "s3cret", "s3cret", true},
+		{"plaintext mismatch", "s3cret", "s3cret ", false},
+		{"empty stored", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyToken(tt.stored, tt.token); got != tt.want {
+				t.Fatalf("VerifyToken() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		prefix    string
+		wantErr   bool
+	}{
+		{"", "$argon2id$", false},
+		{HashArgon2id, "$argon2id$", false},
+		{HashBcrypt, "$2a$", false},
+		{"md5", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			hash, err := HashToken("s3cret", tt.algorithm)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("HashToken() = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !strings.HasPrefix(hash, tt.prefix) || !IsHashed(hash) {
+				t.Fatalf("HashToken() = %q, want prefix %q", hash, tt.prefix)
+			}
+			if err := ValidateHash(hash); err != nil {
+				t.Fatalf("ValidateHash() = %v", err)
+			}
+			again, _ := HashToken("s3cret", tt.algorithm)
+			if again == hash {
+				t.Fatal("HashToken() is not salted")
+			}
+		})
+	}
+}
+
+func TestValidateHash(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  string
+		wantErr bool
+	}{
+		{"plaintext", "s3cret", false},
+		{"argon2id wrong version", "$argon2id$v=16$m=19456,t=2,p=1$c2FsdA$a2V5", true},
+		{"argon2id bad parameters", "$argon2id$v=19$m=x,t=2,p=1$c2FsdA$a2V5", true},
+		{"argon2id bad salt", "$argon2id$v=19$m=19456,t=2,p=1$!!$a2V5", true},
+		{"argon2id missing part", "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA", true},
+		{"argon2id zero memory", "$argon2id$v=19$m=0,t=2,p=1$c2FsdA$a2V5", true},
+		{"argon2id memory too high", "$argon2id$v=19$m=4194304,t=2,p=1$c2FsdA$a2V5", true},
+		{"argon2id zero time", "$argon2id$v=19$m=19456,t=0,p=1$c2FsdA$a2V5", true},
+		{"argon2id time too high", "$argon2id$v=19$m=19456,t=1000,p=1$c2FsdA$a2V5", true},
+		{"argon2id zero parallelism", "$argon2id$v=19$m=19456,t=2,p=0$c2FsdA$a2V5", true},
+		{"argon2id parallelism too high", "$argon2id$v=19$m=19456,t=2,p=255$c2FsdA$a2V5", true},
+		{"argon2id empty salt", "$argon2id$v=19$m=19456,t=2,p=1$$a2V5", true},
+		{"argon2id empty key", "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA$", true},
+		{"argon2id valid", "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA$a2V5", false},
+		{"bcrypt truncated", "$2a$10$abc", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateHash(tt.stored); (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateHash() = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticateHashedToken(t *testing.T) {
+	initLogging(t)
+	hash, err := HashToken("s3cret", HashArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := ACLConfig{
+		Enabled: true,
+		Users: map[string]User{
+			"alice": {Token: hash},
+			"bob":   {Token: "plain"},
+		},
+	}
+	if err := Init(cfg, testPerms); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		user  string
+		token string
+		want  error
+	}{
+		{"hashed token", "alice", "s3cret", nil},
+		{"plaintext token", "bob", "plain", nil},
+		{"wrong token", "alice", "plain", ErrInvalidCredentials},
+		{"token of other user", "bob", "s3cret", ErrInvalidCredentials},
+		{"unknown user", "mallory", "s3cret", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := Authenticate(&protocol.Auth{User: tt.user, Token: tt.token}, nil)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Authenticate() = %v, want %v", err, tt.want)
+			}
+			if err == nil && id.User != tt.user {
+				t.Fatalf("Authenticate() user = %q, want %q", id.User, tt.user)
+			}
+		})
+	}
+
+	bad := cfg
+	bad.Users = map[string]User{"alice": {Token: "$argon2id$v=19$broken"}}
+	if err := Validate(bad, testPerms); err == nil {
+		t.Fatal("Validate() accepted a malformed token hash")
+	}
+}
+
+func TestEnvVerifierInvalidHash(t *testing.T) {
+	initLogging(t)
+	t.Setenv("PGTEST_TOKEN_ALICE", "$argon2id$v=19$m=19456,t=2,p=0$c2FsdA$a2V5")
+	hash, err := HashToken("s3cret", HashArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PGTEST_TOKEN_BOB", hash)
+
+	v, err := newEnvVerifier(CredentialSource{Type: "env", Prefix: "PGTEST_TOKEN_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, valid := v.Verify("alice", "s3cret"); !found || valid {
+		t.Errorf("Verify(alice) = %t, %t, want found and invalid", found, valid)
+	}
+	if found, valid := v.Verify("bob", "s3cret"); !found || !valid {
+		t.Errorf("Verify(bob) = %t, %t, want found and valid", found, valid)
+	}
+}
@@ -0,0 +1,249 @@
+package acl
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/logging"
+)
+
+// SessionTokenPrefix marks session tokens issued by Login.
+const SessionTokenPrefix = "pgs_"
+
+// Session defaults used when the config leaves them unset.
+const (
+	defaultSessionTTL    = time.Hour
+	defaultSessionMaxTTL = 24 * time.Hour
+)
+
+var (
+	// ErrInvalidCredentials is returned for unknown users and wrong tokens.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrSessionExpired is returned for expired or revoked session tokens.
+	ErrSessionExpired = errors.New("session expired or revoked")
+)
+
+// SessionConfig sets the lifetime of session tokens.
+type SessionConfig struct {
+	TTL    time.Duration `mapstructure:"ttl"`     // default lifetime (1h)
+	MaxTTL time.Duration `mapstructure:"max_ttl"` // upper bound for requested lifetimes (24h)
+}
+
+// Session is a short-lived token issued in exchange for a long-lived one.
+// Scope limits the commands it may be used for; empty allows all. A
+// session is only valid on the control instance that issued it.
+type Session struct {
+	ID        string
+	User      string
+	Instance  string
+	Scope     []string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	LastUsed  time.Time
+	Uses      int
+}
+
+// sessionStore holds the active sessions keyed by token hash. Sessions
+// live in memory only and end with the daemon.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+	revoked  map[string]time.Time // token hash -> original expiry
+}
+
+var sessions = &sessionStore{
+	sessions: make(map[string]*Session),
+	revoked:  make(map[string]time.Time),
+}
+
+// sessionConfig returns the session settings of the current ACL config.
+func sessionConfig() SessionConfig {
+	var cfg SessionConfig
+	if a := aclhandle.Load(); a != nil {
+		cfg = a.sessions
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultSessionTTL
+	}
+	if cfg.MaxTTL <= 0 {
+		cfg.MaxTTL = defaultSessionMaxTTL
+	}
+	if cfg.TTL > cfg.MaxTTL {
+		cfg.TTL = cfg.MaxTTL
+	}
+	return cfg
+}
+
+// Login issues a session token for user, bound to the named control
+// instance. A zero ttl selects the configured default, larger values are
+// capped at the configured maximum. Each scope entry is a command pattern
+// such as "proxy.status" or "proxy.*".
+func Login(user, instance string, ttl time.Duration, scope []string) (string, *Session, error) {
+	for _, pattern := range scope {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", nil, fmt.Errorf("invalid scope %q: %w", pattern, err)
+		}
+	}
+
+	cfg := sessionConfig()
+	if ttl <= 0 {
+		ttl = cfg.TTL
+	}
+	if ttl > cfg.MaxTTL {
+		ttl = cfg.MaxTTL
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	token := SessionTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	key := tokenKey(token)
+
+	now := time.Now()
+	s := &Session{
+		ID:        key[:12],
+		User:      user,
+		Instance:  instance,
+		Scope:     scope,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	sessions.mu.Lock()
+	sessions.prune(now)
+	sessions.sessions[key] = s
+	sessions.mu.Unlock()
+
+	logging.Log.Infof("[acl] Session %s issued to '%s' on '%s', expires %s", s.ID, user, instance, s.ExpiresAt.Format(time.RFC3339))
+	copied := *s
+	return token, &copied, nil
+}
+
+// Logout revokes the session of token if it belongs to user.
+func Logout(user, token string) error {
+	key := tokenKey(token)
+
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	s, ok := sessions.sessions[key]
+	if !ok || s.User != user {
+		return fmt.Errorf("unknown session")
+	}
+	delete(sessions.sessions, key)
+	sessions.revoked[key] = s.ExpiresAt
+	logging.Log.Infof("[acl] Session %s of '%s' revoked", s.ID, user)
+	return nil
+}
+
+// RevokeUser revokes all sessions of user, e.g. after its token was
+// rotated, and returns their number.
+func RevokeUser(user string) int {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	n := 0
+	for key, s := range sessions.sessions {
+		if s.User != user {
+			continue
+		}
+		delete(sessions.sessions, key)
+		sessions.revoked[key] = s.ExpiresAt
+		n++
+	}
+	if n > 0 {
+		logging.Log.Infof("[acl] Revoked %d session(s) of '%s'", n, user)
+	}
+	return n
+}
+
+// IsSessionToken reports whether token was issued by Login.
+func IsSessionToken(token string) bool {
+	return strings.HasPrefix(token, SessionTokenPrefix)
+}
+
+// verifySession looks up the session of token and records its use.
+func verifySession(user, token string) (*Session, error) {
+	key := tokenKey(token)
+	now := time.Now()
+
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	if _, ok := sessions.revoked[key]; ok {
+		return nil, ErrSessionExpired
+	}
+	s, ok := sessions.sessions[key]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if now.After(s.ExpiresAt) {
+		delete(sessions.sessions, key)
+		logging.Log.Infof("[acl] Session %s of '%s' expired", s.ID, s.User)
+		return nil, ErrSessionExpired
+	}
+	if user != "" && user != s.User {
+		return nil, ErrInvalidCredentials
+	}
+
+	s.LastUsed = now
+	s.Uses++
+	logging.Log.Debugf("[acl] Session %s of '%s' used (%d)", s.ID, s.User, s.Uses)
+	copied := *s
+	return &copied, nil
+}
+
+// SessionActive reports whether the session of token is neither expired
+// nor revoked. Unlike Authenticate it does not record a use.
+func SessionActive(token string) bool {
+	key := tokenKey(token)
+
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	s, ok := sessions.sessions[key]
+	return ok && !time.Now().After(s.ExpiresAt)
+}
+
+// prune drops expired sessions and revocations. The caller must hold s.mu.
+func (s *sessionStore) prune(now time.Time) {
+	for key, sess := range s.sessions {
+		if now.After(sess.ExpiresAt) {
+			delete(s.sessions, key)
+		}
+	}
+	for key, expires := range s.revoked {
+		if now.After(expires) {
+			delete(s.revoked, key)
+		}
+	}
+}
+
+// Allows reports whether the session scope permits command.
+func (s *Session) Allows(command string) bool {
+	if len(s.Scope) == 0 {
+		return true
+	}
+	for _, pattern := range s.Scope {
+		if ok, _ := path.Match(pattern, command); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenKey returns the map key of a session token. Only hashes are kept
+// in memory.
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
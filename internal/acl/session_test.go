@@ -0,0 +1,142 @@
+package acl
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mfulz/portgeist/protocol"
+)
+
+func TestSessions(t *testing.T) {
+	initLogging(t)
+	cfg := ACLConfig{
+		Enabled:  true,
+		Users:    map[string]User{"alice": {Token: "a"}, "bob": {Token: "b"}},
+		Sessions: SessionConfig{TTL: time.Minute, MaxTTL: time.Hour},
+	}
+	if err := Init(cfg, testPerms); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(t *testing.T, user string, ttl time.Duration, scope ...string) string {
+		t.Helper()
+		token, _, err := Login(user, "local", ttl, scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	auth := func(user, token string) (Identity, error) {
+		return Authenticate(&protocol.Auth{User: user, Token: token}, nil)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		token := login(t, "alice", 0)
+		for _, user := range []string{"alice", ""} {
+			id, err := auth(user, token)
+			if err != nil {
+				t.Fatalf("Authenticate(%q) = %v", user, err)
+			}
+			if id.User != "alice" || id.Source != "session" || id.Session == nil {
+				t.Fatalf("Authenticate(%q) = %+v", user, id)
+			}
+		}
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		token := login(t, "alice", 0)
+		if _, err := auth("bob", token); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Authenticate() = %v, want %v", err, ErrInvalidCredentials)
+		}
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		if _, err := auth("alice", SessionTokenPrefix+"forged"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Authenticate() = %v, want %v", err, ErrInvalidCredentials)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		tests := []struct {
+			ttl  time.Duration
+			want time.Duration
+		}{
+			{0, time.Minute},
+			{10 * time.Minute, 10 * time.Minute},
+			{48 * time.Hour, time.Hour},
+		}
+		for _, tt := range tests {
+			_, s, err := Login("alice", "local", tt.ttl, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.ExpiresAt.Sub(s.CreatedAt); got != tt.want {
+				t.Errorf("Login(ttl %s) lifetime = %s, want %s", tt.ttl, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		token := login(t, "alice", time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		if _, err := auth("alice", token); !errors.Is(err, ErrSessionExpired) {
+			t.Fatalf("Authenticate() = %v, want %v", err, ErrSessionExpired)
+		}
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		token := login(t, "alice", 0)
+		if err := Logout("bob", token); err == nil {
+			t.Fatal("Logout() of another user's session succeeded")
+		}
+		if err := Logout("alice", token); err != nil {
+			t.Fatalf("Logout() = %v", err)
+		}
+		if _, err := auth("alice", token); !errors.Is(err, ErrSessionExpired) {
+			t.Fatalf("Authenticate() = %v, want %v", err, ErrSessionExpired)
+		}
+	})
+
+	t.Run("revoke user", func(t *testing.T) {
+		first, second := login(t, "alice", 0), login(t, "alice", 0)
+		other := login(t, "bob", 0)
+		if n := RevokeUser("alice"); n < 2 {
+			t.Fatalf("RevokeUser() = %d, want at least 2", n)
+		}
+		for _, token := range []string{first, second} {
+			if _, err := auth("alice", token); !errors.Is(err, ErrSessionExpired) {
+				t.Fatalf("Authenticate() = %v, want %v", err, ErrSessionExpired)
+			}
+		}
+		if _, err := auth("bob", other); err != nil {
+			t.Fatalf("Authenticate() of another user = %v", err)
+		}
+	})
+
+	t.Run("invalid scope", func(t *testing.T) {
+		if _, _, err := Login("alice", "local", 0, []string{"proxy.["}); err == nil {
+			t.Fatal("Login() accepted an invalid scope pattern")
+		}
+	})
+}
+
+func TestSessionAllows(t *testing.T) {
+	tests := []struct {
+		scope   []string
+		command string
+		want    bool
+	}{
+		{nil, "config.host.add", true},
+		{[]string{"proxy.*"}, "proxy.status", true},
+		{[]string{"proxy.*"}, "config.host.add", false},
+		{[]string{"proxy.status", "proxy.list"}, "proxy.list", true},
+		{[]string{"proxy.status"}, "proxy.start", false},
+	}
+	for _, tt := range tests {
+		s := &Session{Scope: tt.scope}
+		if got := s.Allows(tt.command); got != tt.want {
+			t.Errorf("Allows(%q) with scope %v = %t, want %t", tt.command, tt.scope, got, tt.want)
+		}
+	}
+}
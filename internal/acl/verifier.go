@@ -0,0 +1,184 @@
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/logging"
+)
+
+// Verifier checks a token presented by a control client. found is false if
+// the source holds no credentials for user, so the next verifier is asked.
+type Verifier interface {
+	Verify(user, token string) (found, valid bool)
+}
+
+// CredentialSource configures a verifier consulted after the tokens of the
+// ACL users themselves.
+type CredentialSource struct {
+	Type   string `mapstructure:"type"`   // "file", "env" or a registered type
+	Path   string `mapstructure:"path"`   // file: lines of user:token-hash
+	Prefix string `mapstructure:"prefix"` // env: variable prefix, default PORTGEIST_TOKEN_
+}
+
+// VerifierFactory creates a verifier from its configuration.
+type VerifierFactory func(src CredentialSource) (Verifier, error)
+
+var verifierTypes = map[string]VerifierFactory{
+	"file": newFileVerifier,
+	"env":  newEnvVerifier,
+}
+
+// RegisterVerifierType makes an additional credential source type available
+// to the credentials section of the ACL config.
+func RegisterVerifierType(name string, factory VerifierFactory) {
+	if _, exists := verifierTypes[name]; exists {
+		panic(fmt.Sprintf("verifier type already registered: %s", name))
+	}
+	verifierTypes[name] = factory
+}
+
+// newVerifier creates the verifier for a configured credential source.
+func newVerifier(src CredentialSource) (Verifier, error) {
+	factory, ok := verifierTypes[src.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown credential source type '%s'", src.Type)
+	}
+	return factory(src)
+}
+
+// configVerifier checks the token configured on the ACL user itself.
+type configVerifier map[string]User
+
+func (c configVerifier) Verify(user, token string) (bool, bool) {
+	u, ok := c[user]
+	if !ok || u.Token == "" {
+		return false, false
+	}
+	return true, VerifyToken(u.Token, token)
+}
+
+// fileVerifier reads htpasswd-style user:hash lines from a file and
+// reloads it when it changes.
+type fileVerifier struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	tokens  map[string]string
+}
+
+// newFileVerifier loads the credentials file of src.
+func newFileVerifier(src CredentialSource) (Verifier, error) {
+	if src.Path == "" {
+		return nil, fmt.Errorf("credential source 'file' requires a path")
+	}
+	f := &fileVerifier{path: src.Path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *fileVerifier) Verify(user, token string) (bool, bool) {
+	f.mu.Lock()
+	if err := f.reload(); err != nil {
+		// keep the previously loaded credentials
+		logging.Log.Warnf("[acl] Failed to reload credentials from %s: %v", f.path, err)
+	}
+	stored, ok := f.tokens[user]
+	f.mu.Unlock()
+
+	if !ok {
+		return false, false
+	}
+	return true, VerifyToken(stored, token)
+}
+
+// reload parses the file if it changed since the last load.
+// The caller must hold f.mu unless f is not shared yet.
+func (f *fileVerifier) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if f.tokens != nil && info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tokens := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, stored, ok := strings.Cut(line, ":")
+		if !ok || user == "" || stored == "" {
+			return fmt.Errorf("%s:%d: expected user:hash", f.path, n)
+		}
+		if err := ValidateHash(stored); err != nil {
+			return fmt.Errorf("%s:%d: %w", f.path, n, err)
+		}
+		tokens[user] = stored
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	f.tokens = tokens
+	f.modTime = info.ModTime()
+	return nil
+}
+
+// envVerifier reads a token or token hash per user from the environment,
+// e.g. PORTGEIST_TOKEN_ADMIN for user admin.
+type envVerifier struct {
+	prefix string
+}
+
+// newEnvVerifier returns a verifier reading variables with src.Prefix.
+func newEnvVerifier(src CredentialSource) (Verifier, error) {
+	prefix := src.Prefix
+	if prefix == "" {
+		prefix = "PORTGEIST_TOKEN_"
+	}
+	return &envVerifier{prefix: prefix}, nil
+}
+
+func (e *envVerifier) Verify(user, token string) (bool, bool) {
+	name := e.prefix + envName(user)
+	stored, ok := os.LookupEnv(name)
+	if !ok || stored == "" {
+		return false, false
+	}
+	// The environment is read on every check and never validated at load.
+	if err := ValidateHash(stored); err != nil {
+		logging.Log.Warnf("[acl] Ignoring invalid token hash in %s: %v", name, err)
+		return true, false
+	}
+	return true, VerifyToken(stored, token)
+}
+
+// envName converts a user name into an environment variable suffix.
+func envName(user string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, user)
+}
@@ -0,0 +1,290 @@
+// Package audit records every control request in an append-only JSON lines
+// file and reads the records back for the system.audit command.
+//
+// Example usage:
+//
+//	audit.Init(cfg.Audit)
+//	dispatcher.Observe(audit.Observe)
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mfulz/portgeist/dispatch"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileName is the default name of the audit log inside the state directory.
+const FileName = "audit.log"
+
+// defaultQueryLimit caps query results if the request sets no limit.
+const defaultQueryLimit = 100
+
+// Config configures the audit log. Rotation works like the daemon log.
+type Config struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	File       string `mapstructure:"file"`        // defaults to audit.log in state_dir
+	MaxSizeMB  int    `mapstructure:"max_size"`    // max size before rotation (in MB)
+	MaxAge     int    `mapstructure:"max_age"`     // max age of rotated files (in days)
+	MaxBackups int    `mapstructure:"max_backups"` // number of rotated files to keep
+	Compress   bool   `mapstructure:"compress"`    // gzip rotated files
+}
+
+// Log is an append-only audit sink.
+type Log struct {
+	path string
+
+	mu     sync.Mutex
+	writer *lumberjack.Logger
+}
+
+// auditLog is the globally accessible instance, nil if auditing is disabled.
+var auditLog *Log
+
+// Init opens the global audit log. With auditing disabled Observe is a no-op.
+func Init(cfg Config) error {
+	if !cfg.Enabled {
+		auditLog = nil
+		return nil
+	}
+	l, err := Open(cfg)
+	if err != nil {
+		return err
+	}
+	auditLog = l
+	return nil
+}
+
+// Default returns the global audit log or nil if auditing is disabled.
+func Default() *Log {
+	return auditLog
+}
+
+// Open prepares the audit log described by cfg.
+func Open(cfg Config) (*Log, error) {
+	if cfg.File == "" {
+		return nil, fmt.Errorf("audit log file not set")
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.File), 0o700); err != nil {
+		return nil, err
+	}
+	return &Log{
+		path: cfg.File,
+		writer: &lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSizeMB,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAge,
+			Compress:   cfg.Compress,
+		},
+	}, nil
+}
+
+// Observe is a dispatch.ObserverFunc recording each request in the global
+// audit log.
+func Observe(call dispatch.Call, req *protocol.Request, resp *protocol.Response) {
+	if l := Default(); l != nil {
+		l.Record(NewEntry(call, req, resp))
+	}
+}
+
+// NewEntry builds the audit record of an answered request.
+func NewEntry(call dispatch.Call, req *protocol.Request, resp *protocol.Response) protocol.AuditEntry {
+	e := protocol.AuditEntry{
+		Time:       call.Started.UTC().Format(time.RFC3339Nano),
+		Instance:   call.Instance,
+		Peer:       call.Peer,
+		AuthSource: call.AuthSource,
+		Session:    call.Session,
+		Command:    req.Type,
+		Decision:   "allow",
+		Outcome:    resp.Status,
+		Error:      resp.Error,
+		Code:       resp.Code,
+		DurationMs: time.Since(call.Started).Milliseconds(),
+	}
+	if req.Auth != nil {
+		e.User = req.Auth.User
+	}
+
+	var target struct {
+		Name string `json:"name"`
+		Host string `json:"host"`
+	}
+	if data, err := json.Marshal(req.Data); err == nil {
+		_ = json.Unmarshal(data, &target)
+	}
+	e.Host = target.Host
+	switch {
+	case strings.HasPrefix(req.Type, "proxy."), strings.HasPrefix(req.Type, "config.proxy."):
+		e.Proxy = target.Name
+	case strings.HasPrefix(req.Type, "config.host."):
+		e.Host = target.Name
+	}
+
+	switch resp.Code {
+	case protocol.ErrCodeUnauthenticated, protocol.ErrCodeSessionExpired:
+		e.Decision = "unauthenticated"
+	case protocol.ErrCodeNotAllowed, protocol.ErrCodeOutOfScope:
+		e.Decision = "deny"
+	}
+	return e
+}
+
+// Record appends e to the log.
+func (l *Log) Record(e protocol.AuditEntry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		logging.Log.Warnf("[audit] Failed to encode entry: %v", err)
+		return
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.writer.Write(data); err != nil {
+		logging.Log.Warnf("[audit] Failed to write entry: %v", err)
+	}
+}
+
+// Close closes the current log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.writer.Close()
+}
+
+// Query returns the entries matching q, oldest first. Rotated files are
+// searched as well.
+func (l *Log) Query(q protocol.AuditRequest) ([]protocol.AuditEntry, error) {
+	now := time.Now()
+	since, err := parseTime(q.Since, now)
+	if err != nil {
+		return nil, fmt.Errorf("invalid since: %w", err)
+	}
+	until, err := parseTime(q.Until, now)
+	if err != nil {
+		return nil, fmt.Errorf("invalid until: %w", err)
+	}
+	if q.Command != "" {
+		if _, err := path.Match(q.Command, ""); err != nil {
+			return nil, fmt.Errorf("invalid command pattern: %w", err)
+		}
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultQueryLimit
+	}
+
+	// Scan without holding the lock so that Record is not blocked by a
+	// long query. Entries written meanwhile may or may not be included.
+	l.mu.Lock()
+	files := l.files()
+	l.mu.Unlock()
+
+	var entries []protocol.AuditEntry
+	for _, file := range files {
+		err := scanFile(file, func(e protocol.AuditEntry) {
+			t, err := time.Parse(time.RFC3339Nano, e.Time)
+			if err != nil {
+				return
+			}
+			if !since.IsZero() && t.Before(since) {
+				return
+			}
+			if !until.IsZero() && t.After(until) {
+				return
+			}
+			if q.User != "" && e.User != q.User {
+				return
+			}
+			if q.Command != "" {
+				if ok, _ := path.Match(q.Command, e.Command); !ok {
+					return
+				}
+			}
+			entries = append(entries, e)
+			if len(entries) > limit {
+				entries = entries[1:]
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// files returns the rotated files in chronological order followed by the
+// current file. The caller must hold l.mu.
+func (l *Log) files() []string {
+	ext := filepath.Ext(l.path)
+	prefix := strings.TrimSuffix(l.path, ext) + "-"
+
+	var rotated []string
+	for _, pattern := range []string{prefix + "*" + ext, prefix + "*" + ext + ".gz"} {
+		matches, _ := filepath.Glob(pattern)
+		rotated = append(rotated, matches...)
+	}
+	// lumberjack names backups by timestamp, so lexical order is chronological
+	sort.Strings(rotated)
+	return append(rotated, l.path)
+}
+
+// scanFile calls fn for every entry in file, which may be gzip compressed.
+func scanFile(file string, fn func(protocol.AuditEntry)) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var e protocol.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		fn(e)
+	}
+	return scanner.Err()
+}
+
+// parseTime accepts an RFC 3339 timestamp or a duration before now.
+// Empty input yields the zero time.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,167 @@
+package audit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"go.uber.org/zap"
+)
+
+func init() {
+	logging.Log = zap.NewNop().Sugar()
+}
+
+// openLog opens an audit log in a temporary directory.
+func openLog(t *testing.T, compress bool) *Log {
+	t.Helper()
+	l, err := Open(Config{
+		Enabled:  true,
+		File:     filepath.Join(t.TempDir(), FileName),
+		Compress: compress,
+	})
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	return l
+}
+
+// entry returns a record age before now.
+func entry(age time.Duration, user, command string) protocol.AuditEntry {
+	return protocol.AuditEntry{
+		Time:     time.Now().Add(-age).UTC().Format(time.RFC3339Nano),
+		Instance: "main",
+		User:     user,
+		Command:  command,
+		Decision: "allow",
+		Outcome:  "ok",
+	}
+}
+
+// commands returns the commands of entries in order.
+func commands(entries []protocol.AuditEntry) []string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = e.Command
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestQueryFilters(t *testing.T) {
+	l := openLog(t, false)
+	l.Record(entry(3*time.Hour, "alice", "proxy.start"))
+	l.Record(entry(2*time.Hour, "bob", "proxy.stop"))
+	l.Record(entry(90*time.Minute, "alice", "system.audit"))
+	l.Record(entry(30*time.Minute, "alice", "proxy.status"))
+	l.Record(entry(time.Minute, "bob", "proxy.start"))
+
+	tests := []struct {
+		name  string
+		query protocol.AuditRequest
+		want  []string
+	}{
+		{"all", protocol.AuditRequest{}, []string{"proxy.start", "proxy.stop", "system.audit", "proxy.status", "proxy.start"}},
+		{"user", protocol.AuditRequest{User: "bob"}, []string{"proxy.stop", "proxy.start"}},
+		{"command glob", protocol.AuditRequest{Command: "proxy.st*"}, []string{"proxy.start", "proxy.stop", "proxy.status", "proxy.start"}},
+		{"user and command", protocol.AuditRequest{User: "alice", Command: "proxy.*"}, []string{"proxy.start", "proxy.status"}},
+		{"since duration", protocol.AuditRequest{Since: "1h"}, []string{"proxy.status", "proxy.start"}},
+		{"until duration", protocol.AuditRequest{Until: "100m"}, []string{"proxy.start", "proxy.stop"}},
+		{"since timestamp", protocol.AuditRequest{Since: time.Now().Add(-100 * time.Minute).Format(time.RFC3339)}, []string{"system.audit", "proxy.status", "proxy.start"}},
+		{"limit keeps newest", protocol.AuditRequest{Limit: 2}, []string{"proxy.status", "proxy.start"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := l.Query(tt.query)
+			if err != nil {
+				t.Fatalf("Query() failed: %v", err)
+			}
+			if !equal(commands(got), tt.want) {
+				t.Errorf("Query() = %v, want %v", commands(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryInvalid(t *testing.T) {
+	l := openLog(t, false)
+
+	tests := []struct {
+		name  string
+		query protocol.AuditRequest
+	}{
+		{"since", protocol.AuditRequest{Since: "yesterday"}},
+		{"until", protocol.AuditRequest{Until: "2024-13-01"}},
+		{"command", protocol.AuditRequest{Command: "proxy.["}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := l.Query(tt.query); err == nil {
+				t.Errorf("Query() accepted an invalid %s", tt.name)
+			}
+		})
+	}
+}
+
+func TestQueryAcrossRotation(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		name := "plain"
+		if compress {
+			name = "gzip"
+		}
+		t.Run(name, func(t *testing.T) {
+			l := openLog(t, compress)
+			rotate := func() {
+				// backups are named by millisecond timestamp
+				time.Sleep(5 * time.Millisecond)
+				if err := l.writer.Rotate(); err != nil {
+					t.Fatalf("Rotate() failed: %v", err)
+				}
+			}
+			l.Record(entry(3*time.Minute, "alice", "proxy.start"))
+			rotate()
+			l.Record(entry(2*time.Minute, "alice", "proxy.stop"))
+			rotate()
+			l.Record(entry(time.Minute, "alice", "proxy.status"))
+
+			// lumberjack compresses in the background
+			deadline := time.Now().Add(5 * time.Second)
+			for compress {
+				gz, _ := filepath.Glob(filepath.Join(filepath.Dir(l.path), "*.gz"))
+				plain, _ := filepath.Glob(filepath.Join(filepath.Dir(l.path), "audit-*.log"))
+				if len(gz) == 2 && len(plain) == 0 {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("rotated files not compressed: %v %v", gz, plain)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			got, err := l.Query(protocol.AuditRequest{})
+			if err != nil {
+				t.Fatalf("Query() failed: %v", err)
+			}
+			want := []string{"proxy.start", "proxy.stop", "proxy.status"}
+			if !equal(commands(got), want) {
+				t.Errorf("Query() = %v, want %v", commands(got), want)
+			}
+		})
+	}
+}
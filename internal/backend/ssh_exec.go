@@ -1,17 +1,24 @@
 // Package backend provides concrete backend implementations for proxy launching.
-// This file implements the SSH backend using exec.Command with sshpass.
+// This file implements the SSH backend using exec.Command with ssh and,
+// for password or passphrase prompts, sshpass.
 // It manages active SSH tunnel processes using Go-controlled lifecycle.
 package backend
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/mfulz/portgeist/interfaces"
 	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/hostkeys"
 	"github.com/mfulz/portgeist/internal/logging"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 type sshExecBackend struct {
@@ -92,30 +99,75 @@ func (s *sshExecBackend) Start(name string, p configd.Proxy, cfg *configd.Config
 
 	logging.Log.Infof("[ssh_exec] Launching SOCKS proxy '%s' on %s via %s", name, localAddr, remoteAddr)
 
-	cmd := exec.Command(
-		sshpassBinary, "-p", login.Password,
-		sshBinary,
+	port := host.Port
+	if port == 0 {
+		port = 22
+	}
+
+	sshArgs := []string{
 		"-N",
-		"-oStrictHostKeyChecking=no",
-		"-oUserKnownHostsFile=/dev/null",
-		"-oConnectTimeout="+connectTimeout,
-		"-D", localAddr,
-		remoteAddr,
-	)
+		"-oConnectTimeout=" + connectTimeout,
+		"-p", strconv.Itoa(port),
+	}
+
+	// The host key is verified in-process first; ssh is then pinned to
+	// exactly that key so a swap between probe and connect is rejected.
+	knownHostsFile := ""
+	spec := cfg.HostKeySpec(hostName)
+	if spec.Policy == hostkeys.PolicyInsecure {
+		sshArgs = append(sshArgs, "-oStrictHostKeyChecking=no", "-oUserKnownHostsFile=/dev/null")
+	} else {
+		timeout, _ := strconv.Atoi(connectTimeout)
+		hostAddr := host.Addr()
+		hostKey, err := hostkeys.Probe(spec, hostAddr, time.Duration(timeout)*time.Second)
+		if err != nil {
+			return fmt.Errorf("host key verification failed: %w", err)
+		}
+		knownHostsFile, err = writeKnownHosts(name, hostAddr, hostKey)
+		if err != nil {
+			return err
+		}
+		sshArgs = append(sshArgs, "-oStrictHostKeyChecking=yes", "-oUserKnownHostsFile="+knownHostsFile)
+	}
 
+	if login.KeyFile != "" {
+		sshArgs = append(sshArgs, "-i", login.KeyFile, "-oIdentitiesOnly=yes")
+	}
+	if login.Certificate != "" {
+		sshArgs = append(sshArgs, "-oCertificateFile="+login.Certificate)
+	}
+	if !login.Agent {
+		sshArgs = append(sshArgs, "-oIdentityAgent=none")
+	}
 	if rawFlags, ok := cfgMap["additional_flags"]; ok {
 		if list, ok := rawFlags.([]interface{}); ok {
 			for _, v := range list {
 				if str, ok := v.(string); ok {
-					cmd.Args = append(cmd.Args, str)
+					sshArgs = append(sshArgs, str)
 				}
 			}
 		}
 	}
+	sshArgs = append(sshArgs, "-D", localAddr, remoteAddr)
+
+	// sshpass answers either the key passphrase or the password prompt.
+	// The secret is handed over via environment to keep it out of argv.
+	var cmd *exec.Cmd
+	switch {
+	case login.KeyFile != "" && login.Passphrase != "":
+		cmd = exec.Command(sshpassBinary, append([]string{"-e", "-P", "passphrase", sshBinary}, sshArgs...)...)
+		cmd.Env = append(os.Environ(), "SSHPASS="+login.Passphrase)
+	case login.Password != "":
+		cmd = exec.Command(sshpassBinary, append([]string{"-e", sshBinary}, sshArgs...)...)
+		cmd.Env = append(os.Environ(), "SSHPASS="+login.Password)
+	default:
+		cmd = exec.Command(sshBinary, append([]string{"-oBatchMode=yes"}, sshArgs...)...)
+	}
 
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	if err := cmd.Start(); err != nil {
+		removeKnownHosts(knownHostsFile)
 		return fmt.Errorf("ssh start failed: %w", err)
 	}
 
@@ -126,6 +178,7 @@ func (s *sshExecBackend) Start(name string, p configd.Proxy, cfg *configd.Config
 
 	go func() {
 		_ = cmd.Wait()
+		removeKnownHosts(knownHostsFile)
 		logging.Log.Infof("[ssh_exec] Proxy '%s' exited", name)
 
 		s.mu.Lock()
@@ -187,3 +240,25 @@ func (s *sshExecBackend) GetInstance(name string) interfaces.RunningInstance {
 	}
 	return &sshInstance{cmd: cmd}
 }
+
+// writeKnownHosts writes a single-entry known_hosts file pinning key for addr.
+func writeKnownHosts(name, addr string, key ssh.PublicKey) (string, error) {
+	f, err := os.CreateTemp("", "portgeist_"+name+"_known_hosts_*")
+	if err != nil {
+		return "", fmt.Errorf("create known_hosts: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)); err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("write known_hosts: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// removeKnownHosts deletes a file created by writeKnownHosts.
+func removeKnownHosts(path string) {
+	if path != "" {
+		_ = os.Remove(path)
+	}
+}
@@ -0,0 +1,300 @@
+// Package backend provides concrete backend implementations for proxy launching.
+// This file implements an in-process SSH backend built on golang.org/x/crypto/ssh.
+// It dials the remote host itself and serves the SOCKS5 listener locally,
+// so no external binaries are involved and credentials never reach argv.
+package backend
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mfulz/portgeist/interfaces"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/hostkeys"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/socks5"
+	"golang.org/x/crypto/ssh"
+)
+
+type sshNativeBackend struct {
+	mu           sync.Mutex // guards all fields below
+	tunnels      map[string]*nativeTunnel
+	settings     map[string]map[string]any
+	exitCallback func(name string)
+}
+
+func init() {
+	interfaces.RegisterBackend("ssh_native", &sshNativeBackend{
+		tunnels:  make(map[string]*nativeTunnel),
+		settings: make(map[string]map[string]any),
+	})
+}
+
+// nativeTunnel bundles the SSH client and the local SOCKS listener of a proxy.
+type nativeTunnel struct {
+	client   *ssh.Client
+	listener net.Listener
+	done     chan struct{}
+
+	mu          sync.Mutex
+	intentional bool
+	closeOnce   sync.Once
+}
+
+// close tears down listener and SSH connection exactly once.
+func (t *nativeTunnel) close(intentional bool) {
+	t.mu.Lock()
+	if intentional {
+		t.intentional = true
+	}
+	t.mu.Unlock()
+
+	t.closeOnce.Do(func() {
+		_ = t.listener.Close()
+		_ = t.client.Close()
+	})
+}
+
+// wasIntentional reports whether the tunnel was closed by Stop.
+func (t *nativeTunnel) wasIntentional() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.intentional
+}
+
+// alive reports whether the tunnel has not yet terminated.
+func (t *nativeTunnel) alive() bool {
+	select {
+	case <-t.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// nativeInstance implements interfaces.RunningInstance for in-process tunnels.
+type nativeInstance struct {
+	tunnel *nativeTunnel
+}
+
+// Stop closes the tunnel and its SOCKS listener.
+func (n *nativeInstance) Stop() {
+	n.tunnel.close(true)
+}
+
+// SetExitHandler registers a callback for unexpected tunnel termination.
+func (s *sshNativeBackend) SetExitHandler(cb func(name string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exitCallback = cb
+}
+
+// Configure stores backend-specific config per proxy instance.
+func (s *sshNativeBackend) Configure(name string, cfg map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[name] = cfg
+	return nil
+}
+
+// Start dials the SSH host and serves a SOCKS5 listener for the proxy.
+func (s *sshNativeBackend) Start(name string, p configd.Proxy, cfg *configd.Config) error {
+	hostName := p.Default
+	host, ok := cfg.Hosts[hostName]
+	if !ok {
+		return fmt.Errorf("default host '%s' not found for proxy '%s'", hostName, name)
+	}
+
+	login, ok := cfg.Logins[host.Login]
+	if !ok {
+		return fmt.Errorf("login '%s' not found for host '%s'", host.Login, hostName)
+	}
+
+	s.mu.Lock()
+	if t, ok := s.tunnels[name]; ok && t.alive() {
+		s.mu.Unlock()
+		return fmt.Errorf("proxy '%s' is already running", name)
+	}
+	cfgMap := s.settings[name]
+	s.mu.Unlock()
+
+	connectTimeout := settingInt(cfgMap, "connect_timeout", 5)
+	keepaliveInterval := settingInt(cfgMap, "keepalive_interval", 15)
+	keepaliveCountMax := settingInt(cfgMap, "keepalive_count_max", 3)
+
+	remoteAddr := host.Addr()
+	localAddr := fmt.Sprintf("%s:%d", cfg.Proxies.Bind, p.Port)
+
+	authMethods, authCloser, err := login.Credentials().AuthMethods()
+	if err != nil {
+		return fmt.Errorf("login '%s': %w", host.Login, err)
+	}
+	defer authCloser.Close()
+
+	clientCfg := &ssh.ClientConfig{
+		User:            login.User,
+		Auth:            authMethods,
+		HostKeyCallback: hostkeys.Callback(cfg.HostKeySpec(hostName)),
+		Timeout:         time.Duration(connectTimeout) * time.Second,
+	}
+
+	logging.Log.Infof("[ssh_native] Connecting proxy '%s' to %s@%s", name, login.User, remoteAddr)
+
+	client, err := ssh.Dial("tcp", remoteAddr, clientCfg)
+	if err != nil {
+		return fmt.Errorf("ssh dial failed: %w", err)
+	}
+
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		_ = client.Close()
+		return fmt.Errorf("listen on %s failed: %w", localAddr, err)
+	}
+
+	t := &nativeTunnel{
+		client:   client,
+		listener: ln,
+		done:     make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.tunnels[name] = t
+	s.mu.Unlock()
+
+	go func() {
+		if err := socks5.Serve(ln, client.Dial); err != nil {
+			logging.Log.Warnf("[ssh_native] SOCKS listener for '%s' failed: %v", name, err)
+		}
+		t.close(false)
+	}()
+
+	go s.keepalive(name, t, time.Duration(keepaliveInterval)*time.Second, keepaliveCountMax)
+
+	go func() {
+		_ = client.Wait()
+		t.close(false)
+		close(t.done)
+		logging.Log.Infof("[ssh_native] Proxy '%s' exited", name)
+
+		s.mu.Lock()
+		if s.tunnels[name] == t {
+			delete(s.tunnels, name)
+		}
+		cb := s.exitCallback
+		s.mu.Unlock()
+
+		if !t.wasIntentional() && cb != nil {
+			cb(name)
+		}
+	}()
+
+	logging.Log.Infof("[ssh_native] Proxy '%s' started on %s", name, localAddr)
+	return nil
+}
+
+// keepalive periodically probes the SSH connection and closes the tunnel
+// once countMax consecutive probes went unanswered.
+func (s *sshNativeBackend) keepalive(name string, t *nativeTunnel, interval time.Duration, countMax int) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			reply := make(chan error, 1)
+			go func() {
+				_, _, err := t.client.SendRequest("keepalive@openssh.com", true, nil)
+				reply <- err
+			}()
+
+			select {
+			case err := <-reply:
+				if err == nil {
+					missed = 0
+					continue
+				}
+			case <-time.After(interval):
+			case <-t.done:
+				return
+			}
+
+			missed++
+			logging.Log.Warnf("[ssh_native] Keepalive for '%s' missed (%d/%d)", name, missed, countMax)
+			if missed >= countMax {
+				t.close(false)
+				return
+			}
+		}
+	}
+}
+
+// Stop closes the tunnel of the given proxy.
+func (s *sshNativeBackend) Stop(name string) error {
+	s.mu.Lock()
+	t, ok := s.tunnels[name]
+	s.mu.Unlock()
+
+	if !ok {
+		logging.Log.Infof("[ssh_native] No active tunnel found for proxy '%s'", name)
+		return nil
+	}
+
+	logging.Log.Infof("[ssh_native] Stopping proxy '%s'", name)
+	t.close(true)
+	return nil
+}
+
+// Status reports whether the SSH connection of a proxy is established.
+// In-process tunnels have no PID of their own, so pid is always 0.
+func (s *sshNativeBackend) Status(name string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tunnels[name]
+	if !ok {
+		return 0, false
+	}
+	return 0, t.alive()
+}
+
+// GetInstance returns a RunningInstance for the proxy, if active.
+func (s *sshNativeBackend) GetInstance(name string) interfaces.RunningInstance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tunnels[name]
+	if !ok {
+		return nil
+	}
+	return &nativeInstance{tunnel: t}
+}
+
+// settingInt reads an integer option from a backend config map.
+func settingInt(cfgMap map[string]any, key string, fallback int) int {
+	val, ok := cfgMap[key]
+	if !ok {
+		return fallback
+	}
+	switch v := val.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,329 @@
+package backend
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/socks5"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+)
+
+// listen opens a TCP listener on a free local port that is closed when the
+// test ends.
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// echoServer writes back whatever its clients send.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	ln := listen(t)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// sshServer runs a minimal SSH server that accepts user/password and serves
+// direct-tcpip channels. It returns its address, its host key and a func
+// dropping all client connections.
+func sshServer(t *testing.T, user, password string) (string, ssh.PublicKey, func()) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == user && string(pass) == password {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(signer)
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	drop := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	t.Cleanup(drop)
+
+	ln := listen(t)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go serveSSH(conn, config)
+		}
+	}()
+	return ln.Addr().String(), signer.PublicKey(), drop
+}
+
+// serveSSH handles a single SSH connection, forwarding direct-tcpip channels.
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		if nc.ChannelType() != "direct-tcpip" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		var target struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+		if err := ssh.Unmarshal(nc.ExtraData(), &target); err != nil {
+			_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+		if err != nil {
+			_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			upstream.Close()
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		go func() {
+			defer ch.Close()
+			defer upstream.Close()
+			go func() {
+				_, _ = io.Copy(upstream, ch)
+				_ = upstream.(*net.TCPConn).CloseWrite()
+			}()
+			_, _ = io.Copy(ch, upstream)
+		}()
+	}
+}
+
+func TestSSHNativeSOCKS(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+
+	sshAddr, hostKey, _ := sshServer(t, "geist", "secret")
+	sshHost, sshPort, _ := net.SplitHostPort(sshAddr)
+	port, _ := strconv.Atoi(sshPort)
+	echo := echoServer(t)
+
+	// reserve a free port for the SOCKS listener
+	ln := listen(t)
+	socksAddr := ln.Addr().String()
+	ln.Close()
+	_, socksPort, _ := net.SplitHostPort(socksAddr)
+	proxyPort, _ := strconv.Atoi(socksPort)
+
+	cfg := &configd.Config{
+		Logins: map[string]configd.Login{
+			"test": {User: "geist", Password: "secret"},
+		},
+		Hosts: map[string]configd.Host{
+			"local": {
+				Address: sshHost,
+				Port:    port,
+				Login:   "test",
+				HostKey: string(ssh.MarshalAuthorizedKey(hostKey)),
+				Proxies: []string{"web"},
+			},
+		},
+		Proxies: configd.ProxiesConfig{Bind: "127.0.0.1"},
+	}
+	p := configd.Proxy{Port: proxyPort, Default: "local"}
+
+	b := &sshNativeBackend{
+		tunnels:  make(map[string]*nativeTunnel),
+		settings: make(map[string]map[string]any),
+	}
+	exited := make(chan string, 1)
+	b.SetExitHandler(func(name string) { exited <- name })
+
+	if err := b.Start("web", p, cfg); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	if _, running := b.Status("web"); !running {
+		t.Fatal("Status() reports the tunnel as not running")
+	}
+
+	conn, err := socks5.Connect(socksAddr, echo, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Connect() = %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("echo = %q, want %q", buf, "ping")
+	}
+	conn.Close()
+
+	b.mu.Lock()
+	tunnel := b.tunnels["web"]
+	b.mu.Unlock()
+	if err := b.Stop("web"); err != nil {
+		t.Fatalf("Stop() = %v", err)
+	}
+	select {
+	case <-tunnel.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel did not terminate after Stop")
+	}
+	if _, running := b.Status("web"); running {
+		t.Fatal("Status() reports the tunnel as running after Stop")
+	}
+	select {
+	case name := <-exited:
+		t.Fatalf("exit handler called for '%s' after an intentional stop", name)
+	default:
+	}
+	if c, err := net.DialTimeout("tcp", socksAddr, time.Second); err == nil {
+		c.Close()
+		t.Fatal("SOCKS listener still accepts connections after Stop")
+	}
+}
+
+func TestSSHNativeHostKeyMismatch(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+
+	sshAddr, _, _ := sshServer(t, "geist", "secret")
+	sshHost, sshPort, _ := net.SplitHostPort(sshAddr)
+	port, _ := strconv.Atoi(sshPort)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &configd.Config{
+		Logins: map[string]configd.Login{
+			"test": {User: "geist", Password: "secret"},
+		},
+		Hosts: map[string]configd.Host{
+			"local": {Address: sshHost, Port: port, Login: "test", HostKey: ssh.FingerprintSHA256(wrong)},
+		},
+		Proxies: configd.ProxiesConfig{Bind: "127.0.0.1"},
+	}
+	ln := listen(t)
+	_, socksPort, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	proxyPort, _ := strconv.Atoi(socksPort)
+
+	b := &sshNativeBackend{
+		tunnels:  make(map[string]*nativeTunnel),
+		settings: make(map[string]map[string]any),
+	}
+	if err := b.Start("web", configd.Proxy{Port: proxyPort, Default: "local"}, cfg); err == nil {
+		b.Stop("web")
+		t.Fatal("Start() succeeded against a host with a mismatching key")
+	}
+	if _, running := b.Status("web"); running {
+		t.Fatal("Status() reports a tunnel after a failed start")
+	}
+}
+
+func TestSSHNativeUnexpectedExit(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+
+	sshAddr, hostKey, drop := sshServer(t, "geist", "secret")
+	sshHost, sshPort, _ := net.SplitHostPort(sshAddr)
+	port, _ := strconv.Atoi(sshPort)
+
+	ln := listen(t)
+	_, socksPort, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	proxyPort, _ := strconv.Atoi(socksPort)
+
+	cfg := &configd.Config{
+		Logins: map[string]configd.Login{
+			"test": {User: "geist", Password: "secret"},
+		},
+		Hosts: map[string]configd.Host{
+			"local": {Address: sshHost, Port: port, Login: "test", HostKey: ssh.FingerprintSHA256(hostKey)},
+		},
+		Proxies: configd.ProxiesConfig{Bind: "127.0.0.1"},
+	}
+
+	b := &sshNativeBackend{
+		tunnels:  make(map[string]*nativeTunnel),
+		settings: make(map[string]map[string]any),
+	}
+	if err := b.Start("web", configd.Proxy{Port: proxyPort, Default: "local"}, cfg); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	defer b.Stop("web")
+
+	// the handler may be replaced while tunnels are running
+	exited := make(chan string, 1)
+	b.SetExitHandler(func(name string) { exited <- name })
+	drop()
+
+	select {
+	case name := <-exited:
+		if name != "web" {
+			t.Fatalf("exit handler called for '%s', want 'web'", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("exit handler not called after the connection dropped")
+	}
+	if _, running := b.Status("web"); running {
+		t.Fatal("Status() reports the tunnel as running after it exited")
+	}
+}
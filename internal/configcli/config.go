@@ -16,10 +16,17 @@ type UserConfig struct {
 	Token    string `mapstructure:"token"`
 }
 
-// DaemonConfig represents one connection target (unix socket or TCP).
+// DaemonConfig represents one connection target (unix socket, TCP or TLS).
+// For TLS targets CA pins the daemon's certificate authority and Cert/Key
+// present a client certificate to daemons requiring one.
 type DaemonConfig struct {
-	Socket string `mapstructure:"socket,omitempty"`
-	TCP    string `mapstructure:"tcp,omitempty"`
+	Socket     string `mapstructure:"socket,omitempty"`
+	TCP        string `mapstructure:"tcp,omitempty"`
+	TLS        string `mapstructure:"tls,omitempty"`         // host:port of a TLS control instance
+	CA         string `mapstructure:"ca,omitempty"`          // CA bundle to verify the daemon, system roots if empty
+	Cert       string `mapstructure:"cert,omitempty"`        // client certificate (PEM)
+	Key        string `mapstructure:"key,omitempty"`         // client private key (PEM)
+	ServerName string `mapstructure:"server_name,omitempty"` // expected name in the daemon certificate
 }
 
 // Config holds the entire client-side geistctl configuration.
@@ -4,29 +4,74 @@
 package configd
 
 import (
+	"bytes"
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/audit"
 	"github.com/mfulz/portgeist/internal/configloader"
+	"github.com/mfulz/portgeist/internal/hostkeys"
 	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/sshauth"
 	"github.com/spf13/viper"
 )
 
 // Config represents the full structure of the portgeist configuration file.
 type Config struct {
-	Logins   map[string]Login          `mapstructure:"logins"`
-	Hosts    map[string]Host           `mapstructure:"hosts"`
-	Proxies  ProxiesConfig             `mapstructure:"proxies"`
-	Control  ControlMultiConfig        `mapstructure:"control"`
-	Backends map[string]map[string]any `yaml:"backends"`
-	Logger   logging.Config            `mapstructure:"log"`
-	ACL      acl.ACLConfig             `mapstructure:"acl"`
+	Logins    map[string]Login          `mapstructure:"logins"`
+	Hosts     map[string]Host           `mapstructure:"hosts"`
+	Proxies   ProxiesConfig             `mapstructure:"proxies"`
+	Control   ControlMultiConfig        `mapstructure:"control"`
+	Backends  map[string]map[string]any `yaml:"backends"`
+	Logger    logging.Config            `mapstructure:"log"`
+	ACL       acl.ACLConfig             `mapstructure:"acl"`
+	HostKeys  HostKeysConfig            `mapstructure:"host_keys"`
+	StateDir  string                    `mapstructure:"state_dir"`  // defaults to the config file's directory
+	PluginDir string                    `mapstructure:"plugin_dir"` // backend plugin executables, defaults to plugins/ next to the config file
+	Audit     audit.Config              `mapstructure:"audit"`
+	Reload    ReloadConfig              `mapstructure:"reload"`
+
+	path        string                    // file the config was loaded from
+	backendACLs map[string]acl.ACLRuleSet // compiled backend rules, set by Validate
+}
+
+// Path returns the file the configuration was loaded from.
+func (c *Config) Path() string {
+	return c.path
+}
+
+// ReloadConfig controls how configuration changes are picked up at runtime.
+// SIGHUP and system.reload always reload the file.
+type ReloadConfig struct {
+	Watch bool `mapstructure:"watch"` // reload when the config file changes
 }
 
 // Login holds SSH/VPN credential information.
+// At least one of Password, KeyFile or Agent must be set.
 type Login struct {
-	User     string `mapstructure:"user"`
-	Password string `mapstructure:"password"`
+	User        string `mapstructure:"user"`
+	Password    string `mapstructure:"password"`
+	KeyFile     string `mapstructure:"key_file"`    // private key used for public key auth
+	Passphrase  string `mapstructure:"passphrase"`  // optional passphrase of KeyFile
+	Certificate string `mapstructure:"certificate"` // optional OpenSSH user certificate for KeyFile
+	Agent       bool   `mapstructure:"agent"`       // use the ssh-agent at SSH_AUTH_SOCK
+}
+
+// Credentials returns the authentication material of the login.
+func (l Login) Credentials() sshauth.Credentials {
+	return sshauth.Credentials{
+		Password:    l.Password,
+		KeyFile:     l.KeyFile,
+		Passphrase:  l.Passphrase,
+		Certificate: l.Certificate,
+		Agent:       l.Agent,
+	}
 }
 
 // Host defines a remote endpoint to connect to.
@@ -37,22 +82,88 @@ type Host struct {
 	Backend string         `mapstructure:"backend"`
 	Config  map[string]any `yaml:"config,omitempty"`
 	Proxies []string       `mapstructure:"allowed_proxies"`
+
+	HostKey       string `mapstructure:"host_key"`        // pinned key (authorized_keys format) or SHA256 fingerprint
+	KnownHosts    string `mapstructure:"known_hosts"`     // known_hosts file authoritative for this host
+	HostKeyPolicy string `mapstructure:"host_key_policy"` // overrides HostKeysConfig.Policy
+
+	ACLs acl.ACLRuleSet `mapstructure:"acls,omitempty"` // optional rules for using this host
+}
+
+// BackendACLKey is the key of the optional access rules in a backend
+// definition. It is not passed on to the backend.
+const BackendACLKey = "acls"
+
+// BackendACLs returns the access rules of the named backend definition,
+// compiled by Validate for loaded configs.
+func (c *Config) BackendACLs(name string) (acl.ACLRuleSet, error) {
+	if rules, ok := c.backendACLs[name]; ok {
+		return rules, nil
+	}
+	return c.decodeBackendACLs(name)
+}
+
+// decodeBackendACLs decodes the access rules of the named backend definition.
+func (c *Config) decodeBackendACLs(name string) (acl.ACLRuleSet, error) {
+	var rules acl.ACLRuleSet
+	raw, ok := c.Backends[name][BackendACLKey]
+	if !ok {
+		return rules, nil
+	}
+	if err := mapstructure.Decode(raw, &rules); err != nil {
+		return rules, fmt.Errorf("backend '%s': acls: %w", name, err)
+	}
+	return rules, nil
+}
+
+// HostKeysConfig configures host key verification and the daemon-managed
+// known_hosts store used for trust-on-first-use.
+type HostKeysConfig struct {
+	File   string `mapstructure:"file"`   // defaults to known_hosts next to the config file
+	Policy string `mapstructure:"policy"` // "tofu" (default), "strict" or "insecure"
 }
 
 // Proxy defines a single proxy endpoint configuration.
 type Proxy struct {
-	Port      int            `mapstructure:"port"`
-	Default   string         `mapstructure:"default"`
-	Autostart bool           `mapstructure:"autostart"`
-	ACLs      acl.ACLRuleSet `mapstructure:"acls,omitempty"` // optional object-level access rules
+	Port          int            `mapstructure:"port"`
+	Default       string         `mapstructure:"default"`
+	Fallback      []string       `mapstructure:"fallback"`       // ordered hosts tried after Default
+	FailoverAfter int            `mapstructure:"failover_after"` // tunnel exits on one host before failing over
+	HealthTarget  string         `mapstructure:"health_target"`  // overrides ProxiesConfig.Health.Target
+	Autostart     bool           `mapstructure:"autostart"`
+	ACLs          acl.ACLRuleSet `mapstructure:"acls,omitempty"` // optional object-level access rules
 }
 
-// ProxiesConfig holds all proxies and the global bind setting.
+// ProxiesConfig holds all proxies and the global bind, restart and health settings.
 type ProxiesConfig struct {
 	Bind    string           `mapstructure:"bind"`
+	Restart RestartPolicy    `mapstructure:"restart"`
+	Health  HealthCheck      `mapstructure:"health"`
 	Proxies map[string]Proxy `mapstructure:",remain"`
 }
 
+// RestartPolicy controls automatic restarts of proxies whose tunnel exited.
+// Restarts are delayed with exponential backoff; once MaxRestarts happened
+// within Window the proxy is marked failed until reset.
+type RestartPolicy struct {
+	InitialDelay time.Duration `mapstructure:"initial_delay"` // delay before the first restart (default 1s)
+	MaxDelay     time.Duration `mapstructure:"max_delay"`     // upper bound for the delay (default 2m)
+	Multiplier   float64       `mapstructure:"multiplier"`    // growth factor per restart (default 2)
+	Jitter       float64       `mapstructure:"jitter"`        // random +/- fraction of the delay (default 0.2)
+	MaxRestarts  int           `mapstructure:"max_restarts"`  // restarts allowed within Window (default 5)
+	Window       time.Duration `mapstructure:"window"`        // sliding window for MaxRestarts (default 10m)
+}
+
+// HealthCheck configures active probing of running proxies. Each probe
+// performs a SOCKS5 handshake and a CONNECT to Target through the proxy.
+// Probing is disabled while no target is configured.
+type HealthCheck struct {
+	Target   string        `mapstructure:"target"`   // host:port to connect to through the proxy
+	Interval time.Duration `mapstructure:"interval"` // time between probes (default 30s)
+	Timeout  time.Duration `mapstructure:"timeout"`  // timeout of a single probe (default 10s)
+	Failures int           `mapstructure:"failures"` // consecutive failed probes treated as an exit (default 3)
+}
+
 // ControlConfig defines how geistctl communicates with the daemon.
 type ControlConfig struct {
 	Mode   string `mapstructure:"mode"`   // "unix" or "tcp"
@@ -62,10 +173,46 @@ type ControlConfig struct {
 
 // ControlInstance describes a single control interface (e.g. unix socket or TCP listener).
 type ControlInstance struct {
-	Name    string `mapstructure:"name"`    // instance identifier
-	Enabled bool   `mapstructure:"enabled"` // whether this instance is active
-	Mode    string `mapstructure:"mode"`    // "unix" or "tcp"
-	Listen  string `mapstructure:"listen"`  // address or socket path
+	Name    string     `mapstructure:"name"`    // instance identifier
+	Enabled bool       `mapstructure:"enabled"` // whether this instance is active
+	Mode    string     `mapstructure:"mode"`    // "unix", "tcp" or "tls"
+	Listen  string     `mapstructure:"listen"`  // address or socket path
+	TLS     ControlTLS `mapstructure:"tls"`     // certificates for mode "tls"
+
+	Socket   UnixSocket `mapstructure:"socket"`   // socket file permissions for mode "unix"
+	PeerCred PeerCred   `mapstructure:"peercred"` // peer credential auth for mode "unix"
+}
+
+// UnixSocket sets ownership and permissions of a control socket file so
+// that only permitted local users can connect. Empty fields are left as
+// created by the daemon.
+type UnixSocket struct {
+	Owner string `mapstructure:"owner"` // user name or uid
+	Group string `mapstructure:"group"` // group name or gid
+	Mode  string `mapstructure:"mode"`  // octal permissions, e.g. "0660"
+}
+
+// PeerCred authenticates callers of a unix control instance by the uid
+// reported by the kernel (SO_PEERCRED). Keys of UIDs and GIDs are numeric
+// ids or local user and group names. The caller acts as the ACL user
+// mapped to its uid, else to one of its groups, else as the ACL user named
+// like its local account.
+type PeerCred struct {
+	Enabled bool              `mapstructure:"enabled"`
+	UIDs    map[string]string `mapstructure:"uids"` // local user -> ACL user
+	GIDs    map[string]string `mapstructure:"gids"` // local group -> ACL user
+}
+
+// ControlTLS configures a TLS control instance. With ClientAuth set,
+// clients authenticate by a certificate signed by ClientCA and act as the
+// ACL user named by the certificate's common name, or as the user mapped
+// to it in Users.
+type ControlTLS struct {
+	Cert       string            `mapstructure:"cert"`        // server certificate (PEM)
+	Key        string            `mapstructure:"key"`         // server private key (PEM)
+	ClientCA   string            `mapstructure:"client_ca"`   // CA bundle verifying client certificates
+	ClientAuth string            `mapstructure:"client_auth"` // "none" (default), "optional" or "require"
+	Users      map[string]string `mapstructure:"users"`       // certificate common name -> ACL user
 }
 
 // ControlMultiConfig supports multiple control instances with distinct settings.
@@ -82,16 +229,9 @@ func LoadConfig() error {
 		return err
 	}
 
-	viper.SetConfigFile(path)
-	viper.SetConfigType("yaml")
-
-	if err := viper.ReadInConfig(); err != nil {
-		return fmt.Errorf("error loading config: %w", err)
-	}
-
-	var cfg Config
-	if err := viper.Unmarshal(&cfg); err != nil {
-		return fmt.Errorf("config unmarshal failed: %w", err)
+	cfg, err := Load(path)
+	if err != nil {
+		return err
 	}
 
 	configCfg, ok := configloader.TryGetConfig[*logging.Config]()
@@ -105,6 +245,79 @@ func LoadConfig() error {
 		return fmt.Errorf("[geistd] Failed to init logger: %v", err)
 	}
 
-	configloader.RegisterConfig(&cfg)
+	configloader.RegisterConfig(cfg)
 	return nil
 }
+
+// Load reads and validates the configuration file at path without
+// registering it, e.g. to check a changed file before applying it.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+	return Parse(path, data)
+}
+
+// Parse reads and validates configuration data as if it was loaded from
+// path, e.g. to check changes before they are written to the file.
+func Parse(path string, data []byte) (*Config, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	v.SetDefault("audit.enabled", true)
+
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("config unmarshal failed: %w", err)
+	}
+
+	cfg.path = path
+	if cfg.HostKeys.File == "" {
+		cfg.HostKeys.File = filepath.Join(filepath.Dir(path), "known_hosts")
+	}
+	if cfg.StateDir == "" {
+		cfg.StateDir = filepath.Dir(path)
+	}
+	if cfg.Audit.File == "" {
+		cfg.Audit.File = filepath.Join(cfg.StateDir, audit.FileName)
+	}
+	if cfg.PluginDir == "" {
+		cfg.PluginDir = filepath.Join(filepath.Dir(path), "plugins")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &cfg, nil
+}
+
+// HostKeySpec returns the host key verification settings for the named host.
+func (c *Config) HostKeySpec(name string) hostkeys.Spec {
+	host := c.Hosts[name]
+	policy := host.HostKeyPolicy
+	if policy == "" {
+		policy = c.HostKeys.Policy
+	}
+	if policy == "" {
+		policy = string(hostkeys.PolicyTOFU)
+	}
+	return hostkeys.Spec{
+		Name:       name,
+		Policy:     hostkeys.Policy(policy),
+		HostKey:    host.HostKey,
+		KnownHosts: host.KnownHosts,
+	}
+}
+
+// Addr returns the host's SSH address as host:port, defaulting to port 22.
+func (h Host) Addr() string {
+	port := h.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(h.Address, strconv.Itoa(port))
+}
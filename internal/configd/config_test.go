@@ -0,0 +1,41 @@
+package configd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigRejectsUnreadableKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	garbage := filepath.Join(dir, "id_garbage")
+	if err := os.WriteFile(garbage, []byte("not a key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keyFile string
+		wantErr string
+	}{
+		{name: "missing", keyFile: filepath.Join(dir, "id_missing"), wantErr: "read key file"},
+		{name: "directory", keyFile: dir, wantErr: "read key file"},
+		{name: "not a key", keyFile: garbage, wantErr: "parse key file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "geistd.yaml")
+			data := "logins:\n  deploy:\n    user: deploy\n    key_file: " + tt.keyFile + "\n"
+			if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("PORTGEIST_CONFIG", path)
+
+			err := LoadConfig()
+			if err == nil || !strings.Contains(err.Error(), "login 'deploy'") || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("LoadConfig() = %v, want error for login 'deploy' containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package configd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Change sets the config entry at Path, e.g. ["hosts", "zurich"], to Value
+// or removes it if Value is nil. Values are written in the config file
+// format, i.e. structs are keyed by their mapstructure tags.
+type Change struct {
+	Path  []string
+	Value any
+}
+
+// Edit applies changes to the YAML document data. Comments and all other
+// content are kept, keys are matched case-insensitively like Viper does.
+func Edit(data []byte, changes ...Change) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config root is not a mapping")
+	}
+
+	for _, c := range changes {
+		if len(c.Path) == 0 {
+			return nil, fmt.Errorf("empty config path")
+		}
+		var value *yaml.Node
+		if c.Value != nil {
+			v := reflect.ValueOf(c.Value)
+			value = encodeNode(v)
+			switch {
+			case value != nil:
+			case v.Kind() == reflect.Slice || v.Kind() == reflect.Array:
+				value = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+			default:
+				value = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+		}
+		if err := setPath(root, c.Path, value); err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(c.Path, "."), err)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// setPath stores value under path below the mapping node, creating
+// intermediate mappings. A nil value removes the entry.
+func setPath(node *yaml.Node, path []string, value *yaml.Node) error {
+	for i := 0; i < len(node.Content); i += 2 {
+		if !strings.EqualFold(node.Content[i].Value, path[0]) {
+			continue
+		}
+		if len(path) == 1 {
+			if value == nil {
+				node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			} else {
+				value.HeadComment = node.Content[i+1].HeadComment
+				node.Content[i+1] = value
+			}
+			return nil
+		}
+		child := node.Content[i+1]
+		if child.Kind == yaml.ScalarNode && child.Tag == "!!null" {
+			*child = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		if child.Kind != yaml.MappingNode {
+			return fmt.Errorf("'%s' is not a mapping", path[0])
+		}
+		return setPath(child, path[1:], value)
+	}
+
+	if value == nil {
+		return nil
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[0]}
+	if len(path) == 1 {
+		node.Content = append(node.Content, key, value)
+		return nil
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	node.Content = append(node.Content, key, child)
+	return setPath(child, path[1:], value)
+}
+
+// encodeNode renders v in the config file format. Struct fields are named
+// by their mapstructure tag, falling back to the yaml tag and the lower
+// case field name, and keep their declaration order. Zero values are left
+// out; nil is returned if nothing remains.
+func encodeNode(v reflect.Value) *yaml.Node {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return encodeNode(v.Elem())
+	case reflect.Struct:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		encodeFields(v, node)
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return node
+	case reflect.Map:
+		if v.Len() == 0 {
+			return nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, k := range keys {
+			value := encodeNode(v.MapIndex(k))
+			if value == nil {
+				value = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Style: yaml.FlowStyle}
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: fmt.Sprint(k.Interface())}, value)
+		}
+		return node
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			return nil
+		}
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for i := 0; i < v.Len(); i++ {
+			item := encodeNode(v.Index(i))
+			if item == nil {
+				item = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+			}
+			node.Content = append(node.Content, item)
+		}
+		return node
+	}
+
+	if v.IsZero() {
+		return nil
+	}
+	value := v.Interface()
+	if d, ok := value.(time.Duration); ok {
+		value = d.String()
+	}
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return nil
+	}
+	return &node
+}
+
+// encodeFields appends the non-zero fields of a struct to a mapping node.
+// Fields tagged ",remain" or ",squash" are inlined.
+func encodeFields(v reflect.Value, node *yaml.Node) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, inline := fieldName(f)
+		if name == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if inline {
+			if inner := encodeNode(fv); inner != nil && inner.Kind == yaml.MappingNode {
+				node.Content = append(node.Content, inner.Content...)
+			}
+			continue
+		}
+		value := encodeNode(fv)
+		if value == nil {
+			continue
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, value)
+	}
+}
+
+// fieldName returns the config key of a struct field and whether the
+// field is inlined into its parent.
+func fieldName(f reflect.StructField) (string, bool) {
+	tag, ok := f.Tag.Lookup("mapstructure")
+	if !ok {
+		tag = f.Tag.Get("yaml")
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	inline := strings.Contains(","+opts+",", ",remain,") || strings.Contains(","+opts+",", ",squash,")
+	if name == "" {
+		name = strings.ToLower(f.Name)
+	}
+	return name, inline
+}
+
+// Marshal converts v into the generic form of the config file, e.g. to
+// hand access rules to clients. Zero values are left out.
+func Marshal(v any) (any, error) {
+	node := encodeNode(reflect.ValueOf(v))
+	if node == nil {
+		return nil, nil
+	}
+	var out any
+	if err := node.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Unmarshal decodes input in the config file format, e.g. access rules
+// sent by a client, into out like the config loader does.
+func Unmarshal(input any, out any) error {
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		Result: out,
+	})
+	if err != nil {
+		return err
+	}
+	return dec.Decode(input)
+}
+
+// WriteFile atomically replaces the file at path with data, keeping its
+// permissions.
+func WriteFile(path string, data []byte) error {
+	mode := os.FileMode(0o600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
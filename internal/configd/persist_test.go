@@ -0,0 +1,165 @@
+package configd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const editConfig = `# portgeist daemon config
+logins:
+  me:
+    user: root # remote user
+    password: secret
+hosts:
+  # main exit
+  Zurich:
+    address: 10.0.0.1
+    login: me
+    allowed_proxies: [pp]
+  # spare exit
+  Spare:
+    address: 10.0.0.9
+    login: me
+proxies:
+  bind: 127.0.0.1
+  pp:
+    port: 1080
+    default: zurich
+`
+
+func TestEdit(t *testing.T) {
+	tests := []struct {
+		name     string
+		changes  []Change
+		contains []string
+		missing  []string
+	}{
+		{
+			name:     "comments kept",
+			changes:  []Change{{Path: []string{"proxies", "pp", "port"}, Value: 1081}},
+			contains: []string{"# portgeist daemon config", "# main exit", "user: root # remote user", "port: 1081"},
+		},
+		{
+			name:     "key case kept",
+			changes:  []Change{{Path: []string{"hosts", "zurich", "port"}, Value: 2222}},
+			contains: []string{"  Zurich:\n", "    port: 2222\n"},
+			missing:  []string{"zurich:"},
+		},
+		{
+			name:     "entry removed",
+			changes:  []Change{{Path: []string{"hosts", "spare"}}},
+			contains: []string{"logins:", "Zurich:", "proxies:"},
+			missing:  []string{"Spare:", "10.0.0.9", "# spare exit"},
+		},
+		{
+			name:     "missing entry removal is a no-op",
+			changes:  []Change{{Path: []string{"hosts", "berlin"}}},
+			contains: []string{"Zurich:"},
+		},
+		{
+			name: "struct added by config keys",
+			changes: []Change{{Path: []string{"hosts", "berlin"}, Value: Host{
+				Address: "10.0.0.2",
+				Login:   "me",
+				Proxies: []string{"pp"},
+			}}},
+			contains: []string{"  berlin:\n    address: 10.0.0.2\n    login: me\n    allowed_proxies:\n      - pp\n"},
+			missing:  []string{"    port: 0", "host_key", "acls"},
+		},
+		{
+			name:     "intermediate mappings created",
+			changes:  []Change{{Path: []string{"reload", "watch"}, Value: true}},
+			contains: []string{"reload:\n  watch: true\n"},
+		},
+		{
+			name:     "empty slice",
+			changes:  []Change{{Path: []string{"hosts", "zurich", "allowed_proxies"}, Value: []string{}}},
+			contains: []string{"allowed_proxies: []"},
+		},
+		{
+			name:     "duration as text",
+			changes:  []Change{{Path: []string{"proxies", "restart", "window"}, Value: 90 * time.Second}},
+			contains: []string{"window: 1m30s"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Edit([]byte(editConfig), tt.changes...)
+			if err != nil {
+				t.Fatalf("Edit() = %v", err)
+			}
+			out := string(data)
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("output lacks %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.missing {
+				if strings.Contains(out, s) {
+					t.Errorf("output still contains %q:\n%s", s, out)
+				}
+			}
+			if _, err := Parse("/etc/portgeist/geistd.yaml", data); err != nil {
+				t.Errorf("edited config does not load: %v", err)
+			}
+		})
+	}
+}
+
+func TestEditErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		changes []Change
+	}{
+		{"empty path", editConfig, []Change{{Value: 1}}},
+		{"scalar in path", editConfig, []Change{{Path: []string{"proxies", "bind", "x"}, Value: 1}}},
+		{"root not a mapping", "- a\n- b\n", []Change{{Path: []string{"x"}, Value: 1}}},
+		{"invalid yaml", "a: [", []Change{{Path: []string{"x"}, Value: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Edit([]byte(tt.data), tt.changes...); err == nil {
+				t.Fatal("Edit() succeeded")
+			}
+		})
+	}
+}
+
+func TestEditEmptyDocument(t *testing.T) {
+	data, err := Edit(nil, Change{Path: []string{"hosts", "zurich", "address"}, Value: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hosts:\n  zurich:\n    address: 10.0.0.1\n"; string(data) != want {
+		t.Fatalf("Edit() = %q, want %q", data, want)
+	}
+}
+
+func TestWriteFileKeepsMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geistd.yaml")
+	if err := os.WriteFile(path, []byte("a: 1\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(path, []byte("a: 2\n")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Fatalf("mode = %o, want 640", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "a: 2\n" {
+		t.Fatalf("content = %q", data)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Fatalf("temporary files left: %v", entries)
+	}
+}
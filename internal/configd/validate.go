@@ -0,0 +1,127 @@
+package configd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/hostkeys"
+)
+
+// Validate performs semantic checks on a freshly loaded configuration
+// that cannot be expressed through unmarshalling alone.
+func (c *Config) Validate() error {
+	for name, login := range c.Logins {
+		if err := login.Credentials().Validate(); err != nil {
+			return fmt.Errorf("login '%s': %w", name, err)
+		}
+	}
+
+	if err := hostkeys.ValidatePolicy(c.HostKeys.Policy); err != nil {
+		return fmt.Errorf("host_keys: %w", err)
+	}
+	for name, host := range c.Hosts {
+		if err := hostkeys.ValidatePolicy(host.HostKeyPolicy); err != nil {
+			return fmt.Errorf("host '%s': %w", name, err)
+		}
+		if host.HostKey != "" {
+			if err := hostkeys.ValidatePinned(host.HostKey); err != nil {
+				return fmt.Errorf("host '%s': %w", name, err)
+			}
+		}
+		if err := host.ACLs.Compile(); err != nil {
+			return fmt.Errorf("host '%s': acls: %w", name, err)
+		}
+		c.Hosts[name] = host
+	}
+
+	backendACLs := make(map[string]acl.ACLRuleSet, len(c.Backends))
+	for name := range c.Backends {
+		rules, err := c.decodeBackendACLs(name)
+		if err != nil {
+			return err
+		}
+		if err := rules.Compile(); err != nil {
+			return fmt.Errorf("backend '%s': acls: %w", name, err)
+		}
+		backendACLs[name] = rules
+	}
+	c.backendACLs = backendACLs
+
+	if err := validateHealthTarget(c.Proxies.Health.Target); err != nil {
+		return fmt.Errorf("proxies.health: %w", err)
+	}
+
+	for _, inst := range c.Control.Instances {
+		if !inst.Enabled {
+			continue
+		}
+		if err := validateControl(inst); err != nil {
+			return fmt.Errorf("control '%s': %w", inst.Name, err)
+		}
+	}
+
+	for name, proxy := range c.Proxies.Proxies {
+		if err := validateHealthTarget(proxy.HealthTarget); err != nil {
+			return fmt.Errorf("proxy '%s': %w", name, err)
+		}
+		if err := proxy.ACLs.Compile(); err != nil {
+			return fmt.Errorf("proxy '%s': acls: %w", name, err)
+		}
+		c.Proxies.Proxies[name] = proxy
+		for _, hostName := range append([]string{proxy.Default}, proxy.Fallback...) {
+			if _, ok := c.Hosts[hostName]; !ok {
+				return fmt.Errorf("proxy '%s': unknown host '%s'", name, hostName)
+			}
+		}
+	}
+	return nil
+}
+
+// validateHealthTarget checks that a health probe target is host:port.
+func validateHealthTarget(target string) error {
+	if target == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return fmt.Errorf("invalid health target %q: %w", target, err)
+	}
+	return nil
+}
+
+// validateControl checks the mode, socket and TLS settings of a control instance.
+func validateControl(inst ControlInstance) error {
+	if inst.Mode != "unix" && (inst.PeerCred.Enabled || inst.Socket != (UnixSocket{})) {
+		return fmt.Errorf("socket and peercred settings require mode unix")
+	}
+
+	switch inst.Mode {
+	case "unix":
+		if inst.Socket.Mode != "" {
+			if _, err := strconv.ParseUint(inst.Socket.Mode, 8, 32); err != nil {
+				return fmt.Errorf("invalid socket.mode %q", inst.Socket.Mode)
+			}
+		}
+		return nil
+	case "tcp":
+		return nil
+	case "tls":
+	default:
+		return fmt.Errorf("unsupported mode %q", inst.Mode)
+	}
+
+	if inst.TLS.Cert == "" || inst.TLS.Key == "" {
+		return fmt.Errorf("mode tls requires tls.cert and tls.key")
+	}
+	switch inst.TLS.ClientAuth {
+	case "", "none":
+	case "optional", "require":
+		if inst.TLS.ClientCA == "" {
+			return fmt.Errorf("tls.client_auth %q requires tls.client_ca", inst.TLS.ClientAuth)
+		}
+	default:
+		return fmt.Errorf("invalid tls.client_auth %q", inst.TLS.ClientAuth)
+	}
+	return nil
+}
@@ -37,6 +37,17 @@ func RegisterConfig[T any](cfg T) {
 	registry.Store(t, cfg)
 }
 
+// ReplaceConfig registers cfg as the config instance of type T, replacing
+// any instance registered before, e.g. after the config was reloaded.
+//
+// Example:
+//
+//	ReplaceConfig[*MyConfig](newCfg)
+func ReplaceConfig[T any](cfg T) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	registry.Store(t, cfg)
+}
+
 // MustGetConfig retrieves the registered config instance of type T.
 //
 // It panics if no config of type T has been registered.
@@ -0,0 +1,33 @@
+package control
+
+import (
+	"errors"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/audit"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// AuditHandler returns audit log entries matching the request filters.
+func AuditHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.AuditRequest
+		_ = decodePayload(req.Data, &payload)
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "system_audit", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		log := audit.Default()
+		if log == nil {
+			return errorResponse(errors.New("audit log is disabled"))
+		}
+		entries, err := log.Query(payload)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: protocol.AuditResponse{Entries: entries}}
+	}
+}
@@ -9,7 +9,7 @@ type ProxyStatus struct {
 	Backend    string `json:"backend"`
 	Running    bool   `json:"running"`
 	PID        int    `json:"pid"`
-	ActiveHost string `json:"active_host`
+	ActiveHost string `json:"active_host"`
 }
 
 // ProxyInfo represents the full configuration and runtime state of a proxy.
@@ -22,5 +22,5 @@ type ProxyInfo struct {
 	Running      bool     `json:"running"`
 	PID          int      `json:"pid"`
 	AllowedUsers []string `json:"allowed_users"`
-	ActiveHost   string   `json:"active_host`
+	ActiveHost   string   `json:"active_host"`
 }
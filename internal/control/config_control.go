@@ -0,0 +1,251 @@
+package control
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/tlsutil"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// controlConfig converts a control instance into its protocol form.
+func controlConfig(inst configd.ControlInstance) protocol.ControlConfig {
+	return protocol.ControlConfig{
+		Mode:          inst.Mode,
+		Listen:        inst.Listen,
+		Enabled:       inst.Enabled,
+		TLSCert:       inst.TLS.Cert,
+		TLSKey:        inst.TLS.Key,
+		TLSClientCA:   inst.TLS.ClientCA,
+		TLSClientAuth: inst.TLS.ClientAuth,
+		TLSUsers:      inst.TLS.Users,
+		SocketOwner:   inst.Socket.Owner,
+		SocketGroup:   inst.Socket.Group,
+		SocketMode:    inst.Socket.Mode,
+		PeerCred:      inst.PeerCred.Enabled,
+		PeerCredUIDs:  inst.PeerCred.UIDs,
+		PeerCredGIDs:  inst.PeerCred.GIDs,
+	}
+}
+
+// controlInstance converts the protocol form of a control instance back.
+func controlInstance(name string, cc protocol.ControlConfig) configd.ControlInstance {
+	return configd.ControlInstance{
+		Name:    name,
+		Enabled: cc.Enabled,
+		Mode:    cc.Mode,
+		Listen:  cc.Listen,
+		TLS: configd.ControlTLS{
+			Cert:       cc.TLSCert,
+			Key:        cc.TLSKey,
+			ClientCA:   cc.TLSClientCA,
+			ClientAuth: cc.TLSClientAuth,
+			Users:      cc.TLSUsers,
+		},
+		Socket: configd.UnixSocket{
+			Owner: cc.SocketOwner,
+			Group: cc.SocketGroup,
+			Mode:  cc.SocketMode,
+		},
+		PeerCred: configd.PeerCred{
+			Enabled: cc.PeerCred,
+			UIDs:    cc.PeerCredUIDs,
+			GIDs:    cc.PeerCredGIDs,
+		},
+	}
+}
+
+// controlIndex returns the position of the named instance or -1.
+func controlIndex(cfg *configd.Config, name string) int {
+	return slices.IndexFunc(cfg.Control.Instances, func(inst configd.ControlInstance) bool {
+		return inst.Name == name
+	})
+}
+
+// checkListen reports whether inst can be started: no other enabled
+// instance may listen on the same address and TLS certificates must load.
+// Whether the address can be bound is reported when the change is applied.
+func checkListen(cfg *configd.Config, inst configd.ControlInstance) error {
+	if inst.Listen == "" {
+		return fmt.Errorf("listen address is required")
+	}
+	for _, other := range cfg.Control.Instances {
+		if other.Name != inst.Name && other.Enabled && other.Listen == inst.Listen && (other.Mode == "unix") == (inst.Mode == "unix") {
+			return fmt.Errorf("%s is already used by control instance '%s'", inst.Listen, other.Name)
+		}
+	}
+
+	if inst.Mode == "tls" {
+		if _, err := tlsutil.ServerConfig(inst.TLS.Cert, inst.TLS.Key, inst.TLS.ClientCA, inst.TLS.ClientAuth); err != nil {
+			return fmt.Errorf("tls setup: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkKeepsAccess refuses to stop the named instance if the request
+// arrived on it or if it is the last enabled one.
+func checkKeepsAccess(cfg *configd.Config, instance configd.ControlInstance, name string) error {
+	if name == instance.Name {
+		return fmt.Errorf("cannot stop control instance '%s', this request arrived on it", name)
+	}
+	for _, inst := range cfg.Control.Instances {
+		if inst.Enabled && inst.Name != name {
+			return nil
+		}
+	}
+	return fmt.Errorf("control instance '%s' is the last enabled one", name)
+}
+
+// ControlListHandler lists the configured control instances.
+func ControlListHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_control_view", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		result := []protocol.ControlEntry{}
+		for _, inst := range cfg.Control.Instances {
+			result = append(result, protocol.ControlEntry{Name: inst.Name, ControlConfig: controlConfig(inst), Current: inst.Name == instance.Name})
+		}
+		return &protocol.Response{Status: "ok", Data: protocol.ControlListResponse{Instances: result}}
+	}
+}
+
+// ControlAddHandler adds a control instance, started right away if enabled.
+func ControlAddHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.ControlAddRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_control_add", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+		if payload.Name == "" {
+			return &protocol.Response{Status: "error", Error: "control instance name is required"}
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if controlIndex(cfg, payload.Name) >= 0 {
+				return nil, fmt.Errorf("control instance '%s' already exists", payload.Name)
+			}
+			inst := controlInstance(payload.Name, payload.Control)
+			if inst.Enabled {
+				if err := checkListen(cfg, inst); err != nil {
+					return nil, err
+				}
+			}
+			instances := append(slices.Clone(cfg.Control.Instances), inst)
+			return []configd.Change{{Path: []string{"control", "instances"}, Value: instances}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// ControlRemoveHandler removes a control instance. Its listener is closed
+// and open connections are drained.
+func ControlRemoveHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.ControlNameRequest
+		_ = decodePayload(req.Data, &payload)
+
+		if controlIndex(cfg, payload.Name) < 0 {
+			return &protocol.Response{Status: "error", Error: "unknown control instance"}
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_control_remove", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			i := controlIndex(cfg, payload.Name)
+			if i < 0 {
+				return nil, fmt.Errorf("unknown control instance '%s'", payload.Name)
+			}
+			if cfg.Control.Instances[i].Enabled {
+				if err := checkKeepsAccess(cfg, instance, payload.Name); err != nil {
+					return nil, err
+				}
+			}
+			instances := slices.Delete(slices.Clone(cfg.Control.Instances), i, i+1)
+			return []configd.Change{{Path: []string{"control", "instances"}, Value: instances}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// ControlEnableHandler enables and starts a control instance.
+func ControlEnableHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		return setControlEnabled(req, cfg, instance, edit, true)
+	}
+}
+
+// ControlDisableHandler disables a control instance. Its listener is closed
+// and open connections are drained.
+func ControlDisableHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		return setControlEnabled(req, cfg, instance, edit, false)
+	}
+}
+
+// setControlEnabled implements config.control.enable and disable.
+func setControlEnabled(req *protocol.Request, cfg *configd.Config, instance configd.ControlInstance, edit EditFunc, enabled bool) *protocol.Response {
+	var payload protocol.ControlNameRequest
+	_ = decodePayload(req.Data, &payload)
+
+	if controlIndex(cfg, payload.Name) < 0 {
+		return &protocol.Response{Status: "error", Error: "unknown control instance"}
+	}
+
+	perm, state := acl.Permission("config_control_disable"), "disabled"
+	if enabled {
+		perm, state = "config_control_enable", "enabled"
+	}
+	actx := aclContext(req, instance)
+	if !acl.Can(actx, perm, acl.ACLRuleSet{}) {
+		return notAllowed()
+	}
+
+	result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+		i := controlIndex(cfg, payload.Name)
+		if i < 0 {
+			return nil, fmt.Errorf("unknown control instance '%s'", payload.Name)
+		}
+		inst := cfg.Control.Instances[i]
+		switch {
+		case inst.Enabled == enabled:
+			return nil, fmt.Errorf("control instance '%s' is already %s", payload.Name, state)
+		case enabled:
+			inst.Enabled = true
+			if err := checkListen(cfg, inst); err != nil {
+				return nil, err
+			}
+		default:
+			if err := checkKeepsAccess(cfg, instance, payload.Name); err != nil {
+				return nil, err
+			}
+			inst.Enabled = false
+		}
+		instances := slices.Clone(cfg.Control.Instances)
+		instances[i] = inst
+		return []configd.Change{{Path: []string{"control", "instances"}, Value: instances}}, nil
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &protocol.Response{Status: "ok", Data: result}
+}
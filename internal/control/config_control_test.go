@@ -0,0 +1,239 @@
+package control
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+const controlEditConfig = `
+acl:
+  enabled: true
+  users:
+    admin: {roles: [control-admin]}
+    viewer: {roles: [control-viewer]}
+  roles:
+    control-admin: {permissions: [config_control_view, config_control_add, config_control_remove, config_control_enable, config_control_disable]}
+    control-viewer: {permissions: [config_control_view]}
+control:
+  instances:
+    - name: local
+      mode: unix
+      listen: /run/portgeist/geistd.sock
+      enabled: true
+    - name: api
+      mode: tcp
+      listen: 127.0.0.1:7000
+      enabled: true
+    - name: spare
+      mode: unix
+      listen: /run/portgeist/spare.sock
+      enabled: false
+    - name: shadow
+      mode: tcp
+      listen: 127.0.0.1:7000
+      enabled: false
+`
+
+func TestControlEditHandlers(t *testing.T) {
+	base := newMemEditor(t, controlEditConfig)
+	if err := acl.Init(base.cfg.ACL, Permissions); err != nil {
+		t.Fatal(err)
+	}
+
+	// An address taken on the OS is not rejected up front, binding it is
+	// left to applying the change.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	busy := ln.Addr().String()
+
+	tests := []struct {
+		name    string
+		user    string
+		command string
+		payload any
+		code    string // expected error code, "" for success
+		wantErr string
+		check   func(t *testing.T, cfg *configd.Config)
+	}{
+		{
+			name:    "add",
+			user:    "admin",
+			command: "add",
+			payload: protocol.ControlAddRequest{Name: "remote", Control: protocol.ControlConfig{Mode: "tcp", Listen: busy, Enabled: true}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if i := controlIndex(cfg, "remote"); i < 0 || cfg.Control.Instances[i].Listen != busy || !cfg.Control.Instances[i].Enabled {
+					t.Errorf("instances = %+v", cfg.Control.Instances)
+				}
+			},
+		},
+		{
+			name:    "add on address of another instance",
+			user:    "admin",
+			command: "add",
+			payload: protocol.ControlAddRequest{Name: "remote", Control: protocol.ControlConfig{Mode: "tcp", Listen: "127.0.0.1:7000", Enabled: true}},
+			wantErr: "already used by control instance 'api'",
+		},
+		{
+			name:    "add disabled on address of another instance",
+			user:    "admin",
+			command: "add",
+			payload: protocol.ControlAddRequest{Name: "remote", Control: protocol.ControlConfig{Mode: "tcp", Listen: "127.0.0.1:7000"}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if controlIndex(cfg, "remote") < 0 {
+					t.Errorf("instances = %+v", cfg.Control.Instances)
+				}
+			},
+		},
+		{
+			name:    "add tls without certificate",
+			user:    "admin",
+			command: "add",
+			payload: protocol.ControlAddRequest{Name: "remote", Control: protocol.ControlConfig{Mode: "tls", Listen: busy, Enabled: true, TLSCert: "/nonexistent/server.pem", TLSKey: "/nonexistent/server.key"}},
+			wantErr: "tls setup",
+		},
+		{
+			name:    "add existing",
+			user:    "admin",
+			command: "add",
+			payload: protocol.ControlAddRequest{Name: "spare", Control: protocol.ControlConfig{Mode: "unix", Listen: "/run/portgeist/other.sock"}},
+			wantErr: "already exists",
+		},
+		{
+			name:    "add without permission",
+			user:    "viewer",
+			command: "add",
+			payload: protocol.ControlAddRequest{Name: "remote", Control: protocol.ControlConfig{Mode: "tcp", Listen: busy}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "remove",
+			user:    "admin",
+			command: "remove",
+			payload: protocol.ControlNameRequest{Name: "api"},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if controlIndex(cfg, "api") >= 0 || len(cfg.Control.Instances) != 3 {
+					t.Errorf("instances = %+v", cfg.Control.Instances)
+				}
+			},
+		},
+		{
+			name:    "remove instance of the request",
+			user:    "admin",
+			command: "remove",
+			payload: protocol.ControlNameRequest{Name: "local"},
+			wantErr: "this request arrived on it",
+		},
+		{
+			name:    "remove unknown",
+			user:    "admin",
+			command: "remove",
+			payload: protocol.ControlNameRequest{Name: "nope"},
+			wantErr: "unknown control instance",
+		},
+		{
+			name:    "enable",
+			user:    "admin",
+			command: "enable",
+			payload: protocol.ControlNameRequest{Name: "spare"},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if i := controlIndex(cfg, "spare"); !cfg.Control.Instances[i].Enabled {
+					t.Errorf("spare = %+v", cfg.Control.Instances[i])
+				}
+			},
+		},
+		{
+			name:    "enable on address of another instance",
+			user:    "admin",
+			command: "enable",
+			payload: protocol.ControlNameRequest{Name: "shadow"},
+			wantErr: "already used by control instance 'api'",
+		},
+		{
+			name:    "enable enabled",
+			user:    "admin",
+			command: "enable",
+			payload: protocol.ControlNameRequest{Name: "api"},
+			wantErr: "already enabled",
+		},
+		{
+			name:    "disable",
+			user:    "admin",
+			command: "disable",
+			payload: protocol.ControlNameRequest{Name: "api"},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if i := controlIndex(cfg, "api"); cfg.Control.Instances[i].Enabled {
+					t.Errorf("api = %+v", cfg.Control.Instances[i])
+				}
+			},
+		},
+		{
+			name:    "disable instance of the request",
+			user:    "admin",
+			command: "disable",
+			payload: protocol.ControlNameRequest{Name: "local"},
+			wantErr: "this request arrived on it",
+		},
+		{
+			name:    "disable without permission",
+			user:    "viewer",
+			command: "disable",
+			payload: protocol.ControlNameRequest{Name: "api"},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newMemEditor(t, controlEditConfig)
+			inst := e.cfg.Control.Instances[0]
+			var handler func(*protocol.Request) *protocol.Response
+			switch tt.command {
+			case "add":
+				handler = ControlAddHandler(e.cfg, inst, e.edit)
+			case "remove":
+				handler = ControlRemoveHandler(e.cfg, inst, e.edit)
+			case "enable":
+				handler = ControlEnableHandler(e.cfg, inst, e.edit)
+			case "disable":
+				handler = ControlDisableHandler(e.cfg, inst, e.edit)
+			}
+
+			resp := handler(&protocol.Request{Auth: &protocol.Auth{User: tt.user}, Data: tt.payload})
+			switch {
+			case tt.code != "" || tt.wantErr != "":
+				if resp.Status != "error" || resp.Code != tt.code || !strings.Contains(resp.Error, tt.wantErr) {
+					t.Fatalf("response = %+v, want code %q error containing %q", resp, tt.code, tt.wantErr)
+				}
+				if string(e.data) != controlEditConfig {
+					t.Errorf("config changed:\n%s", e.data)
+				}
+			case resp.Status != "ok":
+				t.Fatalf("response = %+v", resp)
+			default:
+				tt.check(t, e.cfg)
+			}
+		})
+	}
+}
+
+func TestCheckKeepsAccess(t *testing.T) {
+	cfg := &configd.Config{}
+	cfg.Control.Instances = []configd.ControlInstance{
+		{Name: "local", Enabled: true},
+		{Name: "spare"},
+	}
+	if err := checkKeepsAccess(cfg, configd.ControlInstance{Name: "remote"}, "local"); err == nil || !strings.Contains(err.Error(), "last enabled") {
+		t.Errorf("checkKeepsAccess() = %v, want last enabled error", err)
+	}
+	cfg.Control.Instances[1].Enabled = true
+	if err := checkKeepsAccess(cfg, configd.ControlInstance{Name: "remote"}, "local"); err != nil {
+		t.Errorf("checkKeepsAccess() = %v", err)
+	}
+}
@@ -0,0 +1,376 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/proxy"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// hostConfig converts a configured host into its protocol form.
+func hostConfig(h configd.Host) protocol.HostConfig {
+	rules, _ := configd.Marshal(h.ACLs)
+	return protocol.HostConfig{
+		Address:        h.Address,
+		Port:           h.Port,
+		Login:          h.Login,
+		Backend:        h.Backend,
+		Config:         h.Config,
+		AllowedProxies: h.Proxies,
+		HostKey:        h.HostKey,
+		KnownHosts:     h.KnownHosts,
+		HostKeyPolicy:  h.HostKeyPolicy,
+		ACLs:           rules,
+	}
+}
+
+// applyHostConfig copies the fields of hc named in fields into h, all
+// fields if none are named.
+func applyHostConfig(h *configd.Host, hc protocol.HostConfig, fields []string) error {
+	if len(fields) == 0 {
+		fields = []string{"address", "port", "login", "backend", "config", "allowed_proxies", "host_key", "known_hosts", "host_key_policy", "acls"}
+	}
+	for _, field := range fields {
+		switch field {
+		case "address":
+			h.Address = hc.Address
+		case "port":
+			h.Port = hc.Port
+		case "login":
+			h.Login = hc.Login
+		case "backend":
+			h.Backend = hc.Backend
+		case "config":
+			h.Config = hc.Config
+		case "allowed_proxies":
+			h.Proxies = nil
+			for _, p := range hc.AllowedProxies {
+				h.Proxies = append(h.Proxies, strings.ToLower(p))
+			}
+		case "host_key":
+			h.HostKey = hc.HostKey
+		case "known_hosts":
+			h.KnownHosts = hc.KnownHosts
+		case "host_key_policy":
+			h.HostKeyPolicy = hc.HostKeyPolicy
+		case "acls":
+			h.ACLs = acl.ACLRuleSet{}
+			if err := configd.Unmarshal(hc.ACLs, &h.ACLs); err != nil {
+				return fmt.Errorf("acls: %w", err)
+			}
+		default:
+			return fmt.Errorf("unknown host field: %s", field)
+		}
+	}
+	return nil
+}
+
+// canSetHostACLs reports whether the access rules of a host may change
+// from old to next. Rewriting the rules of an object requires the global
+// config_host_acl permission: host-level rules must not let a user who may
+// only update a host grant themselves more.
+func canSetHostACLs(actx acl.Context, old, next configd.Host) bool {
+	if reflect.DeepEqual(hostConfig(old).ACLs, hostConfig(next).ACLs) {
+		return true
+	}
+	return acl.Can(actx, "config_host_acl", acl.ACLRuleSet{})
+}
+
+// hostConfigKeys are the backend settings of a host that config_host_add
+// and config_host_update may change. Other keys, e.g. additional_flags or
+// ssh_binary of ssh_exec, end up on the command line of the tunnel and
+// require the global config_host_backend permission.
+var hostConfigKeys = []string{"connect_timeout", "keepalive_interval", "keepalive_count_max"}
+
+// canSetHostConfig reports whether the backend settings of a host may
+// change from old to next. Dropping a setting is always allowed.
+func canSetHostConfig(actx acl.Context, old, next configd.Host) bool {
+	// The config loader lower-cases keys, compare them the same way.
+	previous := make(map[string]any)
+	for key, v := range old.Config {
+		previous[strings.ToLower(key)] = v
+	}
+	for key, v := range next.Config {
+		key = strings.ToLower(key)
+		if slices.Contains(hostConfigKeys, key) || sameSetting(previous[key], v) {
+			continue
+		}
+		return acl.Can(actx, "config_host_backend", acl.ACLRuleSet{})
+	}
+	return true
+}
+
+// sameSetting compares two backend setting values by their JSON form, as
+// values decoded from the config file and from a request differ in type.
+func sameSetting(a, b any) bool {
+	if a == nil {
+		return false
+	}
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+// validateHost checks what the config loader does not enforce: a host
+// needs an address and its login must exist.
+func validateHost(cfg *configd.Config, h configd.Host) error {
+	if h.Address == "" {
+		return fmt.Errorf("host address is required")
+	}
+	if _, ok := cfg.Logins[h.Login]; h.Login != "" && !ok {
+		return fmt.Errorf("unknown login '%s'", h.Login)
+	}
+	return nil
+}
+
+// usesHost reports whether name is the default or a fallback host of p.
+func usesHost(p configd.Proxy, name string) bool {
+	return p.Default == name || slices.Contains(p.Fallback, name)
+}
+
+// dropHost returns the changes removing the host name from the default and
+// fallback hosts of all proxies. Without force any such reference is an
+// error. With force the host is dropped from the fallbacks and a proxy it
+// is the default of gets its first remaining fallback as new default; a
+// proxy left without any host is an error, so the config stays valid.
+func dropHost(cfg *configd.Config, name string, force bool) ([]configd.Change, error) {
+	var proxies []string
+	for proxyName, p := range cfg.Proxies.Proxies {
+		if usesHost(p, name) {
+			proxies = append(proxies, proxyName)
+		}
+	}
+	sort.Strings(proxies)
+
+	var changes []configd.Change
+	for _, proxyName := range proxies {
+		if !force {
+			return nil, fmt.Errorf("host '%s' is the default or a fallback of proxy '%s', use force to drop it from the proxy", name, proxyName)
+		}
+		p := cfg.Proxies.Proxies[proxyName]
+		fallback := slices.DeleteFunc(slices.Clone(p.Fallback), func(h string) bool { return h == name })
+		if p.Default == name {
+			if len(fallback) == 0 {
+				return nil, fmt.Errorf("host '%s' is the only host of proxy '%s', change or remove the proxy first", name, proxyName)
+			}
+			changes = append(changes, configd.Change{Path: []string{"proxies", proxyName, "default"}, Value: fallback[0]})
+			fallback = fallback[1:]
+		}
+		change := configd.Change{Path: []string{"proxies", proxyName, "fallback"}}
+		if len(fallback) > 0 {
+			change.Value = fallback
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// hostUsers returns the running proxies whose active host is name.
+func hostUsers(cfg *configd.Config, mgr *proxy.Manager, name string) []string {
+	var out []string
+	for proxyName, p := range cfg.Proxies.Proxies {
+		if e := mgr.ListEntry(proxyName, p, cfg); e.Running && e.ActiveHost == name {
+			out = append(out, proxyName)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// hostRules returns the rules of the named host evaluated for managing it.
+func hostRules(name string, h configd.Host) acl.ACLRuleSet {
+	return acl.HostRules("", name, h.ACLs)
+}
+
+// HostListHandler lists the configured hosts the user may view.
+func HostListHandler(cfg *configd.Config, instance configd.ControlInstance, mgr *proxy.Manager) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_host_view", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		result := []protocol.HostEntry{}
+		for name, h := range cfg.Hosts {
+			if !acl.Can(actx, "config_host_view", hostRules(name, h)) {
+				continue
+			}
+			result = append(result, protocol.HostEntry{Name: name, HostConfig: hostConfig(h), ActiveFor: hostUsers(cfg, mgr, name)})
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Name < result[j].Name
+		})
+		return &protocol.Response{Status: "ok", Data: protocol.HostListResponse{Hosts: result}}
+	}
+}
+
+// HostGetHandler returns the definition of a single host.
+func HostGetHandler(cfg *configd.Config, instance configd.ControlInstance, mgr *proxy.Manager) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.HostConfigRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		// Checking the role grant first keeps unknown and hidden hosts
+		// indistinguishable for callers without it.
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_host_view", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+		h, ok := cfg.Hosts[payload.Name]
+		if !ok {
+			return &protocol.Response{Status: "error", Error: "unknown host"}
+		}
+		if !acl.Can(actx, "config_host_view", hostRules(payload.Name, h)) {
+			return notAllowed()
+		}
+
+		return &protocol.Response{
+			Status: "ok",
+			Data:   protocol.HostEntry{Name: payload.Name, HostConfig: hostConfig(h), ActiveFor: hostUsers(cfg, mgr, payload.Name)},
+		}
+	}
+}
+
+// HostAddHandler adds a host to the daemon configuration.
+func HostAddHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.HostEditRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_host_add", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+		if payload.Name == "" {
+			return &protocol.Response{Status: "error", Error: "host name is required"}
+		}
+		// The config loader lower-cases keys, use the name it will report.
+		name := strings.ToLower(payload.Name)
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.Hosts[name]; ok {
+				return nil, fmt.Errorf("host '%s' already exists", name)
+			}
+			var h configd.Host
+			if err := applyHostConfig(&h, payload.Host, nil); err != nil {
+				return nil, err
+			}
+			if !canSetHostACLs(actx, configd.Host{}, h) || !canSetHostConfig(actx, configd.Host{}, h) {
+				return nil, errNotAllowed
+			}
+			if err := validateHost(cfg, h); err != nil {
+				return nil, err
+			}
+			return []configd.Change{{Path: []string{"hosts", name}, Value: h}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// HostUpdateHandler changes the definition of a host. Running proxies
+// affected by the change are restarted.
+func HostUpdateHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.HostEditRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_host_update", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+		name := strings.ToLower(payload.Name)
+		h, ok := cfg.Hosts[name]
+		if !ok {
+			return &protocol.Response{Status: "error", Error: "unknown host"}
+		}
+		if !acl.Can(actx, "config_host_update", hostRules(name, h)) {
+			return notAllowed()
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			old, ok := cfg.Hosts[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown host '%s'", name)
+			}
+			h := old
+			if err := applyHostConfig(&h, payload.Host, payload.Fields); err != nil {
+				return nil, err
+			}
+			if !canSetHostACLs(actx, old, h) || !canSetHostConfig(actx, old, h) {
+				return nil, errNotAllowed
+			}
+			if err := validateHost(cfg, h); err != nil {
+				return nil, err
+			}
+			for proxyName, p := range cfg.Proxies.Proxies {
+				if usesHost(p, name) && slices.Contains(old.Proxies, proxyName) && !slices.Contains(h.Proxies, proxyName) {
+					return nil, fmt.Errorf("proxy '%s' uses host '%s', it must stay allowed", proxyName, name)
+				}
+			}
+			return []configd.Change{{Path: []string{"hosts", name}, Value: h}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// HostRemoveHandler removes a host from the daemon configuration. Hosts a
+// proxy is currently running on or that are the default or a fallback of a
+// proxy are only removed with Force, see dropHost.
+func HostRemoveHandler(cfg *configd.Config, instance configd.ControlInstance, mgr *proxy.Manager, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.HostConfigRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_host_remove", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+		name := strings.ToLower(payload.Name)
+		h, ok := cfg.Hosts[name]
+		if !ok {
+			return &protocol.Response{Status: "error", Error: "unknown host"}
+		}
+		if !acl.Can(actx, "config_host_remove", hostRules(name, h)) {
+			return notAllowed()
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.Hosts[name]; !ok {
+				return nil, fmt.Errorf("unknown host '%s'", name)
+			}
+			if users := hostUsers(cfg, mgr, name); len(users) > 0 && !payload.Force {
+				return nil, fmt.Errorf("host '%s' is active for running proxies %v, use force to remove it anyway", name, users)
+			}
+			changes, err := dropHost(cfg, name, payload.Force)
+			if err != nil {
+				return nil, err
+			}
+			return append(changes, configd.Change{Path: []string{"hosts", name}}), nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
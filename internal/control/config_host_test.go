@@ -0,0 +1,465 @@
+package control
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+const dropHostConfig = `
+hosts:
+  zurich:
+    address: 10.0.0.1
+    allowed_proxies: [pp, dev, solo]
+  berlin:
+    address: 10.0.0.2
+    allowed_proxies: [pp, dev]
+  paris:
+    address: 10.0.0.3
+    allowed_proxies: [pp]
+proxies:
+  # production proxy
+  pp:
+    port: 1080
+    default: zurich
+    fallback: [berlin, paris]
+  dev:
+    port: 1081
+    default: berlin
+    fallback: [zurich]
+  solo:
+    port: 1082
+    default: zurich
+`
+
+func TestDropHost(t *testing.T) {
+	cfg, err := configd.Parse("/etc/portgeist/geistd.yaml", []byte(dropHostConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type proxyHosts struct {
+		def      string
+		fallback []string
+	}
+	tests := []struct {
+		name    string
+		host    string
+		force   bool
+		wantErr string
+		want    map[string]proxyHosts
+	}{
+		{
+			name:    "referenced without force",
+			host:    "paris",
+			wantErr: "use force",
+		},
+		{
+			name:  "fallback with force",
+			host:  "paris",
+			force: true,
+			want: map[string]proxyHosts{
+				"pp":   {"zurich", []string{"berlin"}},
+				"dev":  {"berlin", []string{"zurich"}},
+				"solo": {"zurich", nil},
+			},
+		},
+		{
+			name:  "default with force",
+			host:  "berlin",
+			force: true,
+			want: map[string]proxyHosts{
+				"pp":   {"zurich", []string{"paris"}},
+				"dev":  {"zurich", nil},
+				"solo": {"zurich", nil},
+			},
+		},
+		{
+			name:    "only host of a proxy",
+			host:    "zurich",
+			force:   true,
+			wantErr: "only host of proxy 'solo'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := dropHost(cfg, tt.host, tt.force)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("dropHost() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dropHost() = %v", err)
+			}
+
+			changes = append(changes, configd.Change{Path: []string{"hosts", tt.host}})
+			data, err := configd.Edit([]byte(dropHostConfig), changes...)
+			if err != nil {
+				t.Fatalf("Edit() = %v", err)
+			}
+			next, err := configd.Parse("/etc/portgeist/geistd.yaml", data)
+			if err != nil {
+				t.Fatalf("edited config is invalid: %v\n%s", err, data)
+			}
+			if _, ok := next.Hosts[tt.host]; ok {
+				t.Fatalf("host '%s' still configured", tt.host)
+			}
+			for name, want := range tt.want {
+				p := next.Proxies.Proxies[name]
+				if p.Default != want.def || !slices.Equal(p.Fallback, want.fallback) {
+					t.Errorf("proxy '%s': default %q fallback %v, want %q %v", name, p.Default, p.Fallback, want.def, want.fallback)
+				}
+			}
+			if !strings.Contains(string(data), "# production proxy") {
+				t.Errorf("comment lost:\n%s", data)
+			}
+		})
+	}
+}
+
+// memEditor is an EditFunc changing a config file kept in memory the way
+// the daemon changes its file.
+type memEditor struct {
+	data []byte
+	cfg  *configd.Config
+}
+
+func newMemEditor(t *testing.T, data string) *memEditor {
+	t.Helper()
+	cfg, err := configd.Parse("/etc/portgeist/geistd.yaml", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &memEditor{data: []byte(data), cfg: cfg}
+}
+
+func (e *memEditor) edit(prepare func(cfg *configd.Config) ([]configd.Change, error)) (*protocol.ReloadResponse, error) {
+	changes, err := prepare(e.cfg)
+	if err != nil {
+		return nil, err
+	}
+	data, err := configd.Edit(e.data, changes...)
+	if err != nil {
+		return nil, err
+	}
+	next, err := configd.Parse("/etc/portgeist/geistd.yaml", data)
+	if err != nil {
+		return nil, err
+	}
+	e.data, e.cfg = data, next
+	return &protocol.ReloadResponse{}, nil
+}
+
+const hostEditConfig = `
+acl:
+  enabled: true
+  users:
+    updater: {roles: [editor]}
+    admin: {roles: [editor, acl-admin]}
+    outsider: {roles: [editor]}
+    guest: {roles: [guest]}
+  roles:
+    editor: {permissions: [config_host_view, config_host_add, config_host_update, config_host_remove]}
+    acl-admin: {permissions: [config_host_acl, config_host_backend]}
+    guest: {permissions: [proxy_status]}
+hosts:
+  Zurich:
+    address: 10.0.0.1
+    allowed_proxies: [pp]
+    config:
+      connect_timeout: 10
+      additional_flags: ["-o", "ExitOnForwardFailure=yes"]
+    acls:
+      rules:
+        - subjects: [updater, admin]
+  berlin:
+    address: 10.0.0.2
+    allowed_proxies: [pp]
+proxies:
+  pp:
+    port: 1080
+    default: zurich
+    fallback: [berlin]
+`
+
+// openRules are access rules granting everything to everyone.
+var openRules = map[string]any{"rules": []any{map[string]any{"subjects": []any{"*"}}}}
+
+func TestHostEditHandlers(t *testing.T) {
+	base := newMemEditor(t, hostEditConfig)
+	if err := acl.Init(base.cfg.ACL, Permissions); err != nil {
+		t.Fatal(err)
+	}
+	current := hostConfig(base.cfg.Hosts["zurich"])
+
+	tests := []struct {
+		name    string
+		user    string
+		command string
+		payload any
+		code    string // expected error code, "" for success
+		wantErr string
+		check   func(t *testing.T, cfg *configd.Config)
+	}{
+		{
+			name:    "update field",
+			user:    "updater",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "Zurich", Host: protocol.HostConfig{Port: 2222}, Fields: []string{"port"}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if h := cfg.Hosts["zurich"]; h.Port != 2222 || len(h.ACLs.Rules) != 1 {
+					t.Errorf("zurich = %+v", h)
+				}
+			},
+		},
+		{
+			name:    "full update keeping the rules",
+			user:    "updater",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "zurich", Host: func() protocol.HostConfig {
+				hc := current
+				hc.Address = "10.0.0.5"
+				return hc
+			}()},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if h := cfg.Hosts["zurich"]; h.Address != "10.0.0.5" {
+					t.Errorf("zurich = %+v", h)
+				}
+			},
+		},
+		{
+			name:    "update rules without config_host_acl",
+			user:    "updater",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "zurich", Host: protocol.HostConfig{ACLs: openRules}, Fields: []string{"acls"}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "drop rules without config_host_acl",
+			user:    "updater",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "zurich", Fields: []string{"acls"}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "update rules with config_host_acl",
+			user:    "admin",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "zurich", Host: protocol.HostConfig{ACLs: openRules}, Fields: []string{"acls"}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if rules := cfg.Hosts["zurich"].ACLs.Rules; len(rules) != 1 || rules[0].Subjects[0] != "*" {
+					t.Errorf("zurich rules = %+v", rules)
+				}
+			},
+		},
+		{
+			name:    "update safe backend setting",
+			user:    "updater",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "zurich", Host: protocol.HostConfig{Config: map[string]any{
+				"connect_timeout":  20,
+				"additional_flags": []any{"-o", "ExitOnForwardFailure=yes"},
+			}}, Fields: []string{"config"}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if c := cfg.Hosts["zurich"].Config; c["connect_timeout"] != 20 {
+					t.Errorf("zurich config = %v", c)
+				}
+			},
+		},
+		{
+			name:    "drop backend setting",
+			user:    "updater",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "zurich", Fields: []string{"config"}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if c := cfg.Hosts["zurich"].Config; len(c) != 0 {
+					t.Errorf("zurich config = %v", c)
+				}
+			},
+		},
+		{
+			name:    "update ssh flags without config_host_backend",
+			user:    "updater",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "zurich", Host: protocol.HostConfig{Config: map[string]any{
+				"additional_flags": []any{"-oProxyCommand=sh -c id"},
+			}}, Fields: []string{"config"}},
+			code: protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "update mixed-case key without config_host_backend",
+			user:    "updater",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "zurich", Host: protocol.HostConfig{Config: map[string]any{
+				"SSH_Binary": "/tmp/evil",
+			}}, Fields: []string{"config"}},
+			code: protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "update ssh flags with config_host_backend",
+			user:    "admin",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "zurich", Host: protocol.HostConfig{Config: map[string]any{
+				"additional_flags": []any{"-oServerAliveInterval=5"},
+			}}, Fields: []string{"config"}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if flags, _ := cfg.Hosts["zurich"].Config["additional_flags"].([]any); len(flags) != 1 {
+					t.Errorf("zurich config = %v", cfg.Hosts["zurich"].Config)
+				}
+			},
+		},
+		{
+			name:    "add with ssh binary without config_host_backend",
+			user:    "updater",
+			command: "add",
+			payload: protocol.HostEditRequest{Name: "paris", Host: protocol.HostConfig{Address: "10.0.0.3", Config: map[string]any{"ssh_binary": "/tmp/evil"}}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "add with rules without config_host_acl",
+			user:    "updater",
+			command: "add",
+			payload: protocol.HostEditRequest{Name: "paris", Host: protocol.HostConfig{Address: "10.0.0.3", ACLs: openRules}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "add without rules",
+			user:    "updater",
+			command: "add",
+			payload: protocol.HostEditRequest{Name: "Paris", Host: protocol.HostConfig{Address: "10.0.0.3"}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if _, ok := cfg.Hosts["paris"]; !ok {
+					t.Error("paris not added")
+				}
+			},
+		},
+		{
+			name:    "update unknown host",
+			user:    "admin",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "oslo", Fields: []string{"port"}},
+			wantErr: "unknown host",
+		},
+		{
+			name:    "update unknown host without permission",
+			user:    "guest",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "oslo", Fields: []string{"port"}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "update host hidden by its rules",
+			user:    "outsider",
+			command: "update",
+			payload: protocol.HostEditRequest{Name: "zurich", Fields: []string{"port"}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "get host",
+			user:    "updater",
+			command: "get",
+			payload: protocol.HostConfigRequest{Name: "zurich"},
+		},
+		{
+			name:    "get unknown host",
+			user:    "outsider",
+			command: "get",
+			payload: protocol.HostConfigRequest{Name: "oslo"},
+			wantErr: "unknown host",
+		},
+		{
+			name:    "get unknown host without permission",
+			user:    "guest",
+			command: "get",
+			payload: protocol.HostConfigRequest{Name: "oslo"},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "get host hidden by its rules",
+			user:    "outsider",
+			command: "get",
+			payload: protocol.HostConfigRequest{Name: "zurich"},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "get with malformed payload",
+			user:    "updater",
+			command: "get",
+			payload: []string{"zurich"},
+			wantErr: "cannot unmarshal",
+		},
+		{
+			name:    "remove unknown host without permission",
+			user:    "guest",
+			command: "remove",
+			payload: protocol.HostConfigRequest{Name: "oslo"},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "remove with malformed payload",
+			user:    "updater",
+			command: "remove",
+			payload: []string{"berlin"},
+			wantErr: "cannot unmarshal",
+		},
+		{
+			name:    "remove referenced host without force",
+			user:    "updater",
+			command: "remove",
+			payload: protocol.HostConfigRequest{Name: "Berlin"},
+			wantErr: "use force",
+		},
+		{
+			name:    "remove with force",
+			user:    "updater",
+			command: "remove",
+			payload: protocol.HostConfigRequest{Name: "Berlin", Force: true},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if _, ok := cfg.Hosts["berlin"]; ok {
+					t.Error("berlin still configured")
+				}
+				if p := cfg.Proxies.Proxies["pp"]; len(p.Fallback) != 0 {
+					t.Errorf("pp fallback = %v", p.Fallback)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newMemEditor(t, hostEditConfig)
+			inst := configd.ControlInstance{Name: "test"}
+			var handler func(*protocol.Request) *protocol.Response
+			switch tt.command {
+			case "get":
+				handler = HostGetHandler(e.cfg, inst, newTestManager(t))
+			case "add":
+				handler = HostAddHandler(e.cfg, inst, e.edit)
+			case "update":
+				handler = HostUpdateHandler(e.cfg, inst, e.edit)
+			case "remove":
+				handler = HostRemoveHandler(e.cfg, inst, newTestManager(t), e.edit)
+			}
+
+			resp := handler(&protocol.Request{Auth: &protocol.Auth{User: tt.user}, Data: tt.payload})
+			switch {
+			case tt.code != "" || tt.wantErr != "":
+				if resp.Status != "error" || resp.Code != tt.code || !strings.Contains(resp.Error, tt.wantErr) {
+					t.Fatalf("response = %+v, want code %q error containing %q", resp, tt.code, tt.wantErr)
+				}
+				if string(e.data) != hostEditConfig {
+					t.Errorf("config changed:\n%s", e.data)
+				}
+			case resp.Status != "ok":
+				t.Fatalf("response = %+v", resp)
+			case tt.check != nil:
+				tt.check(t, e.cfg)
+			}
+		})
+	}
+}
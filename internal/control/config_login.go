@@ -0,0 +1,191 @@
+package control
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// loginEntry describes a configured login without its secrets.
+func loginEntry(cfg *configd.Config, name string, l configd.Login) protocol.LoginEntry {
+	return protocol.LoginEntry{
+		Name:          name,
+		User:          l.User,
+		KeyFile:       l.KeyFile,
+		Certificate:   l.Certificate,
+		Agent:         l.Agent,
+		HasPassword:   l.Password != "",
+		HasPassphrase: l.Passphrase != "",
+		Hosts:         loginHosts(cfg, name),
+	}
+}
+
+// loginHosts returns the hosts connecting with the named login.
+func loginHosts(cfg *configd.Config, name string) []string {
+	var out []string
+	for hostName, h := range cfg.Hosts {
+		if h.Login == name {
+			out = append(out, hostName)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// applyLoginConfig copies the fields of lc named in fields into l, all
+// fields if none are named.
+func applyLoginConfig(l *configd.Login, lc protocol.LoginConfig, fields []string) error {
+	if len(fields) == 0 {
+		fields = []string{"user", "password", "key_file", "passphrase", "certificate", "agent"}
+	}
+	for _, field := range fields {
+		switch field {
+		case "user":
+			l.User = lc.User
+		case "password":
+			l.Password = lc.Password
+		case "key_file":
+			l.KeyFile = lc.KeyFile
+		case "passphrase":
+			l.Passphrase = lc.Passphrase
+		case "certificate":
+			l.Certificate = lc.Certificate
+		case "agent":
+			l.Agent = lc.Agent
+		default:
+			return fmt.Errorf("unknown login field: %s", field)
+		}
+	}
+	if l.User == "" {
+		return fmt.Errorf("login user is required")
+	}
+	return nil
+}
+
+// LoginListHandler lists the configured logins without their secrets.
+func LoginListHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_login_view", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		result := []protocol.LoginEntry{}
+		for name, l := range cfg.Logins {
+			result = append(result, loginEntry(cfg, name, l))
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Name < result[j].Name
+		})
+		return &protocol.Response{Status: "ok", Data: protocol.LoginListResponse{Logins: result}}
+	}
+}
+
+// LoginAddHandler adds an SSH login to the daemon configuration.
+func LoginAddHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.LoginEditRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_login_add", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+		if payload.Name == "" {
+			return &protocol.Response{Status: "error", Error: "login name is required"}
+		}
+		// The config loader lower-cases keys, use the name it will report.
+		name := strings.ToLower(payload.Name)
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.Logins[name]; ok {
+				return nil, fmt.Errorf("login '%s' already exists", name)
+			}
+			var l configd.Login
+			if err := applyLoginConfig(&l, payload.Login, nil); err != nil {
+				return nil, err
+			}
+			return []configd.Change{{Path: []string{"logins", name}, Value: l}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// LoginUpdateHandler changes or rotates the credentials of a login. Running
+// proxies on hosts using it are restarted.
+func LoginUpdateHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.LoginEditRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_login_update", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		name := strings.ToLower(payload.Name)
+		if _, ok := cfg.Logins[name]; !ok {
+			return &protocol.Response{Status: "error", Error: "unknown login"}
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			l, ok := cfg.Logins[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown login '%s'", name)
+			}
+			if err := applyLoginConfig(&l, payload.Login, payload.Fields); err != nil {
+				return nil, err
+			}
+			return []configd.Change{{Path: []string{"logins", name}, Value: l}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// LoginRemoveHandler removes a login no host uses anymore.
+func LoginRemoveHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.LoginRemoveRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_login_remove", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		name := strings.ToLower(payload.Name)
+		if _, ok := cfg.Logins[name]; !ok {
+			return &protocol.Response{Status: "error", Error: "unknown login"}
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.Logins[name]; !ok {
+				return nil, fmt.Errorf("unknown login '%s'", name)
+			}
+			if hosts := loginHosts(cfg, name); len(hosts) > 0 {
+				return nil, fmt.Errorf("login '%s' is used by hosts %v", name, hosts)
+			}
+			return []configd.Change{{Path: []string{"logins", name}}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
@@ -0,0 +1,202 @@
+package control
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+const loginEditConfig = `
+acl:
+  enabled: true
+  users:
+    admin: {roles: [login-admin]}
+    viewer: {roles: [login-viewer]}
+  roles:
+    login-admin: {permissions: [config_login_view, config_login_add, config_login_update, config_login_remove]}
+    login-viewer: {permissions: [config_login_view]}
+logins:
+  deploy:
+    user: deploy
+    password: secret
+  spare:
+    user: backup
+    password: other
+hosts:
+  zurich:
+    address: 10.0.0.1
+    login: deploy
+`
+
+func TestLoginListHandler(t *testing.T) {
+	e := newMemEditor(t, loginEditConfig)
+	if err := acl.Init(e.cfg.ACL, Permissions); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := LoginListHandler(e.cfg, configd.ControlInstance{})(&protocol.Request{Auth: &protocol.Auth{User: "viewer"}})
+	if resp.Status != "ok" {
+		t.Fatalf("response = %+v", resp)
+	}
+	logins := resp.Data.(protocol.LoginListResponse).Logins
+	want := []protocol.LoginEntry{
+		{Name: "deploy", User: "deploy", HasPassword: true, Hosts: []string{"zurich"}},
+		{Name: "spare", User: "backup", HasPassword: true},
+	}
+	if len(logins) != len(want) {
+		t.Fatalf("logins = %+v", logins)
+	}
+	for i := range want {
+		if logins[i].Name != want[i].Name || logins[i].User != want[i].User || logins[i].HasPassword != want[i].HasPassword ||
+			strings.Join(logins[i].Hosts, ",") != strings.Join(want[i].Hosts, ",") {
+			t.Errorf("logins[%d] = %+v, want %+v", i, logins[i], want[i])
+		}
+	}
+}
+
+func TestLoginEditHandlers(t *testing.T) {
+	base := newMemEditor(t, loginEditConfig)
+	if err := acl.Init(base.cfg.ACL, Permissions); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		command string
+		payload any
+		code    string // expected error code, "" for success
+		wantErr string
+		check   func(t *testing.T, cfg *configd.Config)
+	}{
+		{
+			name:    "add",
+			user:    "admin",
+			command: "add",
+			payload: protocol.LoginEditRequest{Name: "Ops", Login: protocol.LoginConfig{User: "ops", Password: "secret"}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if l := cfg.Logins["ops"]; l.User != "ops" || l.Password != "secret" {
+					t.Errorf("ops = %+v", l)
+				}
+			},
+		},
+		{
+			name:    "add without user",
+			user:    "admin",
+			command: "add",
+			payload: protocol.LoginEditRequest{Name: "ops", Login: protocol.LoginConfig{Password: "secret"}},
+			wantErr: "login user is required",
+		},
+		{
+			name:    "add existing",
+			user:    "admin",
+			command: "add",
+			payload: protocol.LoginEditRequest{Name: "Deploy", Login: protocol.LoginConfig{User: "deploy"}},
+			wantErr: "already exists",
+		},
+		{
+			name:    "add without permission",
+			user:    "viewer",
+			command: "add",
+			payload: protocol.LoginEditRequest{Name: "ops", Login: protocol.LoginConfig{User: "ops"}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "rotate password",
+			user:    "admin",
+			command: "update",
+			payload: protocol.LoginEditRequest{Name: "Deploy", Login: protocol.LoginConfig{Password: "new"}, Fields: []string{"password"}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if l := cfg.Logins["deploy"]; l.User != "deploy" || l.Password != "new" {
+					t.Errorf("deploy = %+v", l)
+				}
+			},
+		},
+		{
+			name:    "update unknown field",
+			user:    "admin",
+			command: "update",
+			payload: protocol.LoginEditRequest{Name: "deploy", Fields: []string{"token"}},
+			wantErr: "unknown login field: token",
+		},
+		{
+			name:    "update unknown login",
+			user:    "admin",
+			command: "update",
+			payload: protocol.LoginEditRequest{Name: "ops", Fields: []string{"password"}},
+			wantErr: "unknown login",
+		},
+		{
+			name:    "update unknown login without permission",
+			user:    "viewer",
+			command: "update",
+			payload: protocol.LoginEditRequest{Name: "ops", Fields: []string{"password"}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "remove unknown login without permission",
+			user:    "viewer",
+			command: "remove",
+			payload: protocol.LoginRemoveRequest{Name: "ops"},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "remove with malformed payload",
+			user:    "admin",
+			command: "remove",
+			payload: []string{"spare"},
+			wantErr: "cannot unmarshal",
+		},
+		{
+			name:    "remove unused",
+			user:    "admin",
+			command: "remove",
+			payload: protocol.LoginRemoveRequest{Name: "Spare"},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if _, ok := cfg.Logins["spare"]; ok {
+					t.Error("spare still configured")
+				}
+			},
+		},
+		{
+			name:    "remove in use",
+			user:    "admin",
+			command: "remove",
+			payload: protocol.LoginRemoveRequest{Name: "deploy"},
+			wantErr: "login 'deploy' is used by hosts [zurich]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newMemEditor(t, loginEditConfig)
+			inst := configd.ControlInstance{Name: "test"}
+			var handler func(*protocol.Request) *protocol.Response
+			switch tt.command {
+			case "add":
+				handler = LoginAddHandler(e.cfg, inst, e.edit)
+			case "update":
+				handler = LoginUpdateHandler(e.cfg, inst, e.edit)
+			case "remove":
+				handler = LoginRemoveHandler(e.cfg, inst, e.edit)
+			}
+
+			resp := handler(&protocol.Request{Auth: &protocol.Auth{User: tt.user}, Data: tt.payload})
+			switch {
+			case tt.code != "" || tt.wantErr != "":
+				if resp.Status != "error" || resp.Code != tt.code || !strings.Contains(resp.Error, tt.wantErr) {
+					t.Fatalf("response = %+v, want code %q error containing %q", resp, tt.code, tt.wantErr)
+				}
+				if string(e.data) != loginEditConfig {
+					t.Errorf("config changed:\n%s", e.data)
+				}
+			case resp.Status != "ok":
+				t.Fatalf("response = %+v", resp)
+			default:
+				tt.check(t, e.cfg)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package control
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// reservedProxyNames are the settings sharing the proxies section with the
+// proxy definitions.
+var reservedProxyNames = []string{"bind", "restart", "health"}
+
+// applyProxyConfig copies the fields of pc named in fields into p, all
+// fields if none are named.
+func applyProxyConfig(p *configd.Proxy, pc protocol.ProxyConfig, fields []string) error {
+	if len(fields) == 0 {
+		fields = []string{"port", "default", "fallback", "failover_after", "health_target", "autostart", "acls"}
+	}
+	for _, field := range fields {
+		switch field {
+		case "port":
+			p.Port = pc.Port
+		case "default":
+			p.Default = strings.ToLower(pc.Default)
+		case "fallback":
+			p.Fallback = nil
+			for _, h := range pc.Fallback {
+				p.Fallback = append(p.Fallback, strings.ToLower(h))
+			}
+		case "failover_after":
+			p.FailoverAfter = pc.FailoverAfter
+		case "health_target":
+			p.HealthTarget = pc.HealthTarget
+		case "autostart":
+			p.Autostart = pc.Autostart
+		case "acls":
+			p.ACLs = acl.ACLRuleSet{}
+			if err := configd.Unmarshal(pc.ACLs, &p.ACLs); err != nil {
+				return fmt.Errorf("acls: %w", err)
+			}
+		default:
+			return fmt.Errorf("unknown proxy field: %s", field)
+		}
+	}
+	return nil
+}
+
+// canSetProxyACLs reports whether the access rules of a proxy may change
+// from old to next. Like for hosts, this requires the global
+// config_proxy_acl permission, whatever the proxy's own rules grant.
+func canSetProxyACLs(actx acl.Context, old, next configd.Proxy) bool {
+	oldRules, _ := configd.Marshal(old.ACLs)
+	nextRules, _ := configd.Marshal(next.ACLs)
+	if reflect.DeepEqual(oldRules, nextRules) {
+		return true
+	}
+	return acl.Can(actx, "config_proxy_acl", acl.ACLRuleSet{})
+}
+
+// validateProxy checks what the config loader does not enforce: a proxy
+// needs a default host and every host it may use must allow it.
+func validateProxy(cfg *configd.Config, name string, p configd.Proxy) error {
+	if p.Default == "" {
+		return fmt.Errorf("default host is required")
+	}
+	for _, hostName := range append([]string{p.Default}, p.Fallback...) {
+		h, ok := cfg.Hosts[hostName]
+		if !ok {
+			return fmt.Errorf("unknown host '%s'", hostName)
+		}
+		if !slices.Contains(h.Proxies, name) {
+			return fmt.Errorf("host '%s' does not allow proxy '%s'", hostName, name)
+		}
+	}
+	return nil
+}
+
+// checkPort reports whether port can be used by the named proxy: it must
+// be valid and no other proxy may be configured on it. Whether the OS lets
+// it be bound is reported when the change is applied.
+func checkPort(cfg *configd.Config, name string, port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	for other, p := range cfg.Proxies.Proxies {
+		if other != name && p.Port == port {
+			return fmt.Errorf("port %d is already used by proxy '%s'", port, other)
+		}
+	}
+	return nil
+}
+
+// ProxyAddHandler adds a proxy to the daemon configuration. It is started
+// right away if autostart is set.
+func ProxyAddHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.ProxyEditRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_proxy_add", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+		if payload.Name == "" {
+			return &protocol.Response{Status: "error", Error: "proxy name is required"}
+		}
+		// The config loader lower-cases keys, use the name it will report.
+		name := strings.ToLower(payload.Name)
+		if slices.Contains(reservedProxyNames, name) {
+			return &protocol.Response{Status: "error", Error: fmt.Sprintf("'%s' is a reserved name", name)}
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.Proxies.Proxies[name]; ok {
+				return nil, fmt.Errorf("proxy '%s' already exists", name)
+			}
+			var p configd.Proxy
+			if err := applyProxyConfig(&p, payload.Proxy, nil); err != nil {
+				return nil, err
+			}
+			if !canSetProxyACLs(actx, configd.Proxy{}, p) {
+				return nil, errNotAllowed
+			}
+			if err := validateProxy(cfg, name, p); err != nil {
+				return nil, err
+			}
+			if err := checkPort(cfg, name, p.Port); err != nil {
+				return nil, err
+			}
+			return []configd.Change{{Path: []string{"proxies", name}, Value: p}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// ProxyUpdateHandler changes the definition of a proxy. A running proxy
+// whose port or hosts changed is restarted.
+func ProxyUpdateHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.ProxyEditRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		name := strings.ToLower(payload.Name)
+		p, ok := cfg.Proxies.Proxies[name]
+		if !ok {
+			return &protocol.Response{Status: "error", Error: "unknown proxy"}
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_proxy_update", acl.ProxyRules(name, p.ACLs)) {
+			return notAllowed()
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			old, ok := cfg.Proxies.Proxies[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown proxy '%s'", name)
+			}
+			p := old
+			if err := applyProxyConfig(&p, payload.Proxy, payload.Fields); err != nil {
+				return nil, err
+			}
+			if !canSetProxyACLs(actx, old, p) {
+				return nil, errNotAllowed
+			}
+			if err := validateProxy(cfg, name, p); err != nil {
+				return nil, err
+			}
+			// The proxy itself may hold its current port.
+			if p.Port != old.Port {
+				if err := checkPort(cfg, name, p.Port); err != nil {
+					return nil, err
+				}
+			}
+			return []configd.Change{{Path: []string{"proxies", name}, Value: p}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// ProxyRemoveHandler removes a proxy from the daemon configuration,
+// stopping it if it is running.
+func ProxyRemoveHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.ProxyRemoveRequest
+		_ = decodePayload(req.Data, &payload)
+
+		name := strings.ToLower(payload.Name)
+		p, ok := cfg.Proxies.Proxies[name]
+		if !ok {
+			return &protocol.Response{Status: "error", Error: "unknown proxy"}
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_proxy_remove", acl.ProxyRules(name, p.ACLs)) {
+			return notAllowed()
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.Proxies.Proxies[name]; !ok {
+				return nil, fmt.Errorf("unknown proxy '%s'", name)
+			}
+			return []configd.Change{{Path: []string{"proxies", name}}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
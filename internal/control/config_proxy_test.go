@@ -0,0 +1,207 @@
+package control
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+const proxyEditConfig = `
+acl:
+  enabled: true
+  users:
+    updater: {roles: [editor]}
+    admin: {roles: [editor, acl-admin]}
+  roles:
+    editor: {permissions: [config_proxy_add, config_proxy_update, config_proxy_remove]}
+    acl-admin: {permissions: [config_proxy_acl]}
+hosts:
+  zurich:
+    address: 10.0.0.1
+    allowed_proxies: [pp, dev, new]
+  berlin:
+    address: 10.0.0.2
+    allowed_proxies: [pp]
+proxies:
+  PP:
+    port: 1080
+    default: zurich
+    fallback: [berlin]
+    acls:
+      rules:
+        - subjects: [updater, admin]
+  dev:
+    port: 1081
+    default: zurich
+    acls:
+      rules:
+        - subjects: [admin]
+`
+
+func TestProxyEditHandlers(t *testing.T) {
+	base := newMemEditor(t, proxyEditConfig)
+	if err := acl.Init(base.cfg.ACL, Permissions); err != nil {
+		t.Fatal(err)
+	}
+
+	// A port taken on the OS is not rejected up front, binding it is left
+	// to applying the change.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	busy := ln.Addr().(*net.TCPAddr).Port
+
+	tests := []struct {
+		name    string
+		user    string
+		command string
+		payload any
+		code    string // expected error code, "" for success
+		wantErr string
+		check   func(t *testing.T, cfg *configd.Config)
+	}{
+		{
+			name:    "update field",
+			user:    "updater",
+			command: "update",
+			payload: protocol.ProxyEditRequest{Name: "Pp", Proxy: protocol.ProxyConfig{Port: busy}, Fields: []string{"port"}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if p := cfg.Proxies.Proxies["pp"]; p.Port != busy || len(p.ACLs.Rules) != 1 {
+					t.Errorf("pp = %+v", p)
+				}
+			},
+		},
+		{
+			name:    "update to port of another proxy",
+			user:    "updater",
+			command: "update",
+			payload: protocol.ProxyEditRequest{Name: "pp", Proxy: protocol.ProxyConfig{Port: 1081}, Fields: []string{"port"}},
+			wantErr: "already used by proxy 'dev'",
+		},
+		{
+			name:    "update to invalid port",
+			user:    "updater",
+			command: "update",
+			payload: protocol.ProxyEditRequest{Name: "pp", Proxy: protocol.ProxyConfig{Port: 70000}, Fields: []string{"port"}},
+			wantErr: "invalid port",
+		},
+		{
+			name:    "update rules without config_proxy_acl",
+			user:    "updater",
+			command: "update",
+			payload: protocol.ProxyEditRequest{Name: "pp", Proxy: protocol.ProxyConfig{ACLs: openRules}, Fields: []string{"acls"}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "drop rules without config_proxy_acl",
+			user:    "updater",
+			command: "update",
+			payload: protocol.ProxyEditRequest{Name: "pp", Fields: []string{"acls"}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "full update dropping the rules without config_proxy_acl",
+			user:    "updater",
+			command: "update",
+			payload: protocol.ProxyEditRequest{Name: "pp", Proxy: protocol.ProxyConfig{Port: 1080, Default: "zurich"}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "update rules with config_proxy_acl",
+			user:    "admin",
+			command: "update",
+			payload: protocol.ProxyEditRequest{Name: "pp", Proxy: protocol.ProxyConfig{ACLs: openRules}, Fields: []string{"acls"}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if rules := cfg.Proxies.Proxies["pp"].ACLs.Rules; len(rules) != 1 || rules[0].Subjects[0] != "*" {
+					t.Errorf("pp rules = %+v", rules)
+				}
+			},
+		},
+		{
+			name:    "update unknown proxy",
+			user:    "admin",
+			command: "update",
+			payload: protocol.ProxyEditRequest{Name: "nope", Fields: []string{"port"}},
+			wantErr: "unknown proxy",
+		},
+		{
+			name:    "add with rules without config_proxy_acl",
+			user:    "updater",
+			command: "add",
+			payload: protocol.ProxyEditRequest{Name: "new", Proxy: protocol.ProxyConfig{Port: 1090, Default: "zurich", ACLs: openRules}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "add without rules",
+			user:    "updater",
+			command: "add",
+			payload: protocol.ProxyEditRequest{Name: "New", Proxy: protocol.ProxyConfig{Port: 1090, Default: "Zurich"}},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if p, ok := cfg.Proxies.Proxies["new"]; !ok || p.Default != "zurich" {
+					t.Errorf("new = %+v, %t", p, ok)
+				}
+			},
+		},
+		{
+			name:    "add on host not allowing it",
+			user:    "updater",
+			command: "add",
+			payload: protocol.ProxyEditRequest{Name: "new", Proxy: protocol.ProxyConfig{Port: 1090, Default: "berlin"}},
+			wantErr: "does not allow proxy",
+		},
+		{
+			name:    "remove",
+			user:    "updater",
+			command: "remove",
+			payload: protocol.ProxyRemoveRequest{Name: "PP"},
+			check: func(t *testing.T, cfg *configd.Config) {
+				if _, ok := cfg.Proxies.Proxies["pp"]; ok {
+					t.Error("pp still configured")
+				}
+			},
+		},
+		{
+			name:    "remove not allowed by proxy rules",
+			user:    "updater",
+			command: "remove",
+			payload: protocol.ProxyRemoveRequest{Name: "dev"},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newMemEditor(t, proxyEditConfig)
+			inst := configd.ControlInstance{Name: "test"}
+			var handler func(*protocol.Request) *protocol.Response
+			switch tt.command {
+			case "add":
+				handler = ProxyAddHandler(e.cfg, inst, e.edit)
+			case "update":
+				handler = ProxyUpdateHandler(e.cfg, inst, e.edit)
+			case "remove":
+				handler = ProxyRemoveHandler(e.cfg, inst, e.edit)
+			}
+
+			resp := handler(&protocol.Request{Auth: &protocol.Auth{User: tt.user}, Data: tt.payload})
+			switch {
+			case tt.code != "" || tt.wantErr != "":
+				if resp.Status != "error" || resp.Code != tt.code || !strings.Contains(resp.Error, tt.wantErr) {
+					t.Fatalf("response = %+v, want code %q error containing %q", resp, tt.code, tt.wantErr)
+				}
+				if string(e.data) != proxyEditConfig {
+					t.Errorf("config changed:\n%s", e.data)
+				}
+			case resp.Status != "ok":
+				t.Fatalf("response = %+v", resp)
+			default:
+				tt.check(t, e.cfg)
+			}
+		})
+	}
+}
@@ -0,0 +1,570 @@
+package control
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// lowerNames lower-cases references to users, groups and roles, whose
+// keys the config loader lower-cases.
+func lowerNames(names []string) []string {
+	var out []string
+	for _, n := range names {
+		out = append(out, strings.ToLower(n))
+	}
+	return out
+}
+
+// checkRoles reports the first of roles that is not configured.
+func checkRoles(cfg *configd.Config, roles []string) error {
+	for _, r := range roles {
+		if _, ok := cfg.ACL.Roles[r]; !ok {
+			return fmt.Errorf("unknown role '%s'", r)
+		}
+	}
+	return nil
+}
+
+// userGroups returns the groups listing the named user as member.
+func userGroups(cfg *configd.Config, name string) []string {
+	var out []string
+	for groupName, g := range cfg.ACL.Groups {
+		if slices.Contains(g.Members, name) {
+			out = append(out, groupName)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// membershipChanges returns the changes making the named user a member of
+// exactly groups.
+func membershipChanges(cfg *configd.Config, name string, groups []string) ([]configd.Change, error) {
+	for _, g := range groups {
+		if _, ok := cfg.ACL.Groups[g]; !ok {
+			return nil, fmt.Errorf("unknown group '%s'", g)
+		}
+	}
+	var changes []configd.Change
+	for groupName, g := range cfg.ACL.Groups {
+		member := slices.Contains(g.Members, name)
+		want := slices.Contains(groups, groupName)
+		switch {
+		case want && !member:
+			changes = append(changes, configd.Change{Path: []string{"acl", "groups", groupName, "members"}, Value: append(slices.Clone(g.Members), name)})
+		case !want && member:
+			members := slices.DeleteFunc(slices.Clone(g.Members), func(m string) bool { return m == name })
+			changes = append(changes, configd.Change{Path: []string{"acl", "groups", groupName, "members"}, Value: members})
+		}
+	}
+	return changes, nil
+}
+
+// tokenHash generates a new control token and returns it with the hash
+// stored in the config.
+func tokenHash() (string, string, error) {
+	token, err := acl.NewToken()
+	if err != nil {
+		return "", "", err
+	}
+	hash, err := acl.HashToken(token, acl.HashArgon2id)
+	if err != nil {
+		return "", "", err
+	}
+	return token, hash, nil
+}
+
+// UserListHandler lists the control users, groups and roles. Tokens are
+// never included.
+func UserListHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_user_view", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		result := protocol.UserListResponse{Users: []protocol.UserEntry{}, Groups: []protocol.GroupEntry{}, Roles: []protocol.RoleEntry{}}
+		for name, u := range cfg.ACL.Users {
+			result.Users = append(result.Users, protocol.UserEntry{Name: name, Roles: u.Roles, Groups: userGroups(cfg, name), HasToken: u.Token != ""})
+		}
+		for name, g := range cfg.ACL.Groups {
+			result.Groups = append(result.Groups, protocol.GroupEntry{Name: name, GroupConfig: protocol.GroupConfig{Members: g.Members, Groups: g.Groups, Roles: g.Roles}})
+		}
+		for name, r := range cfg.ACL.Roles {
+			entry := protocol.RoleEntry{Name: name, RoleConfig: protocol.RoleConfig{Extends: r.Extends}}
+			for _, p := range r.Permissions {
+				entry.Permissions = append(entry.Permissions, string(p))
+			}
+			result.Roles = append(result.Roles, entry)
+		}
+		sort.Slice(result.Users, func(i, j int) bool { return result.Users[i].Name < result.Users[j].Name })
+		sort.Slice(result.Groups, func(i, j int) bool { return result.Groups[i].Name < result.Groups[j].Name })
+		sort.Slice(result.Roles, func(i, j int) bool { return result.Roles[i].Name < result.Roles[j].Name })
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// UserAddHandler adds a control user with a newly generated token. The
+// token is returned once, only its hash is written to the config.
+func UserAddHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.UserEditRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_user_add", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+		if payload.Name == "" {
+			return &protocol.Response{Status: "error", Error: "user name is required"}
+		}
+		// The config loader lower-cases keys, use the name it will report.
+		name := strings.ToLower(payload.Name)
+
+		token, hash, err := tokenHash()
+		if err != nil {
+			return errorResponse(err)
+		}
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.ACL.Users[name]; ok {
+				return nil, fmt.Errorf("user '%s' already exists", name)
+			}
+			u := acl.User{Roles: lowerNames(payload.Roles), Token: hash}
+			if err := checkRoles(cfg, u.Roles); err != nil {
+				return nil, err
+			}
+			changes, err := membershipChanges(cfg, name, lowerNames(payload.Groups))
+			if err != nil {
+				return nil, err
+			}
+			return append([]configd.Change{{Path: []string{"acl", "users", name}, Value: u}}, changes...), nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: protocol.UserTokenResponse{Name: name, Token: token, Changes: result}}
+	}
+}
+
+// UserUpdateHandler changes the roles and group memberships of a user.
+func UserUpdateHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.UserEditRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_user_update", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		name := strings.ToLower(payload.Name)
+		if _, ok := cfg.ACL.Users[name]; !ok {
+			return &protocol.Response{Status: "error", Error: "unknown user"}
+		}
+
+		fields := payload.Fields
+		if len(fields) == 0 {
+			fields = []string{"roles", "groups"}
+		}
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.ACL.Users[name]; !ok {
+				return nil, fmt.Errorf("unknown user '%s'", name)
+			}
+			var changes []configd.Change
+			for _, field := range fields {
+				switch field {
+				case "roles":
+					roles := lowerNames(payload.Roles)
+					if err := checkRoles(cfg, roles); err != nil {
+						return nil, err
+					}
+					changes = append(changes, configd.Change{Path: []string{"acl", "users", name, "roles"}, Value: roles})
+				case "groups":
+					membership, err := membershipChanges(cfg, name, lowerNames(payload.Groups))
+					if err != nil {
+						return nil, err
+					}
+					changes = append(changes, membership...)
+				default:
+					return nil, fmt.Errorf("unknown user field: %s", field)
+				}
+			}
+			return changes, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// UserRotateHandler replaces the token of a user with a newly generated
+// one and revokes the user's sessions. The token is returned once.
+func UserRotateHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.ACLNameRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_user_rotate", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		name := strings.ToLower(payload.Name)
+		if _, ok := cfg.ACL.Users[name]; !ok {
+			return &protocol.Response{Status: "error", Error: "unknown user"}
+		}
+
+		token, hash, err := tokenHash()
+		if err != nil {
+			return errorResponse(err)
+		}
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.ACL.Users[name]; !ok {
+				return nil, fmt.Errorf("unknown user '%s'", name)
+			}
+			return []configd.Change{{Path: []string{"acl", "users", name, "token"}, Value: hash}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		acl.RevokeUser(name)
+		return &protocol.Response{Status: "ok", Data: protocol.UserTokenResponse{Name: name, Token: token, Changes: result}}
+	}
+}
+
+// UserRemoveHandler removes a control user, its group memberships and
+// sessions. Users cannot remove themselves.
+func UserRemoveHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.ACLNameRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_user_remove", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		name := strings.ToLower(payload.Name)
+		if _, ok := cfg.ACL.Users[name]; !ok {
+			return &protocol.Response{Status: "error", Error: "unknown user"}
+		}
+		if actx.User == name {
+			return &protocol.Response{Status: "error", Error: "cannot remove the user of this request"}
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.ACL.Users[name]; !ok {
+				return nil, fmt.Errorf("unknown user '%s'", name)
+			}
+			changes, err := membershipChanges(cfg, name, nil)
+			if err != nil {
+				return nil, err
+			}
+			return append(changes, configd.Change{Path: []string{"acl", "users", name}}), nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		acl.RevokeUser(name)
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// applyGroupConfig copies the fields of gc named in fields into g, all
+// fields if none are named.
+func applyGroupConfig(g *acl.Group, gc protocol.GroupConfig, fields []string) error {
+	if len(fields) == 0 {
+		fields = []string{"members", "groups", "roles"}
+	}
+	for _, field := range fields {
+		switch field {
+		case "members":
+			g.Members = lowerNames(gc.Members)
+		case "groups":
+			g.Groups = lowerNames(gc.Groups)
+		case "roles":
+			g.Roles = lowerNames(gc.Roles)
+		default:
+			return fmt.Errorf("unknown group field: %s", field)
+		}
+	}
+	return nil
+}
+
+// GroupAddHandler adds a control group.
+func GroupAddHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.GroupEditRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_group_add", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+		if payload.Name == "" {
+			return &protocol.Response{Status: "error", Error: "group name is required"}
+		}
+		name := strings.ToLower(payload.Name)
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.ACL.Groups[name]; ok {
+				return nil, fmt.Errorf("group '%s' already exists", name)
+			}
+			var g acl.Group
+			if err := applyGroupConfig(&g, payload.Group, nil); err != nil {
+				return nil, err
+			}
+			if err := checkRoles(cfg, g.Roles); err != nil {
+				return nil, err
+			}
+			return []configd.Change{{Path: []string{"acl", "groups", name}, Value: g}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// GroupUpdateHandler changes the members, nested groups or roles of a group.
+func GroupUpdateHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.GroupEditRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_group_update", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		name := strings.ToLower(payload.Name)
+		if _, ok := cfg.ACL.Groups[name]; !ok {
+			return &protocol.Response{Status: "error", Error: "unknown group"}
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			g, ok := cfg.ACL.Groups[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown group '%s'", name)
+			}
+			g.Name = ""
+			if err := applyGroupConfig(&g, payload.Group, payload.Fields); err != nil {
+				return nil, err
+			}
+			if err := checkRoles(cfg, g.Roles); err != nil {
+				return nil, err
+			}
+			return []configd.Change{{Path: []string{"acl", "groups", name}, Value: g}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// GroupRemoveHandler removes a control group and its nesting in other
+// groups.
+func GroupRemoveHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.ACLNameRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_group_remove", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		name := strings.ToLower(payload.Name)
+		if _, ok := cfg.ACL.Groups[name]; !ok {
+			return &protocol.Response{Status: "error", Error: "unknown group"}
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.ACL.Groups[name]; !ok {
+				return nil, fmt.Errorf("unknown group '%s'", name)
+			}
+			changes := []configd.Change{{Path: []string{"acl", "groups", name}}}
+			for groupName, g := range cfg.ACL.Groups {
+				if groupName != name && slices.Contains(g.Groups, name) {
+					nested := slices.DeleteFunc(slices.Clone(g.Groups), func(n string) bool { return n == name })
+					changes = append(changes, configd.Change{Path: []string{"acl", "groups", groupName, "groups"}, Value: nested})
+				}
+			}
+			return changes, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// applyRoleConfig copies the fields of rc named in fields into r, all
+// fields if none are named.
+func applyRoleConfig(r *acl.Role, rc protocol.RoleConfig, fields []string) error {
+	if len(fields) == 0 {
+		fields = []string{"permissions", "extends"}
+	}
+	for _, field := range fields {
+		switch field {
+		case "permissions":
+			r.Permissions = nil
+			for _, p := range rc.Permissions {
+				r.Permissions = append(r.Permissions, acl.Permission(p))
+			}
+		case "extends":
+			r.Extends = lowerNames(rc.Extends)
+		default:
+			return fmt.Errorf("unknown role field: %s", field)
+		}
+	}
+	return nil
+}
+
+// roleUsers returns the users, groups and roles referencing the named role.
+func roleUsers(cfg *configd.Config, name string) []string {
+	var out []string
+	for userName, u := range cfg.ACL.Users {
+		if slices.Contains(u.Roles, name) {
+			out = append(out, "user "+userName)
+		}
+	}
+	for groupName, g := range cfg.ACL.Groups {
+		if slices.Contains(g.Roles, name) {
+			out = append(out, "group "+groupName)
+		}
+	}
+	for roleName, r := range cfg.ACL.Roles {
+		if slices.Contains(r.Extends, name) {
+			out = append(out, "role "+roleName)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// RoleAddHandler adds a control role.
+func RoleAddHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.RoleEditRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_role_add", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+		if payload.Name == "" {
+			return &protocol.Response{Status: "error", Error: "role name is required"}
+		}
+		name := strings.ToLower(payload.Name)
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.ACL.Roles[name]; ok {
+				return nil, fmt.Errorf("role '%s' already exists", name)
+			}
+			var r acl.Role
+			if err := applyRoleConfig(&r, payload.Role, nil); err != nil {
+				return nil, err
+			}
+			return []configd.Change{{Path: []string{"acl", "roles", name}, Value: r}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// RoleUpdateHandler changes the permissions or parents of a role.
+func RoleUpdateHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.RoleEditRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_role_update", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		name := strings.ToLower(payload.Name)
+		if _, ok := cfg.ACL.Roles[name]; !ok {
+			return &protocol.Response{Status: "error", Error: "unknown role"}
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			r, ok := cfg.ACL.Roles[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown role '%s'", name)
+			}
+			r.Name = ""
+			if err := applyRoleConfig(&r, payload.Role, payload.Fields); err != nil {
+				return nil, err
+			}
+			return []configd.Change{{Path: []string{"acl", "roles", name}, Value: r}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
+
+// RoleRemoveHandler removes a role no user, group or role references.
+func RoleRemoveHandler(cfg *configd.Config, instance configd.ControlInstance, edit EditFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.ACLNameRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "config_role_remove", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		name := strings.ToLower(payload.Name)
+		if _, ok := cfg.ACL.Roles[name]; !ok {
+			return &protocol.Response{Status: "error", Error: "unknown role"}
+		}
+
+		result, err := edit(func(cfg *configd.Config) ([]configd.Change, error) {
+			if _, ok := cfg.ACL.Roles[name]; !ok {
+				return nil, fmt.Errorf("unknown role '%s'", name)
+			}
+			if users := roleUsers(cfg, name); len(users) > 0 {
+				return nil, fmt.Errorf("role '%s' is used by %s", name, strings.Join(users, ", "))
+			}
+			return []configd.Change{{Path: []string{"acl", "roles", name}}}, nil
+		})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
@@ -0,0 +1,328 @@
+package control
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+const userEditConfig = `
+acl:
+  enabled: true
+  users:
+    admin: {roles: [user-admin]}
+    bob: {roles: [viewer]}
+    carol: {roles: [viewer]}
+  groups:
+    ops: {members: [bob], roles: [viewer]}
+    all: {groups: [ops]}
+  roles:
+    user-admin:
+      permissions: [config_user_add, config_user_update, config_user_remove, config_user_rotate, config_group_add, config_group_update, config_group_remove, config_role_add, config_role_update, config_role_remove]
+    viewer: {permissions: [proxy_status]}
+    spare: {permissions: [proxy_list]}
+`
+
+func TestUserEditHandlers(t *testing.T) {
+	base := newMemEditor(t, userEditConfig)
+	if err := acl.Init(base.cfg.ACL, Permissions); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		command string
+		payload any
+		code    string // expected error code, "" for success
+		wantErr string
+		check   func(t *testing.T, cfg *configd.Config, resp *protocol.Response)
+	}{
+		{
+			name:    "add user",
+			user:    "admin",
+			command: "user.add",
+			payload: protocol.UserEditRequest{Name: "Dave", Roles: []string{"Viewer"}, Groups: []string{"ops"}},
+			check: func(t *testing.T, cfg *configd.Config, resp *protocol.Response) {
+				token := resp.Data.(protocol.UserTokenResponse)
+				u, ok := cfg.ACL.Users["dave"]
+				if !ok || token.Name != "dave" || !slices.Equal(u.Roles, []string{"viewer"}) {
+					t.Fatalf("dave = %+v, response = %+v", u, token)
+				}
+				if !acl.IsHashed(u.Token) || !acl.VerifyToken(u.Token, token.Token) {
+					t.Errorf("stored token %q does not verify the returned token", u.Token)
+				}
+				if members := cfg.ACL.Groups["ops"].Members; !slices.Equal(members, []string{"bob", "dave"}) {
+					t.Errorf("ops members = %v", members)
+				}
+			},
+		},
+		{
+			name:    "add user with unknown role",
+			user:    "admin",
+			command: "user.add",
+			payload: protocol.UserEditRequest{Name: "dave", Roles: []string{"root"}},
+			wantErr: "unknown role 'root'",
+		},
+		{
+			name:    "add existing user",
+			user:    "admin",
+			command: "user.add",
+			payload: protocol.UserEditRequest{Name: "Bob"},
+			wantErr: "already exists",
+		},
+		{
+			name:    "add user without permission",
+			user:    "bob",
+			command: "user.add",
+			payload: protocol.UserEditRequest{Name: "dave"},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "update groups",
+			user:    "admin",
+			command: "user.update",
+			payload: protocol.UserEditRequest{Name: "Bob", Fields: []string{"groups"}},
+			check: func(t *testing.T, cfg *configd.Config, resp *protocol.Response) {
+				if members := cfg.ACL.Groups["ops"].Members; len(members) != 0 {
+					t.Errorf("ops members = %v", members)
+				}
+				if roles := cfg.ACL.Users["bob"].Roles; !slices.Equal(roles, []string{"viewer"}) {
+					t.Errorf("bob roles = %v", roles)
+				}
+			},
+		},
+		{
+			name:    "update roles",
+			user:    "admin",
+			command: "user.update",
+			payload: protocol.UserEditRequest{Name: "carol", Roles: []string{"spare"}, Fields: []string{"roles"}},
+			check: func(t *testing.T, cfg *configd.Config, resp *protocol.Response) {
+				if roles := cfg.ACL.Users["carol"].Roles; !slices.Equal(roles, []string{"spare"}) {
+					t.Errorf("carol roles = %v", roles)
+				}
+			},
+		},
+		{
+			name:    "update unknown user",
+			user:    "admin",
+			command: "user.update",
+			payload: protocol.UserEditRequest{Name: "dave", Fields: []string{"roles"}},
+			wantErr: "unknown user",
+		},
+		{
+			name:    "rotate token",
+			user:    "admin",
+			command: "user.rotate",
+			payload: protocol.ACLNameRequest{Name: "Bob"},
+			check: func(t *testing.T, cfg *configd.Config, resp *protocol.Response) {
+				token := resp.Data.(protocol.UserTokenResponse)
+				if stored := cfg.ACL.Users["bob"].Token; !acl.VerifyToken(stored, token.Token) {
+					t.Errorf("stored token %q does not verify the returned token", stored)
+				}
+			},
+		},
+		{
+			name:    "remove user",
+			user:    "admin",
+			command: "user.remove",
+			payload: protocol.ACLNameRequest{Name: "Bob"},
+			check: func(t *testing.T, cfg *configd.Config, resp *protocol.Response) {
+				if _, ok := cfg.ACL.Users["bob"]; ok {
+					t.Error("bob still configured")
+				}
+				if members := cfg.ACL.Groups["ops"].Members; len(members) != 0 {
+					t.Errorf("ops members = %v", members)
+				}
+			},
+		},
+		{
+			name:    "remove own user",
+			user:    "admin",
+			command: "user.remove",
+			payload: protocol.ACLNameRequest{Name: "admin"},
+			wantErr: "cannot remove the user of this request",
+		},
+		{
+			name:    "add group",
+			user:    "admin",
+			command: "group.add",
+			payload: protocol.GroupEditRequest{Name: "Dev", Group: protocol.GroupConfig{Members: []string{"Carol"}, Roles: []string{"spare"}}},
+			check: func(t *testing.T, cfg *configd.Config, resp *protocol.Response) {
+				if g := cfg.ACL.Groups["dev"]; !slices.Equal(g.Members, []string{"carol"}) || !slices.Equal(g.Roles, []string{"spare"}) {
+					t.Errorf("dev = %+v", g)
+				}
+			},
+		},
+		{
+			name:    "update group with unknown role",
+			user:    "admin",
+			command: "group.update",
+			payload: protocol.GroupEditRequest{Name: "ops", Group: protocol.GroupConfig{Roles: []string{"root"}}, Fields: []string{"roles"}},
+			wantErr: "unknown role 'root'",
+		},
+		{
+			name:    "remove nested group",
+			user:    "admin",
+			command: "group.remove",
+			payload: protocol.ACLNameRequest{Name: "OPS"},
+			check: func(t *testing.T, cfg *configd.Config, resp *protocol.Response) {
+				if _, ok := cfg.ACL.Groups["ops"]; ok {
+					t.Error("ops still configured")
+				}
+				if nested := cfg.ACL.Groups["all"].Groups; len(nested) != 0 {
+					t.Errorf("all groups = %v", nested)
+				}
+			},
+		},
+		{
+			name:    "update role",
+			user:    "admin",
+			command: "role.update",
+			payload: protocol.RoleEditRequest{Name: "Spare", Role: protocol.RoleConfig{Permissions: []string{"proxy_info"}}, Fields: []string{"permissions"}},
+			check: func(t *testing.T, cfg *configd.Config, resp *protocol.Response) {
+				if p := cfg.ACL.Roles["spare"].Permissions; !slices.Equal(p, []acl.Permission{"proxy_info"}) {
+					t.Errorf("spare permissions = %v", p)
+				}
+			},
+		},
+		{
+			name:    "remove unused role",
+			user:    "admin",
+			command: "role.remove",
+			payload: protocol.ACLNameRequest{Name: "spare"},
+			check: func(t *testing.T, cfg *configd.Config, resp *protocol.Response) {
+				if _, ok := cfg.ACL.Roles["spare"]; ok {
+					t.Error("spare still configured")
+				}
+			},
+		},
+		{
+			name:    "update unknown user without permission",
+			user:    "bob",
+			command: "user.update",
+			payload: protocol.UserEditRequest{Name: "dave", Fields: []string{"roles"}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "rotate unknown user without permission",
+			user:    "bob",
+			command: "user.rotate",
+			payload: protocol.ACLNameRequest{Name: "dave"},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "remove unknown user without permission",
+			user:    "bob",
+			command: "user.remove",
+			payload: protocol.ACLNameRequest{Name: "dave"},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "update unknown group without permission",
+			user:    "bob",
+			command: "group.update",
+			payload: protocol.GroupEditRequest{Name: "dev", Fields: []string{"roles"}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "remove unknown group without permission",
+			user:    "bob",
+			command: "group.remove",
+			payload: protocol.ACLNameRequest{Name: "dev"},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "update unknown role without permission",
+			user:    "bob",
+			command: "role.update",
+			payload: protocol.RoleEditRequest{Name: "root", Fields: []string{"permissions"}},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "remove unknown role without permission",
+			user:    "bob",
+			command: "role.remove",
+			payload: protocol.ACLNameRequest{Name: "root"},
+			code:    protocol.ErrCodeNotAllowed,
+		},
+		{
+			name:    "remove with malformed payload",
+			user:    "admin",
+			command: "user.remove",
+			payload: []string{"bob"},
+			wantErr: "cannot unmarshal",
+		},
+		{
+			name:    "remove role in use",
+			user:    "admin",
+			command: "role.remove",
+			payload: protocol.ACLNameRequest{Name: "viewer"},
+			wantErr: "role 'viewer' is used by group ops, user bob, user carol",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newMemEditor(t, userEditConfig)
+			inst := configd.ControlInstance{Name: "test"}
+			handlers := map[string]func(*configd.Config, configd.ControlInstance, EditFunc) func(*protocol.Request) *protocol.Response{
+				"user.add":     UserAddHandler,
+				"user.update":  UserUpdateHandler,
+				"user.rotate":  UserRotateHandler,
+				"user.remove":  UserRemoveHandler,
+				"group.add":    GroupAddHandler,
+				"group.update": GroupUpdateHandler,
+				"group.remove": GroupRemoveHandler,
+				"role.update":  RoleUpdateHandler,
+				"role.remove":  RoleRemoveHandler,
+			}
+			handler := handlers[tt.command](e.cfg, inst, e.edit)
+
+			resp := handler(&protocol.Request{Auth: &protocol.Auth{User: tt.user}, Data: tt.payload})
+			switch {
+			case tt.code != "" || tt.wantErr != "":
+				if resp.Status != "error" || resp.Code != tt.code || !strings.Contains(resp.Error, tt.wantErr) {
+					t.Fatalf("response = %+v, want code %q error containing %q", resp, tt.code, tt.wantErr)
+				}
+				if string(e.data) != userEditConfig {
+					t.Errorf("config changed:\n%s", e.data)
+				}
+			case resp.Status != "ok":
+				t.Fatalf("response = %+v", resp)
+			default:
+				tt.check(t, e.cfg, resp)
+			}
+		})
+	}
+}
+
+func TestUserRotateRevokesSessions(t *testing.T) {
+	e := newMemEditor(t, userEditConfig)
+	if err := acl.Init(e.cfg.ACL, Permissions); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := acl.Login("bob", "test", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := acl.Login("carol", "test", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := UserRotateHandler(e.cfg, configd.ControlInstance{Name: "test"}, e.edit)
+	resp := handler(&protocol.Request{Auth: &protocol.Auth{User: "admin"}, Data: protocol.ACLNameRequest{Name: "bob"}})
+	if resp.Status != "ok" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if acl.SessionActive(token) {
+		t.Error("session of bob still active after rotation")
+	}
+	if !acl.SessionActive(other) {
+		t.Error("session of carol revoked")
+	}
+}
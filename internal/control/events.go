@@ -0,0 +1,116 @@
+package control
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/mfulz/portgeist/dispatch"
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/events"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// serveSubscription answers a system.subscribe request and streams events
+// on conn until the client disconnects. Proxy events are only delivered if
+// the user may view the proxy's status, authentication failures require
+// system_auth_events. Visibility follows the current configuration of s.
+// A stream opened with a session token ends when the session expires or is
+// revoked.
+func (s *Server) serveSubscription(conn net.Conn, dec *json.Decoder, enc *json.Encoder, req *protocol.Request, session *acl.Session, dispatcher *dispatch.Dispatcher, call dispatch.Call) {
+	inst := s.inst
+	actx := aclContext(req, inst)
+	user := actx.User
+	if !acl.Can(actx, "system_subscribe", acl.ACLRuleSet{}) {
+		resp := notAllowed()
+		dispatcher.Notify(call, req, resp)
+		_ = enc.Encode(resp)
+		return
+	}
+
+	var filter protocol.SubscribeRequest
+	_ = decodePayload(req.Data, &filter)
+
+	sub := events.Subscribe()
+	defer sub.Close()
+
+	resp := &protocol.Response{Status: "ok"}
+	dispatcher.Notify(call, req, resp)
+	if err := enc.Encode(resp); err != nil {
+		return
+	}
+	logging.Log.Infof("[control:%s] '%s' subscribed to events", inst.Name, user)
+
+	// The client does not send anything after subscribing, a read
+	// returning means it went away.
+	gone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, io.MultiReader(dec.Buffered(), conn))
+		close(gone)
+	}()
+
+	var expired <-chan time.Time
+	if session != nil {
+		timer := time.NewTimer(time.Until(session.ExpiresAt))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		select {
+		case <-gone:
+			logging.Log.Infof("[control:%s] '%s' unsubscribed from events", inst.Name, user)
+			return
+		case <-expired:
+			logging.Log.Infof("[control:%s] Session of '%s' expired, closing event stream", inst.Name, user)
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if session != nil && !acl.SessionActive(req.Auth.Token) {
+				logging.Log.Infof("[control:%s] Session of '%s' ended, closing event stream", inst.Name, user)
+				return
+			}
+			actx.Time = time.Now()
+			cfg, _ := s.current()
+			if !eventMatches(ev, &filter) || !eventVisible(ev, actx, cfg) {
+				continue
+			}
+			if err := enc.Encode(&ev); err != nil {
+				logging.Log.Infof("[control:%s] Failed to send event: %v", inst.Name, err)
+				return
+			}
+		}
+	}
+}
+
+// eventMatches applies the filters of a subscription request.
+func eventMatches(ev protocol.Event, filter *protocol.SubscribeRequest) bool {
+	if len(filter.Types) > 0 && !slices.Contains(filter.Types, ev.Type) {
+		return false
+	}
+	if len(filter.Proxies) > 0 && !slices.Contains(filter.Proxies, ev.Proxy) {
+		return false
+	}
+	return true
+}
+
+// eventVisible reports whether the subscriber described by actx may see ev.
+func eventVisible(ev protocol.Event, actx acl.Context, cfg *configd.Config) bool {
+	switch {
+	case ev.Type == protocol.EventAuthFailure:
+		return acl.Can(actx, "system_auth_events", acl.ACLRuleSet{})
+	case ev.Proxy != "":
+		proxyCfg, ok := cfg.Proxies.Proxies[ev.Proxy]
+		if !ok {
+			return false
+		}
+		return acl.Can(actx, "proxy_status", acl.ProxyRules(ev.Proxy, proxyCfg.ACLs))
+	}
+	return true
+}
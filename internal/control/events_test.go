@@ -0,0 +1,197 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mfulz/portgeist/dispatch"
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/events"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// subscriptionACL lets alice subscribe to events and view proxies, and
+// admin additionally see authentication failures.
+var subscriptionACL = acl.ACLConfig{
+	Enabled: true,
+	Users: map[string]acl.User{
+		"alice": {Token: "alice-token", Roles: []string{"watcher"}},
+		"admin": {Token: "admin-token", Roles: []string{"watcher", "auditor"}},
+	},
+	Roles: map[string]acl.Role{
+		"watcher": {Permissions: []acl.Permission{"system_subscribe", "proxy_status"}},
+		"auditor": {Permissions: []acl.Permission{"system_auth_events"}},
+	},
+}
+
+// subscriptionConfig has proxy pp visible to alice and dev only to admin.
+func subscriptionConfig() *configd.Config {
+	return &configd.Config{
+		Proxies: configd.ProxiesConfig{
+			Proxies: map[string]configd.Proxy{
+				"pp":  {Port: 1080, ACLs: acl.ACLRuleSet{Rules: []acl.ACLRule{{Subjects: []string{"alice", "admin"}}}}},
+				"dev": {Port: 1081, ACLs: acl.ACLRuleSet{Rules: []acl.ACLRule{{Subjects: []string{"admin"}}}}},
+			},
+		},
+	}
+}
+
+// startSubscriptionServer runs a unix control instance with
+// subscriptionACL and returns its socket path.
+func startSubscriptionServer(t *testing.T) string {
+	t.Helper()
+	if err := acl.Init(subscriptionACL, Permissions); err != nil {
+		t.Fatal(err)
+	}
+	inst := configd.ControlInstance{
+		Name:    "test",
+		Enabled: true,
+		Mode:    "unix",
+		Listen:  filepath.Join(t.TempDir(), "geistd.sock"),
+	}
+	srv, err := StartServerInstance(inst, subscriptionConfig(), dispatch.New())
+	if err != nil {
+		t.Fatalf("StartServerInstance() = %v", err)
+	}
+	t.Cleanup(srv.Close)
+	return inst.Listen
+}
+
+// subscribe opens an event stream authenticated by token.
+func subscribe(t *testing.T, socket, token string) (net.Conn, *json.Decoder) {
+	t.Helper()
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	req := protocol.Request{Type: protocol.CmdSubscribe, Auth: &protocol.Auth{User: "alice", Token: token}}
+	if err := json.NewEncoder(conn).Encode(&req); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(conn)
+	var resp protocol.Response
+	if err := dec.Decode(&resp); err != nil || resp.Status != "ok" {
+		t.Fatalf("subscribe = %+v, %v", resp, err)
+	}
+	return conn, dec
+}
+
+// nextEvent returns the next event of a stream or an error once the
+// daemon closed it.
+func nextEvent(conn net.Conn, dec *json.Decoder) (protocol.Event, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ev protocol.Event
+	err := dec.Decode(&ev)
+	return ev, err
+}
+
+// expectClosed fails unless the daemon closed the stream.
+func expectClosed(t *testing.T, conn net.Conn, dec *json.Decoder) {
+	t.Helper()
+	ev, err := nextEvent(conn, dec)
+	if err == nil {
+		t.Fatalf("received %+v, want the stream closed", ev)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatal("stream is still open")
+	}
+}
+
+// publishAndExpect publishes a config.reloaded event and expects it on the
+// stream.
+func publishAndExpect(t *testing.T, conn net.Conn, dec *json.Decoder) {
+	t.Helper()
+	events.Publish(protocol.Event{Type: protocol.EventConfigReloaded})
+	ev, err := nextEvent(conn, dec)
+	if err != nil || ev.Type != protocol.EventConfigReloaded {
+		t.Fatalf("event = %+v, %v", ev, err)
+	}
+}
+
+func TestSubscriptionEndsWithSession(t *testing.T) {
+	socket := startSubscriptionServer(t)
+
+	t.Run("logout", func(t *testing.T) {
+		token, _, err := acl.Login("alice", "test", time.Hour, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, dec := subscribe(t, socket, token)
+		publishAndExpect(t, conn, dec)
+
+		if err := acl.Logout("alice", token); err != nil {
+			t.Fatal(err)
+		}
+		events.Publish(protocol.Event{Type: protocol.EventConfigReloaded})
+		expectClosed(t, conn, dec)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		token, _, err := acl.Login("alice", "test", 200*time.Millisecond, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, dec := subscribe(t, socket, token)
+
+		// the stream closes at expiry even without events
+		expectClosed(t, conn, dec)
+	})
+
+	t.Run("long-lived token", func(t *testing.T) {
+		conn, dec := subscribe(t, socket, "alice-token")
+		publishAndExpect(t, conn, dec)
+		publishAndExpect(t, conn, dec)
+	})
+}
+
+func TestEventVisible(t *testing.T) {
+	if err := acl.Init(subscriptionACL, Permissions); err != nil {
+		t.Fatal(err)
+	}
+	cfg := subscriptionConfig()
+
+	tests := []struct {
+		name string
+		user string
+		ev   protocol.Event
+		want bool
+	}{
+		{name: "proxy the user may view", user: "alice", ev: protocol.Event{Type: protocol.EventProxyStarted, Proxy: "pp"}, want: true},
+		{name: "proxy the user may not view", user: "alice", ev: protocol.Event{Type: protocol.EventProxyStarted, Proxy: "dev"}},
+		{name: "proxy of another user", user: "admin", ev: protocol.Event{Type: protocol.EventProxyStarted, Proxy: "dev"}, want: true},
+		{name: "unknown proxy", user: "admin", ev: protocol.Event{Type: protocol.EventProxyStopped, Proxy: "gone"}},
+		{name: "auth failure without system_auth_events", user: "alice", ev: protocol.Event{Type: protocol.EventAuthFailure, User: "mallory"}},
+		{name: "auth failure with system_auth_events", user: "admin", ev: protocol.Event{Type: protocol.EventAuthFailure, User: "mallory"}, want: true},
+		{name: "daemon event", user: "alice", ev: protocol.Event{Type: protocol.EventConfigReloaded}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actx := acl.Context{User: tt.user, Time: time.Now()}
+			if got := eventVisible(tt.ev, actx, cfg); got != tt.want {
+				t.Errorf("eventVisible() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionHidesProxies(t *testing.T) {
+	socket := startSubscriptionServer(t)
+	conn, dec := subscribe(t, socket, "alice-token")
+
+	events.Publish(protocol.Event{Type: protocol.EventProxyStarted, Proxy: "dev"})
+	events.Publish(protocol.Event{Type: protocol.EventAuthFailure, User: "mallory"})
+	events.Publish(protocol.Event{Type: protocol.EventProxyStarted, Proxy: "pp"})
+
+	ev, err := nextEvent(conn, dec)
+	if err != nil || ev.Proxy != "pp" {
+		t.Fatalf("event = %+v, %v, want the start of pp only", ev, err)
+	}
+}
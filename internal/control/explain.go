@@ -0,0 +1,71 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// ExplainHandler returns the evaluation trace of an ACL decision. Without
+// a proxy the global rules apply, without a user the requester is explained.
+// With a host the rules of the host and its backend are evaluated as well.
+// Rule conditions see the context of the explain request unless the
+// payload overrides instance, source or time.
+func ExplainHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.ACLExplainRequest
+		if err := decodePayload(req.Data, &payload); err != nil {
+			return errorResponse(err)
+		}
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "acl_explain", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		perm := acl.Permission(payload.Permission)
+		if !acl.IsPermission(perm) {
+			return errorResponse(fmt.Errorf("unknown permission: %s", payload.Permission))
+		}
+		target := actx
+		if payload.User != "" {
+			target.User = payload.User
+		}
+		if payload.Instance != "" {
+			target.Instance = payload.Instance
+		}
+		if payload.Source != "" {
+			target.Source = payload.Source
+		}
+		if payload.Time != "" {
+			t, err := time.Parse(time.RFC3339, payload.Time)
+			if err != nil {
+				return errorResponse(fmt.Errorf("invalid time: %w", err))
+			}
+			target.Time = t
+		}
+
+		var sets []acl.ACLRuleSet
+		if payload.Proxy != "" {
+			proxyCfg, ok := cfg.Proxies.Proxies[payload.Proxy]
+			if !ok {
+				return errorResponse(fmt.Errorf("unknown proxy: %s", payload.Proxy))
+			}
+			if _, ok := cfg.Hosts[payload.Host]; payload.Host != "" && !ok {
+				return errorResponse(fmt.Errorf("unknown host: %s", payload.Host))
+			}
+			sets = TargetRules(cfg, payload.Proxy, proxyCfg, payload.Host)
+		} else if payload.Host != "" {
+			return errorResponse(errors.New("host requires a proxy"))
+		}
+
+		ex := acl.Explain(target, perm, sets...)
+		ex.Proxy = payload.Proxy
+		ex.Host = payload.Host
+		return &protocol.Response{Status: "ok", Data: ex}
+	}
+}
@@ -0,0 +1,73 @@
+package control
+
+import (
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+func TestExplainHandler(t *testing.T) {
+	err := acl.Init(acl.ACLConfig{
+		Enabled: true,
+		Users: map[string]acl.User{
+			"admin": {Token: "a", Roles: []string{"auditor", "starter"}},
+			"bob":   {Token: "b", Roles: []string{"starter"}},
+		},
+		Roles: map[string]acl.Role{
+			"auditor": {Permissions: []acl.Permission{"acl_explain"}},
+			"starter": {Permissions: []acl.Permission{"proxy_start"}},
+		},
+	}, Permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &configd.Config{}
+	cfg.Proxies.Proxies = map[string]configd.Proxy{
+		"pp": {ACLs: acl.ACLRuleSet{Rules: []acl.ACLRule{
+			{Subjects: []string{"admin"}, Permissions: []acl.Permission{"proxy_start"}},
+		}}},
+	}
+	handler := ExplainHandler(cfg, configd.ControlInstance{})
+
+	tests := []struct {
+		name      string
+		requester string
+		payload   protocol.ACLExplainRequest
+		status    string
+		allowed   bool
+		user      string
+	}{
+		{"requester by default", "admin", protocol.ACLExplainRequest{Permission: "proxy_start"}, "ok", true, "admin"},
+		{"other user", "admin", protocol.ACLExplainRequest{User: "bob", Permission: "proxy_start"}, "ok", true, "bob"},
+		{"proxy rules", "admin", protocol.ACLExplainRequest{User: "bob", Proxy: "pp", Permission: "proxy_start"}, "ok", false, "bob"},
+		{"unknown permission", "admin", protocol.ACLExplainRequest{Permission: "proxy_fly"}, "error", false, ""},
+		{"unknown proxy", "admin", protocol.ACLExplainRequest{Proxy: "nope", Permission: "proxy_start"}, "error", false, ""},
+		{"not allowed", "bob", protocol.ACLExplainRequest{Permission: "proxy_start"}, "error", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := handler(&protocol.Request{
+				Type: protocol.CmdACLExplain,
+				Auth: &protocol.Auth{User: tt.requester},
+				Data: tt.payload,
+			})
+			if resp.Status != tt.status {
+				t.Fatalf("status = %s (%s), want %s", resp.Status, resp.Error, tt.status)
+			}
+			if tt.status != "ok" {
+				return
+			}
+			ex, ok := resp.Data.(*protocol.ACLExplanation)
+			if !ok {
+				t.Fatalf("Data is %T", resp.Data)
+			}
+			if ex.User != tt.user || ex.Allowed != tt.allowed || ex.Proxy != tt.payload.Proxy {
+				t.Errorf("explanation = %+v", ex)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package control
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/hostkeys"
+	"github.com/mfulz/portgeist/protocol"
+	"golang.org/x/crypto/ssh"
+)
+
+// hostScanTimeout bounds the key exchange performed for host.fingerprints --scan.
+const hostScanTimeout = 10 * time.Second
+
+func HostKeysHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.HostKeysRequest
+		_ = decodePayload(req.Data, &payload)
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "host_view", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		store := hostkeys.Default()
+		if store == nil {
+			return &protocol.Response{Status: "error", Error: "host key store not initialized"}
+		}
+
+		var names []string
+		if payload.Host != "" {
+			if _, ok := cfg.Hosts[payload.Host]; !ok {
+				return &protocol.Response{Status: "error", Error: "unknown host"}
+			}
+			names = append(names, payload.Host)
+		} else {
+			for name := range cfg.Hosts {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+
+		if payload.Scan {
+			if payload.Host == "" {
+				return &protocol.Response{Status: "error", Error: "scan requires a host"}
+			}
+			if !acl.Can(actx, "host_trust", acl.ACLRuleSet{}) {
+				return notAllowed()
+			}
+			addr := cfg.Hosts[payload.Host].Addr()
+			key, err := hostkeys.Scan(addr, hostScanTimeout)
+			if err != nil {
+				return &protocol.Response{Status: "error", Error: err.Error()}
+			}
+			if !keyTrusted(store, addr, key) {
+				store.Record(payload.Host, addr, key)
+			}
+		}
+
+		var result []protocol.HostKeysEntry
+		for _, name := range names {
+			host := cfg.Hosts[name]
+			spec := cfg.HostKeySpec(name)
+			entry := protocol.HostKeysEntry{
+				Host:    name,
+				Address: host.Addr(),
+				Policy:  string(spec.Policy),
+				Pinned:  host.HostKey,
+				Trusted: []protocol.HostKey{},
+				Pending: []protocol.HostKey{},
+			}
+
+			trusted, err := store.Lookup(entry.Address)
+			if err != nil {
+				return &protocol.Response{Status: "error", Error: err.Error()}
+			}
+			for _, k := range trusted {
+				entry.Trusted = append(entry.Trusted, protocol.HostKey{
+					Type:        k.Type(),
+					Fingerprint: ssh.FingerprintSHA256(k),
+				})
+			}
+			for _, p := range store.Pending(name) {
+				entry.Pending = append(entry.Pending, protocol.HostKey{
+					Type:        p.Type,
+					Fingerprint: p.Fingerprint,
+					Reason:      p.Reason,
+					SeenAt:      p.SeenAt.Format(time.RFC3339),
+				})
+			}
+			result = append(result, entry)
+		}
+
+		return &protocol.Response{
+			Status: "ok",
+			Data:   protocol.HostKeysResponse{Hosts: result},
+		}
+	}
+}
+
+func HostTrustHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.HostTrustRequest
+		_ = decodePayload(req.Data, &payload)
+
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "host_trust", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		host, ok := cfg.Hosts[payload.Host]
+		if !ok {
+			return &protocol.Response{Status: "error", Error: "unknown host"}
+		}
+		if host.HostKey != "" || host.KnownHosts != "" {
+			return &protocol.Response{Status: "error", Error: "host key is pinned in config"}
+		}
+		if payload.Fingerprint == "" {
+			return &protocol.Response{Status: "error", Error: "fingerprint required"}
+		}
+
+		store := hostkeys.Default()
+		if store == nil {
+			return &protocol.Response{Status: "error", Error: "host key store not initialized"}
+		}
+
+		if _, err := store.Trust(payload.Host, payload.Fingerprint); err != nil {
+			return &protocol.Response{Status: "error", Error: err.Error()}
+		}
+		return &protocol.Response{Status: "ok"}
+	}
+}
+
+// keyTrusted reports whether key is already recorded for addr in the store.
+func keyTrusted(store *hostkeys.Store, addr string, key ssh.PublicKey) bool {
+	known, err := store.Lookup(addr)
+	if err != nil {
+		return false
+	}
+	fp := ssh.FingerprintSHA256(key)
+	for _, k := range known {
+		if ssh.FingerprintSHA256(k) == fp {
+			return true
+		}
+	}
+	return false
+}
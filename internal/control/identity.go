@@ -0,0 +1,164 @@
+package control
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+)
+
+// peerIdentity returns the identity the transport of conn establishes for
+// the caller, or nil if callers have to authenticate by token.
+func peerIdentity(conn net.Conn, inst configd.ControlInstance) (*acl.Identity, error) {
+	switch c := conn.(type) {
+	case *tls.Conn:
+		return certIdentity(c, inst)
+	case *net.UnixConn:
+		if !inst.PeerCred.Enabled {
+			return nil, nil
+		}
+		uid, gid, err := peerCred(c)
+		if err != nil {
+			return nil, err
+		}
+		return &acl.Identity{User: peerCredUser(uid, gid, inst.PeerCred), Source: "peercred"}, nil
+	}
+	return nil, nil
+}
+
+// peerAddress describes the client of conn for logs and audit entries.
+// Unix clients are described by their credentials where available.
+func peerAddress(conn net.Conn) string {
+	if c, ok := conn.(*net.UnixConn); ok {
+		if uid, gid, err := peerCred(c); err == nil {
+			return fmt.Sprintf("uid=%d,gid=%d", uid, gid)
+		}
+		return "unix"
+	}
+	return conn.RemoteAddr().String()
+}
+
+// certIdentity completes the TLS handshake and maps the verified client
+// certificate's common name to an ACL user.
+func certIdentity(conn *tls.Conn, inst configd.ControlInstance) (*acl.Identity, error) {
+	if err := conn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", conn.RemoteAddr(), err)
+	}
+
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return nil, nil
+	}
+	cn := state.VerifiedChains[0][0].Subject.CommonName
+	if u, ok := inst.TLS.Users[cn]; ok {
+		return &acl.Identity{User: u, Source: "tls"}, nil
+	}
+	return &acl.Identity{User: cn, Source: "tls"}, nil
+}
+
+// peerCredUser maps a local uid and its groups to an ACL user. See
+// configd.PeerCred for the lookup order.
+func peerCredUser(uid, gid uint32, cfg configd.PeerCred) string {
+	uidStr := strconv.FormatUint(uint64(uid), 10)
+	local, _ := user.LookupId(uidStr)
+
+	if u, ok := cfg.UIDs[uidStr]; ok {
+		return u
+	}
+	if local != nil {
+		if u, ok := cfg.UIDs[local.Username]; ok {
+			return u
+		}
+	}
+
+	gids := []string{strconv.FormatUint(uint64(gid), 10)}
+	if local != nil {
+		if more, err := local.GroupIds(); err == nil {
+			gids = append(gids, more...)
+		}
+	}
+	for _, g := range gids {
+		if u, ok := cfg.GIDs[g]; ok {
+			return u
+		}
+		if grp, err := user.LookupGroupId(g); err == nil {
+			if u, ok := cfg.GIDs[grp.Name]; ok {
+				return u
+			}
+		}
+	}
+
+	if local != nil {
+		return local.Username
+	}
+	return ""
+}
+
+// applySocketPermissions sets ownership and mode of a unix control socket.
+// Without a configured mode the socket gets defaultMode, unless that is 0.
+func applySocketPermissions(path string, s configd.UnixSocket, defaultMode os.FileMode) error {
+	uid, gid := -1, -1
+	if s.Owner != "" {
+		id, err := lookupID(s.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("socket owner: %w", err)
+		}
+		uid = id
+	}
+	if s.Group != "" {
+		id, err := lookupID(s.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("socket group: %w", err)
+		}
+		gid = id
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	mode := defaultMode
+	if s.Mode != "" {
+		m, err := strconv.ParseUint(s.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("socket mode: %w", err)
+		}
+		mode = os.FileMode(m)
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupID resolves a numeric id or a name via lookup.
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+	idStr, err := lookup(nameOrID)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(idStr)
+}
@@ -0,0 +1,133 @@
+package control
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/configd"
+)
+
+func TestPeerCredUser(t *testing.T) {
+	local, err := user.Current()
+	if err != nil {
+		t.Skipf("no local user: %v", err)
+	}
+	uid, _ := strconv.ParseUint(local.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(local.Gid, 10, 32)
+
+	tests := []struct {
+		name string
+		cfg  configd.PeerCred
+		want string
+	}{
+		{"uid", configd.PeerCred{UIDs: map[string]string{local.Uid: "admin"}}, "admin"},
+		{"user name", configd.PeerCred{UIDs: map[string]string{local.Username: "ops"}}, "ops"},
+		{"gid", configd.PeerCred{GIDs: map[string]string{local.Gid: "viewer"}}, "viewer"},
+		{"uid before gid", configd.PeerCred{
+			UIDs: map[string]string{local.Uid: "admin"},
+			GIDs: map[string]string{local.Gid: "viewer"},
+		}, "admin"},
+		{"other uid", configd.PeerCred{UIDs: map[string]string{"4242424": "admin"}}, local.Username},
+		{"no mapping", configd.PeerCred{}, local.Username},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := peerCredUser(uint32(uid), uint32(gid), tt.cfg); got != tt.want {
+				t.Fatalf("peerCredUser() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeerIdentityUnix(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on Linux")
+	}
+	path := filepath.Join(t.TempDir(), "geistd.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	inst := configd.ControlInstance{
+		Mode: "unix",
+		PeerCred: configd.PeerCred{
+			Enabled: true,
+			UIDs:    map[string]string{strconv.Itoa(os.Getuid()): "admin"},
+		},
+	}
+	id, err := peerIdentity(conn, inst)
+	if err != nil {
+		t.Fatalf("peerIdentity() = %v", err)
+	}
+	if id == nil || id.User != "admin" || id.Source != "peercred" {
+		t.Fatalf("peerIdentity() = %+v, want admin via peercred", id)
+	}
+
+	inst.PeerCred.Enabled = false
+	if id, err := peerIdentity(conn, inst); err != nil || id != nil {
+		t.Fatalf("peerIdentity() with peercred disabled = %+v, %v", id, err)
+	}
+}
+
+func TestSocketPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions are not supported on Windows")
+	}
+
+	tests := []struct {
+		name string
+		mode string
+		want os.FileMode
+	}{
+		{"configured", "0660", 0o660},
+		{"umask default", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "geistd.sock")
+			ln, defaultMode, err := listenUnix(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			// nobody but the owner may connect before the final mode is set
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if perm := info.Mode().Perm(); perm&0o077 != 0 {
+				t.Fatalf("socket created with mode %o", perm)
+			}
+
+			if err := applySocketPermissions(path, configd.UnixSocket{Mode: tt.mode}, defaultMode); err != nil {
+				t.Fatalf("applySocketPermissions() = %v", err)
+			}
+			want := tt.want
+			if want == 0 {
+				want = defaultMode
+			}
+			if info, _ := os.Stat(path); info.Mode().Perm() != want {
+				t.Fatalf("mode = %o, want %o", info.Mode().Perm(), want)
+			}
+		})
+	}
+}
@@ -2,10 +2,14 @@ package control
 
 import (
 	"encoding/json"
+	"errors"
 	"slices"
+	"sort"
+	"time"
 
 	"github.com/mfulz/portgeist/internal/acl"
 	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/hostkeys"
 	"github.com/mfulz/portgeist/internal/logging"
 	"github.com/mfulz/portgeist/internal/proxy"
 	"github.com/mfulz/portgeist/protocol"
@@ -17,6 +21,32 @@ func decodePayload(input any, out any) error {
 	return json.Unmarshal(data, out)
 }
 
+// errorResponse converts err into an error response, attaching a
+// protocol error code for failures clients are expected to act upon.
+func errorResponse(err error) *protocol.Response {
+	resp := &protocol.Response{Status: "error", Error: err.Error()}
+	switch {
+	case errors.Is(err, hostkeys.ErrMismatch):
+		resp.Code = protocol.ErrCodeHostKeyMismatch
+	case errors.Is(err, hostkeys.ErrUnknown):
+		resp.Code = protocol.ErrCodeHostKeyUnknown
+	case errors.Is(err, proxy.ErrProxyFailed):
+		resp.Code = protocol.ErrCodeProxyFailed
+	case errors.Is(err, errNotAllowed):
+		resp.Code = protocol.ErrCodeNotAllowed
+	}
+	return resp
+}
+
+// errNotAllowed is returned by checks the ACL denies after a request was
+// accepted, e.g. while preparing a config change.
+var errNotAllowed = errors.New("not allowed")
+
+// notAllowed is the response to requests the ACL denies.
+func notAllowed() *protocol.Response {
+	return &protocol.Response{Status: "error", Error: "not allowed", Code: protocol.ErrCodeNotAllowed}
+}
+
 // extractUser returns the request auth user or "unauthenticated".
 func extractUser(req *protocol.Request) string {
 	if req.Auth != nil {
@@ -25,7 +55,17 @@ func extractUser(req *protocol.Request) string {
 	return "unauthenticated"
 }
 
-func StartProxyHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+// aclContext returns the ACL context of a request received on instance.
+func aclContext(req *protocol.Request, instance configd.ControlInstance) acl.Context {
+	return acl.Context{
+		User:     extractUser(req),
+		Instance: instance.Name,
+		Source:   req.Peer,
+		Time:     time.Now(),
+	}
+}
+
+func StartProxyHandler(cfg *configd.Config, instance configd.ControlInstance, mgr *proxy.Manager) func(req *protocol.Request) *protocol.Response {
 	return func(req *protocol.Request) *protocol.Response {
 		var payload protocol.StartRequest
 		_ = decodePayload(req.Data, &payload)
@@ -35,30 +75,52 @@ func StartProxyHandler(cfg *configd.Config, instance configd.ControlInstance) fu
 			return &protocol.Response{Status: "error", Error: "unknown proxy"}
 		}
 
-		user := extractUser(req)
-		if !acl.Can(user, "proxy_start", proxyCfg.ACLs) {
-			return &protocol.Response{Status: "error", Error: "not allowed"}
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "proxy_start", acl.ProxyRules(payload.Name, proxyCfg.ACLs)) {
+			return notAllowed()
+		}
+
+		if len(proxy.Candidates(payload.Name, proxyCfg, cfg)) == 0 {
+			return &protocol.Response{Status: "error", Error: "host not allowed"}
+		}
+		// start and fail over only on hosts the caller may use
+		permitted := PermittedCandidates(actx, "proxy_start", cfg, payload.Name, proxyCfg)
+		if len(permitted) == 0 {
+			return notAllowed()
+		}
+
+		if err := mgr.StartProxyOn(payload.Name, proxyCfg, cfg, permitted); err != nil {
+			return errorResponse(err)
 		}
+		return &protocol.Response{Status: "ok"}
+	}
+}
+
+func StopProxyHandler(cfg *configd.Config, instance configd.ControlInstance, mgr *proxy.Manager) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.StopRequest
+		_ = decodePayload(req.Data, &payload)
 
-		host, ok := cfg.Hosts[proxyCfg.Default]
+		proxyCfg, ok := cfg.Proxies.Proxies[payload.Name]
 		if !ok {
-			return &protocol.Response{Status: "error", Error: "unknown host"}
+			return &protocol.Response{Status: "error", Error: "unknown proxy"}
 		}
 
-		if !slices.Contains(host.Proxies, payload.Name) {
-			return &protocol.Response{Status: "error", Error: "host not allowed"}
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "proxy_stop", acl.ProxyRules(payload.Name, proxyCfg.ACLs)) {
+			return notAllowed()
 		}
 
-		if err := proxy.StartProxy(payload.Name, proxyCfg, cfg); err != nil {
+		if err := mgr.StopProxy(payload.Name, proxyCfg, cfg); err != nil {
 			return &protocol.Response{Status: "error", Error: err.Error()}
 		}
 		return &protocol.Response{Status: "ok"}
 	}
 }
 
-func StopProxyHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+func ResetProxyHandler(cfg *configd.Config, instance configd.ControlInstance, mgr *proxy.Manager) func(req *protocol.Request) *protocol.Response {
 	return func(req *protocol.Request) *protocol.Response {
-		var payload protocol.StopRequest
+		var payload protocol.ResetRequest
 		_ = decodePayload(req.Data, &payload)
 
 		proxyCfg, ok := cfg.Proxies.Proxies[payload.Name]
@@ -66,19 +128,19 @@ func StopProxyHandler(cfg *configd.Config, instance configd.ControlInstance) fun
 			return &protocol.Response{Status: "error", Error: "unknown proxy"}
 		}
 
-		user := extractUser(req)
-		if !acl.Can(user, "proxy_stop", proxyCfg.ACLs) {
-			return &protocol.Response{Status: "error", Error: "not allowed"}
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "proxy_reset", acl.ProxyRules(payload.Name, proxyCfg.ACLs)) {
+			return notAllowed()
 		}
 
-		if err := proxy.StopProxy(payload.Name, proxyCfg, cfg); err != nil {
+		if err := mgr.ResetProxy(payload.Name); err != nil {
 			return &protocol.Response{Status: "error", Error: err.Error()}
 		}
 		return &protocol.Response{Status: "ok"}
 	}
 }
 
-func ProxyStatusHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+func ProxyStatusHandler(cfg *configd.Config, instance configd.ControlInstance, mgr *proxy.Manager) func(req *protocol.Request) *protocol.Response {
 	return func(req *protocol.Request) *protocol.Response {
 		var payload protocol.StatusRequest
 		_ = decodePayload(req.Data, &payload)
@@ -88,12 +150,12 @@ func ProxyStatusHandler(cfg *configd.Config, instance configd.ControlInstance) f
 			return &protocol.Response{Status: "error", Error: "unknown proxy"}
 		}
 
-		user := extractUser(req)
-		if !acl.Can(user, "proxy_status", proxyCfg.ACLs) {
-			return &protocol.Response{Status: "error", Error: "not allowed"}
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "proxy_status", acl.ProxyRules(payload.Name, proxyCfg.ACLs)) {
+			return notAllowed()
 		}
 
-		status, err := proxy.GetProxyStatus(payload.Name, proxyCfg, cfg)
+		status, err := mgr.GetProxyStatus(payload.Name, proxyCfg, cfg)
 		if err != nil {
 			return &protocol.Response{Status: "error", Error: err.Error()}
 		}
@@ -101,20 +163,25 @@ func ProxyStatusHandler(cfg *configd.Config, instance configd.ControlInstance) f
 	}
 }
 
-func ProxyListHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+// ProxyListHandler lists the proxies the user may view, i.e. query with
+// proxy_status, together with their runtime state.
+func ProxyListHandler(cfg *configd.Config, instance configd.ControlInstance, mgr *proxy.Manager) func(req *protocol.Request) *protocol.Response {
 	return func(req *protocol.Request) *protocol.Response {
-		user := extractUser(req)
-		if !acl.Can(user, "proxy_list", acl.ACLRuleSet{}) {
-			return &protocol.Response{Status: "error", Error: "not allowed"}
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "proxy_list", acl.ACLRuleSet{}) {
+			return notAllowed()
 		}
 
-		var result []string
-		// for name, proxyCfg := range cfg.Proxies.Proxies {
-		for name := range cfg.Proxies.Proxies {
-			// if IsControlAllowed(proxyCfg, user, !instance.Auth.Enabled) {
-			result = append(result, name)
-			// }
+		result := []protocol.ProxyListEntry{}
+		for name, proxyCfg := range cfg.Proxies.Proxies {
+			if !acl.Can(actx, "proxy_status", acl.ProxyRules(name, proxyCfg.ACLs)) {
+				continue
+			}
+			result = append(result, mgr.ListEntry(name, proxyCfg, cfg))
 		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Name < result[j].Name
+		})
 		return &protocol.Response{
 			Status: "ok",
 			Data: protocol.ListResponse{
@@ -124,7 +191,7 @@ func ProxyListHandler(cfg *configd.Config, instance configd.ControlInstance) fun
 	}
 }
 
-func ProxyInfoHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+func ProxyInfoHandler(cfg *configd.Config, instance configd.ControlInstance, mgr *proxy.Manager) func(req *protocol.Request) *protocol.Response {
 	return func(req *protocol.Request) *protocol.Response {
 		var payload protocol.InfoRequest
 		_ = decodePayload(req.Data, &payload)
@@ -134,14 +201,14 @@ func ProxyInfoHandler(cfg *configd.Config, instance configd.ControlInstance) fun
 			return &protocol.Response{Status: "error", Error: "unknown proxy"}
 		}
 
-		user := extractUser(req)
-		logging.Log.Debugf("extracted user: %v", user)
+		actx := aclContext(req, instance)
+		logging.Log.Debugf("extracted user: %v", actx.User)
 
-		if !acl.Can(user, "proxy_info", proxyCfg.ACLs) {
-			return &protocol.Response{Status: "error", Error: "not allowed"}
+		if !acl.Can(actx, "proxy_info", acl.ProxyRules(payload.Name, proxyCfg.ACLs)) {
+			return notAllowed()
 		}
 
-		info, err := proxy.GetProxyInfo(payload.Name, proxyCfg, cfg)
+		info, err := mgr.GetProxyInfo(payload.Name, proxyCfg, cfg)
 		if err != nil {
 			return &protocol.Response{Status: "error", Error: err.Error()}
 		}
@@ -149,7 +216,7 @@ func ProxyInfoHandler(cfg *configd.Config, instance configd.ControlInstance) fun
 	}
 }
 
-func ProxySetActiveHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+func ProxySetActiveHandler(cfg *configd.Config, instance configd.ControlInstance, mgr *proxy.Manager) func(req *protocol.Request) *protocol.Response {
 	return func(req *protocol.Request) *protocol.Response {
 		var payload protocol.SetActiveRequest
 		_ = decodePayload(req.Data, &payload)
@@ -159,9 +226,9 @@ func ProxySetActiveHandler(cfg *configd.Config, instance configd.ControlInstance
 			return &protocol.Response{Status: "error", Error: "unknown proxy"}
 		}
 
-		user := extractUser(req)
-		if !acl.Can(user, "proxy_setactive", proxyCfg.ACLs) {
-			return &protocol.Response{Status: "error", Error: "not allowed"}
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "proxy_setactive", acl.ProxyRules(payload.Name, proxyCfg.ACLs)) {
+			return notAllowed()
 		}
 
 		host, ok := cfg.Hosts[payload.Host]
@@ -172,11 +239,16 @@ func ProxySetActiveHandler(cfg *configd.Config, instance configd.ControlInstance
 		if !slices.Contains(host.Proxies, payload.Name) {
 			return &protocol.Response{Status: "error", Error: "host not allowed"}
 		}
+		if !acl.CanAll(actx, "proxy_setactive", TargetRules(cfg, payload.Name, proxyCfg, payload.Host)...) {
+			return notAllowed()
+		}
 
 		proxyCfg.Default = payload.Host
-		_ = proxy.StopProxy(payload.Name, proxyCfg, cfg)
-		if err := proxy.StartProxy(payload.Name, proxyCfg, cfg); err != nil {
-			return &protocol.Response{Status: "error", Error: err.Error()}
+		// fail over only to hosts the caller may use
+		permitted := PermittedCandidates(actx, "proxy_setactive", cfg, payload.Name, proxyCfg)
+		_ = mgr.StopProxy(payload.Name, proxyCfg, cfg)
+		if err := mgr.StartProxyOn(payload.Name, proxyCfg, cfg, permitted); err != nil {
+			return errorResponse(err)
 		}
 		return &protocol.Response{Status: "ok"}
 	}
@@ -192,9 +264,9 @@ func ResolveProxyHandler(cfg *configd.Config, instance configd.ControlInstance)
 			return &protocol.Response{Status: "error", Error: "unknown proxy"}
 		}
 
-		user := extractUser(req)
-		if !acl.Can(user, "proxy_resolve", proxyCfg.ACLs) {
-			return &protocol.Response{Status: "error", Error: "not allowed"}
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "proxy_resolve", acl.ProxyRules(payload.Alias, proxyCfg.ACLs)) {
+			return notAllowed()
 		}
 
 		return &protocol.Response{
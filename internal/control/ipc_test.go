@@ -0,0 +1,263 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/mfulz/portgeist/interfaces"
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/proxy"
+	"github.com/mfulz/portgeist/protocol"
+	"go.uber.org/zap"
+)
+
+// Servers started by a test may still log while the next one runs, so the
+// logger is set once for the package.
+func init() {
+	logging.Log = zap.NewNop().Sugar()
+}
+
+// newTestManager returns a proxy manager without state store that is shut
+// down when the test ends.
+func newTestManager(t *testing.T) *proxy.Manager {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	mgr := proxy.NewManager(ctx, nil)
+	t.Cleanup(func() {
+		cancel()
+		<-mgr.Done()
+	})
+	return mgr
+}
+
+func TestProxyListHandler(t *testing.T) {
+	err := acl.Init(acl.ACLConfig{
+		Enabled: true,
+		Users: map[string]acl.User{
+			"alice": {Roles: []string{"viewer"}},
+			"bob":   {Roles: []string{"viewer"}},
+			"carol": {Roles: []string{"lister"}},
+			"dave":  {Roles: []string{"status"}},
+		},
+		Roles: map[string]acl.Role{
+			"viewer": {Permissions: []acl.Permission{"proxy_list", "proxy_status"}},
+			"lister": {Permissions: []acl.Permission{"proxy_list"}},
+			"status": {Permissions: []acl.Permission{"proxy_status"}},
+		},
+	}, Permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &configd.Config{
+		Hosts: map[string]configd.Host{
+			"zurich": {Address: "10.0.0.1", Proxies: []string{"open", "secret"}},
+			"berlin": {Address: "10.0.0.2", Proxies: []string{"open"}},
+		},
+		Proxies: configd.ProxiesConfig{Proxies: map[string]configd.Proxy{
+			"open": {Port: 1080, Default: "zurich"},
+			"secret": {Port: 1081, Default: "zurich", ACLs: acl.ACLRuleSet{Rules: []acl.ACLRule{
+				{Subjects: []string{"alice"}},
+			}}},
+		}},
+	}
+	handler := ProxyListHandler(cfg, configd.ControlInstance{}, newTestManager(t))
+
+	tests := []struct {
+		user   string
+		status string
+		want   []string
+	}{
+		{"alice", "ok", []string{"open", "secret"}},
+		{"bob", "ok", []string{"open"}},
+		{"carol", "ok", nil},
+		{"dave", "error", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			resp := handler(&protocol.Request{Type: protocol.CmdProxyList, Auth: &protocol.Auth{User: tt.user}})
+			if resp.Status != tt.status {
+				t.Fatalf("status = %s (%s), want %s", resp.Status, resp.Error, tt.status)
+			}
+			if tt.status != "ok" {
+				return
+			}
+			list, ok := resp.Data.(protocol.ListResponse)
+			if !ok {
+				t.Fatalf("Data is %T", resp.Data)
+			}
+			var names []string
+			for _, e := range list.Proxies {
+				names = append(names, e.Name)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("proxies = %v, want %v", names, tt.want)
+			}
+		})
+	}
+
+	resp := handler(&protocol.Request{Type: protocol.CmdProxyList, Auth: &protocol.Auth{User: "alice"}})
+	open := resp.Data.(protocol.ListResponse).Proxies[0]
+	want := protocol.ProxyListEntry{Name: "open", Port: 1080, State: "stopped", AllowedHosts: []string{"berlin", "zurich"}}
+	if open.Name != want.Name || open.Port != want.Port || open.Running || open.State != want.State ||
+		!slices.Equal(open.AllowedHosts, want.AllowedHosts) {
+		t.Errorf("entry = %+v, want %+v", open, want)
+	}
+}
+
+func TestProxyListConditions(t *testing.T) {
+	err := acl.Init(acl.ACLConfig{
+		Enabled: true,
+		Users:   map[string]acl.User{"alice": {Roles: []string{"viewer"}}},
+		Roles: map[string]acl.Role{
+			"viewer": {Permissions: []acl.Permission{"proxy_list", "proxy_status"}},
+		},
+	}, Permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := acl.ACLRuleSet{Rules: []acl.ACLRule{{
+		Subjects: []string{"alice"},
+		When:     acl.Conditions{Instances: []string{"local"}, Sources: []string{"10.0.0.0/8"}},
+	}}}
+	if err := rules.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &configd.Config{
+		Proxies: configd.ProxiesConfig{Proxies: map[string]configd.Proxy{
+			"pp": {Port: 1080, ACLs: rules},
+		}},
+	}
+	mgr := newTestManager(t)
+
+	tests := []struct {
+		name     string
+		instance string
+		peer     string
+		want     int
+	}{
+		{"matching instance and source", "local", "10.1.2.3:4711", 1},
+		{"unix client never matches a source", "local", "@", 0},
+		{"other instance", "remote", "10.1.2.3:4711", 0},
+		{"other source", "local", "192.0.2.7:4711", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := ProxyListHandler(cfg, configd.ControlInstance{Name: tt.instance}, mgr)
+			resp := handler(&protocol.Request{
+				Type: protocol.CmdProxyList,
+				Auth: &protocol.Auth{User: "alice"},
+				Peer: tt.peer,
+			})
+			if resp.Status != "ok" {
+				t.Fatalf("status = %s (%s)", resp.Status, resp.Error)
+			}
+			if got := len(resp.Data.(protocol.ListResponse).Proxies); got != tt.want {
+				t.Errorf("got %d proxies, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// recordingBackend starts every proxy except on hosts listed in fail and
+// remembers the host each proxy runs on.
+type recordingBackend struct {
+	mu      sync.Mutex
+	fail    map[string]bool
+	running map[string]string // proxy -> host
+}
+
+var recorder = &recordingBackend{fail: map[string]bool{}, running: map[string]string{}}
+
+func init() {
+	interfaces.RegisterBackend("recording", recorder)
+}
+
+func (b *recordingBackend) Configure(name string, cfg map[string]any) error { return nil }
+
+func (b *recordingBackend) Start(name string, p configd.Proxy, cfg *configd.Config) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail[p.Default] {
+		return fmt.Errorf("host '%s' unreachable", p.Default)
+	}
+	b.running[name] = p.Default
+	return nil
+}
+
+func (b *recordingBackend) Stop(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.running, name)
+	return nil
+}
+
+func (b *recordingBackend) Status(name string) (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.running[name]
+	return 0, ok
+}
+
+func (b *recordingBackend) SetExitHandler(cb func(name string)) {}
+
+// host returns the host the proxy runs on, "" if it is stopped.
+func (b *recordingBackend) host(name string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.running[name]
+}
+
+func TestProxySetActiveFailover(t *testing.T) {
+	err := acl.Init(acl.ACLConfig{
+		Enabled: true,
+		Users:   map[string]acl.User{"bob": {Roles: []string{"switcher"}}},
+		Roles: map[string]acl.Role{
+			"switcher": {Permissions: []acl.Permission{"proxy_setactive"}},
+		},
+	}, Permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	denyBob := acl.ACLRuleSet{Rules: []acl.ACLRule{{Subjects: []string{"alice"}}}}
+	cfg := &configd.Config{
+		Hosts: map[string]configd.Host{
+			"zurich": {Address: "10.0.0.1", Backend: "recording", Proxies: []string{"pp"}},
+			"berlin": {Address: "10.0.0.2", Backend: "recording", Proxies: []string{"pp"}, ACLs: denyBob},
+			"paris":  {Address: "10.0.0.3", Backend: "recording", Proxies: []string{"pp"}},
+		},
+		Proxies: configd.ProxiesConfig{Proxies: map[string]configd.Proxy{
+			"pp": {Port: 1080, Default: "paris", Fallback: []string{"zurich", "berlin", "paris"}},
+		}},
+	}
+	recorder.mu.Lock()
+	recorder.fail["zurich"] = true
+	recorder.mu.Unlock()
+	t.Cleanup(func() {
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		recorder.fail = map[string]bool{}
+		recorder.running = map[string]string{}
+	})
+
+	handler := ProxySetActiveHandler(cfg, configd.ControlInstance{}, newTestManager(t))
+	resp := handler(&protocol.Request{
+		Type: protocol.CmdProxySetActive,
+		Auth: &protocol.Auth{User: "bob"},
+		Data: protocol.SetActiveRequest{Name: "pp", Host: "zurich"},
+	})
+	if resp.Status != "ok" {
+		t.Fatalf("response = %+v", resp)
+	}
+	// zurich fails and berlin denies bob, so the proxy must skip to paris
+	if got := recorder.host("pp"); got != "paris" {
+		t.Errorf("pp runs on %q, want paris", got)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package control
+
+import (
+	"net"
+	"os"
+)
+
+// listenUnix creates a unix socket at path. Permissions are left to the
+// platform.
+func listenUnix(path string) (net.Listener, os.FileMode, error) {
+	ln, err := net.Listen("unix", path)
+	return ln, 0, err
+}
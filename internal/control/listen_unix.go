@@ -0,0 +1,20 @@
+//go:build unix
+
+package control
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenUnix creates a unix socket at path that only its owner can access
+// until applySocketPermissions set the final owner and mode. It returns the
+// mode the process umask would have given the socket. The umask is
+// process-wide, so files created concurrently are restricted as well.
+func listenUnix(path string) (net.Listener, os.FileMode, error) {
+	old := syscall.Umask(0o177)
+	ln, err := net.Listen("unix", path)
+	syscall.Umask(old)
+	return ln, os.FileMode(0o777 &^ old), err
+}
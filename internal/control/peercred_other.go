@@ -0,0 +1,13 @@
+//go:build !linux
+
+package control
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCred is only implemented on Linux.
+func peerCred(conn *net.UnixConn) (uint32, uint32, error) {
+	return 0, 0, errors.New("peer credentials are not supported on this platform")
+}
@@ -0,0 +1,53 @@
+package control
+
+import "github.com/mfulz/portgeist/internal/acl"
+
+// Permissions lists every permission the control handlers check. Roles
+// may only grant permissions from this list.
+var Permissions = []acl.Permission{
+	"proxy_start",
+	"proxy_stop",
+	"proxy_status",
+	"proxy_list",
+	"proxy_info",
+	"proxy_setactive",
+	"proxy_resolve",
+	"proxy_reset",
+	"host_view",
+	"host_trust",
+	"system_subscribe",
+	"system_auth_events",
+	"system_audit",
+	"acl_explain",
+	"system_reload",
+	"config_host_view",
+	"config_host_add",
+	"config_host_update",
+	"config_host_remove",
+	"config_host_acl",
+	"config_host_backend",
+	"config_proxy_add",
+	"config_proxy_update",
+	"config_proxy_remove",
+	"config_proxy_acl",
+	"config_login_view",
+	"config_login_add",
+	"config_login_update",
+	"config_login_remove",
+	"config_user_view",
+	"config_user_add",
+	"config_user_update",
+	"config_user_remove",
+	"config_user_rotate",
+	"config_group_add",
+	"config_group_update",
+	"config_group_remove",
+	"config_role_add",
+	"config_role_update",
+	"config_role_remove",
+	"config_control_view",
+	"config_control_add",
+	"config_control_remove",
+	"config_control_enable",
+	"config_control_disable",
+}
@@ -0,0 +1,33 @@
+package control
+
+import (
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// ReloadFunc re-reads the daemon configuration and applies the changes.
+type ReloadFunc func() (*protocol.ReloadResponse, error)
+
+// EditFunc changes the daemon configuration. prepare is called with the
+// running configuration while other changes and reloads are held off and
+// returns the entries to change. The result is validated, written to the
+// config file and applied like a reload; on error nothing is changed.
+type EditFunc func(prepare func(cfg *configd.Config) ([]configd.Change, error)) (*protocol.ReloadResponse, error)
+
+// ReloadHandler reloads the daemon configuration file. If the file fails
+// validation the running configuration is kept and the error is returned.
+func ReloadHandler(instance configd.ControlInstance, reload ReloadFunc) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		actx := aclContext(req, instance)
+		if !acl.Can(actx, "system_reload", acl.ACLRuleSet{}) {
+			return notAllowed()
+		}
+
+		result, err := reload()
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: result}
+	}
+}
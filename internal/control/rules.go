@@ -0,0 +1,37 @@
+package control
+
+import (
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/proxy"
+)
+
+// TargetRules returns the rule sets that apply when the proxy name runs on
+// hostName: those of the proxy, of the host and of the host's backend.
+func TargetRules(cfg *configd.Config, name string, p configd.Proxy, hostName string) []acl.ACLRuleSet {
+	sets := []acl.ACLRuleSet{acl.ProxyRules(name, p.ACLs)}
+
+	host, ok := cfg.Hosts[hostName]
+	if !ok {
+		return sets
+	}
+	backend := proxy.BackendNameOf(host)
+	// backend rules were checked by Validate
+	backendRules, _ := cfg.BackendACLs(backend)
+	return append(sets,
+		acl.HostRules(name, hostName, host.ACLs),
+		acl.BackendRules(name, backend, backendRules),
+	)
+}
+
+// PermittedCandidates returns the candidate hosts of the proxy name whose
+// proxy, host and backend rules all grant perm in ctx, in failover order.
+func PermittedCandidates(ctx acl.Context, perm acl.Permission, cfg *configd.Config, name string, p configd.Proxy) []string {
+	var out []string
+	for _, hostName := range proxy.Candidates(name, p, cfg) {
+		if acl.CanAll(ctx, perm, TargetRules(cfg, name, p, hostName)...) {
+			out = append(out, hostName)
+		}
+	}
+	return out
+}
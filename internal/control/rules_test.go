@@ -0,0 +1,102 @@
+package control
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+)
+
+// targetRulesConfig has proxy pp open to everyone on three hosts: zurich
+// is limited to the oncall group, berlin's backend to alice and bob, and
+// paris denies carol to start proxies.
+func targetRulesConfig() *configd.Config {
+	everyone := acl.ACLRuleSet{Rules: []acl.ACLRule{{Subjects: []string{"*"}}}}
+	return &configd.Config{
+		ACL: acl.ACLConfig{
+			Enabled: true,
+			Users: map[string]acl.User{
+				"alice": {Roles: []string{"operator"}},
+				"bob":   {Roles: []string{"operator"}},
+				"carol": {Roles: []string{"operator"}},
+			},
+			Groups: map[string]acl.Group{"oncall": {Members: []string{"alice"}}},
+			Roles: map[string]acl.Role{
+				"operator": {Permissions: []acl.Permission{"proxy_start", "proxy_setactive"}},
+			},
+		},
+		Hosts: map[string]configd.Host{
+			"zurich": {Address: "10.0.0.1", Proxies: []string{"pp"},
+				ACLs: acl.ACLRuleSet{Rules: []acl.ACLRule{{Subjects: []string{"oncall"}}}}},
+			"berlin": {Address: "10.0.0.2", Backend: "restricted", Proxies: []string{"pp"}},
+			"paris": {Address: "10.0.0.3", Proxies: []string{"pp"},
+				ACLs: acl.ACLRuleSet{Rules: []acl.ACLRule{
+					{Subjects: []string{"*"}},
+					{Subjects: []string{"carol"}, Permissions: []acl.Permission{"proxy_start"}, Proxies: []string{"p*"}, Deny: true},
+				}}},
+		},
+		Backends: map[string]map[string]any{
+			"restricted": {configd.BackendACLKey: map[string]any{
+				"rules": []any{map[string]any{"subjects": []any{"alice", "bob"}}},
+			}},
+		},
+		Proxies: configd.ProxiesConfig{Proxies: map[string]configd.Proxy{
+			"pp": {Port: 1080, Default: "zurich", Fallback: []string{"berlin", "paris"}, ACLs: everyone},
+		}},
+	}
+}
+
+func TestTargetRules(t *testing.T) {
+	cfg := targetRulesConfig()
+	if err := acl.Init(cfg.ACL, Permissions); err != nil {
+		t.Fatal(err)
+	}
+	p := cfg.Proxies.Proxies["pp"]
+
+	tests := []struct {
+		user string
+		host string
+		want bool
+	}{
+		{"alice", "zurich", true},
+		{"bob", "zurich", false},
+		{"alice", "berlin", true},
+		{"bob", "berlin", true},
+		{"carol", "berlin", false},
+		{"bob", "paris", true},
+		{"carol", "paris", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user+"@"+tt.host, func(t *testing.T) {
+			ctx := acl.Context{User: tt.user}
+			sets := TargetRules(cfg, "pp", p, tt.host)
+			if len(sets) != 3 {
+				t.Fatalf("TargetRules() returned %d rule sets, want proxy, host and backend", len(sets))
+			}
+			if got := acl.CanAll(ctx, "proxy_start", sets...); got != tt.want {
+				t.Fatalf("CanAll() = %t, want %t: %s", got, tt.want, acl.Explain(ctx, "proxy_start", sets...).Reason)
+			}
+		})
+	}
+
+	candidates := []struct {
+		user string
+		want []string
+	}{
+		{"alice", []string{"zurich", "berlin", "paris"}},
+		{"bob", []string{"berlin", "paris"}},
+		{"carol", nil},
+	}
+	for _, tt := range candidates {
+		got := PermittedCandidates(acl.Context{User: tt.user}, "proxy_start", cfg, "pp", p)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("PermittedCandidates(%s) = %v, want %v", tt.user, got, tt.want)
+		}
+	}
+
+	// the deny on paris is limited to proxy_start
+	if !acl.CanAll(acl.Context{User: "carol"}, "proxy_setactive", TargetRules(cfg, "pp", p, "paris")...) {
+		t.Error("carol may not switch pp to paris")
+	}
+}
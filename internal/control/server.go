@@ -3,66 +3,227 @@
 package control
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/mfulz/portgeist/dispatch"
 	"github.com/mfulz/portgeist/internal/acl"
 	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/events"
 	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/tlsutil"
 	"github.com/mfulz/portgeist/protocol"
 )
 
-var dispatcher *dispatch.Dispatcher
+// drainTimeout bounds how long Close waits for requests in flight before
+// it closes the remaining connections.
+var drainTimeout = 10 * time.Second
 
-func SetDispatcher(d *dispatch.Dispatcher) {
-	dispatcher = d
+// Server is a running control instance. Its configuration and handlers can
+// be replaced while it runs; requests already being handled finish with the
+// previous ones.
+type Server struct {
+	inst configd.ControlInstance
+	ln   net.Listener
+
+	mu         sync.Mutex
+	cfg        *configd.Config
+	dispatcher *dispatch.Dispatcher
+	conns      map[net.Conn]bool // connection -> busy handling a request
+	closed     bool
+	wg         sync.WaitGroup
 }
 
-// StartServerInstance starts a control listener based on the given configuration.
-// Supports "unix" and "tcp" control modes.
-func StartServerInstance(inst configd.ControlInstance, cfg *configd.Config) error {
+// StartServerInstance starts a control listener based on the given configuration
+// serving requests with d. Supports "unix", "tcp" and "tls" control modes.
+func StartServerInstance(inst configd.ControlInstance, cfg *configd.Config, d *dispatch.Dispatcher) (*Server, error) {
 	var ln net.Listener
 	var err error
+	var socketMode os.FileMode
 
 	switch inst.Mode {
 	case "unix":
 		_ = os.Remove(inst.Listen) // Remove stale socket
-		ln, err = net.Listen("unix", inst.Listen)
+		ln, socketMode, err = listenUnix(inst.Listen)
 	case "tcp":
 		ln, err = net.Listen("tcp", inst.Listen)
+	case "tls":
+		tlsCfg, tlsErr := tlsutil.ServerConfig(inst.TLS.Cert, inst.TLS.Key, inst.TLS.ClientCA, inst.TLS.ClientAuth)
+		if tlsErr != nil {
+			return nil, fmt.Errorf("tls setup: %w", tlsErr)
+		}
+		ln, err = tls.Listen("tcp", inst.Listen, tlsCfg)
 	default:
-		return fmt.Errorf("unsupported control mode: %s", inst.Mode)
+		return nil, fmt.Errorf("unsupported control mode: %s", inst.Mode)
 	}
 
 	if err != nil {
-		return fmt.Errorf("failed to listen: %w", err)
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	if inst.Mode == "unix" {
+		if err := applySocketPermissions(inst.Listen, inst.Socket, socketMode); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+		}
+	}
+	if inst.Mode == "tcp" && !isLoopback(inst.Listen) {
+		logging.Log.Warnf("[control:%s] Listening on %s without TLS, tokens are sent in cleartext", inst.Name, inst.Listen)
+	}
+
+	s := &Server{
+		inst:       inst,
+		ln:         ln,
+		cfg:        cfg,
+		dispatcher: d,
+		conns:      make(map[net.Conn]bool),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Instance returns the configuration the server was started with.
+func (s *Server) Instance() configd.ControlInstance {
+	return s.inst
+}
+
+// Update replaces the configuration and handlers used for new requests.
+func (s *Server) Update(cfg *configd.Config, d *dispatch.Dispatcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	s.dispatcher = d
+}
+
+// current returns the configuration and handlers for the next request.
+func (s *Server) current() (*configd.Config, *dispatch.Dispatcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg, s.dispatcher
+}
+
+// Close stops accepting connections and drains the open ones: idle
+// connections and event streams are closed right away, requests in flight
+// are answered first. Connections still busy after drainTimeout are
+// closed. Close returns once the listener is closed and drains in the
+// background, so it may be called from a handler of the server itself.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	for conn, busy := range s.conns {
+		if !busy {
+			_ = conn.SetReadDeadline(time.Now())
+		}
 	}
+	s.mu.Unlock()
+	_ = s.ln.Close()
 
 	go func() {
-		defer ln.Close()
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				logging.Log.Infof("[control:%s] Accept error: %v", inst.Name, err)
-				continue
+		drained := make(chan struct{})
+		go func() {
+			s.wg.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-time.After(drainTimeout):
+			s.mu.Lock()
+			for conn := range s.conns {
+				_ = conn.Close()
 			}
-			go handleConn(conn, inst, cfg)
+			s.mu.Unlock()
+			<-drained
 		}
+		logging.Log.Infof("[control:%s] Stopped", s.inst.Name)
 	}()
+}
 
-	return nil
+// serve accepts connections until the server is closed.
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return
+			}
+			logging.Log.Infof("[control:%s] Accept error: %v", s.inst.Name, err)
+			continue
+		}
+		if !s.track(conn) {
+			conn.Close()
+			continue
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// isClosed reports whether Close was called.
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// track registers a new connection as idle. It fails once the server is closed.
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = false
+	s.wg.Add(1)
+	return true
+}
+
+// untrack forgets a connection that was closed.
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// setBusy marks a connection as handling a request or as idle. It reports
+// false if the server is closed and the connection should be ended.
+func (s *Server) setBusy(conn net.Conn, busy bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn] = busy
+	return !s.closed
 }
 
 // handleConn handles an individual control connection.
-// It reads a JSON-encoded protocol.Request from the connection,
-// dispatches it via the global dispatcher, and writes the JSON response.
-func handleConn(conn net.Conn, inst configd.ControlInstance, cfg *configd.Config) {
+// It reads JSON-encoded protocol.Requests from the connection,
+// dispatches them via the server's dispatcher, and writes the JSON responses.
+func (s *Server) handleConn(conn net.Conn) {
+	defer s.untrack(conn)
 	defer conn.Close()
+	// A failing handler must only cost this connection, not the daemon.
+	defer func() {
+		if r := recover(); r != nil {
+			logging.Log.Errorf("[control:%s] Panic while serving connection: %v", s.inst.Name, r)
+		}
+	}()
+
+	inst := s.inst
+	peer, err := peerIdentity(conn, inst)
+	if err != nil {
+		logging.Log.Infof("[control:%s] Failed to identify peer: %v", inst.Name, err)
+		return
+	}
+
+	peerAddr := peerAddress(conn)
 
 	decoder := json.NewDecoder(conn)
 	encoder := json.NewEncoder(conn)
@@ -70,33 +231,114 @@ func handleConn(conn net.Conn, inst configd.ControlInstance, cfg *configd.Config
 	for {
 		var req protocol.Request
 		if err := decoder.Decode(&req); err != nil {
-			if errors.Is(err, io.EOF) {
+			switch {
+			case errors.Is(err, io.EOF):
 				logging.Log.Infof("[control:%s] Client closed connection early", inst.Name)
-				return
+			case s.isClosed():
+				logging.Log.Debugf("[control:%s] Closing idle connection of stopped instance", inst.Name)
+			default:
+				logging.Log.Infof("[control:%s] Failed to decode request: %v", inst.Name, err)
 			}
-			logging.Log.Infof("[control:%s] Failed to decode request: %v", inst.Name, err)
 			return
 		}
+		if !s.setBusy(conn, true) {
+			return
+		}
+		_, dispatcher := s.current()
 
-		// user, ok := Authenticate(&req, cfg, inst.Auth.Enabled)
-		if !acl.Authenticate(req.Auth) {
-			// if !ok {
-			logging.Log.Infof("[control:%s] Invalid credentials for user: %s", inst.Name, req.Auth.User)
-			_ = encoder.Encode(&protocol.Response{
-				Status: "error",
-				Error:  "invalid credentials",
-			})
+		req.Peer = peerAddr
+		call := dispatch.Call{Instance: inst.Name, Peer: peerAddr, Started: time.Now()}
+
+		ident, err := acl.Authenticate(req.Auth, peer)
+		if err != nil {
+			logging.Log.Infof("[control:%s] Invalid credentials for user: %s (%v)", inst.Name, ident.User, err)
+			events.Publish(protocol.Event{Type: protocol.EventAuthFailure, User: ident.User, Instance: inst.Name, Message: err.Error()})
+			resp := &protocol.Response{Status: "error", Error: err.Error(), Code: protocol.ErrCodeUnauthenticated}
+			if errors.Is(err, acl.ErrSessionExpired) {
+				resp.Code = protocol.ErrCodeSessionExpired
+			}
+			req.Auth = &protocol.Auth{User: ident.User}
+			dispatcher.Notify(call, &req, resp)
+			_ = encoder.Encode(resp)
+			if !s.setBusy(conn, false) {
+				return
+			}
 			continue
 		}
+		call.AuthSource = ident.Source
+		if ident.Session != nil {
+			call.Session = ident.Session.ID
+		}
 
+		user := ident.User
+		if user == "" {
+			user = "anon"
+		}
 		if req.Auth == nil {
 			req.Auth = &protocol.Auth{}
-			req.Auth.User = "anon"
 		}
-		resp := dispatcher.Dispatch(&req)
+		req.Auth.User = user
+
+		// A session is only as good as the credentials it was issued for,
+		// which may be local to its instance (peer credentials, client
+		// certificates).
+		if ident.Session != nil && ident.Session.Instance != inst.Name {
+			logging.Log.Infof("[control:%s] Session %s of '%s' was issued on '%s'", inst.Name, ident.Session.ID, user, ident.Session.Instance)
+			resp := &protocol.Response{
+				Status: "error",
+				Error:  "session was issued by another control instance",
+				Code:   protocol.ErrCodeUnauthenticated,
+			}
+			dispatcher.Notify(call, &req, resp)
+			_ = encoder.Encode(resp)
+			if !s.setBusy(conn, false) {
+				return
+			}
+			continue
+		}
+
+		if ident.Session != nil && !ident.Session.Allows(req.Type) && req.Type != protocol.CmdLogout {
+			resp := &protocol.Response{
+				Status: "error",
+				Error:  fmt.Sprintf("command %s is outside the session scope", req.Type),
+				Code:   protocol.ErrCodeOutOfScope,
+			}
+			dispatcher.Notify(call, &req, resp)
+			_ = encoder.Encode(resp)
+			if !s.setBusy(conn, false) {
+				return
+			}
+			continue
+		}
+
+		if req.Type == protocol.CmdSubscribe {
+			// Event streams count as idle, Close ends them right away.
+			if !s.setBusy(conn, false) {
+				return
+			}
+			s.serveSubscription(conn, decoder, encoder, &req, ident.Session, dispatcher, call)
+			return
+		}
+		resp := dispatcher.DispatchCall(call, &req)
 		if err := encoder.Encode(resp); err != nil {
 			logging.Log.Infof("[control:%s] Failed to send response: %v", inst.Name, err)
 			return
 		}
+		if !s.setBusy(conn, false) {
+			return
+		}
+	}
+}
+
+// isLoopback reports whether a listen address only accepts local clients.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
 	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
@@ -0,0 +1,120 @@
+package control
+
+import (
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mfulz/portgeist/dispatch"
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+func TestHandlerPanicDropsConnection(t *testing.T) {
+	err := acl.Init(acl.ACLConfig{
+		Enabled: true,
+		Users:   map[string]acl.User{"alice": {Token: "alice-token"}},
+	}, Permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := dispatch.New()
+	d.Register("test.panic", func(req *protocol.Request) *protocol.Response {
+		panic("boom")
+	})
+	d.Register("test.ok", func(req *protocol.Request) *protocol.Response {
+		return &protocol.Response{Status: "ok"}
+	})
+	inst := configd.ControlInstance{Name: "test", Enabled: true, Mode: "unix", Listen: filepath.Join(t.TempDir(), "geistd.sock")}
+	srv, err := StartServerInstance(inst, &configd.Config{}, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	call := func(command string) (*protocol.Response, error) {
+		conn, err := net.Dial("unix", inst.Listen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		req := protocol.Request{Type: command, Auth: &protocol.Auth{User: "alice", Token: "alice-token"}}
+		if err := json.NewEncoder(conn).Encode(&req); err != nil {
+			t.Fatal(err)
+		}
+		var resp protocol.Response
+		if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
+
+	if resp, err := call("test.panic"); err == nil {
+		t.Fatalf("panicking handler answered %+v", resp)
+	}
+	resp, err := call("test.ok")
+	if err != nil || resp.Status != "ok" {
+		t.Fatalf("request after panic = %+v, %v", resp, err)
+	}
+}
+
+func TestSessionBoundToInstance(t *testing.T) {
+	err := acl.Init(acl.ACLConfig{
+		Enabled: true,
+		Users:   map[string]acl.User{"alice": {Token: "alice-token"}},
+	}, Permissions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := dispatch.New()
+	d.Register("test.ok", func(req *protocol.Request) *protocol.Response {
+		return &protocol.Response{Status: "ok"}
+	})
+	dir := t.TempDir()
+	listen := map[string]string{}
+	for _, name := range []string{"local", "other"} {
+		inst := configd.ControlInstance{Name: name, Enabled: true, Mode: "unix", Listen: filepath.Join(dir, name+".sock")}
+		srv, err := StartServerInstance(inst, &configd.Config{}, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(srv.Close)
+		listen[name] = inst.Listen
+	}
+
+	token, _, err := acl.Login("alice", "local", time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		instance string
+		code     string // expected error code, "" for success
+	}{
+		{instance: "local"},
+		{instance: "other", code: protocol.ErrCodeUnauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.instance, func(t *testing.T) {
+			conn, err := net.Dial("unix", listen[tt.instance])
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			req := protocol.Request{Type: "test.ok", Auth: &protocol.Auth{User: "alice", Token: token}}
+			if err := json.NewEncoder(conn).Encode(&req); err != nil {
+				t.Fatal(err)
+			}
+			var resp protocol.Response
+			if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if tt.code == "" && resp.Status != "ok" || tt.code != "" && resp.Code != tt.code {
+				t.Fatalf("response = %+v, want code %q", resp, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package control
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// LoginHandler issues a session token to the authenticated user. Session
+// tokens cannot be used to log in again, so a session cannot outlive the
+// configured maximum lifetime.
+func LoginHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.LoginRequest
+		_ = decodePayload(req.Data, &payload)
+
+		user := extractUser(req)
+		if user == "anon" {
+			return &protocol.Response{Status: "error", Error: "login requires an authenticated user"}
+		}
+		if acl.IsSessionToken(req.Auth.Token) {
+			return &protocol.Response{Status: "error", Error: "cannot log in with a session token"}
+		}
+
+		var ttl time.Duration
+		if payload.TTL != "" {
+			d, err := time.ParseDuration(payload.TTL)
+			if err != nil {
+				return &protocol.Response{Status: "error", Error: fmt.Sprintf("invalid ttl: %v", err)}
+			}
+			ttl = d
+		}
+
+		token, session, err := acl.Login(user, instance.Name, ttl, payload.Scope)
+		if err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok", Data: protocol.LoginResponse{
+			Token:     token,
+			Session:   session.ID,
+			User:      session.User,
+			ExpiresAt: session.ExpiresAt.Format(time.RFC3339),
+			Scope:     session.Scope,
+		}}
+	}
+}
+
+// LogoutHandler revokes a session of the authenticated user.
+func LogoutHandler(cfg *configd.Config, instance configd.ControlInstance) func(req *protocol.Request) *protocol.Response {
+	return func(req *protocol.Request) *protocol.Response {
+		var payload protocol.LogoutRequest
+		_ = decodePayload(req.Data, &payload)
+
+		token := payload.Token
+		if token == "" {
+			token = req.Auth.Token
+		}
+		if !acl.IsSessionToken(token) {
+			return &protocol.Response{Status: "error", Error: "no session token given"}
+		}
+
+		if err := acl.Logout(extractUser(req), token); err != nil {
+			return errorResponse(err)
+		}
+		return &protocol.Response{Status: "ok"}
+	}
+}
@@ -2,12 +2,14 @@
 package controlcli
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
 	"time"
 
 	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/tlsutil"
 	"github.com/mfulz/portgeist/protocol"
 )
 
@@ -23,27 +25,31 @@ func SendCommandWithAuth(cfg *configcli.Config, daemonName, userName, command st
 		return nil, fmt.Errorf("user '%s' not found", userName)
 	}
 
+	if command != protocol.CmdLogin {
+		if token, ok := cachedSession(daemonName, user.Username); ok {
+			resp, err := sendConfigured(daemonName, daemon, &protocol.Auth{User: user.Username, Token: token}, command, data)
+			if err != nil || !sessionRejected(resp) {
+				return resp, err
+			}
+			// expired or revoked, fall back to the long-lived token
+			dropSession(daemonName, user.Username)
+		}
+	}
+
+	return sendConfigured(daemonName, daemon, &protocol.Auth{User: user.Username, Token: user.Token}, command, data)
+}
+
+// sendConfigured sends a single request to a configured daemon.
+func sendConfigured(daemonName string, daemon configcli.DaemonConfig, auth *protocol.Auth, command string, data interface{}) (*protocol.Response, error) {
 	req := protocol.Request{
 		Type: command,
 		Data: data,
-		Auth: &protocol.Auth{
-			User:  user.Username,
-			Token: user.Token,
-		},
+		Auth: auth,
 	}
 
-	var conn net.Conn
-	var err error
-
-	if daemon.Socket != "" {
-		conn, err = net.DialTimeout("unix", daemon.Socket, 2*time.Second)
-	} else if daemon.TCP != "" {
-		conn, err = net.DialTimeout("tcp", daemon.TCP, 2*time.Second)
-	} else {
-		return nil, fmt.Errorf("invalid daemon config: no socket or tcp defined")
-	}
+	conn, err := dialConfigured(daemonName, daemon)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to daemon '%s': %w", daemonName, err)
+		return nil, err
 	}
 
 	// Achtung: Close erst NACH erfolgreichem Connect & Encode setzen
@@ -66,6 +72,31 @@ func SendCommandWithAuth(cfg *configcli.Config, daemonName, userName, command st
 	return &resp, nil
 }
 
+// dialConfigured connects to a daemon from the client configuration.
+func dialConfigured(daemonName string, daemon configcli.DaemonConfig) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+
+	switch {
+	case daemon.Socket != "":
+		conn, err = net.DialTimeout("unix", daemon.Socket, 2*time.Second)
+	case daemon.TCP != "":
+		conn, err = net.DialTimeout("tcp", daemon.TCP, 2*time.Second)
+	case daemon.TLS != "":
+		tlsCfg, tlsErr := tlsutil.ClientConfig(daemon.CA, daemon.Cert, daemon.Key, daemon.ServerName)
+		if tlsErr != nil {
+			return nil, fmt.Errorf("daemon '%s': %w", daemonName, tlsErr)
+		}
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 2 * time.Second}, "tcp", daemon.TLS, tlsCfg)
+	default:
+		return nil, fmt.Errorf("invalid daemon config: no socket, tcp or tls defined")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to daemon '%s': %w", daemonName, err)
+	}
+	return conn, nil
+}
+
 // connectToDaemon establishes a connection to the Portgeist daemon using either
 // a Unix socket or a TCP address, depending on the mode specified.
 // It returns a net.Conn ready for protocol exchange.
@@ -99,6 +130,21 @@ func SendDirectCommand(addr, token, user, command string, payload interface{}) (
 		mode = "tcp"
 	}
 
+	key := "addr:" + addr
+	if token == "" && command != protocol.CmdLogin {
+		if cached, ok := cachedSession(key, user); ok {
+			resp, err := sendDirect(mode, addr, cached, user, command, payload)
+			if err != nil || !sessionRejected(resp) {
+				return resp, err
+			}
+			dropSession(key, user)
+		}
+	}
+	return sendDirect(mode, addr, token, user, command, payload)
+}
+
+// sendDirect sends a single request to addr.
+func sendDirect(mode, addr, token, user, command string, payload interface{}) (*protocol.Response, error) {
 	conn, err := connectToDaemon(mode, addr)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,232 @@
+package controlcli
+
+import (
+	"encoding/json"
+
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// configChange sends a config.* command changing the daemon configuration
+// and returns the changes the daemon applied.
+func configChange(cmd string, payload any, name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user, successMsg string) (*protocol.ReloadResponse, error) {
+	resp, err := execWithAuth(cmd, payload, name, cfg, daemonName, overrideAddr, overrideToken, user, successMsg)
+	if err != nil {
+		return nil, err
+	}
+	var result protocol.ReloadResponse
+	data, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(data, &result); err != nil {
+		logging.Log.Errorf("Failed to parse ReloadResponse: %v", err)
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ConfigHostList sends CmdConfigHostList and returns the configured hosts.
+func ConfigHostList(cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.HostListResponse, error) {
+	resp, err := execWithAuth(protocol.CmdConfigHostList, nil, "", cfg, daemonName, overrideAddr, overrideToken, user, "")
+	if err != nil {
+		return nil, err
+	}
+	var list protocol.HostListResponse
+	data, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(data, &list); err != nil {
+		logging.Log.Errorf("Failed to parse HostListResponse: %v", err)
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ConfigHostGet sends CmdConfigHostGet and returns the definition of a host.
+func ConfigHostGet(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.HostEntry, error) {
+	resp, err := execWithAuth(protocol.CmdConfigHostGet, protocol.HostConfigRequest{Name: name}, name, cfg, daemonName, overrideAddr, overrideToken, user, "")
+	if err != nil {
+		return nil, err
+	}
+	var host protocol.HostEntry
+	data, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(data, &host); err != nil {
+		logging.Log.Errorf("Failed to parse HostEntry: %v", err)
+		return nil, err
+	}
+	return &host, nil
+}
+
+// ConfigHostAdd sends CmdConfigHostAdd for a new host.
+func ConfigHostAdd(req protocol.HostEditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigHostAdd, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Added host: %s\n")
+}
+
+// ConfigHostUpdate sends CmdConfigHostUpdate changing the fields named in req.
+func ConfigHostUpdate(req protocol.HostEditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigHostUpdate, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Updated host: %s\n")
+}
+
+// ConfigHostRemove sends CmdConfigHostRemove for the named host.
+func ConfigHostRemove(name string, force bool, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigHostRemove, protocol.HostConfigRequest{Name: name, Force: force}, name, cfg, daemonName, overrideAddr, overrideToken, user, "Removed host: %s\n")
+}
+
+// ConfigProxyAdd sends CmdConfigProxyAdd for a new proxy.
+func ConfigProxyAdd(req protocol.ProxyEditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigProxyAdd, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Added proxy: %s\n")
+}
+
+// ConfigProxyUpdate sends CmdConfigProxyUpdate changing the fields named in req.
+func ConfigProxyUpdate(req protocol.ProxyEditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigProxyUpdate, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Updated proxy: %s\n")
+}
+
+// ConfigProxyRemove sends CmdConfigProxyRemove for the named proxy.
+func ConfigProxyRemove(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigProxyRemove, protocol.ProxyRemoveRequest{Name: name}, name, cfg, daemonName, overrideAddr, overrideToken, user, "Removed proxy: %s\n")
+}
+
+// ConfigLoginList sends CmdConfigLoginList and returns the configured logins.
+func ConfigLoginList(cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.LoginListResponse, error) {
+	resp, err := execWithAuth(protocol.CmdConfigLoginList, nil, "", cfg, daemonName, overrideAddr, overrideToken, user, "")
+	if err != nil {
+		return nil, err
+	}
+	var list protocol.LoginListResponse
+	data, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(data, &list); err != nil {
+		logging.Log.Errorf("Failed to parse LoginListResponse: %v", err)
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ConfigLoginAdd sends CmdConfigLoginAdd for a new login.
+func ConfigLoginAdd(req protocol.LoginEditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigLoginAdd, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Added login: %s\n")
+}
+
+// ConfigLoginUpdate sends CmdConfigLoginUpdate changing the fields named in req.
+func ConfigLoginUpdate(req protocol.LoginEditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigLoginUpdate, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Updated login: %s\n")
+}
+
+// ConfigLoginRemove sends CmdConfigLoginRemove for the named login.
+func ConfigLoginRemove(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigLoginRemove, protocol.LoginRemoveRequest{Name: name}, name, cfg, daemonName, overrideAddr, overrideToken, user, "Removed login: %s\n")
+}
+
+// ConfigUserList sends CmdConfigUserList and returns the control users,
+// groups and roles.
+func ConfigUserList(cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.UserListResponse, error) {
+	resp, err := execWithAuth(protocol.CmdConfigUserList, nil, "", cfg, daemonName, overrideAddr, overrideToken, user, "")
+	if err != nil {
+		return nil, err
+	}
+	var list protocol.UserListResponse
+	data, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(data, &list); err != nil {
+		logging.Log.Errorf("Failed to parse UserListResponse: %v", err)
+		return nil, err
+	}
+	return &list, nil
+}
+
+// userToken sends a command issuing a new token and returns it.
+func userToken(cmd string, payload any, name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user, successMsg string) (*protocol.UserTokenResponse, error) {
+	resp, err := execWithAuth(cmd, payload, name, cfg, daemonName, overrideAddr, overrideToken, user, successMsg)
+	if err != nil {
+		return nil, err
+	}
+	var result protocol.UserTokenResponse
+	data, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(data, &result); err != nil {
+		logging.Log.Errorf("Failed to parse UserTokenResponse: %v", err)
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ConfigUserAdd sends CmdConfigUserAdd and returns the token of the new user.
+func ConfigUserAdd(req protocol.UserEditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.UserTokenResponse, error) {
+	return userToken(protocol.CmdConfigUserAdd, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Added user: %s\n")
+}
+
+// ConfigUserUpdate sends CmdConfigUserUpdate changing the fields named in req.
+func ConfigUserUpdate(req protocol.UserEditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigUserUpdate, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Updated user: %s\n")
+}
+
+// ConfigUserRotate sends CmdConfigUserRotate and returns the new token.
+func ConfigUserRotate(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.UserTokenResponse, error) {
+	return userToken(protocol.CmdConfigUserRotate, protocol.ACLNameRequest{Name: name}, name, cfg, daemonName, overrideAddr, overrideToken, user, "Rotated token of user: %s\n")
+}
+
+// ConfigUserRemove sends CmdConfigUserRemove for the named user.
+func ConfigUserRemove(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigUserRemove, protocol.ACLNameRequest{Name: name}, name, cfg, daemonName, overrideAddr, overrideToken, user, "Removed user: %s\n")
+}
+
+// ConfigGroupAdd sends CmdConfigGroupAdd for a new group.
+func ConfigGroupAdd(req protocol.GroupEditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigGroupAdd, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Added group: %s\n")
+}
+
+// ConfigGroupUpdate sends CmdConfigGroupUpdate changing the fields named in req.
+func ConfigGroupUpdate(req protocol.GroupEditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigGroupUpdate, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Updated group: %s\n")
+}
+
+// ConfigGroupRemove sends CmdConfigGroupRemove for the named group.
+func ConfigGroupRemove(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigGroupRemove, protocol.ACLNameRequest{Name: name}, name, cfg, daemonName, overrideAddr, overrideToken, user, "Removed group: %s\n")
+}
+
+// ConfigRoleAdd sends CmdConfigRoleAdd for a new role.
+func ConfigRoleAdd(req protocol.RoleEditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigRoleAdd, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Added role: %s\n")
+}
+
+// ConfigRoleUpdate sends CmdConfigRoleUpdate changing the fields named in req.
+func ConfigRoleUpdate(req protocol.RoleEditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigRoleUpdate, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Updated role: %s\n")
+}
+
+// ConfigRoleRemove sends CmdConfigRoleRemove for the named role.
+func ConfigRoleRemove(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigRoleRemove, protocol.ACLNameRequest{Name: name}, name, cfg, daemonName, overrideAddr, overrideToken, user, "Removed role: %s\n")
+}
+
+// ConfigControlList sends CmdConfigControlList and returns the configured
+// control instances.
+func ConfigControlList(cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ControlListResponse, error) {
+	resp, err := execWithAuth(protocol.CmdConfigControlList, nil, "", cfg, daemonName, overrideAddr, overrideToken, user, "")
+	if err != nil {
+		return nil, err
+	}
+	var list protocol.ControlListResponse
+	data, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(data, &list); err != nil {
+		logging.Log.Errorf("Failed to parse ControlListResponse: %v", err)
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ConfigControlAdd sends CmdConfigControlAdd for a new control instance.
+func ConfigControlAdd(req protocol.ControlAddRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigControlAdd, req, req.Name, cfg, daemonName, overrideAddr, overrideToken, user, "Added control instance: %s\n")
+}
+
+// ConfigControlRemove sends CmdConfigControlRemove for the named instance.
+func ConfigControlRemove(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigControlRemove, protocol.ControlNameRequest{Name: name}, name, cfg, daemonName, overrideAddr, overrideToken, user, "Removed control instance: %s\n")
+}
+
+// ConfigControlEnable sends CmdConfigControlEnable for the named instance.
+func ConfigControlEnable(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigControlEnable, protocol.ControlNameRequest{Name: name}, name, cfg, daemonName, overrideAddr, overrideToken, user, "Enabled control instance: %s\n")
+}
+
+// ConfigControlDisable sends CmdConfigControlDisable for the named instance.
+func ConfigControlDisable(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	return configChange(protocol.CmdConfigControlDisable, protocol.ControlNameRequest{Name: name}, name, cfg, daemonName, overrideAddr, overrideToken, user, "Disabled control instance: %s\n")
+}
@@ -0,0 +1,85 @@
+package controlcli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// Subscribe opens a system.subscribe stream and calls handle for every
+// received event. It blocks until the daemon closes the connection.
+func Subscribe(filter protocol.SubscribeRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, userName string, handle func(protocol.Event)) error {
+	conn, auth, err := dialDaemon(cfg, daemonName, overrideAddr, overrideToken, userName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := protocol.Request{Type: protocol.CmdSubscribe, Data: filter, Auth: auth}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	dec := json.NewDecoder(conn)
+	var resp protocol.Response
+	if err := dec.Decode(&resp); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if resp.Status != "ok" {
+		return fmt.Errorf("%s", resp.Error)
+	}
+
+	for {
+		var ev protocol.Event
+		if err := dec.Decode(&ev); err != nil {
+			return fmt.Errorf("event stream closed: %w", err)
+		}
+		handle(ev)
+	}
+}
+
+// dialDaemon connects to overrideAddr if set and to the configured daemon
+// otherwise, returning the credentials to send along. A cached session
+// token is preferred over the long-lived one.
+func dialDaemon(cfg *configcli.Config, daemonName, overrideAddr, overrideToken, userName string) (net.Conn, *protocol.Auth, error) {
+	if overrideAddr != "" {
+		mode := "tcp"
+		if overrideAddr[0] == '/' {
+			mode = "unix"
+		}
+		conn, err := connectToDaemon(mode, overrideAddr)
+		if err != nil {
+			return nil, nil, err
+		}
+		token := overrideToken
+		if cached, ok := cachedSession("addr:"+overrideAddr, userName); ok && token == "" {
+			token = cached
+		}
+		return conn, &protocol.Auth{User: userName, Token: token}, nil
+	}
+
+	if daemonName == "" {
+		daemonName = GuessDefaultDaemon(cfg)
+	}
+	daemon, ok := cfg.Daemons[daemonName]
+	if !ok {
+		return nil, nil, fmt.Errorf("daemon '%s' not found", daemonName)
+	}
+	user, ok := cfg.Users[userName]
+	if !ok {
+		return nil, nil, fmt.Errorf("user '%s' not found", userName)
+	}
+
+	conn, err := dialConfigured(daemonName, daemon)
+	if err != nil {
+		return nil, nil, err
+	}
+	token := user.Token
+	if cached, ok := cachedSession(daemonName, user.Username); ok {
+		token = cached
+	}
+	return conn, &protocol.Auth{User: user.Username, Token: token}, nil
+}
@@ -44,6 +44,9 @@ func execWithAuth(
 	}
 	if resp.Status != "ok" {
 		logging.Log.Errorf("Error: %s\n", resp.Error)
+		if resp.Code != "" {
+			return resp, fmt.Errorf("%s [%s]", resp.Error, resp.Code)
+		}
 		return resp, fmt.Errorf("%s", resp.Error)
 	}
 	if successMsg != "" {
@@ -64,6 +67,12 @@ func StopProxy(name string, cfg *configcli.Config, daemonName, overrideAddr, ove
 	return err
 }
 
+// ResetProxy sends CmdProxyReset for the given proxy name.
+func ResetProxy(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) error {
+	_, err := execWithAuth(protocol.CmdProxyReset, protocol.ResetRequest{Name: name}, name, cfg, daemonName, overrideAddr, overrideToken, user, "Requested reset of proxy: %s\n")
+	return err
+}
+
 // ProxyStatus sends CmdProxyStatus for the given proxy name.
 func ProxyStatus(name string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.StatusResponse, error) {
 	resp, err := execWithAuth(protocol.CmdProxyStatus, protocol.StatusRequest{Name: name}, name, cfg, daemonName, overrideAddr, overrideToken, user, "")
@@ -100,7 +109,7 @@ func SetActiveProxy(name string, cfg *configcli.Config, daemonName, overrideAddr
 	return err
 }
 
-// ProxyList sends CmdProxyList and returns a slice of proxy names.
+// ProxyList sends CmdProxyList and returns the proxies visible to the user.
 func ProxyList(cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ListResponse, error) {
 	resp, err := execWithAuth(protocol.CmdProxyList, nil, "list", cfg, daemonName, overrideAddr, overrideToken, user, "")
 	if err != nil {
@@ -129,3 +138,71 @@ func ResolveProxy(alias string, cfg *configcli.Config, daemonName, overrideAddr,
 	}
 	return &resolve, nil
 }
+
+// HostKeys sends CmdHostKeys and returns known and pending host keys.
+// If scan is set the daemon connects to the host and records its current key.
+func HostKeys(host string, scan bool, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.HostKeysResponse, error) {
+	resp, err := execWithAuth(protocol.CmdHostKeys, protocol.HostKeysRequest{Host: host, Scan: scan}, host, cfg, daemonName, overrideAddr, overrideToken, user, "")
+	if err != nil {
+		return nil, err
+	}
+	var keys protocol.HostKeysResponse
+	data, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		logging.Log.Errorf("Failed to parse HostKeysResponse: %v", err)
+		return nil, err
+	}
+	return &keys, nil
+}
+
+// TrustHostKey sends CmdHostTrust to approve a pending host key.
+func TrustHostKey(host, fingerprint string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) error {
+	_, err := execWithAuth(protocol.CmdHostTrust, protocol.HostTrustRequest{Host: host, Fingerprint: fingerprint}, host, cfg, daemonName, overrideAddr, overrideToken, user, "Trusted new host key for: %s\n")
+	return err
+}
+
+// Audit sends CmdAudit and returns the matching audit log entries.
+func Audit(query protocol.AuditRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.AuditResponse, error) {
+	resp, err := execWithAuth(protocol.CmdAudit, query, "", cfg, daemonName, overrideAddr, overrideToken, user, "")
+	if err != nil {
+		return nil, err
+	}
+	var entries protocol.AuditResponse
+	data, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		logging.Log.Errorf("Failed to parse AuditResponse: %v", err)
+		return nil, err
+	}
+	return &entries, nil
+}
+
+// ACLExplain asks the daemon for the evaluation trace of an ACL decision.
+func ACLExplain(query protocol.ACLExplainRequest, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ACLExplanation, error) {
+	resp, err := execWithAuth(protocol.CmdACLExplain, query, "", cfg, daemonName, overrideAddr, overrideToken, user, "")
+	if err != nil {
+		return nil, err
+	}
+	var ex protocol.ACLExplanation
+	data, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(data, &ex); err != nil {
+		logging.Log.Errorf("Failed to parse ACLExplanation: %v", err)
+		return nil, err
+	}
+	return &ex, nil
+}
+
+// Reload asks the daemon to re-read its configuration file and returns
+// the applied changes.
+func Reload(cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.ReloadResponse, error) {
+	resp, err := execWithAuth(protocol.CmdReload, nil, "", cfg, daemonName, overrideAddr, overrideToken, user, "")
+	if err != nil {
+		return nil, err
+	}
+	var result protocol.ReloadResponse
+	data, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(data, &result); err != nil {
+		logging.Log.Errorf("Failed to parse ReloadResponse: %v", err)
+		return nil, err
+	}
+	return &result, nil
+}
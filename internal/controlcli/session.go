@@ -0,0 +1,173 @@
+package controlcli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/configcli"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// sessionFile is the name of the session cache in the geistctl config dir.
+const sessionFile = "sessions.json"
+
+// cachedToken is a session token stored by Login.
+type cachedToken struct {
+	Token     string    `json:"token"`
+	Session   string    `json:"session"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Login exchanges the user's long-lived token for a session token and
+// caches it, so that further commands against the daemon use the session.
+func Login(ttl string, scope []string, cfg *configcli.Config, daemonName, overrideAddr, overrideToken, user string) (*protocol.LoginResponse, error) {
+	if daemonName == "" {
+		daemonName = GuessDefaultDaemon(cfg)
+	}
+	resp, err := execWithAuth(protocol.CmdLogin, protocol.LoginRequest{TTL: ttl, Scope: scope}, "", cfg, daemonName, overrideAddr, overrideToken, user, "")
+	if err != nil {
+		return nil, err
+	}
+	var login protocol.LoginResponse
+	data, _ := json.Marshal(resp.Data)
+	if err := json.Unmarshal(data, &login); err != nil {
+		logging.Log.Errorf("Failed to parse LoginResponse: %v", err)
+		return nil, err
+	}
+
+	expires, err := time.Parse(time.RFC3339, login.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session expiry: %w", err)
+	}
+	target, name := sessionKey(cfg, daemonName, overrideAddr, user)
+	if err := storeSession(target, name, cachedToken{Token: login.Token, Session: login.Session, ExpiresAt: expires}); err != nil {
+		return nil, fmt.Errorf("failed to cache session: %w", err)
+	}
+	return &login, nil
+}
+
+// Logout revokes the cached session of the user and removes it from the cache.
+func Logout(cfg *configcli.Config, daemonName, overrideAddr, user string) error {
+	if daemonName == "" {
+		daemonName = GuessDefaultDaemon(cfg)
+	}
+	target, name := sessionKey(cfg, daemonName, overrideAddr, user)
+	if _, ok := cachedSession(target, name); !ok {
+		dropSession(target, name)
+		return errors.New("not logged in")
+	}
+
+	// no explicit token, so the cached session authenticates and is revoked
+	_, err := execWithAuth(protocol.CmdLogout, protocol.LogoutRequest{}, "", cfg, daemonName, overrideAddr, "", user, "")
+	dropSession(target, name)
+	return err
+}
+
+// sessionKey returns the cache target and user name a command for the
+// given daemon selection is sent with.
+func sessionKey(cfg *configcli.Config, daemonName, overrideAddr, user string) (string, string) {
+	if overrideAddr != "" {
+		return "addr:" + overrideAddr, user
+	}
+	if u, ok := cfg.Users[user]; ok {
+		return daemonName, u.Username
+	}
+	return daemonName, user
+}
+
+// sessionRejected reports whether the daemon refused a session token.
+func sessionRejected(resp *protocol.Response) bool {
+	return resp.Status != "ok" && (resp.Code == protocol.ErrCodeSessionExpired || resp.Code == protocol.ErrCodeUnauthenticated)
+}
+
+// cachedSession returns the unexpired session token for user on target.
+func cachedSession(target, user string) (string, bool) {
+	cache, _ := loadSessions()
+	t, ok := cache[user+"@"+target]
+	if !ok || time.Now().After(t.ExpiresAt) {
+		return "", false
+	}
+	return t.Token, true
+}
+
+// storeSession caches t for user on target.
+func storeSession(target, user string, t cachedToken) error {
+	cache, err := loadSessions()
+	if err != nil {
+		return err
+	}
+	cache[user+"@"+target] = t
+	return saveSessions(cache)
+}
+
+// dropSession removes the cached session of user on target and all
+// expired entries.
+func dropSession(target, user string) {
+	cache, err := loadSessions()
+	if err != nil {
+		return
+	}
+	delete(cache, user+"@"+target)
+	for key, t := range cache {
+		if time.Now().After(t.ExpiresAt) {
+			delete(cache, key)
+		}
+	}
+	if err := saveSessions(cache); err != nil {
+		logging.Log.Warnf("[geistctl] Failed to update session cache: %v", err)
+	}
+}
+
+// sessionCachePath returns the location of the session cache.
+func sessionCachePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".portgeist", "geistctl", sessionFile), nil
+}
+
+// loadSessions reads the session cache, returning an empty cache if none exists.
+func loadSessions() (map[string]cachedToken, error) {
+	cache := make(map[string]cachedToken)
+	path, err := sessionCachePath()
+	if err != nil {
+		return cache, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return cache, err
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return make(map[string]cachedToken), fmt.Errorf("corrupt session cache %s: %w", path, err)
+	}
+	return cache, nil
+}
+
+// saveSessions writes the session cache readable by the owner only.
+func saveSessions(cache map[string]cachedToken) error {
+	path, err := sessionCachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
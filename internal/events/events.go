@@ -0,0 +1,100 @@
+// Package events distributes daemon events such as proxy state changes to
+// subscribers of the control protocol's system.subscribe stream.
+//
+// Example usage:
+//
+//	sub := events.Subscribe()
+//	defer sub.Close()
+//	for ev := range sub.C {
+//		fmt.Println(ev.Type, ev.Proxy)
+//	}
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// subscriptionBuffer is the number of events buffered per subscriber.
+// Events are dropped for subscribers that fall further behind.
+const subscriptionBuffer = 128
+
+// Bus fans out published events to all current subscriptions.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription receives events published after it was created.
+// C is closed by Close.
+type Subscription struct {
+	C <-chan protocol.Event
+
+	bus     *Bus
+	ch      chan protocol.Event
+	dropped int
+}
+
+// bus is the globally accessible instance used by the daemon.
+var bus = NewBus()
+
+// NewBus returns an empty bus.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a subscription on the global bus.
+func Subscribe() *Subscription {
+	return bus.Subscribe()
+}
+
+// Publish sends ev to all subscribers of the global bus.
+func Publish(ev protocol.Event) {
+	bus.Publish(ev)
+}
+
+// Subscribe registers a new subscription.
+func (b *Bus) Subscribe() *Subscription {
+	ch := make(chan protocol.Event, subscriptionBuffer)
+	s := &Subscription{C: ch, bus: b, ch: ch}
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Publish stamps ev with the current time if unset and delivers it to every
+// subscription without blocking.
+func (b *Bus) Publish(ev protocol.Event) {
+	if ev.Time == "" {
+		ev.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		select {
+		case s.ch <- ev:
+		default:
+			s.dropped++
+			if s.dropped == 1 || s.dropped%100 == 0 {
+				logging.Log.Warnf("[events] Subscriber too slow, %d events dropped", s.dropped)
+			}
+		}
+	}
+}
+
+// Close removes the subscription and closes C.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; !ok {
+		return
+	}
+	delete(s.bus.subs, s)
+	close(s.ch)
+}
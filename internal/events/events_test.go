@@ -0,0 +1,97 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+	"go.uber.org/zap"
+)
+
+// drain returns the events buffered for s without blocking.
+func drain(s *Subscription) []protocol.Event {
+	var out []protocol.Event
+	for {
+		select {
+		case ev, ok := <-s.C:
+			if !ok {
+				return out
+			}
+			out = append(out, ev)
+		default:
+			return out
+		}
+	}
+}
+
+func TestBus(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+
+	tests := []struct {
+		name      string
+		publish   int
+		read      bool // the fast subscriber reads after every event
+		closed    bool // the second subscriber closed before publishing
+		wantFast  int
+		wantSlow  int
+		wantDrops int
+	}{
+		{name: "fan-out", publish: 3, wantFast: 3, wantSlow: 3},
+		{name: "closed subscriber", publish: 3, closed: true, wantFast: 3},
+		{
+			name:      "slow subscriber drops",
+			publish:   subscriptionBuffer + 10,
+			read:      true,
+			wantFast:  subscriptionBuffer + 10,
+			wantSlow:  subscriptionBuffer,
+			wantDrops: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBus()
+			fast := b.Subscribe()
+			defer fast.Close()
+			slow := b.Subscribe()
+			defer slow.Close()
+			if tt.closed {
+				slow.Close()
+			}
+
+			var got []protocol.Event
+			for i := range tt.publish {
+				b.Publish(protocol.Event{Type: protocol.EventProxyStarted, Proxy: "pp", Message: string(rune('a' + i%26))})
+				if tt.read {
+					got = append(got, drain(fast)...)
+				}
+			}
+			got = append(got, drain(fast)...)
+
+			if len(got) != tt.wantFast {
+				t.Fatalf("fast subscriber got %d events, want %d", len(got), tt.wantFast)
+			}
+			for i, ev := range got {
+				if ev.Time == "" || ev.Proxy != "pp" || ev.Message != string(rune('a'+i%26)) {
+					t.Fatalf("event %d = %+v", i, ev)
+				}
+			}
+			if n := len(drain(slow)); n != tt.wantSlow {
+				t.Errorf("slow subscriber got %d events, want %d", n, tt.wantSlow)
+			}
+			if slow.dropped != tt.wantDrops {
+				t.Errorf("dropped = %d, want %d", slow.dropped, tt.wantDrops)
+			}
+		})
+	}
+}
+
+func TestPublishKeepsTime(t *testing.T) {
+	b := NewBus()
+	s := b.Subscribe()
+	defer s.Close()
+
+	b.Publish(protocol.Event{Type: protocol.EventConfigReloaded, Time: "2025-01-01T00:00:00Z"})
+	if ev := <-s.C; ev.Time != "2025-01-01T00:00:00Z" {
+		t.Errorf("time = %q, want the publisher's", ev.Time)
+	}
+}
@@ -0,0 +1,430 @@
+// Package hostkeys verifies SSH host keys for proxy tunnels and maintains
+// the daemon-managed known_hosts store used for trust-on-first-use.
+//
+// Example usage:
+//
+//	hostkeys.Init("/etc/portgeist/known_hosts")
+//	cb := hostkeys.Callback(hostkeys.Spec{Name: "zurich", Policy: hostkeys.PolicyTOFU})
+package hostkeys
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/logging"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Policy selects how unknown host keys are treated.
+type Policy string
+
+const (
+	// PolicyTOFU records the first key seen for a host and enforces it afterwards.
+	PolicyTOFU Policy = "tofu"
+	// PolicyStrict refuses unknown keys until they are approved via host trust.
+	PolicyStrict Policy = "strict"
+	// PolicyInsecure disables host key verification entirely.
+	PolicyInsecure Policy = "insecure"
+)
+
+var (
+	// ErrMismatch is returned when a host presents a key that differs from the trusted one.
+	ErrMismatch = errors.New("host key mismatch")
+	// ErrUnknown is returned when a host presents a key that has not been trusted yet.
+	ErrUnknown = errors.New("host key not trusted")
+)
+
+// KeyError describes a rejected host key.
+type KeyError struct {
+	Host        string
+	Fingerprint string
+	Err         error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%v for host '%s' (%s)", e.Err, e.Host, e.Fingerprint)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// Spec describes how the key of a single configured host is verified.
+type Spec struct {
+	Name       string // config name of the host
+	Policy     Policy // TOFU, strict or insecure
+	HostKey    string // optional pinned key (authorized_keys format) or SHA256 fingerprint
+	KnownHosts string // optional known_hosts file that is authoritative for this host
+}
+
+// PendingKey is a key that was presented by a host but not accepted.
+type PendingKey struct {
+	Host        string    `json:"host"`
+	Address     string    `json:"address"`
+	Type        string    `json:"type"`
+	Fingerprint string    `json:"fingerprint"`
+	Reason      string    `json:"reason"`
+	SeenAt      time.Time `json:"seen_at"`
+	key         ssh.PublicKey
+}
+
+// Store is a known_hosts file managed by the daemon plus the set of
+// pending keys awaiting approval.
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	pending map[string]PendingKey
+}
+
+// store is the globally accessible instance used by backends.
+var store *Store
+
+// Init opens the daemon-managed known_hosts file at path, creating it if needed.
+func Init(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create known_hosts dir: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open known_hosts: %w", err)
+	}
+	_ = f.Close()
+
+	store = &Store{
+		path:    path,
+		pending: make(map[string]PendingKey),
+	}
+	return nil
+}
+
+// Default returns the global store or nil if Init has not been called.
+func Default() *Store {
+	return store
+}
+
+// Callback returns a host key callback enforcing spec against the global store.
+func Callback(spec Spec) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return Verify(spec, hostname, remote, key)
+	}
+}
+
+// Verify checks key as presented by address against spec. remote is the
+// address actually connected to, as handed to an ssh.HostKeyCallback.
+func Verify(spec Spec, address string, remote net.Addr, key ssh.PublicKey) error {
+	if spec.Policy == PolicyInsecure {
+		return nil
+	}
+
+	fp := ssh.FingerprintSHA256(key)
+
+	if spec.HostKey != "" {
+		ok, err := matchesPinned(spec.HostKey, key)
+		if err != nil {
+			return fmt.Errorf("host '%s': %w", spec.Name, err)
+		}
+		if !ok {
+			store.addPending(spec.Name, address, key, "mismatch")
+			return &KeyError{Host: spec.Name, Fingerprint: fp, Err: ErrMismatch}
+		}
+		return nil
+	}
+
+	if spec.KnownHosts != "" {
+		cb, err := knownhosts.New(spec.KnownHosts)
+		if err != nil {
+			return fmt.Errorf("host '%s': load known_hosts: %w", spec.Name, err)
+		}
+		return classify(spec.Name, fp, cb(address, remote, key))
+	}
+
+	if store == nil {
+		return errors.New("host key store not initialized")
+	}
+
+	known, added, err := store.trustFirst(address, key, spec.Policy != PolicyStrict)
+	if err != nil {
+		return err
+	}
+	if added {
+		logging.Log.Infof("[hostkeys] Trusting first key %s for host '%s' (%s)", fp, spec.Name, address)
+		return nil
+	}
+
+	if len(known) == 0 {
+		store.addPending(spec.Name, address, key, "unknown")
+		return &KeyError{Host: spec.Name, Fingerprint: fp, Err: ErrUnknown}
+	}
+
+	for _, k := range known {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return nil
+		}
+	}
+
+	store.addPending(spec.Name, address, key, "mismatch")
+	return &KeyError{Host: spec.Name, Fingerprint: fp, Err: ErrMismatch}
+}
+
+// classify maps knownhosts callback errors to ErrMismatch / ErrUnknown.
+func classify(name, fp string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if errors.As(err, &keyErr) {
+		if len(keyErr.Want) > 0 {
+			return &KeyError{Host: name, Fingerprint: fp, Err: ErrMismatch}
+		}
+		return &KeyError{Host: name, Fingerprint: fp, Err: ErrUnknown}
+	}
+	return err
+}
+
+// matchesPinned compares key against a pinned authorized_keys line or SHA256 fingerprint.
+func matchesPinned(pinned string, key ssh.PublicKey) (bool, error) {
+	if strings.HasPrefix(pinned, "SHA256:") {
+		return pinned == ssh.FingerprintSHA256(key), nil
+	}
+	want, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pinned))
+	if err != nil {
+		return false, fmt.Errorf("invalid host_key: %w", err)
+	}
+	return bytes.Equal(want.Marshal(), key.Marshal()), nil
+}
+
+// ValidatePinned checks that a host_key setting is a fingerprint or parseable key.
+func ValidatePinned(pinned string) error {
+	if strings.HasPrefix(pinned, "SHA256:") {
+		return nil
+	}
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pinned)); err != nil {
+		return fmt.Errorf("invalid host_key: %w", err)
+	}
+	return nil
+}
+
+// ValidatePolicy checks that p is a known policy. An empty policy is valid.
+func ValidatePolicy(p string) error {
+	switch Policy(p) {
+	case "", PolicyTOFU, PolicyStrict, PolicyInsecure:
+		return nil
+	}
+	return fmt.Errorf("unknown host key policy '%s'", p)
+}
+
+// Lookup returns all trusted keys recorded for address.
+func (s *Store) Lookup(address string) ([]ssh.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookup(knownhosts.Normalize(address))
+}
+
+// lookup scans the known_hosts file for the normalized address.
+func (s *Store) lookup(normalized string) ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("read known_hosts: %w", err)
+	}
+
+	var keys []ssh.PublicKey
+	for len(data) > 0 {
+		_, hosts, key, _, rest, err := ssh.ParseKnownHosts(data)
+		if err != nil {
+			break
+		}
+		data = rest
+		for _, h := range hosts {
+			if h == normalized {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	return keys, nil
+}
+
+// trustFirst returns the trusted keys recorded for address. If there are
+// none and add is set, key is recorded instead and reported as added. The
+// file is checked and written under one lock, so concurrent first
+// connections cannot each trust a different key.
+func (s *Store) trustFirst(address string, key ssh.PublicKey, add bool) ([]ssh.PublicKey, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	known, err := s.lookup(knownhosts.Normalize(address))
+	if err != nil || len(known) > 0 || !add {
+		return known, false, err
+	}
+	if err := s.add(address, key); err != nil {
+		return nil, false, err
+	}
+	return nil, true, nil
+}
+
+// Add appends key as trusted for address.
+func (s *Store) Add(address string, key ssh.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(address, key)
+}
+
+// add implements Add. The caller must hold s.mu.
+func (s *Store) add(address string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("open known_hosts: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(address)}, key)); err != nil {
+		return fmt.Errorf("write known_hosts: %w", err)
+	}
+	return nil
+}
+
+// Replace drops all keys recorded for address and trusts key instead.
+func (s *Store) Replace(address string, key ssh.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	normalized := knownhosts.Normalize(address)
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("read known_hosts: %w", err)
+	}
+
+	var out []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if _, hosts, _, _, _, err := ssh.ParseKnownHosts([]byte(line)); err == nil && slices.Contains(hosts, normalized) {
+			continue
+		}
+		out = append(out, line)
+	}
+	out = append(out, knownhosts.Line([]string{normalized}, key))
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(out, "\n")+"\n"), 0o600); err != nil {
+		return fmt.Errorf("write known_hosts: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Pending returns all pending keys of the given host, or of all hosts if name is empty.
+func (s *Store) Pending(name string) []PendingKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []PendingKey
+	for _, p := range s.pending {
+		if name == "" || p.Host == name {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Trust approves the pending key with the given fingerprint for host name.
+// Any previously trusted keys for the host's address are replaced.
+func (s *Store) Trust(name, fingerprint string) (*PendingKey, error) {
+	s.mu.Lock()
+	var match *PendingKey
+	for id, p := range s.pending {
+		if p.Host == name && p.Fingerprint == fingerprint {
+			match = &p
+			delete(s.pending, id)
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if match == nil {
+		return nil, fmt.Errorf("no pending key %s for host '%s'", fingerprint, name)
+	}
+	if err := s.Replace(match.Address, match.key); err != nil {
+		return nil, err
+	}
+	logging.Log.Infof("[hostkeys] Trusted key %s for host '%s' (%s)", fingerprint, name, match.Address)
+	return match, nil
+}
+
+// addPending records a rejected or scanned key for later approval.
+func (s *Store) addPending(name, address string, key ssh.PublicKey, reason string) {
+	if s == nil {
+		return
+	}
+	fp := ssh.FingerprintSHA256(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[name+" "+fp] = PendingKey{
+		Host:        name,
+		Address:     knownhosts.Normalize(address),
+		Type:        key.Type(),
+		Fingerprint: fp,
+		Reason:      reason,
+		SeenAt:      time.Now(),
+		key:         key,
+	}
+}
+
+// Scan connects to address, completes the key exchange and returns the
+// presented host key without authenticating.
+func Scan(address string, timeout time.Duration) (ssh.PublicKey, error) {
+	key, _, err := scan(address, timeout)
+	return key, err
+}
+
+// scan returns the host key presented by address and the remote address
+// it was presented from.
+func scan(address string, timeout time.Duration) (ssh.PublicKey, net.Addr, error) {
+	var presented ssh.PublicKey
+	var from net.Addr
+	cfg := &ssh.ClientConfig{
+		User: "portgeist-scan",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			presented, from = key, remote
+			return nil
+		},
+		Timeout: timeout,
+	}
+
+	client, err := ssh.Dial("tcp", address, cfg)
+	if client != nil {
+		_ = client.Close()
+	}
+	if presented != nil {
+		return presented, from, nil
+	}
+	return nil, nil, fmt.Errorf("scan %s: %w", address, err)
+}
+
+// Probe scans address and verifies the presented key against spec.
+// Keys that are not accepted are recorded as pending.
+func Probe(spec Spec, address string, timeout time.Duration) (ssh.PublicKey, error) {
+	key, remote, err := scan(address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := Verify(spec, address, remote, key); err != nil {
+		return key, err
+	}
+	return key, nil
+}
+
+// Record stores key as pending for host name regardless of trust state.
+func (s *Store) Record(name, address string, key ssh.PublicKey) {
+	s.addPending(name, address, key, "scanned")
+}
@@ -0,0 +1,235 @@
+package hostkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/logging"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// initStore points the global store at an empty known_hosts file.
+func initStore(t *testing.T) *Store {
+	t.Helper()
+	logging.Log = zap.NewNop().Sugar()
+	if err := Init(filepath.Join(t.TempDir(), "known_hosts")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store = nil })
+	return Default()
+}
+
+// tcpAddr returns the remote address of a connection to address.
+func tcpAddr(t *testing.T, address string) net.Addr {
+	t.Helper()
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+// checkErr fails the test unless err matches want, which may be nil.
+func checkErr(t *testing.T, err, want error) {
+	t.Helper()
+	if want == nil {
+		if err != nil {
+			t.Fatalf("Verify() = %v, want nil", err)
+		}
+		return
+	}
+	if !errors.Is(err, want) {
+		t.Fatalf("Verify() = %v, want %v", err, want)
+	}
+}
+
+func TestVerifyPinned(t *testing.T) {
+	s := initStore(t)
+	trusted := newKey(t)
+	other := newKey(t)
+	remote := tcpAddr(t, "127.0.0.1:22")
+
+	tests := []struct {
+		name   string
+		pinned string
+		key    ssh.PublicKey
+		want   error
+	}{
+		{"authorized key", string(ssh.MarshalAuthorizedKey(trusted)), trusted, nil},
+		{"fingerprint", ssh.FingerprintSHA256(trusted), trusted, nil},
+		{"authorized key mismatch", string(ssh.MarshalAuthorizedKey(trusted)), other, ErrMismatch},
+		{"fingerprint mismatch", ssh.FingerprintSHA256(trusted), other, ErrMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := Spec{Name: "zurich", Policy: PolicyStrict, HostKey: tt.pinned}
+			checkErr(t, Verify(spec, "127.0.0.1:22", remote, tt.key), tt.want)
+		})
+	}
+
+	if err := Verify(Spec{Name: "zurich", HostKey: "garbage"}, "127.0.0.1:22", remote, trusted); err == nil {
+		t.Fatal("Verify() accepted an invalid pinned key")
+	}
+	if pending := s.Pending("zurich"); len(pending) != 1 || pending[0].Fingerprint != ssh.FingerprintSHA256(other) {
+		t.Fatalf("Pending() = %+v, want the mismatching key", pending)
+	}
+	if keys, _ := s.Lookup("127.0.0.1:22"); len(keys) != 0 {
+		t.Fatalf("pinned keys were added to the store: %v", keys)
+	}
+}
+
+func TestVerifyTOFU(t *testing.T) {
+	s := initStore(t)
+	first := newKey(t)
+	other := newKey(t)
+	spec := Spec{Name: "zurich", Policy: PolicyTOFU}
+	remote := tcpAddr(t, "127.0.0.1:2222")
+
+	checkErr(t, Verify(spec, "127.0.0.1:2222", remote, first), nil)
+	if keys, _ := s.Lookup("127.0.0.1:2222"); len(keys) != 1 || ssh.FingerprintSHA256(keys[0]) != ssh.FingerprintSHA256(first) {
+		t.Fatalf("Lookup() = %v, want the first key", keys)
+	}
+
+	checkErr(t, Verify(spec, "127.0.0.1:2222", remote, first), nil)
+	checkErr(t, Verify(spec, "127.0.0.1:2222", remote, other), ErrMismatch)
+	if pending := s.Pending("zurich"); len(pending) != 1 || pending[0].Reason != "mismatch" {
+		t.Fatalf("Pending() = %+v, want the mismatching key", pending)
+	}
+
+	// an approved replacement is trusted from then on
+	if _, err := s.Trust("zurich", ssh.FingerprintSHA256(other)); err != nil {
+		t.Fatalf("Trust() = %v", err)
+	}
+	checkErr(t, Verify(spec, "127.0.0.1:2222", remote, other), nil)
+	checkErr(t, Verify(spec, "127.0.0.1:2222", remote, first), ErrMismatch)
+}
+
+func TestVerifyTOFUConcurrent(t *testing.T) {
+	s := initStore(t)
+	spec := Spec{Name: "zurich", Policy: PolicyTOFU}
+	remote := tcpAddr(t, "127.0.0.1:2222")
+
+	// every connection presents a different key, only one may be trusted
+	const n = 32
+	keys := make([]ssh.PublicKey, n)
+	for i := range keys {
+		keys[i] = newKey(t)
+	}
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = Verify(spec, "127.0.0.1:2222", remote, keys[i])
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	trusted := -1
+	for i, err := range errs {
+		switch {
+		case err == nil && trusted >= 0:
+			t.Fatalf("keys %d and %d were both trusted", trusted, i)
+		case err == nil:
+			trusted = i
+		case !errors.Is(err, ErrMismatch):
+			t.Fatalf("Verify(key %d) = %v, want nil or ErrMismatch", i, err)
+		}
+	}
+	if trusted < 0 {
+		t.Fatal("no key was trusted")
+	}
+	known, err := s.Lookup("127.0.0.1:2222")
+	if err != nil || len(known) != 1 || ssh.FingerprintSHA256(known[0]) != ssh.FingerprintSHA256(keys[trusted]) {
+		t.Fatalf("Lookup() = %v, %v, want only key %d", known, err, trusted)
+	}
+}
+
+func TestVerifyStrict(t *testing.T) {
+	s := initStore(t)
+	key := newKey(t)
+	spec := Spec{Name: "berlin", Policy: PolicyStrict}
+	remote := tcpAddr(t, "127.0.0.1:2223")
+
+	checkErr(t, Verify(spec, "127.0.0.1:2223", remote, key), ErrUnknown)
+	checkErr(t, Verify(spec, "127.0.0.1:2223", remote, key), ErrUnknown)
+
+	pending := s.Pending("berlin")
+	if len(pending) != 1 {
+		t.Fatalf("Pending() = %+v, want one key", pending)
+	}
+	if p := pending[0]; p.Reason != "unknown" || p.Fingerprint != ssh.FingerprintSHA256(key) || p.Address != knownhosts.Normalize("127.0.0.1:2223") {
+		t.Fatalf("Pending() = %+v", p)
+	}
+	if keys, _ := s.Lookup("127.0.0.1:2223"); len(keys) != 0 {
+		t.Fatalf("strict policy trusted an unknown key: %v", keys)
+	}
+
+	if _, err := s.Trust("berlin", "SHA256:unknown"); err == nil {
+		t.Fatal("Trust() of a key that is not pending succeeded")
+	}
+	if _, err := s.Trust("berlin", ssh.FingerprintSHA256(key)); err != nil {
+		t.Fatalf("Trust() = %v", err)
+	}
+	checkErr(t, Verify(spec, "127.0.0.1:2223", remote, key), nil)
+	if pending := s.Pending("berlin"); len(pending) != 0 {
+		t.Fatalf("Pending() after Trust = %+v", pending)
+	}
+}
+
+func TestVerifyKnownHosts(t *testing.T) {
+	trusted := newKey(t)
+	other := newKey(t)
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	lines := knownhosts.Line([]string{knownhosts.Normalize("127.0.0.1:2222")}, trusted) + "\n" +
+		knownhosts.Line([]string{knownhosts.Normalize("gateway.example.com:22")}, trusted) + "\n"
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	spec := Spec{Name: "zurich", Policy: PolicyStrict, KnownHosts: path}
+
+	tests := []struct {
+		name    string
+		address string
+		remote  string
+		key     ssh.PublicKey
+		want    error
+	}{
+		{"matching ip", "127.0.0.1:2222", "127.0.0.1:2222", trusted, nil},
+		{"matching hostname", "gateway.example.com:22", "192.0.2.1:22", trusted, nil},
+		{"mismatching ip", "127.0.0.1:2222", "127.0.0.1:2222", other, ErrMismatch},
+		{"mismatching hostname", "gateway.example.com:22", "192.0.2.1:22", other, ErrMismatch},
+		{"unknown", "127.0.0.1:2223", "127.0.0.1:2223", trusted, ErrUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkErr(t, Verify(spec, tt.address, tcpAddr(t, tt.remote), tt.key), tt.want)
+		})
+	}
+}
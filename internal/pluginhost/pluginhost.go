@@ -0,0 +1,476 @@
+// Package pluginhost runs external backend plugins and exposes them as
+// regular proxy backends. Each plugin is a child process speaking the
+// protocol defined in package plugin over its stdin and stdout.
+//
+// Example usage:
+//
+//	plugins, err := pluginhost.LoadDir("/etc/portgeist/plugins")
+//	defer pluginhost.CloseAll(plugins)
+package pluginhost
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mfulz/portgeist/interfaces"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/plugin"
+)
+
+var (
+	// callTimeout bounds a single request to a plugin.
+	callTimeout = 60 * time.Second
+
+	// closeTimeout is how long a plugin may take to exit after stdin closed.
+	closeTimeout = 5 * time.Second
+)
+
+// ErrNotRunning is returned for calls to a plugin whose process exited.
+var ErrNotRunning = errors.New("plugin process is not running")
+
+// Plugin is an external backend process. It implements
+// interfaces.ProxyBackend and interfaces.ExitAwareBackend. The process is
+// respawned on the next call after it died.
+type Plugin struct {
+	path  string
+	label string // executable name used in log messages
+	name  string // backend name, set by the first handshake
+
+	mu           sync.Mutex // guards all fields below
+	proc         *process
+	settings     map[string]map[string]any // replayed after a respawn
+	running      map[string]bool           // proxies started and not stopped
+	exitCallback func(name string)
+}
+
+// process is a single incarnation of a plugin executable.
+type process struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	done  chan struct{}
+
+	mu      sync.Mutex // guards writes, nextID and pending
+	enc     *json.Encoder
+	nextID  int64
+	pending map[int64]chan *plugin.Message
+}
+
+// Launch starts the plugin at path and performs the handshake.
+func Launch(path string) (*Plugin, error) {
+	p := &Plugin{
+		path:     path,
+		label:    filepath.Base(path),
+		settings: make(map[string]map[string]any),
+		running:  make(map[string]bool),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.spawn(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadDir launches every executable in dir and registers it as a backend
+// under the name reported by its handshake. Plugins that fail to start or
+// whose name is already taken are skipped with a warning. A missing dir
+// yields no plugins.
+func LoadDir(dir string) ([]*Plugin, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read plugins dir: %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+
+	var plugins []*Plugin
+	for _, file := range names {
+		path := filepath.Join(dir, file)
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+
+		p, err := Launch(path)
+		if err != nil {
+			logging.Log.Warnf("[plugin] Skipping %s: %v", path, err)
+			continue
+		}
+		if _, err := interfaces.GetBackend(p.Name()); err == nil {
+			logging.Log.Warnf("[plugin] Skipping %s: backend '%s' already registered", path, p.Name())
+			p.Close()
+			continue
+		}
+
+		interfaces.RegisterBackend(p.Name(), p)
+		logging.Log.Infof("[plugin] Registered backend '%s' from %s", p.Name(), path)
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+// CloseAll stops the processes of all given plugins.
+func CloseAll(plugins []*Plugin) {
+	for _, p := range plugins {
+		p.Close()
+	}
+}
+
+// Name returns the backend name reported by the plugin.
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+// spawn starts a new process, performs the handshake and replays the
+// stored settings. The caller must hold p.mu.
+func (p *Plugin) spawn() (*process, error) {
+	cmd := exec.Command(p.path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start plugin: %w", err)
+	}
+
+	proc := &process{
+		cmd:     cmd,
+		stdin:   stdin,
+		done:    make(chan struct{}),
+		enc:     json.NewEncoder(stdin),
+		pending: make(map[int64]chan *plugin.Message),
+	}
+
+	go logStderr(p.label, stderr)
+	go p.readLoop(proc, stdout)
+
+	var hs plugin.HandshakeResult
+	err = proc.call(plugin.MethodHandshake, plugin.HandshakeParams{ProtocolVersion: plugin.ProtocolVersion}, &hs)
+	if err == nil && hs.ProtocolVersion != plugin.ProtocolVersion {
+		err = fmt.Errorf("unsupported protocol version %d", hs.ProtocolVersion)
+	}
+	if err == nil && hs.Name == "" {
+		err = errors.New("handshake returned no name")
+	}
+	if err == nil && p.name != "" && hs.Name != p.name {
+		err = fmt.Errorf("plugin changed its name from '%s' to '%s'", p.name, hs.Name)
+	}
+	if err != nil {
+		proc.close()
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+	p.name = hs.Name
+
+	for name, cfg := range p.settings {
+		if err := proc.call(plugin.MethodConfigure, plugin.ConfigureParams{Name: name, Config: cfg}, nil); err != nil {
+			proc.close()
+			return nil, fmt.Errorf("configure '%s': %w", name, err)
+		}
+	}
+
+	p.proc = proc
+	return proc, nil
+}
+
+// readLoop dispatches responses and notifications until stdout closes,
+// then reports every running proxy as exited.
+func (p *Plugin) readLoop(proc *process, stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var msg plugin.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			logging.Log.Warnf("[plugin:%s] Invalid message: %v", p.label, err)
+			continue
+		}
+		switch {
+		case msg.ID != nil:
+			proc.deliver(&msg)
+		case msg.Method == plugin.NotifyExit:
+			var params plugin.NameParams
+			if err := json.Unmarshal(msg.Params, &params); err == nil {
+				p.exited(params.Name)
+			}
+		}
+	}
+
+	_ = proc.cmd.Wait()
+	proc.fail()
+	close(proc.done)
+
+	p.mu.Lock()
+	if p.proc != proc {
+		p.mu.Unlock()
+		return
+	}
+	p.proc = nil
+	var lost []string
+	for name := range p.running {
+		lost = append(lost, name)
+	}
+	p.mu.Unlock()
+
+	logging.Log.Warnf("[plugin:%s] Plugin process exited", p.label)
+	for _, name := range lost {
+		p.exited(name)
+	}
+}
+
+// exited handles an unexpected exit of a proxy started via the plugin.
+// The callback runs on its own goroutine: it may wait for a caller that
+// is itself waiting for a reply only readLoop can deliver.
+func (p *Plugin) exited(name string) {
+	p.mu.Lock()
+	wasRunning := p.running[name]
+	delete(p.running, name)
+	cb := p.exitCallback
+	p.mu.Unlock()
+
+	if wasRunning && cb != nil {
+		logging.Log.Infof("[plugin:%s] Proxy '%s' exited", p.label, name)
+		go cb(name)
+	}
+}
+
+// logStderr forwards plugin stderr lines to the daemon log.
+func logStderr(label string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		logging.Log.Infof("[plugin:%s] %s", label, scanner.Text())
+	}
+}
+
+// Call sends a request to the plugin process, spawning it if necessary,
+// and decodes the result into result (which may be nil).
+func (p *Plugin) Call(method string, params, result any) error {
+	p.mu.Lock()
+	proc := p.proc
+	if proc == nil {
+		var err error
+		if proc, err = p.spawn(); err != nil {
+			p.mu.Unlock()
+			return err
+		}
+	}
+	p.mu.Unlock()
+
+	return proc.call(method, params, result)
+}
+
+// call performs a single request on the process.
+func (proc *process) call(method string, params, result any) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	reply := make(chan *plugin.Message, 1)
+	proc.mu.Lock()
+	if proc.pending == nil {
+		proc.mu.Unlock()
+		return ErrNotRunning
+	}
+	proc.nextID++
+	id := proc.nextID
+	proc.pending[id] = reply
+	err = proc.enc.Encode(&plugin.Message{JSONRPC: plugin.JSONRPCVersion, ID: &id, Method: method, Params: raw})
+	proc.mu.Unlock()
+	if err != nil {
+		proc.forget(id)
+		return fmt.Errorf("write %s: %w", method, err)
+	}
+
+	select {
+	case msg := <-reply:
+		if msg == nil {
+			return ErrNotRunning
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				return fmt.Errorf("decode %s result: %w", method, err)
+			}
+		}
+		return nil
+	case <-time.After(callTimeout):
+		proc.forget(id)
+		return fmt.Errorf("%s timed out after %s", method, callTimeout)
+	}
+}
+
+// deliver hands a response to the waiting caller.
+func (proc *process) deliver(msg *plugin.Message) {
+	proc.mu.Lock()
+	reply, ok := proc.pending[*msg.ID]
+	delete(proc.pending, *msg.ID)
+	proc.mu.Unlock()
+	if ok {
+		reply <- msg
+	}
+}
+
+// forget drops a pending request.
+func (proc *process) forget(id int64) {
+	proc.mu.Lock()
+	delete(proc.pending, id)
+	proc.mu.Unlock()
+}
+
+// fail aborts all pending requests after the process exited.
+func (proc *process) fail() {
+	proc.mu.Lock()
+	pending := proc.pending
+	proc.pending = nil
+	proc.mu.Unlock()
+	for _, reply := range pending {
+		reply <- nil
+	}
+}
+
+// close closes stdin and kills the process if it does not exit in time.
+func (proc *process) close() {
+	_ = proc.stdin.Close()
+	select {
+	case <-proc.done:
+	case <-time.After(closeTimeout):
+		_ = proc.cmd.Process.Kill()
+		<-proc.done
+	}
+}
+
+// Close terminates the plugin process. Running proxies are not reported
+// as exited.
+func (p *Plugin) Close() {
+	p.mu.Lock()
+	proc := p.proc
+	p.proc = nil
+	p.running = make(map[string]bool)
+	p.mu.Unlock()
+
+	if proc != nil {
+		proc.close()
+	}
+}
+
+// SetExitHandler registers a callback for unexpected tunnel termination.
+func (p *Plugin) SetExitHandler(cb func(name string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exitCallback = cb
+}
+
+// Configure stores backend-specific config per proxy instance and forwards
+// it to the plugin.
+func (p *Plugin) Configure(name string, cfg map[string]any) error {
+	p.mu.Lock()
+	p.settings[name] = cfg
+	p.mu.Unlock()
+
+	return p.Call(plugin.MethodConfigure, plugin.ConfigureParams{Name: name, Config: cfg}, nil)
+}
+
+// Start resolves host and login of the proxy and asks the plugin to start it.
+func (p *Plugin) Start(name string, proxy configd.Proxy, cfg *configd.Config) error {
+	hostName := proxy.Default
+	host, ok := cfg.Hosts[hostName]
+	if !ok {
+		return fmt.Errorf("default host '%s' not found for proxy '%s'", hostName, name)
+	}
+	login, ok := cfg.Logins[host.Login]
+	if !ok {
+		return fmt.Errorf("login '%s' not found for host '%s'", host.Login, hostName)
+	}
+
+	spec := cfg.HostKeySpec(hostName)
+	params := plugin.StartParams{
+		Name:   name,
+		Listen: fmt.Sprintf("%s:%d", cfg.Proxies.Bind, proxy.Port),
+		Host: plugin.HostInfo{
+			Name:          hostName,
+			Address:       host.Address,
+			Port:          host.Port,
+			Config:        host.Config,
+			HostKey:       host.HostKey,
+			HostKeyPolicy: string(spec.Policy),
+			KnownHosts:    host.KnownHosts,
+		},
+		Login: plugin.LoginInfo{
+			User:        login.User,
+			Password:    login.Password,
+			KeyFile:     login.KeyFile,
+			Passphrase:  login.Passphrase,
+			Certificate: login.Certificate,
+			Agent:       login.Agent,
+		},
+	}
+
+	logging.Log.Infof("[plugin:%s] Starting proxy '%s' on host '%s'", p.name, name, hostName)
+	if err := p.Call(plugin.MethodStart, params, nil); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.running[name] = true
+	p.mu.Unlock()
+	return nil
+}
+
+// Stop asks the plugin to stop the proxy.
+func (p *Plugin) Stop(name string) error {
+	p.mu.Lock()
+	delete(p.running, name)
+	alive := p.proc != nil
+	p.mu.Unlock()
+
+	if !alive {
+		return nil
+	}
+	logging.Log.Infof("[plugin:%s] Stopping proxy '%s'", p.name, name)
+	return p.Call(plugin.MethodStop, plugin.NameParams{Name: name}, nil)
+}
+
+// Status queries the plugin for the state of a proxy. A dead plugin
+// reports every proxy as stopped.
+func (p *Plugin) Status(name string) (int, bool) {
+	p.mu.Lock()
+	alive := p.proc != nil
+	p.mu.Unlock()
+	if !alive {
+		return 0, false
+	}
+
+	var res plugin.StatusResult
+	if err := p.Call(plugin.MethodStatus, plugin.NameParams{Name: name}, &res); err != nil {
+		logging.Log.Warnf("[plugin:%s] Status of '%s' failed: %v", p.name, name, err)
+		return 0, false
+	}
+	return res.PID, res.Running
+}
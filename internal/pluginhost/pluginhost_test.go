@@ -0,0 +1,161 @@
+package pluginhost
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/plugin"
+	"go.uber.org/zap"
+)
+
+// pluginEnv makes the test binary serve testBackend instead of running tests.
+const pluginEnv = "PORTGEIST_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(pluginEnv) == "1" {
+		b := &testBackend{running: make(map[string]bool)}
+		if err := plugin.Serve("test", b); err != nil {
+			fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// testBackend reports every other running proxy as exited before it
+// answers a stop or status request.
+type testBackend struct {
+	mu      sync.Mutex
+	running map[string]bool
+	exited  func(name string)
+}
+
+func (b *testBackend) SetExitHandler(cb func(name string)) { b.exited = cb }
+
+func (b *testBackend) Configure(name string, cfg map[string]any) error { return nil }
+
+func (b *testBackend) Start(params plugin.StartParams) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running[params.Name] = true
+	return nil
+}
+
+func (b *testBackend) Stop(name string) error {
+	b.crashOthers(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.running, name)
+	return nil
+}
+
+func (b *testBackend) Status(name string) (int, bool) {
+	b.crashOthers(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return 0, b.running[name]
+}
+
+// crashOthers reports all running proxies except name as exited.
+func (b *testBackend) crashOthers(name string) {
+	b.mu.Lock()
+	var lost []string
+	for other := range b.running {
+		if other != name {
+			lost = append(lost, other)
+			delete(b.running, other)
+		}
+	}
+	b.mu.Unlock()
+	for _, other := range lost {
+		b.exited(other)
+	}
+}
+
+// launchTestPlugin runs the test binary as plugin and closes it when the
+// test ends.
+func launchTestPlugin(t *testing.T) *Plugin {
+	t.Helper()
+	logging.Log = zap.NewNop().Sugar()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(pluginEnv, "1")
+	p, err := Launch(exe)
+	if err != nil {
+		t.Fatalf("Launch() = %v", err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestExitDuringCall(t *testing.T) {
+	timeout := callTimeout
+	callTimeout = 2 * time.Second
+	t.Cleanup(func() { callTimeout = timeout })
+
+	cfg := &configd.Config{
+		Logins: map[string]configd.Login{"l": {User: "geist"}},
+		Hosts:  map[string]configd.Host{"h": {Address: "10.0.0.1", Port: 22, Login: "l"}},
+		Proxies: configd.ProxiesConfig{
+			Bind: "127.0.0.1",
+		},
+	}
+
+	tests := []struct {
+		name string
+		call func(p *Plugin) error
+	}{
+		{"stop", func(p *Plugin) error { return p.Stop("a") }},
+		{"status", func(p *Plugin) error {
+			if _, running := p.Status("a"); !running {
+				return fmt.Errorf("status of 'a' reports it as stopped")
+			}
+			return nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := launchTestPlugin(t)
+
+			// the exit handler needs the lock the caller holds during the
+			// call, like the proxy manager does
+			var mu sync.Mutex
+			exited := make(chan string, 1)
+			p.SetExitHandler(func(name string) {
+				mu.Lock()
+				defer mu.Unlock()
+				exited <- name
+			})
+
+			for _, name := range []string{"a", "b"} {
+				if err := p.Start(name, configd.Proxy{Port: 1080, Default: "h"}, cfg); err != nil {
+					t.Fatalf("Start(%s) = %v", name, err)
+				}
+			}
+
+			mu.Lock()
+			err := tt.call(p)
+			mu.Unlock()
+			if err != nil {
+				t.Fatalf("%s = %v", tt.name, err)
+			}
+
+			select {
+			case name := <-exited:
+				if name != "b" {
+					t.Fatalf("exit handler called for '%s', want 'b'", name)
+				}
+			case <-time.After(callTimeout):
+				t.Fatal("exit handler was not called")
+			}
+		})
+	}
+}
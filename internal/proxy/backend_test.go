@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mfulz/portgeist/interfaces"
+	"github.com/mfulz/portgeist/internal/configd"
+)
+
+// fakeBackend records starts and refuses hosts listed in fail. Like the
+// SSH backends it refuses hosts whose login is not configured.
+type fakeBackend struct {
+	mu      sync.Mutex
+	running map[string]string // proxy -> host
+	starts  []string          // proxy@host
+	fail    map[string]bool
+	onExit  func(name string)
+}
+
+var fake = &fakeBackend{running: map[string]string{}, fail: map[string]bool{}}
+
+func init() {
+	interfaces.RegisterBackend("fake", fake)
+}
+
+func (f *fakeBackend) Configure(name string, cfg map[string]any) error { return nil }
+
+func (f *fakeBackend) Start(name string, p configd.Proxy, cfg *configd.Config) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail[p.Default] {
+		return fmt.Errorf("host '%s' unreachable", p.Default)
+	}
+	if login := cfg.Hosts[p.Default].Login; login != "" {
+		if _, ok := cfg.Logins[login]; !ok {
+			return fmt.Errorf("login '%s' not found for host '%s'", login, p.Default)
+		}
+	}
+	f.running[name] = p.Default
+	f.starts = append(f.starts, name+"@"+p.Default)
+	return nil
+}
+
+func (f *fakeBackend) Stop(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.running, name)
+	return nil
+}
+
+func (f *fakeBackend) Status(name string) (int, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.running[name]
+	return 0, ok
+}
+
+func (f *fakeBackend) SetExitHandler(cb func(name string)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onExit = cb
+}
+
+// crash ends a running proxy as if its tunnel died and reports the exit.
+func (f *fakeBackend) crash(name string) {
+	f.mu.Lock()
+	delete(f.running, name)
+	cb := f.onExit
+	f.mu.Unlock()
+	if cb != nil {
+		cb(name)
+	}
+}
+
+// reset forgets all proxies and returns the backend to a clean state.
+func (f *fakeBackend) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running = map[string]string{}
+	f.starts = nil
+	f.fail = map[string]bool{}
+}
+
+// takeStarts returns and clears the recorded starts.
+func (f *fakeBackend) takeStarts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := f.starts
+	f.starts = nil
+	return out
+}
+
+func (f *fakeBackend) setFail(host string, fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fail[host] = fail
+}
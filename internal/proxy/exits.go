@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"sync"
+
+	"github.com/mfulz/portgeist/interfaces"
+)
+
+// Backends are process-wide singletons with a single exit callback, so exit
+// notifications are routed to the manager that last started the proxy.
+var (
+	exitsMu sync.Mutex
+
+	// exitHandlerBackends records backends that already route exits to routeExit.
+	exitHandlerBackends = make(map[string]bool)
+
+	// ownerByProxy maps a proxy name to the manager running it.
+	ownerByProxy = make(map[string]*Manager)
+)
+
+// watchExits registers routeExit as exit handler of an exit-aware backend.
+func watchExits(backendName string, backend interfaces.ProxyBackend) {
+	withNotify, ok := backend.(interfaces.ExitAwareBackend)
+	if !ok {
+		return
+	}
+
+	exitsMu.Lock()
+	defer exitsMu.Unlock()
+	if exitHandlerBackends[backendName] {
+		return
+	}
+	withNotify.SetExitHandler(routeExit)
+	exitHandlerBackends[backendName] = true
+}
+
+// setOwner records m as the manager responsible for the named proxy.
+func setOwner(name string, m *Manager) {
+	exitsMu.Lock()
+	defer exitsMu.Unlock()
+	ownerByProxy[name] = m
+}
+
+// routeExit forwards an unexpected tunnel exit to the owning manager.
+func routeExit(name string) {
+	exitsMu.Lock()
+	m := ownerByProxy[name]
+	exitsMu.Unlock()
+
+	if m != nil {
+		m.handleExit(name)
+	}
+}
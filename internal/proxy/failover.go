@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// defaultFailoverAfter is the number of consecutive tunnel exits on one host
+// after which the next candidate host is tried.
+const defaultFailoverAfter = 3
+
+// proxyRuntime tracks the candidate hosts and failover progress of a proxy
+// between StartProxy and StopProxy.
+type proxyRuntime struct {
+	proxy      configd.Proxy
+	cfg        *configd.Config
+	backend    string
+	candidates []string
+	index      int
+	skipped    []protocol.HostFailure
+	exits      int
+	startedAt  time.Time
+	health     *healthCheck // probe of the current tunnel, nil if probing is disabled
+}
+
+// maxSkipped bounds the skipped host history kept per proxy.
+const maxSkipped = 20
+
+// skip records why host was left behind.
+func (rt *proxyRuntime) skip(host, reason string) {
+	rt.skipped = append(rt.skipped, protocol.HostFailure{
+		Host:   host,
+		Reason: reason,
+		At:     time.Now().Format(time.RFC3339),
+	})
+	if len(rt.skipped) > maxSkipped {
+		rt.skipped = rt.skipped[len(rt.skipped)-maxSkipped:]
+	}
+}
+
+// failoverAfter returns the configured exit threshold for failover.
+func (rt *proxyRuntime) failoverAfter() int {
+	if rt.proxy.FailoverAfter > 0 {
+		return rt.proxy.FailoverAfter
+	}
+	return defaultFailoverAfter
+}
+
+// Candidates returns the ordered hosts a proxy may run on: its default host
+// followed by its fallback hosts, skipping duplicates, unknown hosts and
+// hosts whose allowed_proxies list does not include the proxy.
+func Candidates(name string, p configd.Proxy, cfg *configd.Config) []string {
+	var out []string
+	for _, hostName := range append([]string{p.Default}, p.Fallback...) {
+		if hostName == "" || slices.Contains(out, hostName) {
+			continue
+		}
+		host, ok := cfg.Hosts[hostName]
+		if !ok || !slices.Contains(host.Proxies, name) {
+			continue
+		}
+		out = append(out, hostName)
+	}
+	return out
+}
+
+// AllowedHosts returns the sorted names of all hosts that allow the proxy,
+// i.e. the hosts it may be started on or switched to.
+func AllowedHosts(name string, cfg *configd.Config) []string {
+	out := []string{}
+	for hostName, host := range cfg.Hosts {
+		if slices.Contains(host.Proxies, name) {
+			out = append(out, hostName)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/logging"
+	"go.uber.org/zap"
+)
+
+// failoverConfig returns a config with proxy pp defaulting to zurich and
+// falling back to the given hosts, all on the fake backend.
+func failoverConfig(fallback ...string) *configd.Config {
+	cfg := restartConfig()
+	cfg.Logins = map[string]configd.Login{"deploy": {User: "deploy", Password: "secret"}}
+	cfg.Hosts["zurich"] = configd.Host{Address: "10.0.0.1", Backend: "fake", Login: "deploy", Proxies: []string{"pp"}}
+	cfg.Hosts["berlin"] = configd.Host{Address: "10.0.0.2", Backend: "fake", Login: "deploy", Proxies: []string{"pp"}}
+	cfg.Hosts["paris"] = configd.Host{Address: "10.0.0.3", Backend: "fake", Login: "deploy", Proxies: []string{"pp"}}
+	cfg.Hosts["oslo"] = configd.Host{Address: "10.0.0.4", Backend: "fake", Login: "deploy", Proxies: []string{"dev"}}
+	cfg.Hosts["rome"] = configd.Host{Address: "10.0.0.5", Backend: "fake", Login: "gone", Proxies: []string{"pp"}}
+	p := cfg.Proxies.Proxies["pp"]
+	p.Fallback = fallback
+	cfg.Proxies.Proxies["pp"] = p
+	return cfg
+}
+
+func TestCandidates(t *testing.T) {
+	tests := []struct {
+		name     string
+		def      string
+		fallback []string
+		want     string
+	}{
+		{name: "default only", def: "zurich", want: "[zurich]"},
+		{name: "default then fallbacks", def: "zurich", fallback: []string{"paris", "berlin"}, want: "[zurich paris berlin]"},
+		{name: "duplicates", def: "zurich", fallback: []string{"berlin", "zurich", "berlin"}, want: "[zurich berlin]"},
+		{name: "unknown hosts", def: "ghost", fallback: []string{"berlin", "nowhere"}, want: "[berlin]"},
+		{name: "hosts not allowing the proxy", def: "oslo", fallback: []string{"berlin", "oslo"}, want: "[berlin]"},
+		{name: "no default", fallback: []string{"berlin"}, want: "[berlin]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := failoverConfig(tt.fallback...)
+			p := cfg.Proxies.Proxies["pp"]
+			p.Default = tt.def
+			if got := fmt.Sprint(Candidates("pp", p, cfg)); got != tt.want {
+				t.Errorf("Candidates() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailover(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+
+	tests := []struct {
+		name       string
+		fallback   []string
+		candidates []string // passed to StartProxyOn, nil for StartProxy
+		fail       []string
+		crashes    int // tunnel exits after the start, with failover_after 1
+		active     string
+		index      int
+		skipped    []string // host: reason substring
+	}{
+		{
+			name:     "default wins",
+			fallback: []string{"berlin"},
+			active:   "zurich",
+		},
+		{
+			name:     "fallback wins",
+			fallback: []string{"berlin", "paris"},
+			fail:     []string{"zurich"},
+			active:   "berlin",
+			index:    1,
+			skipped:  []string{"zurich: host 'zurich' unreachable"},
+		},
+		{
+			name:     "fallbacks tried in order",
+			fallback: []string{"berlin", "paris"},
+			fail:     []string{"zurich", "berlin"},
+			active:   "paris",
+			index:    2,
+			skipped:  []string{"zurich: unreachable", "berlin: unreachable"},
+		},
+		{
+			name:       "unknown host and host without login skipped",
+			candidates: []string{"ghost", "rome", "berlin"},
+			active:     "berlin",
+			index:      2,
+			skipped:    []string{"ghost: host 'ghost' not found", "rome: login 'gone' not found"},
+		},
+		{
+			name:     "failover after tunnel exit",
+			fallback: []string{"berlin"},
+			crashes:  1,
+			active:   "berlin",
+			index:    1,
+			skipped:  []string{"zurich: tunnel exited 1 times"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newManager(t)
+			cfg := failoverConfig(tt.fallback...)
+			p := cfg.Proxies.Proxies["pp"]
+			if tt.crashes > 0 {
+				p.FailoverAfter = 1
+				cfg.Proxies.Proxies["pp"] = p
+			}
+			for _, host := range tt.fail {
+				fake.setFail(host, true)
+			}
+
+			var err error
+			if tt.candidates != nil {
+				err = m.StartProxyOn("pp", p, cfg, tt.candidates)
+			} else {
+				err = m.StartProxy("pp", p, cfg)
+			}
+			if err != nil {
+				t.Fatalf("start = %v", err)
+			}
+			for range tt.crashes {
+				fake.crash("pp")
+			}
+
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				status, err := m.GetProxyStatus("pp", p, cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if status.ActiveHost == tt.active && status.Running {
+					if status.CandidateIndex != tt.index {
+						t.Errorf("candidate index = %d, want %d", status.CandidateIndex, tt.index)
+					}
+					if len(status.Skipped) != len(tt.skipped) {
+						t.Fatalf("skipped = %+v, want %v", status.Skipped, tt.skipped)
+					}
+					for i, want := range tt.skipped {
+						host, reason, _ := strings.Cut(want, ": ")
+						if got := status.Skipped[i]; got.Host != host || !strings.Contains(got.Reason, reason) {
+							t.Errorf("skipped[%d] = %+v, want %s", i, got, want)
+						}
+					}
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("status = %+v, want running on %s", status, tt.active)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}
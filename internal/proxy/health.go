@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mfulz/portgeist/interfaces"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/events"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/socks5"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// Defaults applied to unset fields of configd.HealthCheck.
+const (
+	defaultHealthInterval = 30 * time.Second
+	defaultHealthTimeout  = 10 * time.Second
+	defaultHealthFailures = 3
+)
+
+// Health states reported in protocol.StatusResponse.
+const (
+	healthUnknown   = "unknown"   // no probe finished yet
+	healthHealthy   = "healthy"   // last probe succeeded
+	healthFailing   = "failing"   // probes fail, threshold not reached yet
+	healthUnhealthy = "unhealthy" // threshold reached, tunnel was recycled
+)
+
+// healthCheck periodically probes the SOCKS listener of a running proxy.
+// Result fields are guarded by the mutex of the proxy's entry.
+type healthCheck struct {
+	host     string
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	status    string
+	lastProbe time.Time
+	latency   time.Duration
+	failures  int
+	lastError string
+}
+
+// halt ends the probe loop.
+func (hc *healthCheck) halt() {
+	hc.stopOnce.Do(func() { close(hc.stop) })
+}
+
+// setStatus updates the health state and publishes changes.
+func (hc *healthCheck) setStatus(name, status string) {
+	if hc.status == status {
+		return
+	}
+	hc.status = status
+	events.Publish(protocol.Event{
+		Type:    protocol.EventHealthChanged,
+		Proxy:   name,
+		Host:    hc.host,
+		Health:  status,
+		Message: hc.lastError,
+	})
+}
+
+// healthPolicy returns the health check settings of a proxy with the
+// per-proxy target override and defaults applied.
+func healthPolicy(p configd.Proxy, cfg *configd.Config) configd.HealthCheck {
+	h := cfg.Proxies.Health
+	if p.HealthTarget != "" {
+		h.Target = p.HealthTarget
+	}
+	if h.Interval <= 0 {
+		h.Interval = defaultHealthInterval
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = defaultHealthTimeout
+	}
+	if h.Failures <= 0 {
+		h.Failures = defaultHealthFailures
+	}
+	return h
+}
+
+// probeAddr returns the address the local SOCKS listener is reachable at.
+func probeAddr(bind string, port int) string {
+	switch bind {
+	case "", "0.0.0.0":
+		bind = "127.0.0.1"
+	case "::":
+		bind = "::1"
+	}
+	return net.JoinHostPort(bind, strconv.Itoa(port))
+}
+
+// startHealthCheck begins probing the proxy of rt running on host, if a
+// probe target is configured. The caller must hold e.mu.
+func (m *Manager) startHealthCheck(name string, e *proxyEntry, rt *proxyRuntime, host string) {
+	stopHealthCheck(rt)
+
+	policy := healthPolicy(rt.proxy, rt.cfg)
+	if policy.Target == "" {
+		rt.health = nil
+		return
+	}
+
+	hc := &healthCheck{
+		host:   host,
+		stop:   make(chan struct{}),
+		status: healthUnknown,
+	}
+	rt.health = hc
+	go m.runHealthCheck(hc, name, e, rt, policy, probeAddr(rt.cfg.Proxies.Bind, rt.proxy.Port))
+}
+
+// stopHealthCheck halts the probe loop of rt while keeping its last result
+// for status reporting. The caller must hold the entry's mutex.
+func stopHealthCheck(rt *proxyRuntime) {
+	if rt.health != nil {
+		rt.health.halt()
+	}
+}
+
+// runHealthCheck probes addr every interval until hc is halted, the proxy
+// is recycled or the manager shuts down.
+func (m *Manager) runHealthCheck(hc *healthCheck, name string, e *proxyEntry, rt *proxyRuntime, policy configd.HealthCheck, addr string) {
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hc.stop:
+			return
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		started := time.Now()
+		conn, err := socks5.Connect(addr, policy.Target, policy.Timeout)
+		latency := time.Since(started)
+		if err == nil {
+			_ = conn.Close()
+		}
+
+		if !m.recordProbe(hc, name, e, rt, policy, latency, err) {
+			return
+		}
+	}
+}
+
+// recordProbe stores the result of a probe. Once policy.Failures probes
+// failed in a row the tunnel is recycled via recycleUnhealthy.
+// It reports whether probing should continue.
+func (m *Manager) recordProbe(hc *healthCheck, name string, e *proxyEntry, rt *proxyRuntime, policy configd.HealthCheck, latency time.Duration, err error) bool {
+	e.mu.Lock()
+	unhealthy, cont := m.updateHealth(hc, name, e, rt, policy, latency, err)
+	e.mu.Unlock()
+
+	if unhealthy != "" {
+		m.recycleUnhealthy(name, e, rt, unhealthy)
+	}
+	return cont
+}
+
+// updateHealth applies a probe result to hc. Once the proxy turned
+// unhealthy it is marked as being recovered and the host it ran on is
+// returned. The caller must hold e.mu.
+func (m *Manager) updateHealth(hc *healthCheck, name string, e *proxyEntry, rt *proxyRuntime, policy configd.HealthCheck, latency time.Duration, err error) (string, bool) {
+	select {
+	case <-hc.stop:
+		return "", false
+	default:
+	}
+	if e.runtime != rt || rt.health != hc || m.ctx.Err() != nil {
+		return "", false
+	}
+
+	hc.lastProbe = time.Now()
+	if err == nil {
+		if hc.failures > 0 {
+			logging.Log.Infof("[proxy] Health probe of '%s' via host '%s' recovered", name, hc.host)
+		}
+		hc.setStatus(name, healthHealthy)
+		hc.latency = latency
+		hc.failures = 0
+		hc.lastError = ""
+		return "", true
+	}
+
+	hc.failures++
+	hc.lastError = err.Error()
+	logging.Log.Warnf("[proxy] Health probe of '%s' via host '%s' failed (%d/%d): %v",
+		name, hc.host, hc.failures, policy.Failures, err)
+	if hc.failures < policy.Failures {
+		hc.setStatus(name, healthFailing)
+		return "", true
+	}
+
+	hc.setStatus(name, healthUnhealthy)
+	hc.halt()
+
+	// Without an active host the exit caused by stopping the tunnel is
+	// not handled as a loss of its own.
+	host := e.activeHost
+	e.activeHost = ""
+	e.instance = nil
+	return host, false
+}
+
+// recycleUnhealthy stops the tunnel of rt and schedules its restart.
+// Stopping may take up to stateCheckMaxWait and runs without holding e.mu,
+// so the restart is skipped if the proxy was stopped or restarted meanwhile.
+func (m *Manager) recycleUnhealthy(name string, e *proxyEntry, rt *proxyRuntime, host string) {
+	logging.Log.Warnf("[proxy] '%s' is unhealthy on host '%s', recycling tunnel", name, host)
+	if backend, err := interfaces.GetBackend(rt.backend); err == nil {
+		if err := backend.Stop(name); err != nil {
+			logging.Log.Warnf("[proxy] Stopping unhealthy '%s' failed: %v", name, err)
+		}
+		waitUntilStopped(backend, name)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.runtime != rt || e.activeHost != "" || m.ctx.Err() != nil {
+		return
+	}
+	m.recoverProxy(name, e, rt, host, "health check failed")
+}
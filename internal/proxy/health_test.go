@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/socks5"
+	"go.uber.org/zap"
+)
+
+// freePort returns a local port that nothing listens on.
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// healthConfig returns restartConfig with fast health probes of target
+// through the proxy listening on port.
+func healthConfig(port int, target string) *configd.Config {
+	cfg := restartConfig()
+	cfg.Proxies.Health = configd.HealthCheck{
+		Target:   target,
+		Interval: 5 * time.Millisecond,
+		Timeout:  time.Second,
+		Failures: 2,
+	}
+	p := cfg.Proxies.Proxies["pp"]
+	p.Port = port
+	cfg.Proxies.Proxies["pp"] = p
+	return cfg
+}
+
+// waitForStatus polls the status of proxy pp until ok accepts it.
+func waitForStatus(t *testing.T, m *Manager, cfg *configd.Config, what string, ok func(health, lastError string, restarts int) bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status, err := m.GetProxyStatus("pp", cfg.Proxies.Proxies["pp"], cfg)
+		if err != nil {
+			t.Fatalf("GetProxyStatus() = %v", err)
+		}
+		if ok(status.Health, status.LastError, status.Restarts) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s: %+v", what, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHealthCheckHealthy(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+	m := newManager(t)
+
+	// the fake backend does not listen, so serve SOCKS5 on the proxy port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go socks5.Serve(ln, net.Dial)
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { target.Close() })
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	cfg := healthConfig(ln.Addr().(*net.TCPAddr).Port, target.Addr().String())
+	if err := m.StartProxy("pp", cfg.Proxies.Proxies["pp"], cfg); err != nil {
+		t.Fatalf("m.StartProxy() = %v", err)
+	}
+	waitForStatus(t, m, cfg, "a healthy probe", func(health, _ string, _ int) bool {
+		return health == healthHealthy
+	})
+	if starts := fake.takeStarts(); len(starts) != 1 {
+		t.Fatalf("starts = %v, want a single start", starts)
+	}
+}
+
+func TestHealthCheckRecyclesUnhealthy(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+	m := newManager(t)
+
+	// nothing listens on the proxy port, so every probe fails
+	cfg := healthConfig(freePort(t), "127.0.0.1:9")
+	if err := m.StartProxy("pp", cfg.Proxies.Proxies["pp"], cfg); err != nil {
+		t.Fatalf("m.StartProxy() = %v", err)
+	}
+
+	waitForStatus(t, m, cfg, "a recycled tunnel", func(_, lastError string, restarts int) bool {
+		return restarts >= 1 && strings.Contains(lastError, "health check failed")
+	})
+	deadline := time.Now().Add(2 * time.Second)
+	var starts []string
+	for len(starts) < 2 && time.Now().Before(deadline) {
+		starts = append(starts, fake.takeStarts()...)
+		time.Sleep(time.Millisecond)
+	}
+	if len(starts) < 2 || starts[0] != "pp@zurich" || starts[1] != "pp@zurich" {
+		t.Fatalf("starts = %v, want the tunnel restarted on zurich", starts)
+	}
+}
+
+func TestProbeAddr(t *testing.T) {
+	tests := []struct {
+		bind string
+		want string
+	}{
+		{"", "127.0.0.1:1080"},
+		{"0.0.0.0", "127.0.0.1:1080"},
+		{"::", "[::1]:1080"},
+		{"10.0.0.5", "10.0.0.5:1080"},
+	}
+	for _, tt := range tests {
+		if got := probeAddr(tt.bind, 1080); got != tt.want {
+			t.Errorf("probeAddr(%q) = %q, want %q", tt.bind, got, tt.want)
+		}
+	}
+}
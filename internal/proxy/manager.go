@@ -4,33 +4,93 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/mfulz/portgeist/interfaces"
 	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/events"
 	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/state"
 	"github.com/mfulz/portgeist/protocol"
 )
 
-var (
-	// proxyTransitionMu ensures that start/stop operations are serialized to avoid race conditions.
-	proxyTransitionMu sync.Mutex
-
-	// activeHostByProxy keeps track of the currently active host used by a proxy.
-	activeHostByProxy = make(map[string]string)
-
-	// activeProxies stores the backend-level live instances by proxy name.
-	activeProxies = make(map[string]interfaces.RunningInstance)
+// ErrManagerClosed is returned when starting a proxy after shutdown began.
+var ErrManagerClosed = errors.New("proxy manager is shutting down")
 
+var (
 	// stateCheckMaxWait sets the maximum wait time for stopping proxy
 	stateCheckMaxWait = 15 * time.Second
 
 	// stateCheckInterval sets the iteration wait time for stopping proxy loop
 	stateCheckInterval = 100 * time.Millisecond
+
+	// failoverStableTime is the uptime after which a tunnel's exit count is reset.
+	failoverStableTime = time.Minute
 )
 
+// Manager owns the runtime state of all proxies. Transitions of a single
+// proxy are serialized while different proxies may start and stop in
+// parallel. Cancelling the context passed to NewManager stops every proxy.
+type Manager struct {
+	ctx   context.Context
+	store *state.Store
+	done  chan struct{}
+
+	mu      sync.Mutex // guards proxies
+	proxies map[string]*proxyEntry
+}
+
+// proxyEntry holds the runtime state of a single proxy.
+// mu serializes start, stop and recovery of that proxy.
+type proxyEntry struct {
+	mu sync.Mutex
+
+	activeHost string                     // host the proxy currently runs on
+	lastHost   string                     // host the proxy ran on most recently
+	instance   interfaces.RunningInstance // backend-level live instance
+	runtime    *proxyRuntime              // candidates and failover progress, nil when stopped
+	restart    *restartState              // survives StopProxy so failed proxies stay failed
+}
+
+// NewManager returns a manager that persists desired proxy states to store
+// (which may be nil). Once ctx is cancelled all proxies are stopped and
+// Done is closed after their backends exited.
+func NewManager(ctx context.Context, store *state.Store) *Manager {
+	m := &Manager{
+		ctx:     ctx,
+		store:   store,
+		done:    make(chan struct{}),
+		proxies: make(map[string]*proxyEntry),
+	}
+	go func() {
+		<-ctx.Done()
+		m.StopAll()
+		close(m.done)
+	}()
+	return m
+}
+
+// Done returns a channel that is closed once the manager shut down.
+func (m *Manager) Done() <-chan struct{} {
+	return m.done
+}
+
+// entry returns the state of the named proxy, creating it on first use.
+func (m *Manager) entry(name string) *proxyEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.proxies[name]
+	if !ok {
+		e = &proxyEntry{}
+		m.proxies[name] = e
+	}
+	return e
+}
+
 // waitUntilStopped polls backend.Status until it reports not running or timeout.
 func waitUntilStopped(backend interfaces.ProxyBackend, name string) {
 	timeout := time.After(stateCheckMaxWait)
@@ -49,12 +109,49 @@ func waitUntilStopped(backend interfaces.ProxyBackend, name string) {
 	}
 }
 
-// StopAll cleanly stops all active proxies using tracked instances.
-func StopAll() {
-	for name, inst := range activeProxies {
-		logging.Log.Infof("[proxy] Shutting down '%s'...", name)
-		inst.Stop()
+// StopAll cleanly stops all active proxies in parallel and waits until
+// their backends exited. Unlike StopProxy the desired state is kept, so
+// the proxies are restored on the next start of the daemon.
+func (m *Manager) StopAll() {
+	m.mu.Lock()
+	entries := make(map[string]*proxyEntry, len(m.proxies))
+	for name, e := range m.proxies {
+		entries[name] = e
 	}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for name, e := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.mu.Lock()
+			defer e.mu.Unlock()
+
+			cancelRestart(e)
+			rt := e.runtime
+			if rt == nil {
+				return
+			}
+			stopHealthCheck(rt)
+
+			logging.Log.Infof("[proxy] Shutting down '%s'...", name)
+			backend, err := interfaces.GetBackend(rt.backend)
+			if e.instance != nil {
+				e.instance.Stop()
+			} else if err == nil {
+				_ = backend.Stop(name)
+			}
+			if err == nil {
+				waitUntilStopped(backend, name)
+			}
+
+			e.activeHost = ""
+			e.instance = nil
+			e.runtime = nil
+		}()
+	}
+	wg.Wait()
 }
 
 // mergeConfig merges global and host-specific backend configuration values.
@@ -71,11 +168,11 @@ func mergeConfig(global, override map[string]any) map[string]any {
 
 // StartAutostartProxies starts all proxies marked as autostart=true
 // from the provided configuration.
-func StartAutostartProxies(cfg *configd.Config) error {
+func (m *Manager) StartAutostartProxies(cfg *configd.Config) error {
 	for name, p := range cfg.Proxies.Proxies {
 		if p.Autostart {
 			logging.Log.Infof("[proxy] Autostart enabled for '%s'", name)
-			if err := StartProxy(name, p, cfg); err != nil {
+			if err := m.StartProxy(name, p, cfg); err != nil {
 				logging.Log.Infof("[proxy] Failed to start '%s': %v", name, err)
 			}
 		}
@@ -83,130 +180,332 @@ func StartAutostartProxies(cfg *configd.Config) error {
 	return nil
 }
 
-// StartProxy attempts to start a proxy via its defined backend,
-// using resolved backend config and storing active instance.
-func StartProxy(name string, p configd.Proxy, cfg *configd.Config) error {
-	proxyTransitionMu.Lock()
-	defer proxyTransitionMu.Unlock()
+// StartProxy attempts to start a proxy on the first candidate host that
+// comes up, using resolved backend config and storing the active instance.
+// Candidates are the proxy's default host followed by its fallback hosts.
+func (m *Manager) StartProxy(name string, p configd.Proxy, cfg *configd.Config) error {
+	return m.StartProxyOn(name, p, cfg, Candidates(name, p, cfg))
+}
 
-	hostCfg, ok := cfg.Hosts[p.Default]
-	if !ok {
-		return fmt.Errorf("host '%s' not found for proxy '%s'", p.Default, name)
-	}
-	backendName := hostCfg.Backend
-	if backendName == "" {
-		backendName = "ssh_exec"
+// StartProxyOn is StartProxy limited to candidates, e.g. the hosts a caller
+// is allowed on. The proxy's own default host is persisted, not the host it
+// comes up on, so a later restore is not bound to one caller's choice.
+func (m *Manager) StartProxyOn(name string, p configd.Proxy, cfg *configd.Config, candidates []string) error {
+	e := m.entry(name)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if m.ctx.Err() != nil {
+		return ErrManagerClosed
 	}
-	backend, err := interfaces.GetBackend(backendName)
-	if err != nil {
-		return fmt.Errorf("unknown backend '%s': %w", backendName, err)
+
+	if rs := e.restart; rs != nil && rs.failed {
+		return fmt.Errorf("%w: '%s' (%s), reset it first", ErrProxyFailed, name, rs.lastError)
 	}
 
 	// check if already running
-	_, running := backend.Status(name)
-	if running {
-		logging.Log.Infof("[proxy] '%s' is already running", name)
+	if backend, _, err := backendFor(e, name, p, cfg); err == nil {
+		if _, running := backend.Status(name); running {
+			logging.Log.Infof("[proxy] '%s' is already running", name)
+			return nil
+		}
+	}
+
+	if len(candidates) == 0 {
+		return fmt.Errorf("no allowed host for proxy '%s'", name)
+	}
+
+	rt := &proxyRuntime{
+		proxy:      p,
+		cfg:        cfg,
+		candidates: candidates,
+	}
+	e.runtime = rt
+
+	if err := m.startFrom(name, e, rt, 0); err != nil {
+		e.runtime = nil
+		return err
+	}
+	m.persistDesired(name, true, p.Default, cfg)
+	events.Publish(protocol.Event{Type: protocol.EventProxyStarted, Proxy: name, Host: e.activeHost})
+	return nil
+}
+
+// startFrom tries the candidates of rt beginning at index from and stops at
+// the first host that starts successfully. Every failed host is recorded.
+// The caller must hold e.mu.
+func (m *Manager) startFrom(name string, e *proxyEntry, rt *proxyRuntime, from int) error {
+	var lastErr error
+	for i := from; i < len(rt.candidates); i++ {
+		host := rt.candidates[i]
+		if err := m.startOnHost(name, e, rt, host); err != nil {
+			logging.Log.Warnf("[proxy] Starting '%s' on host '%s' failed: %v", name, host, err)
+			rt.skip(host, err.Error())
+			lastErr = err
+			continue
+		}
+
+		if i != rt.index || e.activeHost == "" {
+			logging.Log.Infof("[proxy] '%s' is now using host '%s'", name, host)
+		}
+		if e.lastHost != "" && e.lastHost != host {
+			events.Publish(protocol.Event{Type: protocol.EventHostSwitched, Proxy: name, Host: host, PrevHost: e.lastHost})
+		}
+		rt.index = i
+		e.activeHost = host
+		e.lastHost = host
+		m.startHealthCheck(name, e, rt, host)
 		return nil
 	}
 
+	rt.index = len(rt.candidates)
+	if lastErr == nil {
+		return fmt.Errorf("no candidate host left for proxy '%s'", name)
+	}
+	if len(rt.candidates)-from == 1 {
+		return lastErr
+	}
+	return fmt.Errorf("all candidate hosts failed for proxy '%s': %w", name, lastErr)
+}
+
+// startOnHost configures the host's backend and starts the proxy on it.
+// The caller must hold e.mu.
+func (m *Manager) startOnHost(name string, e *proxyEntry, rt *proxyRuntime, hostName string) error {
+	cfg := rt.cfg
+	hostCfg, ok := cfg.Hosts[hostName]
+	if !ok {
+		return fmt.Errorf("host '%s' not found for proxy '%s'", hostName, name)
+	}
+	backendName := BackendNameOf(hostCfg)
+	backend, err := interfaces.GetBackend(backendName)
+	if err != nil {
+		return fmt.Errorf("unknown backend '%s': %w", backendName, err)
+	}
+
 	globalCfg := cfg.Backends[backendName]
 	resolved := mergeConfig(globalCfg, hostCfg.Config)
+	delete(resolved, configd.BackendACLKey)
 
 	if err := backend.Configure(name, resolved); err != nil {
 		return fmt.Errorf("backend configure failed: %w", err)
 	}
 
-	// Register restart callback if supported
-	if withNotify, ok := backend.(interfaces.ExitAwareBackend); ok {
-		withNotify.SetExitHandler(func(deadName string) {
-			logging.Log.Infof("[proxy] Detected exit of '%s', attempting restart", deadName)
-			_ = StopProxy(deadName, p, cfg)
-			if err := StartProxy(deadName, p, cfg); err != nil {
-				logging.Log.Infof("[proxy] Restart of '%s' failed: %v", deadName, err)
-			} else {
-				logging.Log.Infof("[proxy] Restarted '%s' successfully", deadName)
-			}
-		})
-	}
-
-	activeHostByProxy[name] = p.Default
+	// Route unexpected exits of this proxy to the manager
+	watchExits(backendName, backend)
+	setOwner(name, m)
 
+	p := rt.proxy
+	p.Default = hostName
 	if err := backend.Start(name, p, cfg); err != nil {
 		return err
 	}
 
+	rt.backend = backendName
+	rt.startedAt = time.Now()
+
 	if reporting, ok := backend.(interfaces.InstanceReportingBackend); ok {
 		if inst := reporting.GetInstance(name); inst != nil {
-			activeProxies[name] = inst
+			e.instance = inst
 		}
 	}
 	return nil
 }
 
-// StopProxy stops a running proxy by name and clears tracked state.
-func StopProxy(name string, p configd.Proxy, cfg *configd.Config) error {
-	proxyTransitionMu.Lock()
-	defer proxyTransitionMu.Unlock()
+// handleExit is invoked when a tunnel of the manager dies unexpectedly.
+func (m *Manager) handleExit(name string) {
+	e := m.entry(name)
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-	hostCfg, ok := cfg.Hosts[p.Default]
-	if !ok {
-		return fmt.Errorf("host '%s' not found", p.Default)
+	rt := e.runtime
+	if rt == nil || m.ctx.Err() != nil {
+		return
 	}
-	backendName := hostCfg.Backend
-	if backendName == "" {
-		backendName = "ssh_exec"
+	if e.activeHost == "" {
+		// already being recovered, e.g. after a failed health check
+		return
 	}
-	backend, err := interfaces.GetBackend(backendName)
+	m.recoverProxy(name, e, rt, e.activeHost, "tunnel exited")
+}
+
+// recoverProxy handles the loss of a proxy's tunnel on host. The proxy is
+// restarted on that host after a backoff delay; after repeated losses the
+// manager fails over to the next candidate host.
+// The caller must hold e.mu.
+func (m *Manager) recoverProxy(name string, e *proxyEntry, rt *proxyRuntime, host, reason string) {
+	e.activeHost = ""
+	e.instance = nil
+	stopHealthCheck(rt)
+
+	if time.Since(rt.startedAt) >= failoverStableTime {
+		rt.exits = 0
+	}
+	rt.exits++
+
+	from := rt.index
+	if rt.exits >= rt.failoverAfter() {
+		logging.Log.Warnf("[proxy] '%s' lost its tunnel %d times on host '%s', failing over", name, rt.exits, host)
+		rt.skip(host, fmt.Sprintf("%s %d times", reason, rt.exits))
+		rt.exits = 0
+		from++
+	} else {
+		logging.Log.Infof("[proxy] Detected loss of '%s' on host '%s': %s", name, host, reason)
+	}
+	events.Publish(protocol.Event{Type: protocol.EventProxyExited, Proxy: name, Host: host, Message: reason})
+
+	m.scheduleRestart(name, e, rt, from, fmt.Sprintf("%s on host '%s'", reason, host))
+}
+
+// StopProxy stops a running proxy by name and clears tracked state.
+func (m *Manager) StopProxy(name string, p configd.Proxy, cfg *configd.Config) error {
+	e := m.entry(name)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	backend, _, err := backendFor(e, name, p, cfg)
 	if err != nil {
 		return err
 	}
 
-	delete(activeHostByProxy, name)
+	if e.runtime != nil {
+		stopHealthCheck(e.runtime)
+	}
+	e.activeHost = ""
+	e.instance = nil
+	e.runtime = nil
+	cancelRestart(e)
+	m.persistDesired(name, false, "", cfg)
 
 	if err := backend.Stop(name); err != nil {
 		return err
 	}
 
 	waitUntilStopped(backend, name)
+	events.Publish(protocol.Event{Type: protocol.EventProxyStopped, Proxy: name})
 	return nil
 }
 
-// GetProxyStatus returns runtime information about a proxy.
-func GetProxyStatus(name string, p configd.Proxy, cfg *configd.Config) (*protocol.StatusResponse, error) {
-	hostCfg, ok := cfg.Hosts[p.Default]
-	if !ok {
-		return nil, fmt.Errorf("host '%s' not found", p.Default)
-	}
-	backendName := hostCfg.Backend
-	if backendName == "" {
-		backendName = "ssh_exec"
+// backendFor returns the backend a proxy is running on, falling back to
+// the backend of its default host when it is not running.
+func backendFor(e *proxyEntry, name string, p configd.Proxy, cfg *configd.Config) (interfaces.ProxyBackend, string, error) {
+	backendName := ""
+	if rt := e.runtime; rt != nil && rt.backend != "" {
+		backendName = rt.backend
+	} else {
+		hostCfg, ok := cfg.Hosts[p.Default]
+		if !ok {
+			return nil, "", fmt.Errorf("host '%s' not found", p.Default)
+		}
+		backendName = BackendNameOf(hostCfg)
 	}
 	backend, err := interfaces.GetBackend(backendName)
+	if err != nil {
+		return nil, "", err
+	}
+	return backend, backendName, nil
+}
+
+// BackendNameOf returns the backend configured for a host, defaulting to ssh_exec.
+func BackendNameOf(host configd.Host) string {
+	if host.Backend == "" {
+		return "ssh_exec"
+	}
+	return host.Backend
+}
+
+// GetProxyStatus returns runtime information about a proxy.
+func (m *Manager) GetProxyStatus(name string, p configd.Proxy, cfg *configd.Config) (*protocol.StatusResponse, error) {
+	e := m.entry(name)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	backend, backendName, err := backendFor(e, name, p, cfg)
 	if err != nil {
 		return nil, err
 	}
 	pid, running := backend.Status(name)
-	return &protocol.StatusResponse{
-		Name:       name,
-		Backend:    backendName,
-		Running:    running,
-		PID:        pid,
-		ActiveHost: activeHostByProxy[name],
-	}, nil
+	status := &protocol.StatusResponse{
+		Name:           name,
+		Backend:        backendName,
+		Running:        running,
+		PID:            pid,
+		ActiveHost:     e.activeHost,
+		Candidates:     Candidates(name, p, cfg),
+		CandidateIndex: -1,
+		Skipped:        []protocol.HostFailure{},
+	}
+	if rt := e.runtime; rt != nil {
+		status.Candidates = rt.candidates
+		if status.ActiveHost != "" {
+			status.CandidateIndex = rt.index
+		}
+		status.Skipped = rt.skipped
+		if hc := rt.health; hc != nil {
+			status.Health = hc.status
+			status.HealthFailures = hc.failures
+			status.HealthError = hc.lastError
+			status.LatencyMs = hc.latency.Milliseconds()
+			if !hc.lastProbe.IsZero() {
+				status.LastProbe = hc.lastProbe.Format(time.RFC3339)
+			}
+		}
+	}
+	status.State = "stopped"
+	if running {
+		status.State = "running"
+	}
+	if rs := e.restart; rs != nil {
+		rs.prune(time.Now(), restartPolicy(cfg).Window)
+		status.Restarts = len(rs.restarts)
+		status.LastError = rs.lastError
+		switch {
+		case rs.failed:
+			status.State = "failed"
+		case rs.timer != nil:
+			status.State = "backoff"
+			status.NextRestart = rs.nextRestart.Format(time.RFC3339)
+		}
+	}
+	return status, nil
+}
+
+// ListEntry returns the summary of a proxy shown in listings. Proxies
+// whose backend is unavailable are listed as stopped.
+func (m *Manager) ListEntry(name string, p configd.Proxy, cfg *configd.Config) protocol.ProxyListEntry {
+	entry := protocol.ProxyListEntry{
+		Name:         name,
+		Port:         p.Port,
+		State:        "stopped",
+		AllowedHosts: AllowedHosts(name, cfg),
+	}
+	status, err := m.GetProxyStatus(name, p, cfg)
+	if err != nil {
+		return entry
+	}
+	entry.Running = status.Running
+	entry.State = status.State
+	entry.ActiveHost = status.ActiveHost
+	entry.Health = status.Health
+	return entry
 }
 
 // GetProxyInfo returns static and dynamic information about a proxy,
 // including its host, port, backend, credentials, allowed users and active host.
-func GetProxyInfo(name string, p configd.Proxy, cfg *configd.Config) (*protocol.InfoResponse, error) {
-	hostCfg, ok := cfg.Hosts[p.Default]
+// Host details refer to the active host while running, otherwise to the default.
+func (m *Manager) GetProxyInfo(name string, p configd.Proxy, cfg *configd.Config) (*protocol.InfoResponse, error) {
+	e := m.entry(name)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	hostName := e.activeHost
+	if hostName == "" {
+		hostName = p.Default
+	}
+	hostCfg, ok := cfg.Hosts[hostName]
 	if !ok {
 		return nil, fmt.Errorf("host not found")
 	}
-	backend := hostCfg.Backend
-	if backend == "" {
-		backend = "ssh_exec"
-	}
-	be, err := interfaces.GetBackend(backend)
+	be, backend, err := backendFor(e, name, p, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -219,6 +518,6 @@ func GetProxyInfo(name string, p configd.Proxy, cfg *configd.Config) (*protocol.
 		Login:      hostCfg.Login,
 		Running:    running,
 		PID:        pid,
-		ActiveHost: activeHostByProxy[name],
+		ActiveHost: e.activeHost,
 	}, nil
 }
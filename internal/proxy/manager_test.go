@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/logging"
+	"go.uber.org/zap"
+)
+
+// concurrentConfig returns a config with n proxies on the fake host zurich.
+func concurrentConfig(n int) *configd.Config {
+	cfg := &configd.Config{
+		Hosts: map[string]configd.Host{
+			"zurich": {Address: "10.0.0.1", Backend: "fake"},
+		},
+		Proxies: configd.ProxiesConfig{
+			Bind:    "127.0.0.1",
+			Restart: configd.RestartPolicy{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			Proxies: map[string]configd.Proxy{},
+		},
+	}
+	host := cfg.Hosts["zurich"]
+	for i := range n {
+		name := fmt.Sprintf("p%d", i)
+		host.Proxies = append(host.Proxies, name)
+		cfg.Proxies.Proxies[name] = configd.Proxy{Port: 1080 + i, Default: "zurich"}
+	}
+	cfg.Hosts["zurich"] = host
+	return cfg
+}
+
+func TestManagerConcurrentStartStop(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+	interval := stateCheckInterval
+	stateCheckInterval = time.Millisecond
+	t.Cleanup(func() { stateCheckInterval = interval })
+	m := newManager(t)
+
+	cfg := concurrentConfig(4)
+	var wg sync.WaitGroup
+	for name, p := range cfg.Proxies.Proxies {
+		for worker := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 20 {
+					switch (worker + i) % 5 {
+					case 0, 1:
+						_ = m.StartProxy(name, p, cfg)
+					case 2:
+						_ = m.StopProxy(name, p, cfg)
+					case 3:
+						fake.crash(name)
+					case 4:
+						if _, err := m.GetProxyStatus(name, p, cfg); err != nil {
+							t.Errorf("GetProxyStatus(%s) = %v", name, err)
+						}
+						_, _ = m.GetProxyInfo(name, p, cfg)
+						_ = m.ResetProxy(name)
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	// every proxy ends up in a consistent state once stopped
+	for name, p := range cfg.Proxies.Proxies {
+		if err := m.StopProxy(name, p, cfg); err != nil {
+			t.Fatalf("StopProxy(%s) = %v", name, err)
+		}
+		status, err := m.GetProxyStatus(name, p, cfg)
+		if err != nil {
+			t.Fatalf("GetProxyStatus(%s) = %v", name, err)
+		}
+		if status.Running || status.ActiveHost != "" {
+			t.Fatalf("%s after StopProxy: running=%v active=%q", name, status.Running, status.ActiveHost)
+		}
+	}
+}
+
+func TestManagerShutdown(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+	fake.reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewManager(ctx, nil)
+
+	cfg := concurrentConfig(3)
+	for name, p := range cfg.Proxies.Proxies {
+		if err := m.StartProxy(name, p, cfg); err != nil {
+			t.Fatalf("StartProxy(%s) = %v", name, err)
+		}
+	}
+
+	cancel()
+	<-m.Done()
+	for name, p := range cfg.Proxies.Proxies {
+		if _, running := fake.Status(name); running {
+			t.Fatalf("%s still running after shutdown", name)
+		}
+		if err := m.StartProxy(name, p, cfg); !errors.Is(err, ErrManagerClosed) {
+			t.Fatalf("StartProxy(%s) after shutdown = %v, want ErrManagerClosed", name, err)
+		}
+	}
+}
+
+func TestStartProxyOnFailureClearsRuntime(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+	m := newManager(t)
+
+	cfg := restartConfig()
+	cfg.Hosts["berlin"] = configd.Host{Address: "10.0.0.2", Backend: "fake", Proxies: []string{"pp"}}
+	p := cfg.Proxies.Proxies["pp"]
+	p.Fallback = []string{"berlin"}
+	cfg.Proxies.Proxies["pp"] = p
+	fake.setFail("zurich", true)
+	fake.setFail("berlin", true)
+
+	if err := m.StartProxyOn("pp", p, cfg, []string{"berlin"}); err == nil {
+		t.Fatal("m.StartProxyOn() succeeded with every candidate failing")
+	}
+	status, err := m.GetProxyStatus("pp", p, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != "stopped" || status.CandidateIndex != -1 || len(status.Skipped) != 0 ||
+		fmt.Sprint(status.Candidates) != "[zurich berlin]" {
+		t.Errorf("status = %+v, want a stopped proxy without failover progress", status)
+	}
+	if active, _ := m.active("pp"); active {
+		t.Error("proxy reported active after a failed start")
+	}
+}
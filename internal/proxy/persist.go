@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/state"
+)
+
+// persistDesired records whether a proxy should be running and on which
+// host. host is only kept when it differs from the configured default.
+// Nothing is persisted when the manager has no state store.
+func (m *Manager) persistDesired(name string, running bool, host string, cfg *configd.Config) {
+	s := m.store
+	if s == nil {
+		return
+	}
+	if p, ok := cfg.Proxies.Proxies[name]; ok && p.Default == host {
+		host = ""
+	}
+	if err := s.SetProxy(name, state.ProxyState{Running: running, Host: host}); err != nil {
+		logging.Log.Warnf("[proxy] Failed to persist state of '%s': %v", name, err)
+	}
+}
+
+// Reconcile brings proxies to the state persisted before the last shutdown.
+// Proxies recorded as running are started on their chosen host, proxies
+// recorded as stopped stay stopped even if autostart is set, and proxies
+// without a record fall back to their autostart setting. Records of proxies
+// that are no longer configured are dropped.
+func (m *Manager) Reconcile(cfg *configd.Config) {
+	saved := map[string]state.ProxyState{}
+	if s := m.store; s != nil {
+		saved = s.Proxies()
+		for name := range saved {
+			if _, ok := cfg.Proxies.Proxies[name]; !ok {
+				logging.Log.Infof("[proxy] Dropping saved state of unknown proxy '%s'", name)
+				_ = s.DeleteProxy(name)
+			}
+		}
+	}
+
+	var names []string
+	for name := range cfg.Proxies.Proxies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := cfg.Proxies.Proxies[name]
+		ps, known := saved[name]
+
+		switch {
+		case known && !ps.Running:
+			logging.Log.Infof("[proxy] '%s' was stopped before shutdown, leaving it stopped", name)
+			continue
+		case known:
+			if ps.Host != "" {
+				if slices.Contains(Candidates(name, configd.Proxy{Default: ps.Host}, cfg), ps.Host) {
+					p.Default = ps.Host
+				} else {
+					logging.Log.Warnf("[proxy] Saved host '%s' of '%s' is no longer allowed, using '%s'", ps.Host, name, p.Default)
+				}
+			}
+			logging.Log.Infof("[proxy] Restoring '%s' on host '%s'", name, p.Default)
+		case p.Autostart:
+			logging.Log.Infof("[proxy] Autostart enabled for '%s'", name)
+		default:
+			continue
+		}
+
+		if err := m.StartProxy(name, p, cfg); err != nil {
+			logging.Log.Warnf("[proxy] Failed to start '%s': %v", name, err)
+		} else {
+			logging.Log.Infof("[proxy] Proxy '%s' started", name)
+		}
+	}
+}
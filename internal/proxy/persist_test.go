@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/internal/state"
+	"go.uber.org/zap"
+)
+
+func TestStartProxyOnPersistsDefault(t *testing.T) {
+	cfg := restartConfig()
+	cfg.Hosts["berlin"] = configd.Host{Address: "10.0.0.2", Backend: "fake", Proxies: []string{"pp"}}
+	p := cfg.Proxies.Proxies["pp"]
+	p.Fallback = []string{"berlin"}
+	cfg.Proxies.Proxies["pp"] = p
+
+	store, err := state.Open(filepath.Join(t.TempDir(), state.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewManager(ctx, store)
+	t.Cleanup(func() {
+		cancel()
+		<-m.Done()
+	})
+
+	// a caller only allowed on berlin
+	if err := m.StartProxyOn("pp", p, cfg, []string{"berlin"}); err != nil {
+		t.Fatalf("m.StartProxyOn() = %v", err)
+	}
+	status, err := m.GetProxyStatus("pp", p, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.ActiveHost != "berlin" {
+		t.Errorf("active host = %q, want berlin", status.ActiveHost)
+	}
+
+	ps, ok := store.Proxy("pp")
+	if !ok || !ps.Running {
+		t.Fatalf("persisted state = %+v, %t, want running", ps, ok)
+	}
+	if ps.Host != "" {
+		t.Errorf("persisted host = %q, want the configured default", ps.Host)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+
+	tests := []struct {
+		name    string
+		saved   map[string]state.ProxyState
+		running map[string]string // proxy -> host it runs on
+		kept    []string          // saved records left after reconciling
+	}{
+		{
+			name:    "no saved state uses autostart",
+			running: map[string]string{"auto": "zurich"},
+		},
+		{
+			name:    "saved active host restored",
+			saved:   map[string]state.ProxyState{"pp": {Running: true, Host: "berlin"}},
+			running: map[string]string{"pp": "berlin", "auto": "zurich"},
+			kept:    []string{"pp"},
+		},
+		{
+			name:    "saved stop overrides autostart",
+			saved:   map[string]state.ProxyState{"auto": {Running: false}},
+			running: map[string]string{},
+			kept:    []string{"auto"},
+		},
+		{
+			name:    "removed host falls back to default",
+			saved:   map[string]state.ProxyState{"pp": {Running: true, Host: "paris"}},
+			running: map[string]string{"pp": "zurich", "auto": "zurich"},
+			kept:    []string{"pp"},
+		},
+		{
+			name:    "removed proxy dropped",
+			saved:   map[string]state.ProxyState{"gone": {Running: true}, "pp": {Running: true}},
+			running: map[string]string{"pp": "zurich", "auto": "zurich"},
+			kept:    []string{"pp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := restartConfig()
+			cfg.Hosts["zurich"] = configd.Host{Address: "10.0.0.1", Backend: "fake", Proxies: []string{"pp", "auto"}}
+			cfg.Hosts["berlin"] = configd.Host{Address: "10.0.0.2", Backend: "fake", Proxies: []string{"pp"}}
+			cfg.Proxies.Proxies["auto"] = configd.Proxy{Port: 1081, Default: "zurich", Autostart: true}
+
+			path := filepath.Join(t.TempDir(), state.FileName)
+			store, err := state.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, ps := range tt.saved {
+				if err := store.SetProxy(name, ps); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fake.reset()
+			ctx, cancel := context.WithCancel(context.Background())
+			m := NewManager(ctx, store)
+			t.Cleanup(func() {
+				cancel()
+				<-m.Done()
+			})
+
+			m.Reconcile(cfg)
+
+			for name, p := range cfg.Proxies.Proxies {
+				status, err := m.GetProxyStatus(name, p, cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := tt.running[name]; status.ActiveHost != want || status.Running != (want != "") {
+					t.Errorf("%s: running=%t on %q, want %q", name, status.Running, status.ActiveHost, want)
+				}
+			}
+			reopened, err := state.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name := range tt.saved {
+				_, ok := reopened.Proxy(name)
+				if want := slices.Contains(tt.kept, name); ok != want {
+					t.Errorf("saved state of %s kept = %t, want %t", name, ok, want)
+				}
+			}
+		})
+	}
+}
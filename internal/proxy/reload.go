@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/logging"
+)
+
+// Changes lists the proxies affected by applying a new configuration.
+type Changes struct {
+	Started   []string
+	Stopped   []string
+	Restarted []string
+	Errors    []string
+}
+
+// runtimeConfig is the part of the configuration a running proxy depends
+// on. Access rules and autostart are left out, changing them does not
+// require a restart.
+type runtimeConfig struct {
+	Proxy    configd.Proxy
+	Bind     string
+	Restart  configd.RestartPolicy
+	Health   configd.HealthCheck
+	HostKeys configd.HostKeysConfig
+	Hosts    map[string]configd.Host
+	Logins   map[string]configd.Login
+	Backends map[string]map[string]any
+}
+
+// runtimeConfigOf collects the effective configuration of the named proxy,
+// including every host it may run on and their logins and backends.
+func runtimeConfigOf(name string, p configd.Proxy, cfg *configd.Config) runtimeConfig {
+	p.ACLs = acl.ACLRuleSet{}
+	p.Autostart = false
+	rc := runtimeConfig{
+		Proxy:    p,
+		Bind:     cfg.Proxies.Bind,
+		Restart:  cfg.Proxies.Restart,
+		Health:   cfg.Proxies.Health,
+		HostKeys: cfg.HostKeys,
+		Hosts:    map[string]configd.Host{},
+		Logins:   map[string]configd.Login{},
+		Backends: map[string]map[string]any{},
+	}
+	for _, hostName := range append(Candidates(name, p, cfg), AllowedHosts(name, cfg)...) {
+		host := cfg.Hosts[hostName]
+		host.ACLs = acl.ACLRuleSet{}
+		rc.Hosts[hostName] = host
+		if login, ok := cfg.Logins[host.Login]; ok {
+			rc.Logins[host.Login] = login
+		}
+		backendName := BackendNameOf(host)
+		backend := mergeConfig(cfg.Backends[backendName], nil)
+		delete(backend, configd.BackendACLKey)
+		rc.Backends[backendName] = backend
+	}
+	return rc
+}
+
+// Changed reports whether the effective configuration of the named proxy
+// differs between old and next.
+func Changed(name string, old, next *configd.Config) bool {
+	oldP, inOld := old.Proxies.Proxies[name]
+	nextP, inNext := next.Proxies.Proxies[name]
+	if inOld != inNext {
+		return true
+	}
+	return !reflect.DeepEqual(runtimeConfigOf(name, oldP, old), runtimeConfigOf(name, nextP, next))
+}
+
+// Apply brings the proxies from configuration old to next. Removed proxies
+// are stopped and their saved state is dropped, added proxies are started
+// if autostart is set and active proxies whose effective configuration
+// changed are restarted, on the host they ran on while it is still a
+// candidate and their default did not change, otherwise on the new
+// default. All other proxies keep running untouched.
+func (m *Manager) Apply(old, next *configd.Config) Changes {
+	var ch Changes
+	fail := func(name string, err error) {
+		logging.Log.Warnf("[proxy] Applying config to '%s' failed: %v", name, err)
+		ch.Errors = append(ch.Errors, fmt.Sprintf("proxy '%s': %v", name, err))
+	}
+
+	var names []string
+	for name := range old.Proxies.Proxies {
+		names = append(names, name)
+	}
+	for name := range next.Proxies.Proxies {
+		if _, ok := old.Proxies.Proxies[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		oldP, inOld := old.Proxies.Proxies[name]
+		nextP, inNext := next.Proxies.Proxies[name]
+		active, host := m.active(name)
+
+		switch {
+		case !inNext:
+			if active {
+				if err := m.StopProxy(name, oldP, old); err != nil {
+					fail(name, err)
+					continue
+				}
+				ch.Stopped = append(ch.Stopped, name)
+			}
+			m.forget(name)
+			logging.Log.Infof("[proxy] '%s' was removed from the config", name)
+		case !inOld:
+			if !nextP.Autostart {
+				continue
+			}
+			if err := m.StartProxy(name, nextP, next); err != nil {
+				fail(name, err)
+				continue
+			}
+			ch.Started = append(ch.Started, name)
+		case !Changed(name, old, next):
+			m.rebind(name, nextP, next)
+		case active:
+			logging.Log.Infof("[proxy] Config of '%s' changed, restarting", name)
+			if host != "" && oldP.Default == nextP.Default && slices.Contains(Candidates(name, configd.Proxy{Default: host}, next), host) {
+				nextP.Default = host
+			}
+			_ = m.StopProxy(name, oldP, old)
+			if err := m.StartProxy(name, nextP, next); err != nil {
+				fail(name, err)
+				continue
+			}
+			ch.Restarted = append(ch.Restarted, name)
+		}
+	}
+	return ch
+}
+
+// active reports whether a proxy is running or waiting for a restart and
+// the host it runs on or ran on last.
+func (m *Manager) active(name string) (bool, string) {
+	e := m.entry(name)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	backoff := e.restart != nil && e.restart.timer != nil
+	host := e.activeHost
+	if host == "" {
+		host = e.lastHost
+	}
+	return e.activeHost != "" || backoff, host
+}
+
+// rebind points a proxy whose effective configuration did not change to
+// the new configuration, so restarts and access checks use it.
+func (m *Manager) rebind(name string, p configd.Proxy, cfg *configd.Config) {
+	e := m.entry(name)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if rt := e.runtime; rt != nil {
+		rt.proxy.ACLs = p.ACLs
+		rt.proxy.Autostart = p.Autostart
+		rt.cfg = cfg
+	}
+}
+
+// forget drops all runtime and saved state of a proxy removed from the
+// configuration.
+func (m *Manager) forget(name string) {
+	m.mu.Lock()
+	e := m.proxies[name]
+	delete(m.proxies, name)
+	m.mu.Unlock()
+
+	if e != nil {
+		e.mu.Lock()
+		cancelRestart(e)
+		e.mu.Unlock()
+	}
+	if s := m.store; s != nil {
+		if err := s.DeleteProxy(name); err != nil {
+			logging.Log.Warnf("[proxy] Failed to drop saved state of '%s': %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/acl"
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/logging"
+	"go.uber.org/zap"
+)
+
+// reloadConfig returns a config with the proxies pp (zurich, then berlin),
+// dev (berlin) and idle (zurich, never started).
+func reloadConfig() *configd.Config {
+	host := func(address string, proxies ...string) configd.Host {
+		return configd.Host{Address: address, Backend: "fake", Proxies: proxies}
+	}
+	return &configd.Config{
+		Hosts: map[string]configd.Host{
+			"zurich": host("10.0.0.1", "pp", "idle"),
+			"berlin": host("10.0.0.2", "pp", "dev"),
+			"paris":  host("10.0.0.3", "pp"),
+			"oslo":   host("10.0.0.4", "other"),
+		},
+		Proxies: configd.ProxiesConfig{
+			Bind: "127.0.0.1",
+			Proxies: map[string]configd.Proxy{
+				"pp":   {Port: 1080, Default: "zurich", Fallback: []string{"berlin"}},
+				"dev":  {Port: 1081, Default: "berlin"},
+				"idle": {Port: 1082, Default: "zurich"},
+			},
+		},
+	}
+}
+
+func TestApply(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+
+	tests := []struct {
+		name      string
+		setup     func() // runs after the initial start
+		change    func(*configd.Config)
+		want      Changes
+		wantStart []string
+	}{
+		{
+			name:   "unchanged",
+			change: func(cfg *configd.Config) {},
+		},
+		{
+			name: "acl and autostart apply in place",
+			change: func(cfg *configd.Config) {
+				p := cfg.Proxies.Proxies["pp"]
+				p.ACLs = acl.ACLRuleSet{Rules: []acl.ACLRule{{Subjects: []string{"ops"}}}}
+				p.Autostart = true
+				cfg.Proxies.Proxies["pp"] = p
+				h := cfg.Hosts["zurich"]
+				h.ACLs = acl.ACLRuleSet{Rules: []acl.ACLRule{{Subjects: []string{"ops"}}}}
+				cfg.Hosts["zurich"] = h
+			},
+		},
+		{
+			name: "port change restarts",
+			change: func(cfg *configd.Config) {
+				p := cfg.Proxies.Proxies["pp"]
+				p.Port = 1090
+				cfg.Proxies.Proxies["pp"] = p
+			},
+			want:      Changes{Restarted: []string{"pp"}},
+			wantStart: []string{"pp@zurich"},
+		},
+		{
+			name: "restart keeps the current host",
+			setup: func() {
+				fake.setFail("zurich", false)
+			},
+			change: func(cfg *configd.Config) {
+				h := cfg.Hosts["berlin"]
+				h.Port = 2222
+				cfg.Hosts["berlin"] = h
+			},
+			want:      Changes{Restarted: []string{"dev", "pp"}},
+			wantStart: []string{"dev@berlin", "pp@berlin"},
+		},
+		{
+			name: "default change moves the proxy",
+			setup: func() {
+				fake.setFail("zurich", false)
+			},
+			change: func(cfg *configd.Config) {
+				p := cfg.Proxies.Proxies["pp"]
+				p.Default, p.Fallback = "paris", []string{"berlin"}
+				cfg.Proxies.Proxies["pp"] = p
+			},
+			want:      Changes{Restarted: []string{"pp"}},
+			wantStart: []string{"pp@paris"},
+		},
+		{
+			name: "host no longer allows the proxy",
+			setup: func() {
+				fake.setFail("zurich", false)
+			},
+			change: func(cfg *configd.Config) {
+				h := cfg.Hosts["berlin"]
+				h.Proxies = []string{"dev"}
+				cfg.Hosts["berlin"] = h
+			},
+			want:      Changes{Restarted: []string{"dev", "pp"}},
+			wantStart: []string{"dev@berlin", "pp@zurich"},
+		},
+		{
+			name: "change of an unrelated host",
+			change: func(cfg *configd.Config) {
+				h := cfg.Hosts["oslo"]
+				h.Port = 2222
+				cfg.Hosts["oslo"] = h
+			},
+		},
+		{
+			name: "inactive proxy is not started",
+			change: func(cfg *configd.Config) {
+				p := cfg.Proxies.Proxies["idle"]
+				p.Port = 1092
+				cfg.Proxies.Proxies["idle"] = p
+			},
+		},
+		{
+			name: "removed proxy is stopped",
+			change: func(cfg *configd.Config) {
+				delete(cfg.Proxies.Proxies, "dev")
+			},
+			want: Changes{Stopped: []string{"dev"}},
+		},
+		{
+			name: "added proxies",
+			change: func(cfg *configd.Config) {
+				cfg.Proxies.Proxies["other"] = configd.Proxy{Port: 1083, Default: "oslo", Autostart: true}
+				cfg.Proxies.Proxies["manual"] = configd.Proxy{Port: 1084, Default: "oslo"}
+			},
+			want:      Changes{Started: []string{"other"}},
+			wantStart: []string{"other@oslo"},
+		},
+		{
+			name: "failed restart is reported",
+			change: func(cfg *configd.Config) {
+				p := cfg.Proxies.Proxies["dev"]
+				p.Port = 1091
+				cfg.Proxies.Proxies["dev"] = p
+				fake.setFail("berlin", true)
+			},
+			want: Changes{Errors: []string{"proxy 'dev': host 'berlin' unreachable"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newManager(t)
+
+			old := reloadConfig()
+			if tt.setup != nil {
+				// start pp on its fallback, zurich comes back afterwards
+				fake.setFail("zurich", true)
+			}
+			for _, name := range []string{"pp", "dev"} {
+				if err := m.StartProxy(name, old.Proxies.Proxies[name], old); err != nil {
+					t.Fatalf("StartProxy(%s) = %v", name, err)
+				}
+			}
+			if tt.setup != nil {
+				tt.setup()
+			}
+			fake.takeStarts()
+
+			next := reloadConfig()
+			tt.change(next)
+			got := m.Apply(old, next)
+
+			if !slices.Equal(got.Started, tt.want.Started) || !slices.Equal(got.Stopped, tt.want.Stopped) ||
+				!slices.Equal(got.Restarted, tt.want.Restarted) || !slices.Equal(got.Errors, tt.want.Errors) {
+				t.Fatalf("Apply() = %+v, want %+v", got, tt.want)
+			}
+			if starts := fake.takeStarts(); !slices.Equal(starts, tt.wantStart) {
+				t.Fatalf("backend starts = %v, want %v", starts, tt.wantStart)
+			}
+		})
+	}
+}
+
+func TestApplyRebind(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+	m := newManager(t)
+
+	old := reloadConfig()
+	if err := m.StartProxy("pp", old.Proxies.Proxies["pp"], old); err != nil {
+		t.Fatal(err)
+	}
+
+	next := reloadConfig()
+	p := next.Proxies.Proxies["pp"]
+	p.ACLs = acl.ACLRuleSet{Rules: []acl.ACLRule{{Subjects: []string{"ops"}}}}
+	next.Proxies.Proxies["pp"] = p
+	m.Apply(old, next)
+
+	e := m.entry("pp")
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.runtime == nil || e.runtime.cfg != next || len(e.runtime.proxy.ACLs.Rules) != 1 {
+		t.Fatal("running proxy was not rebound to the new config")
+	}
+	if e.activeHost != "zurich" {
+		t.Fatalf("active host = %q, want zurich", e.activeHost)
+	}
+}
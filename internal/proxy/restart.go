@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/events"
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/protocol"
+)
+
+// ErrProxyFailed is returned when starting a proxy that was given up on
+// after too many restarts. It is cleared via ResetProxy.
+var ErrProxyFailed = errors.New("proxy is in failed state")
+
+// Defaults applied to unset fields of configd.RestartPolicy.
+const (
+	defaultRestartInitialDelay = time.Second
+	defaultRestartMaxDelay     = 2 * time.Minute
+	defaultRestartMultiplier   = 2.0
+	defaultRestartJitter       = 0.2
+	defaultRestartMaxRestarts  = 5
+	defaultRestartWindow       = 10 * time.Minute
+)
+
+// restartState records recent restarts and the pending restart of a proxy.
+// Unlike proxyRuntime it survives StopProxy so that a failed proxy stays
+// failed until Reset.
+type restartState struct {
+	restarts    []time.Time
+	failed      bool
+	lastError   string
+	timer       *time.Timer
+	nextRestart time.Time
+}
+
+// prune drops restarts that happened before the window.
+func (rs *restartState) prune(now time.Time, window time.Duration) {
+	cutoff := now.Add(-window)
+	kept := rs.restarts[:0]
+	for _, t := range rs.restarts {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	rs.restarts = kept
+}
+
+// restartPolicy returns the configured restart policy with defaults applied.
+func restartPolicy(cfg *configd.Config) configd.RestartPolicy {
+	p := cfg.Proxies.Restart
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = defaultRestartInitialDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultRestartMaxDelay
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = defaultRestartMultiplier
+	}
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = defaultRestartJitter
+	}
+	if p.MaxRestarts <= 0 {
+		p.MaxRestarts = defaultRestartMaxRestarts
+	}
+	if p.Window <= 0 {
+		p.Window = defaultRestartWindow
+	}
+	return p
+}
+
+// backoffDelay returns the delay before the n-th restart (1-based) with jitter applied.
+func backoffDelay(p configd.RestartPolicy, n int) time.Duration {
+	delay := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(n-1))
+	if delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
+
+// scheduleRestart arranges a delayed restart of rt beginning at candidate
+// index from, or marks the proxy failed once the restart budget is spent.
+// The caller must hold e.mu.
+func (m *Manager) scheduleRestart(name string, e *proxyEntry, rt *proxyRuntime, from int, reason string) {
+	rs := e.restart
+	if rs == nil {
+		rs = &restartState{}
+		e.restart = rs
+	}
+
+	policy := restartPolicy(rt.cfg)
+	now := time.Now()
+	rs.prune(now, policy.Window)
+	rs.lastError = reason
+
+	if len(rs.restarts) >= policy.MaxRestarts {
+		rs.failed = true
+		logging.Log.Errorf("[proxy] '%s' restarted %d times within %s, giving up: %s",
+			name, len(rs.restarts), policy.Window, reason)
+		events.Publish(protocol.Event{Type: protocol.EventProxyFailed, Proxy: name, Message: reason})
+		return
+	}
+
+	rs.restarts = append(rs.restarts, now)
+	delay := backoffDelay(policy, len(rs.restarts))
+	rs.nextRestart = now.Add(delay)
+	logging.Log.Infof("[proxy] Restarting '%s' in %s (attempt %d/%d)",
+		name, delay.Round(time.Millisecond), len(rs.restarts), policy.MaxRestarts)
+
+	rs.timer = time.AfterFunc(delay, func() {
+		m.restart(name, e, rt, from)
+	})
+}
+
+// restart performs a scheduled restart unless the proxy was stopped or
+// restarted in the meantime.
+func (m *Manager) restart(name string, e *proxyEntry, rt *proxyRuntime, from int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.runtime != rt || m.ctx.Err() != nil {
+		return
+	}
+	if e.restart != nil {
+		e.restart.timer = nil
+	}
+
+	if from >= len(rt.candidates) {
+		from = 0
+	}
+	if err := m.startFrom(name, e, rt, from); err != nil {
+		logging.Log.Infof("[proxy] Restart of '%s' failed: %v", name, err)
+		m.scheduleRestart(name, e, rt, 0, err.Error())
+		return
+	}
+	logging.Log.Infof("[proxy] Restarted '%s' successfully", name)
+	events.Publish(protocol.Event{Type: protocol.EventProxyRestarted, Proxy: name, Host: e.activeHost})
+}
+
+// cancelRestart stops a pending restart. The caller must hold e.mu.
+func cancelRestart(e *proxyEntry) {
+	if rs := e.restart; rs != nil && rs.timer != nil {
+		rs.timer.Stop()
+		rs.timer = nil
+	}
+}
+
+// ResetProxy clears the failed state and restart history of a proxy.
+// A proxy that is not running also loses its failover history.
+func (m *Manager) ResetProxy(name string) error {
+	e := m.entry(name)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.restart == nil && e.runtime == nil {
+		return nil
+	}
+
+	cancelRestart(e)
+	e.restart = nil
+
+	if e.activeHost == "" {
+		e.runtime = nil
+	}
+
+	logging.Log.Infof("[proxy] Reset restart state of '%s'", name)
+	return nil
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/logging"
+	"go.uber.org/zap"
+)
+
+// restartConfig returns a config with proxy pp on the fake host zurich and
+// a fast restart policy allowing two restarts.
+func restartConfig() *configd.Config {
+	return &configd.Config{
+		Hosts: map[string]configd.Host{
+			"zurich": {Address: "10.0.0.1", Backend: "fake", Proxies: []string{"pp"}},
+		},
+		Proxies: configd.ProxiesConfig{
+			Bind: "127.0.0.1",
+			Restart: configd.RestartPolicy{
+				InitialDelay: time.Millisecond,
+				MaxDelay:     5 * time.Millisecond,
+				MaxRestarts:  2,
+				Window:       time.Minute,
+			},
+			Proxies: map[string]configd.Proxy{
+				"pp": {Port: 1080, Default: "zurich", FailoverAfter: 100},
+			},
+		},
+	}
+}
+
+// newManager returns a manager without state store that is shut down
+// when the test ends.
+func newManager(t *testing.T) *Manager {
+	t.Helper()
+	fake.reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewManager(ctx, nil)
+	t.Cleanup(func() {
+		cancel()
+		<-m.Done()
+	})
+	return m
+}
+
+// waitForState polls the status of proxy pp until it reports state.
+func waitForState(t *testing.T, m *Manager, cfg *configd.Config, state string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status, err := m.GetProxyStatus("pp", cfg.Proxies.Proxies["pp"], cfg)
+		if err != nil {
+			t.Fatalf("m.GetProxyStatus() = %v", err)
+		}
+		if status.State == state {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("state = %q, want %q", status.State, state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	policy := configd.RestartPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     10 * time.Second,
+		Multiplier:   2,
+		Jitter:       0.1,
+	}
+
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{20, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 50 {
+			got := backoffDelay(policy, tt.n)
+			low := time.Duration(float64(tt.want) * 0.9)
+			high := time.Duration(float64(tt.want) * 1.1)
+			if got < low || got > high {
+				t.Fatalf("backoffDelay(%d) = %s, want %s +/- 10%%", tt.n, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestRestartPolicyDefaults(t *testing.T) {
+	got := restartPolicy(&configd.Config{})
+	want := configd.RestartPolicy{
+		InitialDelay: defaultRestartInitialDelay,
+		MaxDelay:     defaultRestartMaxDelay,
+		Multiplier:   defaultRestartMultiplier,
+		Jitter:       defaultRestartJitter,
+		MaxRestarts:  defaultRestartMaxRestarts,
+		Window:       defaultRestartWindow,
+	}
+	if got != want {
+		t.Fatalf("restartPolicy() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCrashLoopAndReset(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+	m := newManager(t)
+
+	cfg := restartConfig()
+	p := cfg.Proxies.Proxies["pp"]
+	if err := m.StartProxy("pp", p, cfg); err != nil {
+		t.Fatalf("m.StartProxy() = %v", err)
+	}
+
+	// every exit within the budget is followed by a restart
+	for i := 1; i <= 2; i++ {
+		fake.crash("pp")
+		waitForState(t, m, cfg, "running")
+		status, _ := m.GetProxyStatus("pp", p, cfg)
+		if status.Restarts != i {
+			t.Fatalf("Restarts = %d, want %d", status.Restarts, i)
+		}
+	}
+
+	// the next exit exceeds max_restarts and leaves the proxy failed
+	fake.crash("pp")
+	waitForState(t, m, cfg, "failed")
+	status, _ := m.GetProxyStatus("pp", p, cfg)
+	if status.LastError == "" {
+		t.Fatal("LastError is empty for a failed proxy")
+	}
+	if err := m.StartProxy("pp", p, cfg); !errors.Is(err, ErrProxyFailed) {
+		t.Fatalf("m.StartProxy() of a failed proxy = %v, want ErrProxyFailed", err)
+	}
+
+	if err := m.ResetProxy("pp"); err != nil {
+		t.Fatalf("m.ResetProxy() = %v", err)
+	}
+	waitForState(t, m, cfg, "stopped")
+	if err := m.StartProxy("pp", p, cfg); err != nil {
+		t.Fatalf("m.StartProxy() after reset = %v", err)
+	}
+	status, _ = m.GetProxyStatus("pp", p, cfg)
+	if status.State != "running" || status.Restarts != 0 {
+		t.Fatalf("status after reset = %s with %d restarts, want running with 0", status.State, status.Restarts)
+	}
+}
+
+func TestStopCancelsRestart(t *testing.T) {
+	logging.Log = zap.NewNop().Sugar()
+	m := newManager(t)
+
+	cfg := restartConfig()
+	cfg.Proxies.Restart.InitialDelay = time.Hour
+	cfg.Proxies.Restart.MaxDelay = time.Hour
+	p := cfg.Proxies.Proxies["pp"]
+	if err := m.StartProxy("pp", p, cfg); err != nil {
+		t.Fatalf("m.StartProxy() = %v", err)
+	}
+
+	fake.crash("pp")
+	waitForState(t, m, cfg, "backoff")
+	if err := m.StopProxy("pp", p, cfg); err != nil {
+		t.Fatalf("m.StopProxy() = %v", err)
+	}
+	waitForState(t, m, cfg, "stopped")
+}
@@ -0,0 +1,109 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Connect dials the SOCKS5 server at proxyAddr and asks it to CONNECT to
+// target (host:port). The whole handshake is bounded by timeout. On success
+// the returned connection is relayed to target by the server.
+func Connect(proxyAddr, target string, timeout time.Duration) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target port %q", portStr)
+	}
+
+	conn, err := net.DialTimeout("tcp", proxyAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := clientHandshake(conn, host, uint16(port)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// clientHandshake performs the no-auth greeting and a CONNECT request.
+func clientHandshake(conn net.Conn, host string, port uint16) error {
+	if _, err := conn.Write([]byte{version5, 1, methodNoAuth}); err != nil {
+		return fmt.Errorf("write greeting: %w", err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("read method: %w", err)
+	}
+	if reply[0] != version5 {
+		return fmt.Errorf("unsupported socks version %d", reply[0])
+	}
+	if reply[1] != methodNoAuth {
+		return errors.New("no acceptable authentication method")
+	}
+
+	req := []byte{version5, cmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, atypIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, atypIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %s", host)
+		}
+		req = append(req, atypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("write request: %w", err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("read reply: %w", err)
+	}
+	if header[0] != version5 {
+		return fmt.Errorf("unsupported socks version %d", header[0])
+	}
+	if header[1] != repSucceeded {
+		return fmt.Errorf("connect to %s refused by proxy (reply %d)", net.JoinHostPort(host, strconv.Itoa(int(port))), header[1])
+	}
+
+	// skip the bound address
+	var skip int
+	switch header[3] {
+	case atypIPv4:
+		skip = net.IPv4len
+	case atypIPv6:
+		skip = net.IPv6len
+	case atypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("unsupported address type %d", header[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip+2)); err != nil {
+		return fmt.Errorf("read bound address: %w", err)
+	}
+	return nil
+}
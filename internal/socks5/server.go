@@ -0,0 +1,194 @@
+// Package socks5 provides a minimal SOCKS5 server used by in-process proxy
+// backends. It supports the unauthenticated method and the CONNECT command,
+// which is all a dynamic port forward needs. The matching client handshake
+// is used to probe the health of running proxies.
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Protocol constants as defined in RFC 1928.
+const (
+	version5 = 0x05
+
+	methodNoAuth       = 0x00
+	methodNoAcceptable = 0xff
+
+	cmdConnect = 0x01
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	repSucceeded           = 0x00
+	repConnectionRefused   = 0x05
+	repCommandNotSupported = 0x07
+	repAddrNotSupported    = 0x08
+)
+
+// handshakeTimeout bounds the negotiation and request of a client, so idle
+// or stalled connections do not tie up the listener.
+const handshakeTimeout = 10 * time.Second
+
+// DialFunc opens an outgoing connection on behalf of a SOCKS client.
+type DialFunc func(network, addr string) (net.Conn, error)
+
+// Serve accepts SOCKS5 clients on ln until the listener is closed.
+// Each CONNECT request is fulfilled through dial.
+func Serve(ln net.Listener, dial DialFunc) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = handle(conn, dial)
+		}()
+	}
+}
+
+// handle runs the SOCKS5 negotiation for a single client connection and
+// relays traffic once the upstream connection has been established.
+func handle(conn net.Conn, dial DialFunc) error {
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := negotiate(conn); err != nil {
+		return err
+	}
+
+	target, err := readRequest(conn)
+	if err != nil {
+		return err
+	}
+
+	upstream, err := dial("tcp", target)
+	if err != nil {
+		_ = writeReply(conn, repConnectionRefused)
+		return fmt.Errorf("dial %s: %w", target, err)
+	}
+	defer upstream.Close()
+
+	if err := writeReply(conn, repSucceeded); err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	relay(conn, upstream)
+	return nil
+}
+
+// negotiate reads the client greeting and selects the no-auth method.
+func negotiate(conn net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("read greeting: %w", err)
+	}
+	if header[0] != version5 {
+		return fmt.Errorf("unsupported socks version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return fmt.Errorf("read methods: %w", err)
+	}
+
+	for _, m := range methods {
+		if m == methodNoAuth {
+			_, err := conn.Write([]byte{version5, methodNoAuth})
+			return err
+		}
+	}
+
+	_, _ = conn.Write([]byte{version5, methodNoAcceptable})
+	return errors.New("no acceptable authentication method")
+}
+
+// readRequest parses a CONNECT request and returns the target as host:port.
+func readRequest(conn net.Conn) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("read request: %w", err)
+	}
+	if header[0] != version5 {
+		return "", fmt.Errorf("unsupported socks version %d", header[0])
+	}
+	if header[1] != cmdConnect {
+		_ = writeReply(conn, repCommandNotSupported)
+		return "", fmt.Errorf("unsupported command %d", header[1])
+	}
+
+	var host string
+	switch header[3] {
+	case atypIPv4:
+		addr := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case atypIPv6:
+		addr := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case atypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		_ = writeReply(conn, repAddrNotSupported)
+		return "", fmt.Errorf("unsupported address type %d", header[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// writeReply sends a reply with an unspecified IPv4 bind address.
+func writeReply(conn net.Conn, rep byte) error {
+	_, err := conn.Write([]byte{version5, rep, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// relay copies data in both directions until either side closes.
+func relay(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go pipe(a, b)
+	go pipe(b, a)
+	<-done
+	<-done
+}
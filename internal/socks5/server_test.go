@@ -0,0 +1,132 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// echoServer accepts connections on a local port and writes back whatever
+// it reads.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// socksServer serves SOCKS5 on a local port until the test ends.
+func socksServer(t *testing.T, dial DialFunc) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- Serve(ln, dial) }()
+	t.Cleanup(func() {
+		ln.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve() = %v", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	echo := echoServer(t)
+	_, port, _ := net.SplitHostPort(echo)
+
+	dialed := make(chan string, 2)
+	proxy := socksServer(t, func(network, addr string) (net.Conn, error) {
+		dialed <- addr
+		if addr == net.JoinHostPort("echo.test", port) {
+			addr = echo
+		}
+		return net.Dial(network, addr)
+	})
+
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"ipv4", echo},
+		{"domain", net.JoinHostPort("echo.test", port)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := Connect(proxy, tt.target, 5*time.Second)
+			if err != nil {
+				t.Fatalf("Connect() = %v", err)
+			}
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != "ping" {
+				t.Fatalf("echo = %q, want %q", buf, "ping")
+			}
+			if got := <-dialed; got != tt.target {
+				t.Fatalf("server dialed %q, want %q", got, tt.target)
+			}
+		})
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	proxy := socksServer(t, func(network, addr string) (net.Conn, error) {
+		return nil, errors.New("unreachable")
+	})
+
+	conn, err := Connect(proxy, "127.0.0.1:9", 5*time.Second)
+	if err == nil {
+		conn.Close()
+		t.Fatal("Connect() succeeded, want refusal")
+	}
+}
+
+func TestUnsupportedMethod(t *testing.T) {
+	proxy := socksServer(t, net.Dial)
+
+	conn, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// offer username/password only
+	if _, err := conn.Write([]byte{version5, 1, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[1] != methodNoAcceptable {
+		t.Fatalf("method = %#x, want %#x", reply[1], methodNoAcceptable)
+	}
+}
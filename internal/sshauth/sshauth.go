@@ -0,0 +1,147 @@
+// Package sshauth turns login definitions into SSH authentication methods.
+// It is shared by the SSH backends and by config validation so that a
+// broken key or certificate is reported at load time instead of on first use.
+package sshauth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Credentials describes the authentication material of a single login.
+type Credentials struct {
+	Password    string
+	KeyFile     string
+	Passphrase  string
+	Certificate string
+	Agent       bool
+}
+
+// Validate checks that the credentials are usable. Key files and
+// certificates are parsed, and agent usage requires SSH_AUTH_SOCK.
+func (c Credentials) Validate() error {
+	if c.Password == "" && c.KeyFile == "" && !c.Agent {
+		return errors.New("no authentication method configured")
+	}
+
+	if c.Certificate != "" && c.KeyFile == "" {
+		return errors.New("certificate requires key_file")
+	}
+
+	if c.KeyFile != "" {
+		if _, err := c.Signer(); err != nil {
+			return err
+		}
+	}
+
+	if c.Agent {
+		if _, err := AgentSocket(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Signer loads the private key and, if configured, wraps it with the
+// OpenSSH user certificate.
+func (c Credentials) Signer() (ssh.Signer, error) {
+	pemBytes, err := os.ReadFile(c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+
+	var signer ssh.Signer
+	if c.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(c.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(pemBytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse key file '%s': %w", c.KeyFile, err)
+	}
+
+	if c.Certificate == "" {
+		return signer, nil
+	}
+
+	certBytes, err := os.ReadFile(c.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("read certificate: %w", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate '%s': %w", c.Certificate, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not an OpenSSH certificate", c.Certificate)
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("'%s' is not a user certificate", c.Certificate)
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate does not match key file: %w", err)
+	}
+	return certSigner, nil
+}
+
+// AgentSocket returns the ssh-agent socket path from SSH_AUTH_SOCK.
+func AgentSocket() (string, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return "", errors.New("agent authentication requested but SSH_AUTH_SOCK is not set")
+	}
+	return sock, nil
+}
+
+// AuthMethods builds the SSH authentication methods in preference order:
+// key file (optionally certificate-backed), agent, password.
+// The returned closer releases the agent connection and must be called
+// once the handshake has completed.
+func (c Credentials) AuthMethods() ([]ssh.AuthMethod, io.Closer, error) {
+	var methods []ssh.AuthMethod
+	var closer io.Closer = nopCloser{}
+
+	if c.KeyFile != "" {
+		signer, err := c.Signer()
+		if err != nil {
+			return nil, nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if c.Agent {
+		sock, err := AgentSocket()
+		if err != nil {
+			return nil, nil, err
+		}
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, nil, fmt.Errorf("connect to ssh-agent: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		closer = conn
+	}
+
+	if c.Password != "" {
+		methods = append(methods, ssh.Password(c.Password))
+	}
+
+	if len(methods) == 0 {
+		_ = closer.Close()
+		return nil, nil, errors.New("no authentication method configured")
+	}
+	return methods, closer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,136 @@
+package sshauth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeKey writes a new ed25519 private key, encrypted if passphrase is
+// set, and returns its path and signer.
+func writeKey(t *testing.T, dir, name, passphrase string) (string, ssh.Signer) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, name, []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(priv, name)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, signer
+}
+
+// writeCert writes an OpenSSH certificate of the given type for key,
+// signed by a throwaway CA, and returns its path.
+func writeCert(t *testing.T, dir, name string, key ssh.PublicKey, certType uint32) string {
+	t.Helper()
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        certType,
+		ValidPrincipals: []string{"deploy"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, ssh.MarshalAuthorizedKey(cert), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	plain, plainSigner := writeKey(t, dir, "id_plain", "")
+	encrypted, _ := writeKey(t, dir, "id_encrypted", "secret")
+	_, otherSigner := writeKey(t, dir, "id_other", "")
+	cert := writeCert(t, dir, "id_plain-cert.pub", plainSigner.PublicKey(), ssh.UserCert)
+	foreignCert := writeCert(t, dir, "id_other-cert.pub", otherSigner.PublicKey(), ssh.UserCert)
+	hostCert := writeCert(t, dir, "host-cert.pub", plainSigner.PublicKey(), ssh.HostCert)
+
+	tests := []struct {
+		name    string
+		creds   Credentials
+		noAgent bool
+		wantErr string
+	}{
+		{name: "password only", creds: Credentials{Password: "secret"}},
+		{name: "key only", creds: Credentials{KeyFile: plain}},
+		{name: "key with passphrase", creds: Credentials{KeyFile: encrypted, Passphrase: "secret"}},
+		{name: "key with certificate", creds: Credentials{KeyFile: plain, Certificate: cert}},
+		{name: "agent", creds: Credentials{Agent: true}},
+		{name: "nothing configured", creds: Credentials{}, wantErr: "no authentication method configured"},
+		{name: "encrypted key without passphrase", creds: Credentials{KeyFile: encrypted}, wantErr: "parse key file"},
+		{name: "wrong passphrase", creds: Credentials{KeyFile: encrypted, Passphrase: "wrong"}, wantErr: "parse key file"},
+		{name: "missing key file", creds: Credentials{KeyFile: filepath.Join(dir, "missing")}, wantErr: "read key file"},
+		{name: "certificate without key", creds: Credentials{Password: "secret", Certificate: cert}, wantErr: "certificate requires key_file"},
+		{name: "certificate of another key", creds: Credentials{KeyFile: plain, Certificate: foreignCert}, wantErr: "certificate does not match key file"},
+		{name: "host certificate", creds: Credentials{KeyFile: plain, Certificate: hostCert}, wantErr: "is not a user certificate"},
+		{name: "public key as certificate", creds: Credentials{KeyFile: plain, Certificate: plain}, wantErr: "parse certificate"},
+		{name: "agent without SSH_AUTH_SOCK", creds: Credentials{Agent: true}, noAgent: true, wantErr: "SSH_AUTH_SOCK is not set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.noAgent {
+				t.Setenv("SSH_AUTH_SOCK", "")
+			} else {
+				t.Setenv("SSH_AUTH_SOCK", filepath.Join(dir, "agent.sock"))
+			}
+
+			err := tt.creds.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Validate() = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignerCertificate(t *testing.T) {
+	dir := t.TempDir()
+	key, signer := writeKey(t, dir, "id_ed25519", "")
+	certPath := writeCert(t, dir, "id_ed25519-cert.pub", signer.PublicKey(), ssh.UserCert)
+
+	got, err := Credentials{KeyFile: key, Certificate: certPath}.Signer()
+	if err != nil {
+		t.Fatalf("Signer() = %v", err)
+	}
+	cert, ok := got.PublicKey().(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("public key = %T, want a certificate", got.PublicKey())
+	}
+	if string(cert.Key.Marshal()) != string(signer.PublicKey().Marshal()) {
+		t.Error("certificate signer does not wrap the key file")
+	}
+}
@@ -0,0 +1,138 @@
+// Package state persists the desired runtime state of the daemon, such as
+// which proxies should be running and on which host, so that it survives
+// restarts of geistd.
+//
+// Example usage:
+//
+//	s, err := state.Open("/var/lib/portgeist/state.json")
+//	if err != nil {
+//		return err
+//	}
+//	s.SetProxy("pp", state.ProxyState{Running: true, Host: "zurich"})
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the state file inside the state dir.
+const FileName = "state.json"
+
+// fileVersion is the format version written to the state file.
+const fileVersion = 1
+
+// ProxyState is the persisted desired state of a single proxy.
+type ProxyState struct {
+	Running   bool   `json:"running"`
+	Host      string `json:"host,omitempty"` // host chosen via proxy.setactive, empty for the config default
+	UpdatedAt string `json:"updated_at"`
+}
+
+// file is the on-disk layout of the state file.
+type file struct {
+	Version int                   `json:"version"`
+	Proxies map[string]ProxyState `json:"proxies"`
+}
+
+// Store holds the desired state and writes every change through to disk.
+type Store struct {
+	mu   sync.Mutex
+	path string
+	data file
+}
+
+// Open loads the state file at path, creating its directory if needed.
+// A missing file yields an empty state.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create state dir: %w", err)
+	}
+
+	s := &Store{
+		path: path,
+		data: file{Version: fileVersion, Proxies: make(map[string]ProxyState)},
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state: %w", err)
+	}
+	if err := json.Unmarshal(raw, &s.data); err != nil {
+		return nil, fmt.Errorf("parse state %s: %w", path, err)
+	}
+	if s.data.Proxies == nil {
+		s.data.Proxies = make(map[string]ProxyState)
+	}
+	return s, nil
+}
+
+// Path returns the file the state is persisted to.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Proxy returns the persisted state of a proxy.
+func (s *Store) Proxy(name string) (ProxyState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps, ok := s.data.Proxies[name]
+	return ps, ok
+}
+
+// Proxies returns a copy of all persisted proxy states.
+func (s *Store) Proxies() map[string]ProxyState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]ProxyState, len(s.data.Proxies))
+	for name, ps := range s.data.Proxies {
+		out[name] = ps
+	}
+	return out
+}
+
+// SetProxy records the desired state of a proxy and saves the file.
+func (s *Store) SetProxy(name string, ps ProxyState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	s.data.Proxies[name] = ps
+	return s.save()
+}
+
+// DeleteProxy forgets the state of a proxy and saves the file.
+func (s *Store) DeleteProxy(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Proxies[name]; !ok {
+		return nil
+	}
+	delete(s.data.Proxies, name)
+	return s.save()
+}
+
+// save atomically replaces the state file. The caller must hold s.mu.
+func (s *Store) save() error {
+	s.data.Version = fileVersion
+	raw, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(raw, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	return nil
+}
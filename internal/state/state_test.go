@@ -0,0 +1,119 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lib", FileName)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if err := s.SetProxy("pp", ProxyState{Running: true, Host: "berlin"}); err != nil {
+		t.Fatalf("SetProxy() = %v", err)
+	}
+	if err := s.SetProxy("dev", ProxyState{}); err != nil {
+		t.Fatalf("SetProxy() = %v", err)
+	}
+	if err := s.SetProxy("old", ProxyState{Running: true}); err != nil {
+		t.Fatalf("SetProxy() = %v", err)
+	}
+	if err := s.DeleteProxy("old"); err != nil {
+		t.Fatalf("DeleteProxy() = %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	got := reopened.Proxies()
+	if len(got) != 2 {
+		t.Fatalf("proxies = %+v", got)
+	}
+	if pp := got["pp"]; !pp.Running || pp.Host != "berlin" || pp.UpdatedAt == "" {
+		t.Errorf("pp = %+v", pp)
+	}
+	if dev, ok := got["dev"]; !ok || dev.Running || dev.Host != "" {
+		t.Errorf("dev = %+v, %t", dev, ok)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string // "" leaves the file missing
+		wantErr string
+		want    int
+	}{
+		{name: "missing file"},
+		{name: "empty proxies", content: `{"version": 1}`},
+		{name: "saved proxies", content: `{"version": 1, "proxies": {"pp": {"running": true}}}`, want: 1},
+		{name: "corrupt file", content: `{"version": 1, "proxies": {`, wantErr: "parse state"},
+		{name: "wrong type", content: `{"proxies": []}`, wantErr: "parse state"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), FileName)
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s, err := Open(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Open() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open() = %v", err)
+			}
+			if got := s.Proxies(); len(got) != tt.want {
+				t.Fatalf("proxies = %+v, want %d", got, tt.want)
+			}
+			// an empty state must still accept changes
+			if err := s.SetProxy("new", ProxyState{Running: true}); err != nil {
+				t.Fatalf("SetProxy() = %v", err)
+			}
+		})
+	}
+}
+
+func TestSaveIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, running := range []bool{true, false, true} {
+		if err := s.SetProxy("pp", ProxyState{Running: running}); err != nil {
+			t.Fatalf("SetProxy() = %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != FileName {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Fatalf("state dir = %v, want only %s", names, FileName)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("mode = %o, want 600", mode)
+	}
+}
@@ -0,0 +1,90 @@
+// Package tlsutil builds the TLS configurations used by TLS control
+// instances and geistctl, and generates a small local CA with server and
+// client certificates for them.
+//
+// Example usage:
+//
+//	srv, err := tlsutil.ServerConfig("server.pem", "server-key.pem", "ca.pem", "require")
+//	ln, err := tls.Listen("tcp", ":4711", srv)
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// ServerConfig returns the TLS configuration of a control listener.
+// clientAuth is "none" (or empty), "optional" or "require"; for the latter
+// two client certificates must be signed by a CA from clientCA.
+func ServerConfig(certFile, keyFile, clientCA, clientAuth string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	switch clientAuth {
+	case "", "none":
+		return cfg, nil
+	case "optional":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid client auth %q", clientAuth)
+	}
+
+	pool, err := loadPool(clientCA)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs = pool
+	return cfg, nil
+}
+
+// ClientConfig returns the TLS configuration for connecting to a daemon.
+// If caFile is set only servers signed by it are accepted, otherwise the
+// system roots are used. certFile and keyFile optionally present a client
+// certificate.
+func ClientConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pool, err := loadPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// loadPool reads a PEM bundle of CA certificates.
+func loadPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
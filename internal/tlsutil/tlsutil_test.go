@@ -0,0 +1,128 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"testing"
+)
+
+// issueAll creates a CA with a server certificate for 127.0.0.1 and a
+// client certificate for alice in a temporary dir.
+func issueAll(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	ca, created, err := InitCA(dir)
+	if err != nil || !created {
+		t.Fatalf("InitCA() = %v, %v", created, err)
+	}
+	if err := ca.IssueServer(dir, "server", []string{"127.0.0.1"}); err != nil {
+		t.Fatalf("IssueServer() = %v", err)
+	}
+	if err := ca.IssueClient(dir, "alice"); err != nil {
+		t.Fatalf("IssueClient() = %v", err)
+	}
+	return dir
+}
+
+// handshake connects a client using clientCfg to a listener using
+// serverCfg and returns the server-side handshake result and peer name.
+func handshake(t *testing.T, serverCfg, clientCfg *tls.Config) (string, error) {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), clientCfg)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// TLS 1.3 clients only learn about a rejected certificate on read
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tlsConn := conn.(*tls.Conn)
+	if err := tlsConn.Handshake(); err != nil {
+		return "", err
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", nil
+	}
+	return certs[0].Subject.CommonName, nil
+}
+
+func TestClientAuth(t *testing.T) {
+	dir := issueAll(t)
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name       string
+		clientAuth string
+		withCert   bool
+		wantUser   string
+		wantErr    bool
+	}{
+		{"require with certificate", "require", true, "alice", false},
+		{"require without certificate", "require", false, "", true},
+		{"optional with certificate", "optional", true, "alice", false},
+		{"optional without certificate", "optional", false, "", false},
+		{"none", "none", false, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverCfg, err := ServerConfig(file("server.pem"), file("server-key.pem"), file(CAFile), tt.clientAuth)
+			if err != nil {
+				t.Fatalf("ServerConfig() = %v", err)
+			}
+			certFile, keyFile := "", ""
+			if tt.withCert {
+				certFile, keyFile = file("client-alice.pem"), file("client-alice-key.pem")
+			}
+			clientCfg, err := ClientConfig(file(CAFile), certFile, keyFile, "127.0.0.1")
+			if err != nil {
+				t.Fatalf("ClientConfig() = %v", err)
+			}
+
+			user, err := handshake(t, serverCfg, clientCfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handshake error = %v, want error %v", err, tt.wantErr)
+			}
+			if user != tt.wantUser {
+				t.Fatalf("peer = %q, want %q", user, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestClientAuthForeignCA(t *testing.T) {
+	dir := issueAll(t)
+	other := issueAll(t)
+
+	serverCfg, err := ServerConfig(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"), filepath.Join(dir, CAFile), "require")
+	if err != nil {
+		t.Fatalf("ServerConfig() = %v", err)
+	}
+	clientCfg, err := ClientConfig(filepath.Join(dir, CAFile), filepath.Join(other, "client-alice.pem"), filepath.Join(other, "client-alice-key.pem"), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("ClientConfig() = %v", err)
+	}
+	if _, err := handshake(t, serverCfg, clientCfg); err == nil {
+		t.Fatal("handshake accepted a client certificate from a foreign CA")
+	}
+}
+
+func TestServerConfigInvalidClientAuth(t *testing.T) {
+	dir := issueAll(t)
+	if _, err := ServerConfig(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"), filepath.Join(dir, CAFile), "sometimes"); err == nil {
+		t.Fatal("ServerConfig() accepted an invalid client auth mode")
+	}
+}
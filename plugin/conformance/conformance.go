@@ -0,0 +1,265 @@
+// Package conformance checks that a backend plugin executable follows the
+// geistd plugin protocol. Plugin authors can run it from a Go test:
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.Run(t, conformance.Options{Path: "./my-plugin"})
+//	}
+//
+// or without Go tooling via the geist-plugin-check command.
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mfulz/portgeist/internal/configd"
+	"github.com/mfulz/portgeist/internal/pluginhost"
+	"github.com/mfulz/portgeist/internal/socks5"
+	"github.com/mfulz/portgeist/plugin"
+)
+
+// proxyName is the name of the proxy started during the checks.
+const proxyName = "conformance"
+
+// Options configures a conformance run. Host and Login are passed to the
+// plugin's start call and only need to be set for plugins that connect to
+// a remote endpoint.
+type Options struct {
+	Path    string           // plugin executable
+	Config  map[string]any   // backend config sent with configure
+	Host    plugin.HostInfo  // host the proxy is started on
+	Login   plugin.LoginInfo // credentials for Host
+	Listen  string           // SOCKS5 listen address, a free local port if empty
+	Target  string           // optional host:port to CONNECT to through the started proxy
+	Timeout time.Duration    // how long state changes may take (default 10s)
+}
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name string
+	Err  error // nil if the check passed
+}
+
+// Run executes all checks as subtests of t.
+func Run(t *testing.T, opts Options) {
+	t.Helper()
+	for _, r := range Check(opts) {
+		t.Run(r.Name, func(t *testing.T) {
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+		})
+	}
+}
+
+// Check runs all checks against the plugin at opts.Path. Checks after a
+// failed handshake or start are not run.
+func Check(opts Options) []Result {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	var results []Result
+	record := func(name string, err error) bool {
+		results = append(results, Result{Name: name, Err: err})
+		return err == nil
+	}
+
+	p, err := pluginhost.Launch(opts.Path)
+	if err == nil && p.Name() == "" {
+		err = errors.New("empty backend name")
+	}
+	if !record("handshake", err) {
+		return results
+	}
+	defer p.Close()
+
+	var exitsMu sync.Mutex
+	var exits []string
+	p.SetExitHandler(func(name string) {
+		exitsMu.Lock()
+		exits = append(exits, name)
+		exitsMu.Unlock()
+	})
+
+	name := proxyName
+
+	err = p.Call("conformance.unknown", plugin.NameParams{Name: name}, nil)
+	var rpcErr *plugin.Error
+	switch {
+	case err == nil:
+		err = errors.New("unknown method succeeded")
+	case !errors.As(err, &rpcErr):
+		err = fmt.Errorf("unknown method: %w", err)
+	case rpcErr.Code != plugin.CodeMethodNotFound:
+		err = fmt.Errorf("unknown method returned code %d, want %d", rpcErr.Code, plugin.CodeMethodNotFound)
+	default:
+		err = nil
+	}
+	record("unknown method", err)
+
+	var status plugin.StatusResult
+	err = p.Call(plugin.MethodStatus, plugin.NameParams{Name: name}, &status)
+	if err == nil && status.Running {
+		err = errors.New("unknown proxy reported as running")
+	}
+	record("status of unknown proxy", err)
+
+	record("stop of unknown proxy", p.Stop(name))
+
+	cfg := opts.Config
+	if cfg == nil {
+		cfg = map[string]any{}
+	}
+	if !record("configure", p.Configure(name, cfg)) {
+		return results
+	}
+
+	if opts.Listen == "" {
+		if opts.Listen, err = freeAddr(); err != nil {
+			record("listen address", err)
+			return results
+		}
+	}
+	daemonCfg, proxy, err := daemonConfig(p.Name(), opts)
+	if err != nil {
+		record("listen address", err)
+		return results
+	}
+
+	start := func() error {
+		if err := p.Start(name, proxy, daemonCfg); err != nil {
+			return err
+		}
+		return waitFor(p, name, true, opts.Timeout)
+	}
+	if !record("start", start()) {
+		return results
+	}
+
+	record("socks5 listener", probe(opts.Listen, opts.Target, opts.Timeout))
+
+	err = p.Stop(name)
+	if err == nil {
+		err = waitFor(p, name, false, opts.Timeout)
+	}
+	record("stop", err)
+
+	// give a misbehaving plugin time to report the intentional stop
+	time.Sleep(500 * time.Millisecond)
+	exitsMu.Lock()
+	if len(exits) > 0 {
+		err = fmt.Errorf("exit notification sent for stopped proxy %v", exits)
+	} else {
+		err = nil
+	}
+	exitsMu.Unlock()
+	record("no exit notification on stop", err)
+
+	err = start()
+	if err == nil {
+		err = p.Stop(name)
+	}
+	record("restart after stop", err)
+
+	return results
+}
+
+// daemonConfig builds the daemon configuration geistd would pass to the
+// plugin backend for opts.
+func daemonConfig(backend string, opts Options) (*configd.Config, configd.Proxy, error) {
+	bind, portStr, err := net.SplitHostPort(opts.Listen)
+	if err != nil {
+		return nil, configd.Proxy{}, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, configd.Proxy{}, fmt.Errorf("invalid listen port %q", portStr)
+	}
+
+	hostName := opts.Host.Name
+	if hostName == "" {
+		hostName = proxyName
+	}
+	proxy := configd.Proxy{Port: port, Default: hostName}
+	cfg := &configd.Config{
+		Logins: map[string]configd.Login{
+			proxyName: {
+				User:        opts.Login.User,
+				Password:    opts.Login.Password,
+				KeyFile:     opts.Login.KeyFile,
+				Passphrase:  opts.Login.Passphrase,
+				Certificate: opts.Login.Certificate,
+				Agent:       opts.Login.Agent,
+			},
+		},
+		Hosts: map[string]configd.Host{
+			hostName: {
+				Address:       opts.Host.Address,
+				Port:          opts.Host.Port,
+				Login:         proxyName,
+				Backend:       backend,
+				Config:        opts.Host.Config,
+				Proxies:       []string{proxyName},
+				HostKey:       opts.Host.HostKey,
+				KnownHosts:    opts.Host.KnownHosts,
+				HostKeyPolicy: opts.Host.HostKeyPolicy,
+			},
+		},
+		Proxies: configd.ProxiesConfig{
+			Bind:    bind,
+			Proxies: map[string]configd.Proxy{proxyName: proxy},
+		},
+	}
+	return cfg, proxy, nil
+}
+
+// waitFor polls the status of a proxy until it matches running.
+func waitFor(p *pluginhost.Plugin, name string, running bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		var status plugin.StatusResult
+		err := p.Call(plugin.MethodStatus, plugin.NameParams{Name: name}, &status)
+		if err != nil {
+			return fmt.Errorf("status: %w", err)
+		}
+		if status.Running == running {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("status did not report running=%v within %s", running, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// probe checks the SOCKS5 listener, connecting to target if given.
+func probe(listen, target string, timeout time.Duration) error {
+	if target != "" {
+		conn, err := socks5.Connect(listen, target, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	conn, err := net.DialTimeout("tcp", listen, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// freeAddr returns a currently unused local TCP address.
+func freeAddr() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer ln.Close()
+	return ln.Addr().String(), nil
+}
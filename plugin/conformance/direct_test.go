@@ -0,0 +1,51 @@
+package conformance_test
+
+import (
+	"io"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/mfulz/portgeist/internal/logging"
+	"github.com/mfulz/portgeist/plugin/conformance"
+	"go.uber.org/zap"
+)
+
+// TestDirect runs the suite against the reference plugin, so protocol
+// changes that break plugins fail here first.
+func TestDirect(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the plugin executable")
+	}
+	logging.Log = zap.NewNop().Sugar()
+
+	path := filepath.Join(t.TempDir(), "geist-plugin-direct")
+	build := exec.Command("go", "build", "-o", path, "github.com/mfulz/portgeist/cmd/geist-plugin-direct")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("build plugin: %v\n%s", err, out)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	conformance.Run(t, conformance.Options{
+		Path:   path,
+		Target: ln.Addr().String(),
+	})
+}
@@ -0,0 +1,127 @@
+// Package plugin defines the wire protocol between geistd and external
+// backend plugins and provides a small SDK for writing plugins in Go.
+//
+// A plugin is an executable placed in the daemon's plugins directory.
+// geistd starts it, talks JSON-RPC 2.0 over its stdin/stdout with one JSON
+// object per line, and registers it as a backend under the name returned by
+// the handshake. Anything the plugin writes to stderr ends up in the daemon
+// log. The methods mirror interfaces.ProxyBackend:
+//
+//	handshake  HandshakeParams -> HandshakeResult
+//	configure  ConfigureParams -> null
+//	start      StartParams     -> null
+//	stop       NameParams      -> null
+//	status     NameParams      -> StatusResult
+//
+// A plugin reports an unexpected tunnel exit by sending the notification
+// "exit" with NameParams. Exits caused by stop must not be reported.
+package plugin
+
+import "encoding/json"
+
+// ProtocolVersion is the plugin protocol version spoken by this package.
+const ProtocolVersion = 1
+
+// JSONRPCVersion is the value of the jsonrpc member of every message.
+const JSONRPCVersion = "2.0"
+
+// Method names used by geistd and plugins.
+const (
+	MethodHandshake = "handshake"
+	MethodConfigure = "configure"
+	MethodStart     = "start"
+	MethodStop      = "stop"
+	MethodStatus    = "status"
+
+	// NotifyExit is sent by a plugin when a tunnel exits unexpectedly.
+	NotifyExit = "exit"
+)
+
+// JSON-RPC error codes.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeBackendError   = -32000 // the backend operation itself failed
+)
+
+// Message is a JSON-RPC 2.0 request, response or notification.
+// Requests carry Method and ID, notifications only Method, and responses
+// ID plus either Result or Error.
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error object.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// HandshakeParams is sent by geistd right after starting a plugin.
+type HandshakeParams struct {
+	ProtocolVersion int `json:"protocol_version"`
+}
+
+// HandshakeResult identifies the plugin.
+type HandshakeResult struct {
+	Name            string `json:"name"` // backend name hosts refer to
+	ProtocolVersion int    `json:"protocol_version"`
+}
+
+// ConfigureParams carries the merged backend configuration of a proxy.
+type ConfigureParams struct {
+	Name   string         `json:"name"`
+	Config map[string]any `json:"config"`
+}
+
+// StartParams describes everything needed to bring up a proxy.
+type StartParams struct {
+	Name   string    `json:"name"`   // proxy name
+	Listen string    `json:"listen"` // address the SOCKS5 listener must bind to
+	Host   HostInfo  `json:"host"`
+	Login  LoginInfo `json:"login"`
+}
+
+// HostInfo describes the remote endpoint a proxy runs on.
+type HostInfo struct {
+	Name          string         `json:"name"`
+	Address       string         `json:"address"`
+	Port          int            `json:"port"`
+	Config        map[string]any `json:"config,omitempty"`
+	HostKey       string         `json:"host_key,omitempty"`
+	HostKeyPolicy string         `json:"host_key_policy,omitempty"`
+	KnownHosts    string         `json:"known_hosts,omitempty"`
+}
+
+// LoginInfo carries the credentials of the host's login.
+type LoginInfo struct {
+	User        string `json:"user"`
+	Password    string `json:"password,omitempty"`
+	KeyFile     string `json:"key_file,omitempty"`
+	Passphrase  string `json:"passphrase,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+	Agent       bool   `json:"agent,omitempty"`
+}
+
+// NameParams addresses a single proxy.
+type NameParams struct {
+	Name string `json:"name"`
+}
+
+// StatusResult reports the runtime state of a proxy.
+type StatusResult struct {
+	PID     int  `json:"pid"`
+	Running bool `json:"running"`
+}
@@ -0,0 +1,167 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// maxMessageSize bounds a single protocol line.
+const maxMessageSize = 1 << 20
+
+// Backend is implemented by plugins built with Serve. It mirrors
+// interfaces.ProxyBackend with the host context resolved into StartParams.
+type Backend interface {
+	Configure(name string, config map[string]any) error
+	Start(params StartParams) error
+	Stop(name string) error
+	Status(name string) (pid int, running bool)
+}
+
+// ExitAwareBackend is an optional extension of Backend. Serve registers a
+// handler that forwards unexpected exits to geistd as exit notifications.
+type ExitAwareBackend interface {
+	Backend
+	SetExitHandler(func(name string))
+}
+
+// Serve runs the plugin protocol for b on stdin and stdout until stdin is
+// closed. name is the backend name reported in the handshake.
+func Serve(name string, b Backend) error {
+	return ServeConn(name, b, os.Stdin, os.Stdout)
+}
+
+// ServeConn runs the plugin protocol for b on r and w. Requests are handled
+// concurrently so that a slow start does not block status queries.
+func ServeConn(name string, b Backend, r io.Reader, w io.Writer) error {
+	s := &server{name: name, backend: b, enc: json.NewEncoder(w)}
+
+	if withNotify, ok := b.(ExitAwareBackend); ok {
+		withNotify.SetExitHandler(func(proxy string) {
+			params, _ := json.Marshal(NameParams{Name: proxy})
+			s.write(&Message{JSONRPC: JSONRPCVersion, Method: NotifyExit, Params: params})
+		})
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			s.write(&Message{JSONRPC: JSONRPCVersion, Error: &Error{Code: CodeParseError, Message: err.Error()}})
+			continue
+		}
+		if msg.ID == nil {
+			// notifications from geistd are not defined yet
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(&msg)
+		}()
+	}
+	return scanner.Err()
+}
+
+// server holds the state of a running ServeConn loop.
+type server struct {
+	name    string
+	backend Backend
+
+	mu  sync.Mutex // serializes writes
+	enc *json.Encoder
+}
+
+// write sends a single message.
+func (s *server) write(msg *Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.enc.Encode(msg)
+}
+
+// handle executes a request and writes its response.
+func (s *server) handle(req *Message) {
+	result, rpcErr := s.call(req)
+	resp := &Message{JSONRPC: JSONRPCVersion, ID: req.ID, Error: rpcErr}
+	if rpcErr == nil {
+		raw, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = &Error{Code: CodeInternalError, Message: err.Error()}
+		} else {
+			resp.Result = raw
+		}
+	}
+	s.write(resp)
+}
+
+// call dispatches a request to the backend.
+func (s *server) call(req *Message) (any, *Error) {
+	switch req.Method {
+	case MethodHandshake:
+		var p HandshakeParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		if p.ProtocolVersion != ProtocolVersion {
+			return nil, &Error{Code: CodeInvalidParams, Message: fmt.Sprintf("unsupported protocol version %d", p.ProtocolVersion)}
+		}
+		return HandshakeResult{Name: s.name, ProtocolVersion: ProtocolVersion}, nil
+
+	case MethodConfigure:
+		var p ConfigureParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, backendError(s.backend.Configure(p.Name, p.Config))
+
+	case MethodStart:
+		var p StartParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, backendError(s.backend.Start(p))
+
+	case MethodStop:
+		var p NameParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, backendError(s.backend.Stop(p.Name))
+
+	case MethodStatus:
+		var p NameParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		pid, running := s.backend.Status(p.Name)
+		return StatusResult{PID: pid, Running: running}, nil
+	}
+
+	return nil, &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+}
+
+// decodeParams unmarshals request params into v.
+func decodeParams(raw json.RawMessage, v any) *Error {
+	if len(raw) == 0 {
+		return &Error{Code: CodeInvalidParams, Message: "missing params"}
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// backendError wraps a backend failure into a JSON-RPC error.
+func backendError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: CodeBackendError, Message: err.Error()}
+}
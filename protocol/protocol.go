@@ -13,6 +13,75 @@ const (
 	CmdProxySetActive = "proxy.setactive"
 	CmdPing           = "system.ping"
 	CmdProxyResolv    = "proxy.resolve"
+	CmdProxyReset     = "proxy.reset"
+	CmdHostKeys       = "host.fingerprints"
+	CmdHostTrust      = "host.trust"
+	CmdSubscribe      = "system.subscribe"
+	CmdLogin          = "system.login"
+	CmdLogout         = "system.logout"
+	CmdAudit          = "system.audit"
+	CmdACLExplain     = "acl.explain"
+	CmdReload         = "system.reload"
+
+	CmdConfigHostAdd    = "config.host.add"
+	CmdConfigHostUpdate = "config.host.update"
+	CmdConfigHostRemove = "config.host.remove"
+	CmdConfigHostList   = "config.host.list"
+	CmdConfigHostGet    = "config.host.get"
+
+	CmdConfigProxyAdd    = "config.proxy.add"
+	CmdConfigProxyUpdate = "config.proxy.update"
+	CmdConfigProxyRemove = "config.proxy.remove"
+
+	CmdConfigLoginList   = "config.login.list"
+	CmdConfigLoginAdd    = "config.login.add"
+	CmdConfigLoginUpdate = "config.login.update"
+	CmdConfigLoginRemove = "config.login.remove"
+
+	CmdConfigUserList   = "config.user.list"
+	CmdConfigUserAdd    = "config.user.add"
+	CmdConfigUserUpdate = "config.user.update"
+	CmdConfigUserRemove = "config.user.remove"
+	CmdConfigUserRotate = "config.user.rotate"
+
+	CmdConfigGroupAdd    = "config.group.add"
+	CmdConfigGroupUpdate = "config.group.update"
+	CmdConfigGroupRemove = "config.group.remove"
+
+	CmdConfigRoleAdd    = "config.role.add"
+	CmdConfigRoleUpdate = "config.role.update"
+	CmdConfigRoleRemove = "config.role.remove"
+
+	CmdConfigControlList    = "config.control.list"
+	CmdConfigControlAdd     = "config.control.add"
+	CmdConfigControlRemove  = "config.control.remove"
+	CmdConfigControlEnable  = "config.control.enable"
+	CmdConfigControlDisable = "config.control.disable"
+)
+
+// Event types streamed by system.subscribe.
+const (
+	EventProxyStarted   = "proxy.started"
+	EventProxyStopped   = "proxy.stopped"
+	EventProxyExited    = "proxy.exited"    // tunnel lost unexpectedly or failed its health check
+	EventProxyRestarted = "proxy.restarted" // automatic restart after an exit succeeded
+	EventProxyFailed    = "proxy.failed"    // restart budget spent, proxy needs a reset
+	EventHostSwitched   = "proxy.host_switched"
+	EventHealthChanged  = "proxy.health_changed"
+	EventConfigReloaded = "config.reloaded"
+	EventAuthFailure    = "auth.failure"
+)
+
+// Error codes for Response.Code. They allow clients to react to specific
+// failures without parsing the error message.
+const (
+	ErrCodeHostKeyMismatch = "host_key_mismatch"
+	ErrCodeHostKeyUnknown  = "host_key_unknown"
+	ErrCodeProxyFailed     = "proxy_failed"
+	ErrCodeSessionExpired  = "session_expired"
+	ErrCodeOutOfScope      = "out_of_scope"
+	ErrCodeNotAllowed      = "not_allowed"
+	ErrCodeUnauthenticated = "unauthenticated"
 )
 
 // Request represents a message sent from a client to the daemon.
@@ -20,6 +89,8 @@ type Request struct {
 	Type string      `json:"type"`           // e.g. "proxy.start", "proxy.status"
 	Auth *Auth       `json:"auth,omitempty"` // Optional auth block
 	Data interface{} `json:"data,omitempty"` // Optional payload
+
+	Peer string `json:"-"` // client address, set by the daemon
 }
 
 // Response represents a message sent from the daemon to a client.
@@ -27,6 +98,7 @@ type Response struct {
 	Status string      `json:"status"`          // "ok" or "error"
 	Data   interface{} `json:"data,omitempty"`  // Optional result
 	Error  string      `json:"error,omitempty"` // Optional error message
+	Code   string      `json:"code,omitempty"`  // Optional machine-readable error code
 }
 
 // Auth holds authentication information for a client.
@@ -45,6 +117,10 @@ type StopRequest struct {
 	Name string `json:"name"`
 }
 
+type ResetRequest struct {
+	Name string `json:"name"`
+}
+
 type StatusRequest struct {
 	Name string `json:"name"`
 }
@@ -54,11 +130,30 @@ type InfoRequest struct {
 }
 
 type StatusResponse struct {
-	Name       string `json:"name"`
-	Backend    string `json:"backend"`
-	Running    bool   `json:"running"`
-	PID        int    `json:"pid"`
-	ActiveHost string `json:"active_host"`
+	Name           string        `json:"name"`
+	Backend        string        `json:"backend"`
+	Running        bool          `json:"running"`
+	PID            int           `json:"pid"`
+	ActiveHost     string        `json:"active_host"`
+	Candidates     []string      `json:"candidates"`      // ordered hosts the proxy may fail over to
+	CandidateIndex int           `json:"candidate_index"` // index of ActiveHost in Candidates, -1 if none
+	Skipped        []HostFailure `json:"skipped"`         // hosts left behind and why
+	State          string        `json:"state"`           // running, stopped, backoff or failed
+	Restarts       int           `json:"restarts"`        // automatic restarts within the restart window
+	NextRestart    string        `json:"next_restart,omitempty"`
+	LastError      string        `json:"last_error,omitempty"`
+	Health         string        `json:"health,omitempty"`          // unknown, healthy, failing or unhealthy; empty if not probed
+	LastProbe      string        `json:"last_probe,omitempty"`      // time of the last finished probe
+	LatencyMs      int64         `json:"latency_ms,omitempty"`      // duration of the last successful probe
+	HealthFailures int           `json:"health_failures,omitempty"` // consecutive failed probes
+	HealthError    string        `json:"health_error,omitempty"`
+}
+
+// HostFailure records why a candidate host was skipped.
+type HostFailure struct {
+	Host   string `json:"host"`
+	Reason string `json:"reason"`
+	At     string `json:"at"`
 }
 
 // InfoResponse combines proxy config and runtime status.
@@ -79,9 +174,20 @@ type SetActiveRequest struct {
 	Host string `json:"host"`
 }
 
-// ListResponse wraps a list of available proxy names for structured parsing.
+// ListResponse lists the proxies visible to the caller.
 type ListResponse struct {
-	Proxies []string `json:"proxies"`
+	Proxies []ProxyListEntry `json:"proxies"`
+}
+
+// ProxyListEntry summarizes a proxy for listings.
+type ProxyListEntry struct {
+	Name         string   `json:"name"`
+	Port         int      `json:"port"`
+	Running      bool     `json:"running"`
+	State        string   `json:"state"` // running, stopped, backoff or failed
+	ActiveHost   string   `json:"active_host,omitempty"`
+	Health       string   `json:"health,omitempty"`
+	AllowedHosts []string `json:"allowed_hosts"` // hosts the proxy may run on
 }
 
 type ResolvRequest struct {
@@ -92,3 +198,440 @@ type ResolvResponse struct {
 	Host string `json:"host"`
 	Port int    `json:"port"`
 }
+
+// HostKeysRequest asks for the known and pending host keys of a host.
+// An empty Host selects all hosts. Scan connects to the host and records
+// the presented key as pending.
+type HostKeysRequest struct {
+	Host string `json:"host"`
+	Scan bool   `json:"scan,omitempty"`
+}
+
+// HostKey describes a single SSH host key.
+type HostKey struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	Reason      string `json:"reason,omitempty"`  // pending keys only: unknown, mismatch or scanned
+	SeenAt      string `json:"seen_at,omitempty"` // pending keys only
+}
+
+// HostKeysEntry lists the trusted and pending keys of one host.
+type HostKeysEntry struct {
+	Host    string    `json:"host"`
+	Address string    `json:"address"`
+	Policy  string    `json:"policy"`
+	Pinned  string    `json:"pinned,omitempty"`
+	Trusted []HostKey `json:"trusted"`
+	Pending []HostKey `json:"pending"`
+}
+
+// HostKeysResponse wraps the host key listing.
+type HostKeysResponse struct {
+	Hosts []HostKeysEntry `json:"hosts"`
+}
+
+// HostTrustRequest approves a pending host key by fingerprint.
+type HostTrustRequest struct {
+	Host        string `json:"host"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// SubscribeRequest opens an event stream. After the initial "ok" response
+// the connection carries one Event per line until the client disconnects.
+// Empty filters match everything the subscriber is allowed to see.
+type SubscribeRequest struct {
+	Types   []string `json:"types,omitempty"`
+	Proxies []string `json:"proxies,omitempty"`
+}
+
+// Event is a single entry of the system.subscribe stream.
+type Event struct {
+	Type     string `json:"type"`
+	Time     string `json:"time"`
+	Proxy    string `json:"proxy,omitempty"`
+	Host     string `json:"host,omitempty"`
+	PrevHost string `json:"prev_host,omitempty"` // proxy.host_switched: host used before
+	Health   string `json:"health,omitempty"`    // proxy.health_changed: new health state
+	User     string `json:"user,omitempty"`      // auth.failure: claimed user
+	Instance string `json:"instance,omitempty"`  // control instance the event refers to
+	Message  string `json:"message,omitempty"`   // reason or error detail
+}
+
+// LoginRequest exchanges the presented long-lived credentials for a
+// session token. TTL is a Go duration such as "30m", empty selects the
+// daemon default. Scope limits the session to matching commands, e.g.
+// "proxy.status" or "proxy.*".
+type LoginRequest struct {
+	TTL   string   `json:"ttl,omitempty"`
+	Scope []string `json:"scope,omitempty"`
+}
+
+// LoginResponse carries the issued session token.
+type LoginResponse struct {
+	Token     string   `json:"token"`
+	Session   string   `json:"session"` // session id for logs and audit entries
+	User      string   `json:"user"`
+	ExpiresAt string   `json:"expires_at"`
+	Scope     []string `json:"scope,omitempty"`
+}
+
+// LogoutRequest revokes a session. An empty Token revokes the session the
+// request was authenticated with.
+type LogoutRequest struct {
+	Token string `json:"token,omitempty"`
+}
+
+// AuditRequest queries the audit log. Since and Until are RFC 3339
+// timestamps or durations relative to now (e.g. "24h"). Command may be a
+// pattern such as "proxy.*". Limit caps the number of returned entries,
+// keeping the most recent ones.
+type AuditRequest struct {
+	Since   string `json:"since,omitempty"`
+	Until   string `json:"until,omitempty"`
+	User    string `json:"user,omitempty"`
+	Command string `json:"command,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// AuditEntry is a single record of the audit log.
+type AuditEntry struct {
+	Time       string `json:"time"`
+	Instance   string `json:"instance"`
+	Peer       string `json:"peer,omitempty"`
+	User       string `json:"user"`
+	AuthSource string `json:"auth_source,omitempty"` // token, session, tls or peercred
+	Session    string `json:"session,omitempty"`
+	Command    string `json:"command"`
+	Proxy      string `json:"proxy,omitempty"`
+	Host       string `json:"host,omitempty"`
+	Decision   string `json:"decision"` // allow, deny or unauthenticated
+	Outcome    string `json:"outcome"`  // ok or error
+	Error      string `json:"error,omitempty"`
+	Code       string `json:"code,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// AuditResponse returns matching audit entries, oldest first.
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+// ACLExplainRequest asks how the ACL decides Permission for User, against
+// the rules of Proxy if set. An empty User explains the requester.
+type ACLExplainRequest struct {
+	User       string `json:"user,omitempty"`
+	Proxy      string `json:"proxy,omitempty"`
+	Host       string `json:"host,omitempty"` // adds the rules of the host and its backend
+	Permission string `json:"permission"`
+
+	// Request context for rule conditions, defaulting to the explain request's own
+	Instance string `json:"instance,omitempty"`
+	Source   string `json:"source,omitempty"`
+	Time     string `json:"time,omitempty"` // RFC 3339
+}
+
+// ACLExplanation is the evaluation trace of a single ACL decision.
+type ACLExplanation struct {
+	User       string            `json:"user"`
+	Permission string            `json:"permission"`
+	Proxy      string            `json:"proxy,omitempty"`
+	Host       string            `json:"host,omitempty"`
+	Instance   string            `json:"instance,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Time       string            `json:"time,omitempty"`
+	Allowed    bool              `json:"allowed"`
+	Reason     string            `json:"reason"`
+	KnownUser  bool              `json:"known_user"`
+	Groups     []string          `json:"groups,omitempty"` // including nested groups
+	Roles      []ACLRoleTrace    `json:"roles,omitempty"`
+	RuleSets   []ACLRuleSetTrace `json:"rule_sets,omitempty"` // every set must allow
+}
+
+// ACLRuleSetTrace records how the rules of one object were evaluated.
+type ACLRuleSetTrace struct {
+	Source  string         `json:"source"` // "proxy", "defaults", "host" or "backend"
+	Object  string         `json:"object"`
+	Allowed bool           `json:"allowed"`
+	Reason  string         `json:"reason"`
+	Rules   []ACLRuleTrace `json:"rules"`
+}
+
+// ACLRoleTrace tells whether a role of the user grants the permission.
+type ACLRoleTrace struct {
+	Name      string `json:"name"`
+	Via       string `json:"via"` // "user" or "group:<name>"
+	Exists    bool   `json:"exists"`
+	Grants    bool   `json:"grants"`
+	GrantedBy string `json:"granted_by,omitempty"` // the role itself or an extended role
+	Condition string `json:"condition,omitempty"`  // failed role condition if it does not grant
+}
+
+// ACLRuleTrace records how a single rule was evaluated.
+type ACLRuleTrace struct {
+	Index           int      `json:"index"`
+	Description     string   `json:"description,omitempty"`
+	Subjects        []string `json:"subjects"`
+	Permissions     []string `json:"permissions,omitempty"`
+	Deny            bool     `json:"deny"`
+	Proxies         []string `json:"proxies,omitempty"`
+	ProxyMatch      bool     `json:"proxy_match"`
+	PermissionMatch bool     `json:"permission_match"`
+	MatchedSubject  string   `json:"matched_subject,omitempty"`
+	ConditionMatch  bool     `json:"condition_match"`
+	Condition       string   `json:"condition,omitempty"` // first condition that failed
+	Effect          string   `json:"effect"`              // "allow", "deny" or "skip"
+}
+
+// ReloadResponse summarizes the changes applied by a configuration reload.
+// Proxies are listed by name, control instances by instance name.
+type ReloadResponse struct {
+	ProxiesStarted    []string `json:"proxies_started,omitempty"`
+	ProxiesStopped    []string `json:"proxies_stopped,omitempty"`
+	ProxiesRestarted  []string `json:"proxies_restarted,omitempty"`
+	ControlsStarted   []string `json:"controls_started,omitempty"`
+	ControlsStopped   []string `json:"controls_stopped,omitempty"`
+	ControlsRestarted []string `json:"controls_restarted,omitempty"`
+	ACLChanged        bool     `json:"acl_changed"`
+	RestartRequired   []string `json:"restart_required,omitempty"` // changed settings only applied when the daemon restarts
+	Errors            []string `json:"errors,omitempty"`           // parts of the new config that failed to apply
+}
+
+// HostConfig is the definition of a host as managed by the config.host
+// commands. ACLs holds the access rules in the config file format.
+type HostConfig struct {
+	Address        string         `json:"address"`
+	Port           int            `json:"port,omitempty"`
+	Login          string         `json:"login,omitempty"`
+	Backend        string         `json:"backend,omitempty"`
+	Config         map[string]any `json:"config,omitempty"` // host-specific backend settings
+	AllowedProxies []string       `json:"allowed_proxies,omitempty"`
+	HostKey        string         `json:"host_key,omitempty"`
+	KnownHosts     string         `json:"known_hosts,omitempty"`
+	HostKeyPolicy  string         `json:"host_key_policy,omitempty"`
+	ACLs           any            `json:"acls,omitempty"`
+}
+
+// HostEditRequest adds or updates a host. An update only changes the
+// fields named in Fields by their JSON names; without Fields the whole
+// definition is replaced.
+type HostEditRequest struct {
+	Name   string     `json:"name"`
+	Host   HostConfig `json:"host"`
+	Fields []string   `json:"fields,omitempty"`
+}
+
+// HostConfigRequest names a host for config.host.get and config.host.remove.
+// Force removes a host that is active for a running proxy or referenced as
+// default or fallback: it is dropped from the fallbacks, a proxy it is the
+// default of falls back to its first fallback, and affected running
+// proxies are restarted on their remaining hosts.
+type HostConfigRequest struct {
+	Name  string `json:"name"`
+	Force bool   `json:"force,omitempty"`
+}
+
+// HostEntry is a configured host together with the running proxies using it.
+type HostEntry struct {
+	Name string `json:"name"`
+	HostConfig
+	ActiveFor []string `json:"active_for,omitempty"`
+}
+
+// HostListResponse lists the configured hosts visible to the caller.
+type HostListResponse struct {
+	Hosts []HostEntry `json:"hosts"`
+}
+
+// ProxyConfig is the definition of a proxy as managed by the config.proxy
+// commands. ACLs holds the access rules in the config file format.
+type ProxyConfig struct {
+	Port          int      `json:"port"`
+	Default       string   `json:"default"`
+	Fallback      []string `json:"fallback,omitempty"`
+	FailoverAfter int      `json:"failover_after,omitempty"`
+	HealthTarget  string   `json:"health_target,omitempty"`
+	Autostart     bool     `json:"autostart,omitempty"`
+	ACLs          any      `json:"acls,omitempty"`
+}
+
+// ProxyEditRequest adds or updates a proxy. An update only changes the
+// fields named in Fields by their JSON names; without Fields the whole
+// definition is replaced.
+type ProxyEditRequest struct {
+	Name   string      `json:"name"`
+	Proxy  ProxyConfig `json:"proxy"`
+	Fields []string    `json:"fields,omitempty"`
+}
+
+// ProxyRemoveRequest names a proxy for config.proxy.remove.
+type ProxyRemoveRequest struct {
+	Name string `json:"name"`
+}
+
+// LoginConfig carries the credentials of an SSH login for config.login.add
+// and config.login.update. Secrets are never sent back by the daemon.
+type LoginConfig struct {
+	User        string `json:"user"`
+	Password    string `json:"password,omitempty"`
+	KeyFile     string `json:"key_file,omitempty"`
+	Passphrase  string `json:"passphrase,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+	Agent       bool   `json:"agent,omitempty"`
+}
+
+// LoginEditRequest adds or updates a login. An update only changes the
+// fields named in Fields by their JSON names, e.g. ["password"] to rotate
+// a password; without Fields the whole login is replaced.
+type LoginEditRequest struct {
+	Name   string      `json:"name"`
+	Login  LoginConfig `json:"login"`
+	Fields []string    `json:"fields,omitempty"`
+}
+
+// LoginRemoveRequest names a login for config.login.remove.
+type LoginRemoveRequest struct {
+	Name string `json:"name"`
+}
+
+// LoginEntry describes a configured login without its secrets.
+type LoginEntry struct {
+	Name          string   `json:"name"`
+	User          string   `json:"user"`
+	KeyFile       string   `json:"key_file,omitempty"`
+	Certificate   string   `json:"certificate,omitempty"`
+	Agent         bool     `json:"agent,omitempty"`
+	HasPassword   bool     `json:"has_password,omitempty"`
+	HasPassphrase bool     `json:"has_passphrase,omitempty"`
+	Hosts         []string `json:"hosts,omitempty"` // hosts using the login
+}
+
+// LoginListResponse lists the configured logins.
+type LoginListResponse struct {
+	Logins []LoginEntry `json:"logins"`
+}
+
+// UserEditRequest adds or updates a control user. Groups sets the groups
+// the user is a member of. An update only changes the fields named in
+// Fields ("roles", "groups"); without Fields both are replaced.
+type UserEditRequest struct {
+	Name   string   `json:"name"`
+	Roles  []string `json:"roles,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+// UserTokenResponse is returned when a user is added or its token rotated.
+// Token is the only copy of the new plaintext token, the daemon keeps its
+// hash.
+type UserTokenResponse struct {
+	Name    string          `json:"name"`
+	Token   string          `json:"token"`
+	Changes *ReloadResponse `json:"changes"`
+}
+
+// GroupConfig is the definition of a control group.
+type GroupConfig struct {
+	Members []string `json:"members,omitempty"`
+	Groups  []string `json:"groups,omitempty"` // nested groups
+	Roles   []string `json:"roles,omitempty"`
+}
+
+// GroupEditRequest adds or updates a group. An update only changes the
+// fields named in Fields by their JSON names.
+type GroupEditRequest struct {
+	Name   string      `json:"name"`
+	Group  GroupConfig `json:"group"`
+	Fields []string    `json:"fields,omitempty"`
+}
+
+// RoleConfig is the definition of a control role.
+type RoleConfig struct {
+	Permissions []string `json:"permissions,omitempty"`
+	Extends     []string `json:"extends,omitempty"`
+}
+
+// RoleEditRequest adds or updates a role. An update only changes the
+// fields named in Fields by their JSON names.
+type RoleEditRequest struct {
+	Name   string     `json:"name"`
+	Role   RoleConfig `json:"role"`
+	Fields []string   `json:"fields,omitempty"`
+}
+
+// ACLNameRequest names a user, group or role for the remove commands and
+// config.user.rotate.
+type ACLNameRequest struct {
+	Name string `json:"name"`
+}
+
+// UserEntry describes a control user without its token.
+type UserEntry struct {
+	Name     string   `json:"name"`
+	Roles    []string `json:"roles,omitempty"`
+	Groups   []string `json:"groups,omitempty"` // groups listing the user as member
+	HasToken bool     `json:"has_token,omitempty"`
+}
+
+// GroupEntry is a configured control group.
+type GroupEntry struct {
+	Name string `json:"name"`
+	GroupConfig
+}
+
+// RoleEntry is a configured control role.
+type RoleEntry struct {
+	Name string `json:"name"`
+	RoleConfig
+}
+
+// UserListResponse lists the control users, groups and roles.
+type UserListResponse struct {
+	Users  []UserEntry  `json:"users"`
+	Groups []GroupEntry `json:"groups"`
+	Roles  []RoleEntry  `json:"roles"`
+}
+
+// ControlConfig is the definition of a control instance as managed by the
+// config.control commands. The TLS fields apply to mode "tls", the socket
+// and peer credential fields to mode "unix".
+type ControlConfig struct {
+	Mode          string            `json:"mode"`
+	Listen        string            `json:"listen"`
+	Enabled       bool              `json:"enabled"`
+	TLSCert       string            `json:"tls_cert,omitempty"`
+	TLSKey        string            `json:"tls_key,omitempty"`
+	TLSClientCA   string            `json:"tls_client_ca,omitempty"`
+	TLSClientAuth string            `json:"tls_client_auth,omitempty"`
+	TLSUsers      map[string]string `json:"tls_users,omitempty"`
+	SocketOwner   string            `json:"socket_owner,omitempty"`
+	SocketGroup   string            `json:"socket_group,omitempty"`
+	SocketMode    string            `json:"socket_mode,omitempty"`
+	PeerCred      bool              `json:"peercred,omitempty"`
+	PeerCredUIDs  map[string]string `json:"peercred_uids,omitempty"`
+	PeerCredGIDs  map[string]string `json:"peercred_gids,omitempty"`
+}
+
+// ControlAddRequest adds a control instance.
+type ControlAddRequest struct {
+	Name    string        `json:"name"`
+	Control ControlConfig `json:"control"`
+}
+
+// ControlNameRequest names a control instance for config.control.remove,
+// config.control.enable and config.control.disable.
+type ControlNameRequest struct {
+	Name string `json:"name"`
+}
+
+// ControlEntry is a configured control instance. Current marks the
+// instance the listing was requested on.
+type ControlEntry struct {
+	Name string `json:"name"`
+	ControlConfig
+	Current bool `json:"current,omitempty"`
+}
+
+// ControlListResponse lists the configured control instances.
+type ControlListResponse struct {
+	Instances []ControlEntry `json:"instances"`
+}